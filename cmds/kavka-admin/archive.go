@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/legionus/kavka/pkg/archive"
+)
+
+var topicsExportCmd = &command{
+	Usage: "[-partition N] [-from OFFSET] [-to OFFSET] [-file FILE] <topic>",
+	Run:   topicsExport,
+}
+
+var topicsImportCmd = &command{
+	Usage: "[-file FILE] <topic>",
+	Run:   topicsImport,
+}
+
+func topicsExport(env *environment, args []string) error {
+	fs := flag.NewFlagSet("topics export", flag.ExitOnError)
+	partition := fs.Int64("partition", -1, "export only this partition")
+	from := fs.Int64("from", 0, "first offset to export")
+	to := fs.Int64("to", 0, "offset after the last exported one")
+	file := fs.String("file", "", "archive file (default is stdout)")
+
+	topic, err := topicArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+
+	if *partition >= 0 {
+		query.Set("partition", strconv.FormatInt(*partition, 10))
+	}
+	if *from > 0 {
+		query.Set("from", strconv.FormatInt(*from, 10))
+	}
+	if *to > 0 {
+		query.Set("to", strconv.FormatInt(*to, 10))
+	}
+
+	// The chunks are stored on the servers, so the archive is made by the
+	// server.
+	c, err := env.Client()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	return c.ExportTopic(topic, query, w)
+}
+
+func topicsImport(env *environment, args []string) error {
+	fs := flag.NewFlagSet("topics import", flag.ExitOnError)
+	file := fs.String("file", "", "archive file (default is stdin)")
+
+	topic, err := topicArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	c, err := env.Client()
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	data, err := c.ImportTopic(topic, r)
+	if err != nil {
+		return err
+	}
+
+	res := &archive.ImportResult{}
+
+	if err := json.Unmarshal(data, res); err != nil {
+		return err
+	}
+
+	t := &table{
+		Header: []string{"TOPIC", "SOURCE", "MESSAGES", "BLOBS", "SKIPPED"},
+	}
+	t.Append(
+		res.Topic,
+		res.Source,
+		strconv.FormatInt(res.Messages, 10),
+		strconv.FormatInt(res.Blobs, 10),
+		strconv.FormatInt(res.BlobsSkipped, 10),
+	)
+
+	return output(env, res, t)
+}
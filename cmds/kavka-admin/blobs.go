@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/placement"
+	"github.com/legionus/kavka/pkg/repair"
+)
+
+var blobsLocateCmd = &command{
+	Usage: "<digest>",
+	Run:   blobsLocate,
+}
+
+var blobsReportCmd = &command{
+	Usage: "",
+	Run:   blobsReport,
+}
+
+type blobLocation struct {
+	Digest string `json:"digest"`
+	Group  string `json:"group"`
+	Host   string `json:"host"`
+	Live   bool   `json:"live"`
+	Placed bool   `json:"placed"`
+}
+
+func blobsLocate(env *environment, args []string) error {
+	fs := flag.NewFlagSet("blobs locate", flag.ExitOnError)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("digest required")
+	}
+
+	dgst, err := digest.ParseDigest(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	coll, err := metadata.NewBlobsCollection(env.ctx, env.cfg)
+	if err != nil {
+		return err
+	}
+
+	records, err := coll.List(&metadata.BlobEtcdKey{
+		Digest: dgst,
+	}, metadata.SortAscend)
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		return fmt.Errorf("blob not found: %s", dgst)
+	}
+
+	live, err := placement.ListLiveNodes(env.ctx)
+	if err != nil {
+		return err
+	}
+
+	// Without replication factor every node which receives replicas keeps
+	// the chunk.
+	placed, err := placement.ListNodes(env.ctx)
+	if err != nil {
+		return err
+	}
+
+	if env.cfg.Storage.ReplicationFactor > 0 {
+		placed = placement.Select(dgst, placed, env.cfg.Storage.ReplicationFactor)
+	}
+
+	res := []*blobLocation{}
+
+	t := &table{
+		Header: []string{"DIGEST", "GROUP", "HOST", "LIVE", "PLACED"},
+	}
+
+	for _, rec := range records {
+		key, err := metadata.ParseBlobsEtcdKey(rec.RawKey)
+		if err != nil {
+			return err
+		}
+
+		loc := &blobLocation{
+			Digest: key.Digest.String(),
+			Group:  key.Group,
+			Host:   key.Host,
+			Live:   placement.Contains(live, key.Group, key.Host),
+			Placed: placement.Contains(placed, key.Group, key.Host),
+		}
+
+		res = append(res, loc)
+
+		t.Append(loc.Digest, loc.Group, loc.Host, fmt.Sprintf("%t", loc.Live), fmt.Sprintf("%t", loc.Placed))
+	}
+
+	return output(env, res, t)
+}
+
+func blobsReport(env *environment, args []string) error {
+	report, err := repair.GetReport(env.ctx)
+	if err != nil {
+		return err
+	}
+
+	t := &table{
+		Header: []string{"DIGEST", "GROUPS", "REQUIRED", "MESSAGES"},
+	}
+
+	for _, blob := range report.Blobs {
+		var refs []string
+		for _, ref := range blob.Messages {
+			refs = append(refs, fmt.Sprintf("%s/%d/%s", ref.Topic, ref.Partition, ref.ID))
+		}
+
+		t.Append(blob.Digest.String(), strings.Join(blob.Groups, ","), fmt.Sprintf("%d", blob.Required), strings.Join(refs, ","))
+	}
+
+	return output(env, report, t)
+}
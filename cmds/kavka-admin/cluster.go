@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/legionus/kavka/pkg/cluster"
+	"github.com/legionus/kavka/pkg/jobs"
+	"github.com/legionus/kavka/pkg/leader"
+)
+
+var clusterNodesCmd = &command{
+	Usage: "",
+	Run:   clusterNodes,
+}
+
+var clusterLeadersCmd = &command{
+	Usage: "",
+	Run:   clusterLeaders,
+}
+
+var clusterDecommissionCmd = &command{
+	Usage: "[-etcd-member ID] <group> <node>",
+	Run:   clusterDecommission,
+}
+
+func clusterNodes(env *environment, args []string) error {
+	members, err := cluster.ListMembers(env.ctx)
+	if err != nil {
+		return err
+	}
+
+	t := &table{
+		Header: []string{"GROUP", "NODE", "ADDRESS", "LIVE", "DRAINING", "REGISTERED", "STARTED", "VERSION"},
+	}
+
+	for _, m := range members {
+		registered := ""
+		if m.Registered != nil {
+			registered = m.Registered.Format(time.RFC3339)
+		}
+
+		started := ""
+		if m.Started != nil {
+			started = m.Started.Format(time.RFC3339)
+		}
+
+		t.Append(m.Group, m.Node, m.Address, fmt.Sprintf("%t", m.Live), fmt.Sprintf("%t", m.Draining), registered, started, m.Version)
+	}
+
+	return output(env, members, t)
+}
+
+func clusterLeaders(env *environment, args []string) error {
+	elections, err := leader.List(env.ctx)
+	if err != nil {
+		return err
+	}
+
+	t := &table{
+		Header: []string{"TOPIC", "PARTITION", "GROUP", "NODE", "ADDRESS", "CANDIDATES"},
+	}
+
+	for _, e := range elections {
+		t.Append(e.Topic, fmt.Sprintf("%d", e.Partition), e.Leader.Group, e.Leader.Node, e.Leader.Address, fmt.Sprintf("%d", e.Candidates))
+	}
+
+	return output(env, elections, t)
+}
+
+func clusterDecommission(env *environment, args []string) error {
+	fs := flag.NewFlagSet("cluster decommission", flag.ExitOnError)
+	etcdMember := fs.String("etcd-member", "", "also remove the etcd member with the ID")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("group and node required")
+	}
+
+	// The decommission is performed by the server, so the request is sent
+	// to the HTTP API.
+	c, err := env.Client()
+	if err != nil {
+		return err
+	}
+
+	data, err := c.DecommissionNode(fs.Arg(0), fs.Arg(1), *etcdMember)
+	if err != nil {
+		return err
+	}
+
+	job := &jobs.Job{}
+
+	if err := json.Unmarshal(data, job); err != nil {
+		return err
+	}
+
+	t := &table{
+		Header: []string{"JOB", "NAME", "OWNER", "STATE", "STAGE"},
+	}
+	t.Append(job.Type, job.Name, job.Owner, job.State, job.Stage)
+
+	return output(env, job, t)
+}
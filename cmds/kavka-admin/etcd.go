@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/legionus/kavka/pkg/etcd"
+)
+
+var etcdMembersCmd = &command{
+	Usage: "",
+	Run:   etcdMembers,
+}
+
+func etcdMembers(env *environment, args []string) error {
+	client, err := etcd.NewEtcdClient(env.cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	resp, err := client.MemberList(env.ctx)
+	if err != nil {
+		return err
+	}
+
+	t := &table{
+		Header: []string{"ID", "NAME", "PEERS", "CLIENTS"},
+	}
+
+	for _, m := range resp.Members {
+		t.Append(
+			strconv.FormatUint(m.ID, 10),
+			m.Name,
+			strings.Join(m.PeerURLs, ","),
+			strings.Join(m.ClientURLs, ","),
+		)
+	}
+
+	return output(env, resp.Members, t)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/legionus/kavka/pkg/client"
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+)
+
+const (
+	dialTimeout = 10 * time.Second
+)
+
+var (
+	configFile = flag.String("config", "", "Path to configuration file")
+	serverAddr = flag.String("server", "", "Address of the kavka HTTP API (default is the address from the configuration)")
+	outputFmt  = flag.String("output", "table", "Output format: table or json")
+)
+
+type environment struct {
+	ctx    context.Context
+	cfg    *config.Config
+	server string
+	json   bool
+}
+
+// Client returns the HTTP API client.
+func (e *environment) Client() (*client.Client, error) {
+	c, err := client.New(e.server, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c.SetToken(e.cfg.Tenants.AdminToken)
+	return c, nil
+}
+
+type command struct {
+	Usage string
+	Run   func(env *environment, args []string) error
+}
+
+var commands = map[string]map[string]*command{
+	"topics": {
+		"list":     topicsListCmd,
+		"describe": topicsDescribeCmd,
+		"create":   topicsCreateCmd,
+		"delete":   topicsDeleteCmd,
+		"alter":    topicsAlterCmd,
+		"export":   topicsExportCmd,
+		"import":   topicsImportCmd,
+	},
+	"cluster": {
+		"nodes":        clusterNodesCmd,
+		"decommission": clusterDecommissionCmd,
+		"leaders":      clusterLeadersCmd,
+	},
+	"blobs": {
+		"locate": blobsLocateCmd,
+		"report": blobsReportCmd,
+	},
+	"messages": {
+		"show": messagesShowCmd,
+	},
+	"etcd": {
+		"members": etcdMembersCmd,
+	},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] <group> <command> [arguments]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+
+	var groups []string
+	for group := range commands {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		var names []string
+		for name := range commands[group] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "  %s %s %s\n", group, name, commands[group][name].Usage)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "\nOptions:\n")
+	flag.PrintDefaults()
+}
+
+// apiAddress returns the address suitable to connect to the server.
+func apiAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[args[0]][args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	cfgFile := "./config.yaml"
+
+	if v := os.Getenv("KAVKA_CONFIG"); v != "" {
+		cfgFile = v
+	}
+	if *configFile != "" {
+		cfgFile = *configFile
+	}
+	if cfgFile == "" {
+		log.Fatal("Config file not found")
+	}
+
+	cfg, err := config.NewConfig(cfgFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	env := &environment{
+		ctx:    context.WithValue(context.Background(), config.AppConfigContextVar, cfg),
+		cfg:    cfg,
+		server: *serverAddr,
+	}
+
+	if env.server == "" {
+		env.server = apiAddress(cfg.Global.Address)
+	}
+
+	switch strings.ToLower(*outputFmt) {
+	case "json":
+		env.json = true
+	case "table":
+	default:
+		log.Fatalf("unknown output format: %s", *outputFmt)
+	}
+
+	if err := cmd.Run(env, args[2:]); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+var messagesShowCmd = &command{
+	Usage: "[-body] <topic> <partition> <offset>",
+	Run:   messagesShow,
+}
+
+func messagesShow(env *environment, args []string) error {
+	fs := flag.NewFlagSet("messages show", flag.ExitOnError)
+	withBody := fs.Bool("body", false, "print the message body obtained from the HTTP API")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		return fmt.Errorf("topic, partition and offset required")
+	}
+
+	partition, err := strconv.ParseInt(fs.Arg(1), 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad partition: %s", err)
+	}
+
+	offset, err := strconv.ParseInt(fs.Arg(2), 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad offset: %s", err)
+	}
+
+	coll, err := metadata.NewQueuesCollection(env.ctx, env.cfg)
+	if err != nil {
+		return err
+	}
+
+	rec, err := coll.Get(&metadata.QueueEtcdKey{
+		Topic:     fs.Arg(0),
+		Partition: partition,
+		Offset:    offset,
+	})
+	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			return fmt.Errorf("message not found")
+		}
+		return err
+	}
+
+	msg, err := message.ParseMessageInfo(rec.Value)
+	if err != nil {
+		return err
+	}
+
+	t := &table{
+		Header: []string{"FIELD", "VALUE"},
+	}
+	t.Append("id", msg.ID)
+	t.Append("key", msg.Key)
+	t.Append("tombstone", strconv.FormatBool(msg.Tombstone))
+	t.Append("created", msg.CreationTime)
+
+	var names []string
+	for name := range msg.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t.Append("header."+name, msg.Headers[name])
+	}
+
+	for i, blob := range msg.Blobs {
+		t.Append(fmt.Sprintf("chunk.%d", i), fmt.Sprintf("%s %d", blob.Digest, blob.Size))
+	}
+
+	if err := output(env, msg, t); err != nil {
+		return err
+	}
+
+	if !*withBody {
+		return nil
+	}
+
+	c, err := env.Client()
+	if err != nil {
+		return err
+	}
+
+	body, err := c.GetMessage(fs.Arg(0), partition, offset)
+	if err != nil {
+		return err
+	}
+
+	if !env.json {
+		fmt.Println(strings.Repeat("-", 8))
+	}
+
+	_, err = os.Stdout.Write(body)
+	return err
+}
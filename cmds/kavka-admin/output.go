@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// table is the tabular representation of the command result.
+type table struct {
+	Header []string
+	Rows   [][]string
+}
+
+func (t *table) Append(row ...string) {
+	t.Rows = append(t.Rows, row)
+}
+
+func (t *table) Print() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(w, strings.Join(t.Header, "\t"))
+	for _, row := range t.Rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+
+	w.Flush()
+}
+
+// output prints the result of the command. The value is used for JSON
+// output and the table for the table output.
+func output(env *environment, value interface{}, t *table) error {
+	if !env.json {
+		t.Print()
+		return nil
+	}
+
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(data))
+	return nil
+}
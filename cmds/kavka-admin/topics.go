@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/jobs"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/queue"
+	"github.com/legionus/kavka/pkg/tenant"
+)
+
+var topicsListCmd = &command{
+	Usage: "",
+	Run:   topicsList,
+}
+
+var topicsDescribeCmd = &command{
+	Usage: "<topic>",
+	Run:   topicsDescribe,
+}
+
+var topicsCreateCmd = &command{
+	Usage: "[-partitions N] <topic>",
+	Run:   topicsCreate,
+}
+
+var topicsDeleteCmd = &command{
+	Usage: "<topic>",
+	Run:   topicsDelete,
+}
+
+var topicsAlterCmd = &command{
+	Usage: "[-partitions N] [-config JSON] <topic>",
+	Run:   topicsAlter,
+}
+
+// topicArg parses the command flags and returns the topic name.
+func topicArg(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("topic name required")
+	}
+	if !config.ValidTopicName(fs.Arg(0)) {
+		return "", fmt.Errorf("bad topic name: %q", fs.Arg(0))
+	}
+	return fs.Arg(0), nil
+}
+
+type topicInfo struct {
+	Topic      string `json:"topic"`
+	Partitions int64  `json:"partitions"`
+}
+
+func topicsList(env *environment, args []string) error {
+	coll, err := metadata.NewTopicsCollection(env.ctx, env.cfg)
+	if err != nil {
+		return err
+	}
+
+	records, err := coll.List(&metadata.TopicEtcdKey{
+		Topic:     metadata.NoString,
+		Partition: metadata.NoPartition,
+	})
+	if err != nil {
+		return err
+	}
+
+	topics := make(map[string]int64)
+
+	for _, rec := range records {
+		key, err := metadata.ParseTopicEtcdKey(rec.RawKey)
+		if err != nil {
+			return err
+		}
+		if _, ok := topics[key.Topic]; !ok {
+			topics[key.Topic] = 0
+		}
+		if key.Partition >= topics[key.Topic] {
+			topics[key.Topic] = key.Partition + 1
+		}
+	}
+
+	var names []string
+	for name := range topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := []*topicInfo{}
+	for _, name := range names {
+		res = append(res, &topicInfo{
+			Topic:      name,
+			Partitions: topics[name],
+		})
+	}
+
+	t := &table{
+		Header: []string{"TOPIC", "PARTITIONS"},
+	}
+	for _, info := range res {
+		t.Append(info.Topic, strconv.FormatInt(info.Partitions, 10))
+	}
+
+	return output(env, res, t)
+}
+
+type partitionInfo struct {
+	Partition    int64 `json:"partition"`
+	OffsetOldest int64 `json:"offset-oldest"`
+	OffsetNewest int64 `json:"offset-newest"`
+}
+
+type topicDescription struct {
+	Topic      string              `json:"topic"`
+	Partitions []*partitionInfo    `json:"partitions"`
+	Overrides  *config.TopicConfig `json:"overrides"`
+	Effective  *config.TopicConfig `json:"effective"`
+	Job        *jobs.Job           `json:"deletion,omitempty"`
+}
+
+func topicsDescribe(env *environment, args []string) error {
+	fs := flag.NewFlagSet("topics describe", flag.ExitOnError)
+
+	topic, err := topicArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	topicsColl, err := metadata.NewTopicsCollection(env.ctx, env.cfg)
+	if err != nil {
+		return err
+	}
+
+	queuesColl, err := metadata.NewQueuesCollection(env.ctx, env.cfg)
+	if err != nil {
+		return err
+	}
+
+	count, err := metadata.GetPartitionsCount(topicsColl, topic)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return fmt.Errorf("topic not found: %s", topic)
+	}
+
+	overrides, err := metadata.GetTopicOverrides(topicsColl, topic)
+	if err != nil {
+		return err
+	}
+
+	res := &topicDescription{
+		Topic:     topic,
+		Overrides: overrides,
+		Effective: config.NewTopicConfig(overrides.Apply(&env.cfg.Topic, topic), topic),
+	}
+
+	for i := int64(0); i < count; i++ {
+		info := &partitionInfo{
+			Partition: i,
+		}
+
+		info.OffsetOldest, info.OffsetNewest, err = queue.GetCornerOffsets(queuesColl, topic, i)
+		if err != nil && err != metadata.ErrKeyNotFound {
+			return err
+		}
+
+		res.Partitions = append(res.Partitions, info)
+	}
+
+	job, err := jobs.Get(env.ctx, jobs.TopicDeletion, topic)
+	switch err {
+	case nil:
+		res.Job = job
+	case jobs.ErrJobNotFound:
+	default:
+		return err
+	}
+
+	t := &table{
+		Header: []string{"PARTITION", "OLDEST", "NEWEST"},
+	}
+	for _, info := range res.Partitions {
+		t.Append(
+			strconv.FormatInt(info.Partition, 10),
+			strconv.FormatInt(info.OffsetOldest, 10),
+			strconv.FormatInt(info.OffsetNewest, 10),
+		)
+	}
+
+	if !env.json {
+		effective, err := json.Marshal(res.Effective)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Topic: %s\nConfig: %s\n", topic, effective)
+		if res.Job != nil {
+			fmt.Printf("Deletion: %s %s\n", res.Job.State, res.Job.Stage)
+		}
+		fmt.Println()
+	}
+
+	return output(env, res, t)
+}
+
+func topicsCreate(env *environment, args []string) error {
+	fs := flag.NewFlagSet("topics create", flag.ExitOnError)
+	partitions := fs.Int64("partitions", 1, "number of partitions")
+
+	topic, err := topicArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if err := tenant.CheckTopic(env.ctx, topic); err != nil {
+		return fmt.Errorf("%s: %s", topic, err)
+	}
+
+	coll, err := metadata.NewTopicsCollection(env.ctx, env.cfg)
+	if err != nil {
+		return err
+	}
+
+	val := fmt.Sprintf("%s", time.Now())
+	key := &metadata.TopicEtcdKey{
+		Topic:     topic,
+		Partition: 0,
+	}
+
+	t := &table{
+		Header: []string{"TOPIC", "PARTITION"},
+	}
+
+	for i := int64(0); i < *partitions; i++ {
+		key.Partition = i
+
+		if err := MakeKey(coll, key, val); err != nil {
+			return err
+		}
+
+		t.Append(topic, strconv.FormatInt(i, 10))
+	}
+
+	return output(env, &topicInfo{
+		Topic:      topic,
+		Partitions: *partitions,
+	}, t)
+}
+
+func topicsDelete(env *environment, args []string) error {
+	fs := flag.NewFlagSet("topics delete", flag.ExitOnError)
+
+	topic, err := topicArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	// The deletion is performed by the server, so the request is sent to
+	// the HTTP API.
+	c, err := env.Client()
+	if err != nil {
+		return err
+	}
+
+	data, err := c.DeleteTopic(topic)
+	if err != nil {
+		return err
+	}
+
+	job := &jobs.Job{}
+
+	if err := json.Unmarshal(data, job); err != nil {
+		return err
+	}
+
+	t := &table{
+		Header: []string{"JOB", "NAME", "OWNER", "STATE", "STAGE"},
+	}
+	t.Append(job.Type, job.Name, job.Owner, job.State, job.Stage)
+
+	return output(env, job, t)
+}
+
+func topicsAlter(env *environment, args []string) error {
+	fs := flag.NewFlagSet("topics alter", flag.ExitOnError)
+	partitions := fs.Int64("partitions", 0, "new number of partitions")
+	overrides := fs.String("config", "", "topic settings in JSON (e.g. '{\"max-message-size\":1024}')")
+
+	topic, err := topicArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if *partitions == 0 && *overrides == "" {
+		return fmt.Errorf("nothing to change")
+	}
+
+	coll, err := metadata.NewTopicsCollection(env.ctx, env.cfg)
+	if err != nil {
+		return err
+	}
+
+	if *overrides != "" {
+		topicCfg, err := metadata.GetTopicOverrides(coll, topic)
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal([]byte(*overrides), topicCfg); err != nil {
+			return fmt.Errorf("bad topic config: %s", err)
+		}
+
+		if err := topicCfg.Validate(); err != nil {
+			return fmt.Errorf("bad topic config: %s", err)
+		}
+
+		if topicCfg.MaxChunkSize != nil {
+			if err := env.cfg.Topic.CheckMaxChunkSize(*topicCfg.MaxChunkSize); err != nil {
+				return fmt.Errorf("bad topic config: %s", err)
+			}
+		}
+
+		if topicCfg.WriteConcern != nil {
+			if err := env.cfg.Storage.CheckWriteConcern(*topicCfg.WriteConcern); err != nil {
+				return fmt.Errorf("bad topic config: %s", err)
+			}
+		}
+
+		if err := metadata.PutTopicOverrides(coll, topic, topicCfg); err != nil {
+			return err
+		}
+	}
+
+	if *partitions > 0 {
+		err := metadata.AddPartitions(env.ctx, env.cfg, topic, *partitions, fmt.Sprintf("%s", time.Now()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return topicsDescribe(env, []string{topic})
+}
+
+func MakeKey(coll metadata.EtcdCollection, key metadata.EtcdKey, value string) error {
+	if _, err := coll.Get(key); err != nil {
+		if err != metadata.ErrKeyNotFound {
+			return err
+		}
+		return coll.Put(key, value)
+	}
+	return nil
+}
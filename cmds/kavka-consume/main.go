@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/legionus/kavka/pkg/client"
+)
+
+var (
+	serverAddr   = flag.String("server", "127.0.0.1:8080", "Address of the kavka HTTP API")
+	token        = flag.String("token", os.Getenv("KAVKA_TOKEN"), "tenant token")
+	topic        = flag.String("topic", "", "topic name")
+	partition    = flag.Int64("partition", 0, "partition number")
+	offset       = flag.Int64("offset", -1, "start from the absolute offset")
+	relative     = flag.String("relative", "", "start from the position relative to the beginning (positive) or end (negative) of the partition")
+	follow       = flag.Bool("follow", false, "wait for new messages")
+	pollInterval = flag.Duration("poll-interval", time.Second, "interval between checks for new messages in follow mode")
+	limit        = flag.Int64("limit", 0, "stop after the number of messages (0 means no limit)")
+	printOffset  = flag.Bool("print-offset", false, "print offset of the message")
+	printHeaders = flag.Bool("print-headers", false, "print key and headers of the message")
+	outputFmt    = flag.String("output", "raw", "Output format: raw or json")
+)
+
+type jsonMessage struct {
+	Topic     string            `json:"topic"`
+	Partition int64             `json:"partition"`
+	Offset    *int64            `json:"offset,omitempty"`
+	Key       string            `json:"key,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      string            `json:"body"`
+}
+
+func main() {
+	flag.Parse()
+
+	if *topic == "" {
+		log.Fatal("topic name required")
+	}
+
+	if *outputFmt != "raw" && *outputFmt != "json" {
+		log.Fatalf("unknown output format: %s", *outputFmt)
+	}
+
+	c, err := client.New(*serverAddr, 10*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.SetToken(*token)
+
+	if err := consume(c); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// startOffset returns the offset of the first message to read.
+func startOffset(c *client.Client) (int64, error) {
+	if *relative == "" {
+		if *offset >= 0 {
+			return *offset, nil
+		}
+		return 0, nil
+	}
+
+	position, err := strconv.ParseInt(*relative, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad relative position: %s", err)
+	}
+
+	msg, err := c.FetchRelative(*topic, *partition, position)
+	if err == nil {
+		return msg.Offset, nil
+	}
+
+	rangeErr, ok := err.(*client.OffsetRangeError)
+	if !ok {
+		return 0, err
+	}
+
+	if position >= 0 {
+		return rangeErr.OffsetOldest + position, nil
+	}
+
+	if rangeErr.OffsetNewest+position < rangeErr.OffsetOldest {
+		return rangeErr.OffsetOldest, nil
+	}
+
+	return rangeErr.OffsetNewest + position, nil
+}
+
+func consume(c *client.Client) error {
+	next, err := startOffset(c)
+	if err != nil {
+		return err
+	}
+
+	for count := int64(0); *limit == 0 || count < *limit; {
+		msg, err := c.Fetch(*topic, *partition, next)
+		if err != nil {
+			rangeErr, ok := err.(*client.OffsetRangeError)
+			if !ok {
+				return err
+			}
+
+			if next < rangeErr.OffsetOldest {
+				// Messages have been removed by cleanup.
+				next = rangeErr.OffsetOldest
+				continue
+			}
+
+			if rangeErr.OffsetNewest > next {
+				next = rangeErr.OffsetNewest
+			}
+
+			if !*follow {
+				return nil
+			}
+
+			time.Sleep(*pollInterval)
+			continue
+		}
+
+		if err := printMessage(msg); err != nil {
+			return err
+		}
+
+		next = msg.Offset + 1
+		count++
+	}
+
+	return nil
+}
+
+func printMessage(msg *client.Message) error {
+	if *outputFmt == "json" {
+		out := &jsonMessage{
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Body:      string(msg.Body),
+		}
+
+		if *printOffset {
+			out.Offset = &msg.Offset
+		}
+
+		if *printHeaders {
+			out.Key = msg.Key
+			out.Headers = msg.Headers
+		}
+
+		data, err := json.Marshal(out)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(data))
+		return nil
+	}
+
+	var prefix []string
+
+	if *printOffset {
+		prefix = append(prefix, strconv.FormatInt(msg.Offset, 10))
+	}
+
+	if *printHeaders {
+		fields := []string{"key=" + msg.Key}
+
+		var names []string
+		for name := range msg.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fields = append(fields, name+"="+msg.Headers[name])
+		}
+
+		prefix = append(prefix, strings.Join(fields, ","))
+	}
+
+	if len(prefix) > 0 {
+		fmt.Print(strings.Join(prefix, "\t") + "\t")
+	}
+
+	os.Stdout.Write(msg.Body)
+	fmt.Println()
+
+	return nil
+}
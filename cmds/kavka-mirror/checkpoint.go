@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// checkpoints keeps the next source offset of every mirrored partition. The
+// checkpoints are used to resume mirroring when the destination partition
+// does not contain the messages of this source (e.g. they have been removed
+// by the retention).
+type checkpoints struct {
+	file string
+
+	mu      sync.Mutex
+	offsets map[string]int64
+	dirty   bool
+}
+
+func loadCheckpoints(file string) (*checkpoints, error) {
+	c := &checkpoints{
+		file:    file,
+		offsets: make(map[string]int64),
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &c.offsets); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Get returns the next source offset of the partition.
+func (c *checkpoints) Get(id string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	offset, ok := c.offsets[id]
+	return offset, ok
+}
+
+// Set records the next source offset of the partition. The checkpoints are
+// written to the file by Run.
+func (c *checkpoints) Set(id string, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.offsets[id] == offset {
+		return
+	}
+
+	c.offsets[id] = offset
+	c.dirty = true
+}
+
+// Save writes the changed checkpoints to the file. The file is replaced
+// atomically.
+func (c *checkpoints) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c.offsets)
+	if err != nil {
+		return err
+	}
+
+	tmp := c.file + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, c.file); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// Run saves the checkpoints periodically until the stop channel is closed.
+// The checkpoints are saved once more before Run returns.
+func (c *checkpoints) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			if err := c.Save(); err != nil {
+				log.Errorf("unable to save checkpoints: %s", err)
+			}
+			return
+		}
+
+		if err := c.Save(); err != nil {
+			log.Errorf("unable to save checkpoints: %s", err)
+		}
+	}
+}
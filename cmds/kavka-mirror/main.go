@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/legionus/kavka/pkg/client"
+)
+
+var (
+	sourceAddr   = flag.String("source", "", "Address of the source kavka HTTP API")
+	sourceToken  = flag.String("source-token", os.Getenv("KAVKA_SOURCE_TOKEN"), "tenant token on the source cluster")
+	destAddr     = flag.String("destination", "", "Address of the destination kavka HTTP API")
+	destToken    = flag.String("destination-token", os.Getenv("KAVKA_DESTINATION_TOKEN"), "tenant token on the destination cluster")
+	name         = flag.String("name", "", "name of the source recorded in mirrored messages (default is the source address)")
+	include      = flag.String("include", ".*", "regular expression of topics to mirror")
+	exclude      = flag.String("exclude", "", "regular expression of topics to skip")
+	topicPrefix  = flag.String("topic-prefix", "", "prefix added to the destination topic names")
+	pollInterval = flag.Duration("poll-interval", time.Second, "interval between checks for new messages")
+	refresh      = flag.Duration("refresh-interval", time.Minute, "interval between checks for new topics and partitions")
+	resumeScan   = flag.Int64("resume-scan", 1000, "number of destination messages checked to find the resume offset")
+	checkpoint   = flag.String("checkpoint", "kavka-mirror.checkpoint", "file to store the source offsets of mirrored partitions")
+)
+
+func main() {
+	flag.Parse()
+
+	if *sourceAddr == "" || *destAddr == "" {
+		log.Fatal("source and destination required")
+	}
+
+	src, err := client.New(*sourceAddr, 10*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src.SetToken(*sourceToken)
+
+	dst, err := client.New(*destAddr, 10*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dst.SetToken(*destToken)
+
+	cp, err := loadCheckpoints(*checkpoint)
+	if err != nil {
+		log.Fatalf("unable to load checkpoints: %s", err)
+	}
+
+	stop := make(chan struct{})
+	saved := make(chan struct{})
+
+	go func() {
+		cp.Run(*pollInterval, stop)
+		close(saved)
+	}()
+
+	m := &mirror{
+		name:         *name,
+		src:          src,
+		dst:          dst,
+		topicPrefix:  *topicPrefix,
+		pollInterval: *pollInterval,
+		resumeScan:   *resumeScan,
+		checkpoints:  cp,
+		running:      make(map[string]struct{}),
+	}
+
+	if m.name == "" {
+		m.name = *sourceAddr
+	}
+
+	if m.include, err = regexp.Compile("^(" + *include + ")$"); err != nil {
+		log.Fatalf("bad include pattern: %s", err)
+	}
+
+	if *exclude != "" {
+		if m.exclude, err = regexp.Compile("^(" + *exclude + ")$"); err != nil {
+			log.Fatalf("bad exclude pattern: %s", err)
+		}
+	}
+
+	go func() {
+		for {
+			if err := m.startPartitions(); err != nil {
+				log.Errorf("unable to list source topics: %s", err)
+			}
+			time.Sleep(*refresh)
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	log.Infof("stopping on signal %s", <-sig)
+
+	close(stop)
+	<-saved
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/legionus/kavka/pkg/client"
+)
+
+// Headers added to the mirrored messages. The source offset is stored in the
+// same message, so the mirroring can be resumed from the last message
+// written to the destination partition.
+const (
+	sourceHeader = "mirror-source"
+	offsetHeader = "mirror-offset"
+)
+
+type mirror struct {
+	name         string
+	src          *client.Client
+	dst          *client.Client
+	include      *regexp.Regexp
+	exclude      *regexp.Regexp
+	topicPrefix  string
+	pollInterval time.Duration
+	resumeScan   int64
+	checkpoints  *checkpoints
+
+	mu      sync.Mutex
+	running map[string]struct{}
+}
+
+func (m *mirror) matchTopic(topic string) bool {
+	if !m.include.MatchString(topic) {
+		return false
+	}
+	if m.exclude != nil && m.exclude.MatchString(topic) {
+		return false
+	}
+	return true
+}
+
+// startPartitions starts mirroring of the new partitions of the matching
+// topics.
+func (m *mirror) startPartitions() error {
+	topics, err := m.src.Topics()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, info := range topics {
+		if !m.matchTopic(info.Topic) {
+			continue
+		}
+
+		for i := int64(0); i < info.Partitions; i++ {
+			id := fmt.Sprintf("%s/%d", info.Topic, i)
+
+			if _, ok := m.running[id]; ok {
+				continue
+			}
+			m.running[id] = struct{}{}
+
+			log.Infof("Start mirroring of %s", id)
+			go m.mirrorPartition(info.Topic, i)
+		}
+	}
+
+	return nil
+}
+
+func (m *mirror) mirrorPartition(topic string, partition int64) {
+	for {
+		if err := m.copyPartition(topic, partition); err != nil {
+			log.Errorf("mirroring of %s/%d fails: %s", topic, partition, err)
+		}
+		time.Sleep(m.pollInterval)
+	}
+}
+
+// resumeOffset returns the source offset of the next message to mirror. The
+// last messages of the destination partition are checked for the offset
+// recorded by this source. The checkpoint is used if the destination does not
+// contain the messages of this source.
+func (m *mirror) resumeOffset(id string, topic string, partition int64) (int64, error) {
+	checkpoint, hasCheckpoint := m.checkpoints.Get(id)
+
+	source, found, err := m.findSourceOffset(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case found && hasCheckpoint && checkpoint > source+1:
+		return checkpoint, nil
+	case found:
+		return source + 1, nil
+	case hasCheckpoint:
+		return checkpoint, nil
+	}
+
+	empty, err := m.isEmpty(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+
+	// Nothing has been mirrored yet.
+	if empty {
+		return 0, nil
+	}
+
+	return 0, fmt.Errorf("no messages of %s found among the last %d messages of %s/%d and no checkpoint",
+		m.name, m.resumeScan, topic, partition)
+}
+
+// findSourceOffset returns the last source offset recorded by this source in
+// the destination partition.
+func (m *mirror) findSourceOffset(topic string, partition int64) (int64, bool, error) {
+	last, err := m.dst.FetchRelative(topic, partition, -1)
+	if err != nil {
+		if _, ok := err.(*client.OffsetRangeError); ok {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	for offset := last.Offset; offset >= 0 && last.Offset-offset < m.resumeScan; offset-- {
+		msg := last
+
+		if offset != last.Offset {
+			msg, err = m.dst.Fetch(topic, partition, offset)
+			if err != nil {
+				if rangeErr, ok := err.(*client.OffsetRangeError); ok && offset < rangeErr.OffsetOldest {
+					break
+				}
+				return 0, false, err
+			}
+
+			// The message has been removed and the next one is returned.
+			if msg.Offset != offset {
+				continue
+			}
+		}
+
+		if msg.Headers[sourceHeader] != m.name {
+			continue
+		}
+
+		source, err := strconv.ParseInt(msg.Headers[offsetHeader], 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("bad source offset at %s/%d/%d: %s", topic, partition, offset, err)
+		}
+
+		return source, true, nil
+	}
+
+	return 0, false, nil
+}
+
+// isEmpty returns true if nothing has ever been written to the destination
+// partition.
+func (m *mirror) isEmpty(topic string, partition int64) (bool, error) {
+	_, err := m.dst.Fetch(topic, partition, 0)
+	if err == nil {
+		return false, nil
+	}
+
+	rangeErr, ok := err.(*client.OffsetRangeError)
+	if !ok {
+		return false, err
+	}
+
+	return rangeErr.OffsetNewest == 0, nil
+}
+
+func (m *mirror) copyPartition(topic string, partition int64) error {
+	dstTopic := m.topicPrefix + topic
+	id := fmt.Sprintf("%s/%d", topic, partition)
+
+	next, err := m.resumeOffset(id, dstTopic, partition)
+	if err != nil {
+		return fmt.Errorf("unable to find resume offset: %s", err)
+	}
+
+	for {
+		msg, err := m.src.Fetch(topic, partition, next)
+		if err != nil {
+			rangeErr, ok := err.(*client.OffsetRangeError)
+			if !ok {
+				return err
+			}
+
+			if next < rangeErr.OffsetOldest {
+				log.Warnf("Messages %s/%d/%d-%d have been removed before mirroring", topic, partition, next, rangeErr.OffsetOldest-1)
+				next = rangeErr.OffsetOldest
+				continue
+			}
+
+			if rangeErr.OffsetNewest > next {
+				log.Warnf("Messages %s/%d/%d-%d are not available, skipping them", topic, partition, next, rangeErr.OffsetNewest-1)
+				next = rangeErr.OffsetNewest
+				continue
+			}
+
+			time.Sleep(m.pollInterval)
+			continue
+		}
+
+		headers := make(map[string]string)
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		headers[sourceHeader] = m.name
+		headers[offsetHeader] = strconv.FormatInt(msg.Offset, 10)
+
+		_, err = m.dst.Produce(&client.Message{
+			Topic:     dstTopic,
+			Partition: partition,
+			Key:       msg.Key,
+			Tombstone: msg.Tombstone,
+			Headers:   headers,
+			Body:      msg.Body,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to write %s/%d/%d: %s", topic, partition, msg.Offset, err)
+		}
+
+		next = msg.Offset + 1
+		m.checkpoints.Set(id, next)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/legionus/kavka/pkg/client"
+)
+
+type headersFlag map[string]string
+
+func (h headersFlag) String() string {
+	var res []string
+	for name, value := range h {
+		res = append(res, name+"="+value)
+	}
+	return strings.Join(res, ",")
+}
+
+func (h headersFlag) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i <= 0 {
+		return fmt.Errorf("header must be in the form name=value")
+	}
+	h[strings.ToLower(v[:i])] = v[i+1:]
+	return nil
+}
+
+var (
+	serverAddr = flag.String("server", "127.0.0.1:8080", "Address of the kavka HTTP API")
+	token      = flag.String("token", os.Getenv("KAVKA_TOKEN"), "tenant token")
+	topic      = flag.String("topic", "", "topic name")
+	partition  = flag.Int64("partition", -1, "partition number (by default the partition is chosen by the message key)")
+	key        = flag.String("key", "", "message key")
+	keySep     = flag.String("key-separator", "", "split every line into the message key and body by separator")
+	wholeFile  = flag.Bool("whole", false, "send the whole input as one message")
+	acks       = flag.String("acks", "", "number of groups which should confirm the write: 0, 1, ..., all (by default the write-concern of the topic)")
+	outputFmt  = flag.String("output", "raw", "Output format: raw or json")
+	headers    = headersFlag{}
+)
+
+func main() {
+	flag.Var(headers, "header", "message header in the form name=value (can be repeated)")
+	flag.Parse()
+
+	if *topic == "" {
+		log.Fatal("topic name required")
+	}
+
+	if *outputFmt != "raw" && *outputFmt != "json" {
+		log.Fatalf("unknown output format: %s", *outputFmt)
+	}
+
+	c, err := client.New(*serverAddr, 10*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.SetToken(*token)
+
+	if *wholeFile {
+		body, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := produce(c, *key, body); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		msgKey := *key
+
+		if *keySep != "" {
+			if i := strings.Index(line, *keySep); i >= 0 {
+				msgKey, line = line[:i], line[i+len(*keySep):]
+			}
+		}
+
+		if err := produce(c, msgKey, []byte(line)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func produce(c *client.Client, msgKey string, body []byte) error {
+	res, err := c.Produce(&client.Message{
+		Topic:     *topic,
+		Partition: *partition,
+		Key:       msgKey,
+		Headers:   headers,
+		Body:      body,
+		Acks:      *acks,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *outputFmt == "json" {
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("%s %d %d\n", res.Topic, res.Partition, res.Offset)
+	return nil
+}
@@ -2,25 +2,29 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"net/http"
 	"os"
-	"time"
 
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/altlinux/logfile-go"
 
 	"github.com/legionus/kavka/pkg/cleanup"
+	"github.com/legionus/kavka/pkg/cluster"
 	"github.com/legionus/kavka/pkg/config"
 	"github.com/legionus/kavka/pkg/context"
 	etcdclient "github.com/legionus/kavka/pkg/etcd"
 	etcdobserver "github.com/legionus/kavka/pkg/etcd/observer"
 	etcdserver "github.com/legionus/kavka/pkg/etcd/server"
+	"github.com/legionus/kavka/pkg/leader"
 	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/placement"
+	"github.com/legionus/kavka/pkg/repair"
+	"github.com/legionus/kavka/pkg/scheduler"
 	"github.com/legionus/kavka/pkg/storage"
 	"github.com/legionus/kavka/pkg/storage/factory"
 	"github.com/legionus/kavka/pkg/syncer"
+	"github.com/legionus/kavka/pkg/tenant"
 	"github.com/legionus/kavka/pkg/webapi"
 	"github.com/legionus/kavka/pkg/webapi/handlers"
 	"github.com/legionus/kavka/pkg/webapi/middleware/mlog"
@@ -49,30 +53,43 @@ func registerEtcd(cfg *config.Config, ctx context.Context) context.Context {
 	}
 	log.Info("Etcd ready")
 
-	nodesColl, err := metadata.NewNodesCollection(ctx, cfg)
+	if err = cluster.Register(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Info("Run etcd observer")
+	etcdObserver, err := etcdobserver.NewEtcdObserver(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
+	etcdObserver.RunEtcdObserver(metadata.BlobsEtcd)
 
-	_, err = nodesColl.Create(
-		&metadata.ClusterEtcdKey{
-			Group: cfg.Global.Group,
-			Node:  cfg.Global.Hostname,
-		},
-		fmt.Sprintf("%s", time.Now()),
-	)
+	ctx = context.WithValue(ctx, metadata.BlobsObserverContextVar, etcdObserver)
+
+	log.Info("Run cluster observer")
+	clusterObserver, err := etcdobserver.NewEtcdObserver(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
+	clusterObserver.RunEtcdObserver(metadata.ClusterEtcd)
 
-	log.Info("Run etcd observer")
-	etcdObserver, err := etcdobserver.NewEtcdObserver(cfg)
+	ctx = context.WithValue(ctx, metadata.ClusterObserverContextVar, clusterObserver)
+
+	log.Info("Run members observer")
+	membersObserver, err := etcdobserver.NewEtcdObserver(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
-	etcdObserver.RunEtcdObserver(metadata.BlobsEtcd)
+	membersObserver.RunEtcdObserver(metadata.MembersEtcd)
 
-	return context.WithValue(ctx, metadata.BlobsObserverContextVar, etcdObserver)
+	ctx = context.WithValue(ctx, metadata.MembersObserverContextVar, membersObserver)
+
+	membership, err := placement.NewMembership(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return context.WithValue(ctx, placement.AppMembershipContextVar, membership)
 }
 
 func main() {
@@ -127,21 +144,60 @@ func main() {
 	ctx = context.WithValue(ctx, storage.AppStorageDriverContextVar, storageDriver)
 	ctx = context.WithValue(ctx, webapi.HTTPEndpointsContextVar, handlers.Endpoints)
 
+	if cfg.Topic.PartitionLeaders {
+		log.Info("Run partition leaders")
+		leaders, err := leader.RunLeaders(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctx = context.WithValue(ctx, leader.AppLeadersContextVar, leaders)
+	}
+
 	log.Info("Run queue cleaner")
 	_, err = cleanup.RunCleanupQueues(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	//log.Info("Run storage cleaner")
-	//_, err = cleanup.RunCleanupStorage(ctx)
-	//if err != nil {
-	//	log.Fatal(err)
-	//}
+	log.Info("Run topic deleter")
+	_, err = cleanup.RunTopicDeleter(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Info("Run tenant accounting")
+	_, err = tenant.RunAccounting(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Info("Run message scheduler")
+	_, err = scheduler.RunScheduler(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Info("Run storage cleaner")
+	_, err = cleanup.RunCleanupStorage(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	log.Info("Run blob syncer")
 	syncer.RunSyncer(ctx)
 
+	log.Info("Run blob repair")
+	_, err = repair.RunRepair(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Info("Run node decommissioner")
+	_, err = repair.RunDecommissioner(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	log.Info("Setup http interface")
 	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		reqCtx, cancel := context.WithCancel(ctx)
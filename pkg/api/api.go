@@ -1,13 +1,52 @@
 package api
 
 var (
-	Version         = "/v1"
-	TopicsPath      = Version + "/topics"
-	BlobsPath       = Version + "/blobs"
-	InfoPath        = Version + "/info"
-	InfoTopicsPath  = InfoPath + "/topics"
-	PingPath        = "/ping"
-	JSONPath        = Version + "/json"
-	JSONTopicsPath  = JSONPath + "/topics"
-	EtcdMembersPath = Version + "/etcd/members"
+	Version            = "/v1"
+	TopicsPath         = Version + "/topics"
+	BlobsPath          = Version + "/blobs"
+	InfoPath           = Version + "/info"
+	InfoTopicsPath     = InfoPath + "/topics"
+	PingPath           = "/ping"
+	JSONPath           = Version + "/json"
+	JSONTopicsPath     = JSONPath + "/topics"
+	EtcdMembersPath    = Version + "/etcd/members"
+	WorkQueuesPath     = Version + "/queues"
+	MessagesPath       = Version + "/messages"
+	SchemasPath        = Version + "/schemas"
+	ClusterPath        = Version + "/cluster"
+	AdminPath          = Version + "/admin"
+	AdminTopicsPath    = AdminPath + "/topics"
+	AdminJobsPath      = AdminPath + "/jobs"
+	AdminTenantsPath   = AdminPath + "/tenants"
+	AdminNodesPath     = AdminPath + "/nodes"
+	AdminRepairPath    = AdminPath + "/repair"
+	AdminSyncPath      = AdminPath + "/sync"
+	AdminObserversPath = AdminPath + "/observers"
+	AdminLeadersPath   = AdminPath + "/leaders"
+)
+
+var (
+	// MessageKeyHeader contains the key of the produced message.
+	MessageKeyHeader = "X-Kavka-Key"
+	// MessageHeaderPrefix is the prefix of the custom message headers.
+	MessageHeaderPrefix = "X-Kavka-Header-"
+	// MessageTombstoneHeader marks the produced message as a deletion marker for its key.
+	MessageTombstoneHeader = "X-Kavka-Tombstone"
+	// MessageDeliverAtHeader contains the time (RFC3339) after which the produced message becomes visible.
+	MessageDeliverAtHeader = "X-Kavka-Deliver-At"
+	// MessageAcksHeader contains the number of groups ("0", "1", ..., "all") which should confirm
+	// the write of the produced message.
+	MessageAcksHeader = "X-Kavka-Acks"
+	// MessageTopicHeader contains the topic of the delivered message.
+	MessageTopicHeader = "X-Kavka-Topic"
+	// MessagePartitionHeader contains the partition of the delivered message.
+	MessagePartitionHeader = "X-Kavka-Partition"
+	// MessageCreationTimeHeader contains the creation time of the delivered message.
+	MessageCreationTimeHeader = "X-Kavka-Creation-Time"
+	// MessageOffsetHeader contains the offset of the delivered message.
+	MessageOffsetHeader = "X-Kavka-Offset"
+	// MessageReceiptHeader contains the receipt of the leased message.
+	MessageReceiptHeader = "X-Kavka-Receipt"
+	// MessageDeliveriesHeader contains the number of deliveries of the leased message.
+	MessageDeliveriesHeader = "X-Kavka-Deliveries"
 )
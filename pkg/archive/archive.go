@@ -0,0 +1,498 @@
+// Package archive implements export of the topic to a self-contained tar
+// archive and its import into another cluster.
+//
+// The archive contains the manifest, the queue records and all chunks
+// referenced by them:
+//
+//	manifest.json
+//	blobs/<algorithm>/<hex>
+//	queues/<partition>/<offset>.json
+//
+// Each chunk is written before the first queue record which refers to it, so
+// the archive can be imported in one pass.
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/queue"
+	"github.com/legionus/kavka/pkg/storage"
+	"github.com/legionus/kavka/pkg/syncer"
+)
+
+const (
+	Version = 1
+
+	ManifestName = "manifest.json"
+	BlobsDir     = "blobs"
+	QueuesDir    = "queues"
+
+	// exportBatch is the number of queue records read from etcd at once.
+	exportBatch = 100
+)
+
+// Partition describes the range of offsets [OffsetFrom, OffsetTo) exported
+// from the partition.
+type Partition struct {
+	Partition  int64 `json:"partition"`
+	OffsetFrom int64 `json:"offset-from"`
+	OffsetTo   int64 `json:"offset-to"`
+}
+
+type Manifest struct {
+	Version    int          `json:"version"`
+	Topic      string       `json:"topic"`
+	Created    time.Time    `json:"created"`
+	Partitions []*Partition `json:"partitions"`
+}
+
+// HasPartition checks whether the partition is present in the archive.
+func (m *Manifest) HasPartition(partition int64) bool {
+	for _, part := range m.Partitions {
+		if part.Partition == partition {
+			return true
+		}
+	}
+	return false
+}
+
+// Range limits the exported records. NoPartition selects all partitions.
+// Zero OffsetFrom and OffsetTo mean the oldest and the newest offsets of
+// the partition.
+type Range struct {
+	Partition  int64
+	OffsetFrom int64
+	OffsetTo   int64
+}
+
+// ImportResult describes the imported archive.
+type ImportResult struct {
+	Topic        string `json:"topic"`
+	Source       string `json:"source"`
+	Messages     int64  `json:"messages"`
+	Blobs        int64  `json:"blobs"`
+	BlobsSkipped int64  `json:"blobs-skipped"`
+}
+
+func blobName(dgst digest.Digest) string {
+	return path.Join(BlobsDir, dgst.Algorithm().String(), dgst.Hex())
+}
+
+func queueName(partition, offset int64) string {
+	return path.Join(QueuesDir, strconv.FormatInt(partition, 10), fmt.Sprintf("%020d.json", offset))
+}
+
+func parseBlobName(name string) (digest.Digest, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[0] != BlobsDir {
+		return "", fmt.Errorf("bad blob name: %s", name)
+	}
+	return digest.ParseDigest(parts[1] + ":" + parts[2])
+}
+
+func parseQueueName(name string) (int64, int64, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[0] != QueuesDir || !strings.HasSuffix(parts[2], ".json") {
+		return 0, 0, fmt.Errorf("bad queue record name: %s", name)
+	}
+
+	partition, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad queue record name: %s", name)
+	}
+
+	offset, err := strconv.ParseInt(strings.TrimSuffix(parts[2], ".json"), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad queue record name: %s", name)
+	}
+
+	return partition, offset, nil
+}
+
+func writeFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// Export writes the records of the topic and the chunks referenced by them
+// to w as a tar archive.
+func Export(ctx context.Context, w io.Writer, topic string, rng *Range) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	st, ok := ctx.Value(storage.AppStorageDriverContextVar).(storage.StorageDriver)
+	if !ok {
+		return fmt.Errorf("Unable to obtain storage driver from context")
+	}
+
+	topicsColl, err := metadata.NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	count, err := metadata.GetPartitionsCount(topicsColl, topic)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return metadata.ErrKeyNotFound
+	}
+
+	manifest := &Manifest{
+		Version: Version,
+		Topic:   topic,
+		Created: time.Now().UTC(),
+	}
+
+	for i := int64(0); i < count; i++ {
+		if rng.Partition != metadata.NoPartition && rng.Partition != i {
+			continue
+		}
+
+		offsetOldest, offsetNewest, err := queue.GetCornerOffsets(queuesColl, topic, i)
+		if err != nil {
+			if err != metadata.ErrKeyNotFound {
+				return err
+			}
+			continue
+		}
+
+		part := &Partition{
+			Partition:  i,
+			OffsetFrom: offsetOldest,
+			OffsetTo:   offsetNewest,
+		}
+
+		if rng.OffsetFrom > part.OffsetFrom {
+			part.OffsetFrom = rng.OffsetFrom
+		}
+		if rng.OffsetTo > 0 && rng.OffsetTo < part.OffsetTo {
+			part.OffsetTo = rng.OffsetTo
+		}
+		if part.OffsetFrom >= part.OffsetTo {
+			continue
+		}
+
+		manifest.Partitions = append(manifest.Partitions, part)
+	}
+
+	tw := tar.NewWriter(w)
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(tw, ManifestName, data); err != nil {
+		return err
+	}
+
+	written := make(map[digest.Digest]struct{})
+
+	for _, part := range manifest.Partitions {
+		for offset := part.OffsetFrom; offset < part.OffsetTo; offset += exportBatch {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+
+			lastOffset := offset + exportBatch
+			if lastOffset > part.OffsetTo {
+				lastOffset = part.OffsetTo
+			}
+
+			records, err := queuesColl.ListRange(
+				&metadata.QueueEtcdKey{
+					Topic:     topic,
+					Partition: part.Partition,
+					Offset:    offset,
+				},
+				&metadata.QueueEtcdKey{
+					Topic:     topic,
+					Partition: part.Partition,
+					Offset:    lastOffset,
+				},
+			)
+			if err != nil {
+				if err == metadata.ErrKeyNotFound {
+					continue
+				}
+				return err
+			}
+
+			for _, rec := range records {
+				key, err := metadata.ParseQueueEtcdKey(rec.RawKey)
+				if err != nil {
+					return err
+				}
+
+				msg, err := message.ParseMessageInfo(rec.Value)
+				if err != nil {
+					return err
+				}
+
+				if err := exportBlobs(ctx, tw, st, msg, written); err != nil {
+					return err
+				}
+
+				if err := writeFile(tw, queueName(key.Partition, key.Offset), []byte(rec.Value)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+func exportBlobs(ctx context.Context, tw *tar.Writer, st storage.StorageDriver, msg *message.MessageInfo, written map[digest.Digest]struct{}) error {
+	if err := syncer.SyncBlobSeries(ctx, msg.Blobs); err != nil {
+		return err
+	}
+
+	for _, chunk := range msg.Blobs {
+		if _, ok := written[chunk.Digest]; ok {
+			continue
+		}
+
+		blob, err := st.Read(chunk.Digest)
+		if err != nil {
+			if err == storage.ErrBlobUnknown {
+				err = fmt.Errorf("Not found: %s", chunk.Digest.String())
+			}
+			return err
+		}
+
+		if err := writeFile(tw, blobName(chunk.Digest), blob); err != nil {
+			return err
+		}
+
+		written[chunk.Digest] = struct{}{}
+	}
+
+	return nil
+}
+
+// Import restores the records from the archive into the topic. The records
+// are appended to the same partitions, so they get new offsets. The chunks
+// already known to the cluster are not stored again.
+func Import(ctx context.Context, r io.Reader, topic string) (*ImportResult, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	st, ok := ctx.Value(storage.AppStorageDriverContextVar).(storage.StorageDriver)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain storage driver from context")
+	}
+
+	blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ImportResult{
+		Topic: topic,
+	}
+
+	var manifest *Manifest
+
+	// Chunks which are present in the cluster.
+	present := make(map[digest.Digest]struct{})
+
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		name := path.Clean(hdr.Name)
+
+		if manifest == nil {
+			if name != ManifestName {
+				return nil, fmt.Errorf("archive must start with %s", ManifestName)
+			}
+
+			manifest = &Manifest{}
+
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("bad manifest: %s", err)
+			}
+
+			if manifest.Version != Version {
+				return nil, fmt.Errorf("unsupported archive version: %d", manifest.Version)
+			}
+
+			if err := makePartitions(ctx, cfg, topic, manifest); err != nil {
+				return nil, err
+			}
+
+			res.Source = manifest.Topic
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(name, BlobsDir+"/"):
+			dgst, err := parseBlobName(name)
+			if err != nil {
+				return nil, err
+			}
+
+			stored, err := importBlob(ctx, cfg, st, blobsColl, dgst, tr)
+			if err != nil {
+				return nil, err
+			}
+
+			if stored {
+				res.Blobs++
+			} else {
+				res.BlobsSkipped++
+			}
+
+			present[dgst] = struct{}{}
+
+		case strings.HasPrefix(name, QueuesDir+"/"):
+			partition, _, err := parseQueueName(name)
+			if err != nil {
+				return nil, err
+			}
+
+			if !manifest.HasPartition(partition) {
+				return nil, fmt.Errorf("partition of %s is not described in manifest", name)
+			}
+
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+
+			msg, err := message.ParseMessageInfo(string(data))
+			if err != nil {
+				return nil, fmt.Errorf("bad queue record %s: %s", name, err)
+			}
+
+			for _, chunk := range msg.Blobs {
+				if _, ok := present[chunk.Digest]; !ok {
+					return nil, fmt.Errorf("chunk %s of %s is missing in archive", chunk.Digest, name)
+				}
+			}
+
+			if err := msg.MakeRefs(ctx, topic, partition); err != nil {
+				return nil, err
+			}
+
+			if _, err := queue.CreateQueue(ctx, topic, partition, msg); err != nil {
+				return nil, err
+			}
+
+			res.Messages++
+		}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("%s not found in archive", ManifestName)
+	}
+
+	return res, nil
+}
+
+// importBlob stores the chunk unless the cluster already has it. It returns
+// true if the chunk was stored.
+func importBlob(ctx context.Context, cfg *config.Config, st storage.StorageDriver, blobsColl metadata.EtcdCollection, dgst digest.Digest, r io.Reader) (bool, error) {
+	nodes, err := blobsColl.List(&metadata.BlobEtcdKey{
+		Digest: dgst,
+	})
+	if err != nil && err != metadata.ErrKeyNotFound {
+		return false, err
+	}
+
+	if len(nodes) > 0 {
+		return false, nil
+	}
+
+	blob, err := ioutil.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+
+	if dgst.Algorithm().FromBytes(blob) != dgst {
+		return false, fmt.Errorf("chunk %s is corrupted", dgst)
+	}
+
+	if _, err := st.Write(blob); err != nil && err != storage.ErrBlobExists {
+		return false, err
+	}
+
+	_, err = blobsColl.Create(
+		&metadata.BlobEtcdKey{
+			Digest: dgst,
+			Group:  cfg.Global.Group,
+			Host:   cfg.Global.Hostname,
+		},
+		time.Now().String(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// makePartitions creates the partitions of the topic up to the highest
+// partition of the archive.
+func makePartitions(ctx context.Context, cfg *config.Config, topic string, manifest *Manifest) error {
+	last := int64(metadata.NoPartition)
+
+	for _, part := range manifest.Partitions {
+		if part.Partition > last {
+			last = part.Partition
+		}
+	}
+
+	if last == metadata.NoPartition {
+		return nil
+	}
+
+	return metadata.CreatePartitions(ctx, cfg, topic, last, time.Now().String())
+}
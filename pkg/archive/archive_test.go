@@ -0,0 +1,243 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/queue"
+	"github.com/legionus/kavka/pkg/storage"
+	"github.com/legionus/kavka/pkg/storage/factory"
+	_ "github.com/legionus/kavka/pkg/storage/inmemory"
+)
+
+func newContext(t *testing.T, cfg *config.Config) context.Context {
+	st, err := factory.Create("inmemory", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), config.AppConfigContextVar, cfg)
+	return context.WithValue(ctx, storage.AppStorageDriverContextVar, st)
+}
+
+func writeMessage(t *testing.T, ctx context.Context, topic string, partition int64, id string, body string, located bool) digest.Digest {
+	cfg := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	st := ctx.Value(storage.AppStorageDriverContextVar).(storage.StorageDriver)
+
+	dgst, err := st.Write(storage.Blob(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the chunks with the known location are considered present in
+	// the cluster by Import.
+	if located {
+		blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key := &metadata.BlobEtcdKey{
+			Digest: dgst,
+			Group:  cfg.Global.Group,
+			Host:   cfg.Global.Hostname,
+		}
+		if err := blobsColl.Put(key, time.Now().String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg := &message.MessageInfo{
+		ID:           id,
+		CreationTime: time.Now().String(),
+		Blobs: []storage.Descriptor{
+			{Digest: dgst, Size: int64(len(body))},
+		},
+	}
+
+	if err := metadata.CreatePartitions(ctx, cfg, topic, partition, time.Now().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := msg.MakeRefs(ctx, topic, partition); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := queue.CreateQueue(ctx, topic, partition, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	return dgst
+}
+
+func TestExportImport(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	ctx := newContext(t, cfg)
+
+	// Partition 0 stays empty, so the archive describes partition 1 only.
+	writeMessage(t, ctx, "src", 1, "m1", "first", true)
+	writeMessage(t, ctx, "src", 1, "m2", "second", false)
+
+	buf := &bytes.Buffer{}
+
+	err := Export(ctx, buf, "src", &Range{Partition: metadata.NoPartition})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Import(ctx, buf, "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Source != "src" || res.Messages != 2 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	if res.Blobs != 1 || res.BlobsSkipped != 1 {
+		t.Errorf("expected 1 stored and 1 skipped chunk, got %d and %d", res.Blobs, res.BlobsSkipped)
+	}
+
+	topicsColl, err := metadata.NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := metadata.GetPartitionsCount(topicsColl, "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 partitions, got %d", count)
+	}
+
+	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := queuesColl.List(&metadata.QueueEtcdKey{
+		Topic:     "dst",
+		Partition: 1,
+		Offset:    metadata.NoOffset,
+	}, metadata.SortAscend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, rec := range records {
+		msg, err := message.ParseMessageInfo(rec.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.ID)
+	}
+
+	if strings.Join(ids, ",") != "m1,m2" {
+		t.Errorf("unexpected messages: %v", ids)
+	}
+}
+
+func makeArchive(t *testing.T, manifest *Manifest, files map[string]string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFile(tw, ManifestName, data); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range files {
+		if err := writeFile(tw, name, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf
+}
+
+func TestImportErrors(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	ctx := newContext(t, cfg)
+
+	msg := &message.MessageInfo{
+		ID:           "m1",
+		CreationTime: time.Now().String(),
+		Blobs: []storage.Descriptor{
+			{Digest: digest.FromBytes([]byte("lost")), Size: 4},
+		},
+	}
+
+	testCases := []struct {
+		topic    string
+		manifest *Manifest
+		files    map[string]string
+		err      string
+	}{
+		{
+			topic: "version",
+			manifest: &Manifest{
+				Version:    Version + 1,
+				Partitions: []*Partition{{Partition: 0, OffsetTo: 1}},
+			},
+			err: "unsupported archive version",
+		},
+		{
+			topic: "missing",
+			manifest: &Manifest{
+				Version:    Version,
+				Partitions: []*Partition{{Partition: 0, OffsetTo: 1}},
+			},
+			files: map[string]string{
+				queueName(0, 0): msg.String(),
+			},
+			err: "is missing in archive",
+		},
+	}
+
+	for _, tc := range testCases {
+		_, err := Import(ctx, makeArchive(t, tc.manifest, tc.files), tc.topic)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected error %q, got %v", tc.topic, tc.err, err)
+		}
+	}
+
+	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := queuesColl.List(&metadata.QueueEtcdKey{
+		Topic:     "missing",
+		Partition: metadata.NoPartition,
+		Offset:    metadata.NoOffset,
+	})
+	if err != nil && err != metadata.ErrKeyNotFound {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("the record with the missing chunk has been imported")
+	}
+}
@@ -0,0 +1,115 @@
+package cleanup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+type queueRecord struct {
+	Key     *metadata.QueueEtcdKey
+	Message *message.MessageInfo
+	Created time.Time
+}
+
+// compactionCandidates returns records superseded by a newer record with the
+// same key and records whose latest state is a tombstone created before the
+// deadline. The tombstone is kept until then, so the consumers which are
+// behind still see the delete. Records must be sorted by offset. The newest
+// record in the partition is never returned because offsets are allocated
+// after the last existing key.
+func compactionCandidates(records []*queueRecord, deadline time.Time) []*queueRecord {
+	latest := make(map[string]int)
+
+	for i, rec := range records {
+		if rec.Message.Key == "" {
+			continue
+		}
+		latest[rec.Message.Key] = i
+	}
+
+	var res []*queueRecord
+
+	for i, rec := range records {
+		if i == len(records)-1 {
+			break
+		}
+
+		if rec.Message.Key == "" {
+			continue
+		}
+
+		if latest[rec.Message.Key] != i || (rec.Message.Tombstone && rec.Created.Before(deadline)) {
+			res = append(res, rec)
+		}
+	}
+
+	return res
+}
+
+func cleanupCompactedMessages(ctx context.Context, topicCfg *config.Topic, topicKey *metadata.TopicEtcdKey) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	queueColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	records, err := queueColl.List(&metadata.QueueEtcdKey{
+		Topic:     topicKey.Topic,
+		Partition: topicKey.Partition,
+		Offset:    metadata.NoOffset,
+	}, metadata.SortAscend)
+	if err != nil {
+		return err
+	}
+
+	var queue []*queueRecord
+
+	for _, rec := range records {
+		key, err := metadata.ParseQueueEtcdKey(rec.RawKey)
+		if err != nil {
+			logrus.Errorf("Invalid key %s: %s", rec.RawKey, err)
+			return err
+		}
+
+		msg, err := message.ParseMessageInfo(rec.Value)
+		if err != nil {
+			logrus.Errorf("Bad message: %s", err)
+			return err
+		}
+
+		creationTime, err := parseCreationTime(msg.CreationTime)
+		if err != nil {
+			logrus.Errorf("Unable to parse date: %s", err)
+			return err
+		}
+
+		queue = append(queue, &queueRecord{
+			Key:     key,
+			Message: msg,
+			Created: creationTime,
+		})
+	}
+
+	deadline := time.Now().Add(-1 * topicCfg.DeleteRetentionPeriod)
+
+	for _, rec := range compactionCandidates(queue, deadline) {
+		if err := removeMessage(ctx, rec.Key, rec.Message); err != nil {
+			logrus.Errorf("%s", err)
+			continue
+		}
+		logrus.Infof("Message compacted: %s", rec.Key.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package cleanup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+func newQueueRecord(offset int64, key string, tombstone bool) *queueRecord {
+	return &queueRecord{
+		Key: &metadata.QueueEtcdKey{
+			Topic:     "test",
+			Partition: 0,
+			Offset:    offset,
+		},
+		Message: &message.MessageInfo{
+			Key:       key,
+			Tombstone: tombstone,
+		},
+	}
+}
+
+func TestCompactionCandidates(t *testing.T) {
+	records := []*queueRecord{
+		newQueueRecord(0, "a", false),
+		newQueueRecord(1, "b", false),
+		newQueueRecord(2, "", false),
+		newQueueRecord(3, "a", false),
+		newQueueRecord(4, "c", false),
+		newQueueRecord(5, "c", true),
+		newQueueRecord(6, "b", false),
+		newQueueRecord(7, "d", true),
+	}
+
+	expect := []int64{0, 1, 4, 5}
+
+	res := compactionCandidates(records, time.Now())
+
+	if len(res) != len(expect) {
+		t.Fatalf("wrong number of candidates = %d, expected %d", len(res), len(expect))
+	}
+
+	for i, rec := range res {
+		if rec.Key.Offset != expect[i] {
+			t.Fatalf("wrong candidate = %d, expected %d", rec.Key.Offset, expect[i])
+		}
+	}
+}
+
+func TestCompactionCandidatesEmpty(t *testing.T) {
+	if res := compactionCandidates(nil, time.Now()); len(res) != 0 {
+		t.Fatalf("unexpected candidates: %d", len(res))
+	}
+}
+
+func TestCompactionCandidatesDeleteRetention(t *testing.T) {
+	now := time.Now()
+
+	records := []*queueRecord{
+		newQueueRecord(0, "a", false),
+		newQueueRecord(1, "a", true),
+		newQueueRecord(2, "b", false),
+		newQueueRecord(3, "b", true),
+		newQueueRecord(4, "c", false),
+	}
+	records[1].Created = now.Add(-2 * time.Hour)
+	records[3].Created = now
+
+	// The superseded records are removed at once, the tombstone only after
+	// the delete retention period.
+	expect := []int64{0, 1, 2}
+
+	res := compactionCandidates(records, now.Add(-time.Hour))
+
+	if len(res) != len(expect) {
+		t.Fatalf("wrong number of candidates = %d, expected %d", len(res), len(expect))
+	}
+
+	for i, rec := range res {
+		if rec.Key.Offset != expect[i] {
+			t.Fatalf("wrong candidate = %d, expected %d", rec.Key.Offset, expect[i])
+		}
+	}
+}
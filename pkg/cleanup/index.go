@@ -0,0 +1,254 @@
+package cleanup
+
+import (
+	"math"
+	"sync"
+
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/queue"
+)
+
+type indexChunk struct {
+	Size int64
+	Refs int64
+}
+
+// partitionIndex tracks the records of the partition to apply the count and
+// size limits without listing the whole partition every cycle. Every chunk is
+// counted once, so the chunks shared by several messages of the partition
+// don't inflate its size.
+type partitionIndex struct {
+	sync.Mutex
+
+	// next is the offset following the last indexed record.
+	next    int64
+	records []*queueRecord
+	chunks  map[digest.Digest]*indexChunk
+	size    int64
+	valid   bool
+}
+
+func newPartitionIndex() *partitionIndex {
+	return &partitionIndex{
+		chunks: make(map[digest.Digest]*indexChunk),
+	}
+}
+
+func (idx *partitionIndex) reset() {
+	idx.next = 0
+	idx.records = nil
+	idx.chunks = make(map[digest.Digest]*indexChunk)
+	idx.size = 0
+	idx.valid = false
+}
+
+// add appends the record. Records must be added in the order of offsets.
+func (idx *partitionIndex) add(rec *queueRecord) {
+	seen := make(map[digest.Digest]struct{})
+
+	for _, blob := range rec.Message.Blobs {
+		if _, ok := seen[blob.Digest]; ok {
+			continue
+		}
+		seen[blob.Digest] = struct{}{}
+
+		chunk, ok := idx.chunks[blob.Digest]
+		if !ok {
+			chunk = &indexChunk{
+				Size: blob.Size,
+			}
+			idx.chunks[blob.Digest] = chunk
+			idx.size += blob.Size
+		}
+		chunk.Refs++
+	}
+
+	idx.records = append(idx.records, rec)
+	idx.next = rec.Key.Offset + 1
+}
+
+// removeOldest drops the oldest record from the index.
+func (idx *partitionIndex) removeOldest() {
+	rec := idx.records[0]
+	idx.records = idx.records[1:]
+
+	seen := make(map[digest.Digest]struct{})
+
+	for _, blob := range rec.Message.Blobs {
+		if _, ok := seen[blob.Digest]; ok {
+			continue
+		}
+		seen[blob.Digest] = struct{}{}
+
+		chunk := idx.chunks[blob.Digest]
+		chunk.Refs--
+
+		if chunk.Refs == 0 {
+			delete(idx.chunks, blob.Digest)
+			idx.size -= chunk.Size
+		}
+	}
+}
+
+// exceeds checks whether the oldest record should be removed to satisfy the
+// limits. Zero value means no limit.
+func (idx *partitionIndex) exceeds(maxMessages, maxSize int64) bool {
+	if len(idx.records) == 0 {
+		return false
+	}
+	if maxMessages > 0 && int64(len(idx.records)) > maxMessages {
+		return true
+	}
+	if maxSize > 0 && idx.size >= maxSize {
+		return true
+	}
+	return false
+}
+
+// update appends the records written since the last update. If records were
+// removed by someone else, the index is rebuilt.
+func (idx *partitionIndex) update(coll metadata.EtcdCollection, topicKey *metadata.TopicEtcdKey) error {
+	firstKey := &metadata.QueueEtcdKey{
+		Topic:     topicKey.Topic,
+		Partition: topicKey.Partition,
+		Offset:    0,
+	}
+
+	if idx.valid {
+		records, err := coll.ListRange(
+			&metadata.QueueEtcdKey{
+				Topic:     topicKey.Topic,
+				Partition: topicKey.Partition,
+				Offset:    idx.next,
+			},
+			&metadata.QueueEtcdKey{
+				Topic:     topicKey.Topic,
+				Partition: topicKey.Partition,
+				Offset:    math.MaxInt64,
+			},
+		)
+		if err != nil && err != metadata.ErrKeyNotFound {
+			return err
+		}
+
+		if err := idx.addRecords(records); err != nil {
+			idx.reset()
+			return err
+		}
+
+		// Offsets are allocated after the last existing key, so the number
+		// of records before the next offset changes only if records were
+		// removed.
+		count, err := metadata.CountRange(coll, firstKey, &metadata.QueueEtcdKey{
+			Topic:     topicKey.Topic,
+			Partition: topicKey.Partition,
+			Offset:    idx.next,
+		})
+		if err != nil {
+			return err
+		}
+
+		if count == int64(len(idx.records)) {
+			return nil
+		}
+
+		// Usually the oldest records are removed by the retention period.
+		offsetOldest, _, err := queue.GetCornerOffsets(coll, topicKey.Topic, topicKey.Partition)
+		if err != nil && err != metadata.ErrKeyNotFound {
+			return err
+		}
+		if err == metadata.ErrKeyNotFound {
+			offsetOldest = idx.next
+		}
+
+		for len(idx.records) > 0 && idx.records[0].Key.Offset < offsetOldest {
+			idx.removeOldest()
+		}
+
+		if count == int64(len(idx.records)) {
+			return nil
+		}
+	}
+
+	idx.reset()
+
+	records, err := coll.List(&metadata.QueueEtcdKey{
+		Topic:     topicKey.Topic,
+		Partition: topicKey.Partition,
+		Offset:    metadata.NoOffset,
+	}, metadata.SortAscend)
+	if err != nil {
+		return err
+	}
+
+	if err := idx.addRecords(records); err != nil {
+		idx.reset()
+		return err
+	}
+
+	idx.valid = true
+	return nil
+}
+
+func (idx *partitionIndex) addRecords(records []metadata.EtcdValue) error {
+	for _, rec := range records {
+		key, err := metadata.ParseQueueEtcdKey(rec.RawKey)
+		if err != nil {
+			return err
+		}
+
+		msg, err := message.ParseMessageInfo(rec.Value)
+		if err != nil {
+			return err
+		}
+
+		idx.add(&queueRecord{
+			Key:     key,
+			Message: msg,
+		})
+	}
+	return nil
+}
+
+// partitionIndexes keeps the indexes of the partitions between cleanup cycles.
+type partitionIndexes struct {
+	sync.Mutex
+	m map[string]*partitionIndex
+}
+
+var indexes = &partitionIndexes{
+	m: make(map[string]*partitionIndex),
+}
+
+func (p *partitionIndexes) get(key string) *partitionIndex {
+	p.Lock()
+	defer p.Unlock()
+
+	idx, ok := p.m[key]
+	if !ok {
+		idx = newPartitionIndex()
+		p.m[key] = idx
+	}
+	return idx
+}
+
+func (p *partitionIndexes) drop(key string) {
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.m, key)
+}
+
+// retain drops the indexes of the partitions which no longer exist.
+func (p *partitionIndexes) retain(keys map[string]struct{}) {
+	p.Lock()
+	defer p.Unlock()
+
+	for key := range p.m {
+		if _, ok := keys[key]; !ok {
+			delete(p.m, key)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package cleanup
+
+import (
+	"testing"
+
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/storage"
+)
+
+func newChunkRecord(offset int64, chunks ...string) *queueRecord {
+	rec := newQueueRecord(offset, "", false)
+
+	for _, chunk := range chunks {
+		rec.Message.Blobs = append(rec.Message.Blobs, storage.Descriptor{
+			Digest: digest.FromBytes([]byte(chunk)),
+			Size:   int64(len(chunk)),
+		})
+	}
+	return rec
+}
+
+func TestPartitionIndexSharedChunks(t *testing.T) {
+	idx := newPartitionIndex()
+
+	idx.add(newChunkRecord(0, "aaaa", "bb"))
+	idx.add(newChunkRecord(1, "aaaa", "aaaa"))
+	idx.add(newChunkRecord(2, "cccccc"))
+
+	if idx.size != 12 {
+		t.Fatalf("wrong size = %d, expected 12", idx.size)
+	}
+
+	if idx.next != 3 {
+		t.Fatalf("wrong next offset = %d, expected 3", idx.next)
+	}
+
+	// The first chunk is still used by the second message.
+	idx.removeOldest()
+
+	if idx.size != 10 {
+		t.Fatalf("wrong size = %d, expected 10", idx.size)
+	}
+
+	idx.removeOldest()
+
+	if idx.size != 6 {
+		t.Fatalf("wrong size = %d, expected 6", idx.size)
+	}
+}
+
+func TestPartitionIndexExceeds(t *testing.T) {
+	idx := newPartitionIndex()
+
+	for i := int64(0); i < 5; i++ {
+		idx.add(newChunkRecord(i, "chunk"))
+	}
+
+	if idx.exceeds(0, 0) {
+		t.Fatalf("unexpected excess without limits")
+	}
+
+	if !idx.exceeds(4, 0) {
+		t.Fatalf("expected excess by count")
+	}
+
+	if idx.exceeds(5, 0) {
+		t.Fatalf("unexpected excess by count")
+	}
+
+	// All messages share the same chunk.
+	if idx.exceeds(0, 6) {
+		t.Fatalf("unexpected excess by size")
+	}
+
+	if !idx.exceeds(0, 5) {
+		t.Fatalf("expected excess by size")
+	}
+}
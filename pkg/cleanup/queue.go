@@ -2,6 +2,7 @@ package cleanup
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -14,6 +15,19 @@ import (
 
 const dateFormat = "2006-01-02 15:04:05 -0700 MST"
 
+// expirationPage is the number of queue records read at once while looking
+// for the expired messages.
+const expirationPage = 1000
+
+// parseCreationTime parses the time.Time.String() output. The monotonic clock
+// reading is dropped.
+func parseCreationTime(value string) (time.Time, error) {
+	if i := strings.Index(value, " m="); i >= 0 {
+		value = value[:i]
+	}
+	return time.Parse(dateFormat, value)
+}
+
 func RunCleanupQueues(ctx context.Context) (chan struct{}, error) {
 	stopChan := make(chan struct{})
 
@@ -54,34 +68,62 @@ func cleanupTopics(ctx context.Context) error {
 	}
 
 	pool := make(chan int, 10)
+	partitions := make(map[string]struct{})
 
 	for _, rec := range records {
-		go func(key string) {
+		k, err := metadata.ParseTopicEtcdKey(rec.RawKey)
+		if err != nil {
+			logrus.Errorf("Invalid key %s: %s", rec.RawKey, err)
+			continue
+		}
+
+		// Skip the topic record.
+		if k.Partition == metadata.NoPartition {
+			continue
+		}
+
+		partitions[k.String()] = struct{}{}
+
+		go func(key string, k *metadata.TopicEtcdKey) {
 			pool <- 1
-			k, _ := metadata.ParseTopicEtcdKey(key)
+			defer func() { <-pool }()
 
-			if err := cleanupExpiredMessages(ctx, k); err != nil {
-				logrus.Errorf("expired messages cleanup fails for %s: %s", key, err)
+			topicCfg, err := metadata.GetTopicConfig(ctx, cfg, k.Topic)
+			if err != nil {
+				logrus.Errorf("unable to get topic config for %s: %s", key, err)
+				return
 			}
 
-			if err := cleanupOversizedMessages(ctx, k); err != nil {
-				logrus.Errorf("oversized messages cleanup fails for %s: %s", key, err)
+			if topicCfg.HasCleanupPolicy(k.Topic, config.CleanupPolicyDelete) {
+				if err := cleanupExpiredMessages(ctx, topicCfg, k); err != nil {
+					logrus.Errorf("expired messages cleanup fails for %s: %s", key, err)
+				}
+
+				if err := cleanupExcessMessages(ctx, topicCfg, k); err != nil {
+					logrus.Errorf("partition limits cleanup fails for %s: %s", key, err)
+				}
 			}
 
-			<-pool
-		}(rec.RawKey)
+			if topicCfg.HasCleanupPolicy(k.Topic, config.CleanupPolicyCompact) {
+				if err := cleanupCompactedMessages(ctx, topicCfg, k); err != nil {
+					logrus.Errorf("compaction fails for %s: %s", key, err)
+				}
+			}
+		}(rec.RawKey, k)
 	}
 
+	indexes.retain(partitions)
+
 	return nil
 }
 
-func cleanupExpiredMessages(ctx context.Context, topicKey *metadata.TopicEtcdKey) error {
+func cleanupExpiredMessages(ctx context.Context, topicCfg *config.Topic, topicKey *metadata.TopicEtcdKey) error {
 	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
 	if !ok {
 		return fmt.Errorf("Unable to obtain config from context")
 	}
 
-	if cfg.Topic.MessageRetentionPeriod == 0 {
+	if topicCfg.MessageRetentionPeriod == 0 {
 		return nil
 	}
 
@@ -90,67 +132,60 @@ func cleanupExpiredMessages(ctx context.Context, topicKey *metadata.TopicEtcdKey
 		return err
 	}
 
-	records, err := queueColl.List(&metadata.QueueEtcdKey{
+	prefix := &metadata.QueueEtcdKey{
 		Topic:     topicKey.Topic,
 		Partition: topicKey.Partition,
 		Offset:    metadata.NoOffset,
-	}, metadata.SortAscend)
-	if err != nil {
-		return err
 	}
 
-	deadline := time.Now().Add(-1 * cfg.Topic.MessageRetentionPeriod)
+	deadline := time.Now().Add(-1 * topicCfg.MessageRetentionPeriod)
 
-	for _, rec := range records {
-		key, err := metadata.ParseQueueEtcdKey(rec.RawKey)
-		if err != nil {
-			logrus.Errorf("Invalid key %s: %s", rec.RawKey, err)
-			return err
-		}
-
-		msg, err := message.ParseMessageInfo(rec.Value)
-		if err != nil {
-			logrus.Errorf("Bad message: %s", err)
-			return err
-		}
-
-		creationTime, err := time.Parse(dateFormat, msg.CreationTime)
-		if err != nil {
-			logrus.Errorf("Unable to parse date: %s", err)
-			return err
-		}
+	return metadata.ListPages(queueColl, prefix, expirationPage, func(records []metadata.EtcdValue) error {
+		for _, rec := range records {
+			key, err := metadata.ParseQueueEtcdKey(rec.RawKey)
+			if err != nil {
+				logrus.Errorf("Invalid key %s: %s", rec.RawKey, err)
+				return err
+			}
 
-		if creationTime.After(deadline) {
-			continue
-		}
+			msg, err := message.ParseMessageInfo(rec.Value)
+			if err != nil {
+				logrus.Errorf("Bad message: %s", err)
+				return err
+			}
 
-		if err := msg.RemoveRefs(ctx, topicKey.Topic, topicKey.Partition); err != nil {
-			logrus.Errorf("Unable to remove references: %s", err)
-			continue
-		}
+			creationTime, err := parseCreationTime(msg.CreationTime)
+			if err != nil {
+				logrus.Errorf("Unable to parse date: %s", err)
+				return err
+			}
 
-		err = queueColl.Delete(key)
-		if err != nil {
-			logrus.Errorf("Unable to remove message from queue %s: %s", key.String(), err)
-			continue
-		}
-		logrus.Infof("Message expired: %s", key.String())
+			if creationTime.After(deadline) {
+				continue
+			}
 
-		if err := msg.Delete(ctx); err != nil {
-			logrus.Errorf("Unable to remove message: %s", err)
+			if err := removeMessage(ctx, key, msg); err != nil {
+				logrus.Errorf("%s", err)
+				continue
+			}
+			logrus.Infof("Message expired: %s", key.String())
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
-func cleanupOversizedMessages(ctx context.Context, topicKey *metadata.TopicEtcdKey) error {
+// cleanupExcessMessages removes the oldest messages of the partition while
+// it has more messages than MaxPartitionMessages or its size is not less than
+// MaxPartitionSize.
+func cleanupExcessMessages(ctx context.Context, topicCfg *config.Topic, topicKey *metadata.TopicEtcdKey) error {
 	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
 	if !ok {
 		return fmt.Errorf("Unable to obtain config from context")
 	}
 
-	if cfg.Topic.MaxPartitionSize == 0 {
+	if topicCfg.MaxPartitionSize == 0 && topicCfg.MaxPartitionMessages == 0 {
+		indexes.drop(topicKey.String())
 		return nil
 	}
 
@@ -159,52 +194,57 @@ func cleanupOversizedMessages(ctx context.Context, topicKey *metadata.TopicEtcdK
 		return err
 	}
 
-	records, err := queueColl.List(&metadata.QueueEtcdKey{
-		Topic:     topicKey.Topic,
-		Partition: topicKey.Partition,
-		Offset:    metadata.NoOffset,
-	}, metadata.SortDescend)
-	if err != nil {
+	idx := indexes.get(topicKey.String())
+
+	idx.Lock()
+	defer idx.Unlock()
+
+	if err := idx.update(queueColl, topicKey); err != nil {
 		return err
 	}
 
-	queueSize := uint64(0)
-
-	for _, rec := range records {
-		key, err := metadata.ParseQueueEtcdKey(rec.RawKey)
-		if err != nil {
-			logrus.Errorf("Invalid key %s: %s", rec.RawKey, err)
-			continue
-		}
+	for idx.exceeds(topicCfg.MaxPartitionMessages, topicCfg.MaxPartitionSize) {
+		rec := idx.records[0]
 
-		msg, err := message.ParseMessageInfo(rec.Value)
-		if err != nil {
-			logrus.Errorf("Unable to parse message: %s", err)
+		if err := removeMessage(ctx, rec.Key, rec.Message); err != nil {
+			idx.reset()
 			return err
 		}
+		idx.removeOldest()
 
-		for _, v := range msg.Blobs {
-			queueSize += uint64(v.Size)
-		}
+		logrus.Infof("Message removed by partition limits: %s", rec.Key.String())
+	}
 
-		if queueSize < uint64(cfg.Topic.MaxPartitionSize) {
-			continue
-		}
+	return nil
+}
 
-		if err := msg.RemoveRefs(ctx, topicKey.Topic, topicKey.Partition); err != nil {
-			logrus.Errorf("Unable to remove references: %s", err)
-			continue
-		}
+// removeMessage drops the message from the queue along with its references.
+// Chunks that are no longer referenced are removed from the storage.
+func removeMessage(ctx context.Context, key *metadata.QueueEtcdKey, msg *message.MessageInfo) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
 
-		err = queueColl.Delete(key)
-		if err != nil {
-			logrus.Errorf("Unable to remove message from queue %s: %s", key.String(), err)
-			continue
-		}
+	if err := msg.RemoveRefs(ctx, key.Topic, key.Partition); err != nil {
+		return fmt.Errorf("Unable to remove references: %s", err)
+	}
 
-		if err := msg.Delete(ctx); err != nil {
-			logrus.Errorf("Unable to remove message: %s", err)
-		}
+	txn := metadata.NewTransaction(ctx, cfg)
+	txn.Delete(key)
+	txn.Delete(&metadata.MessageEtcdKey{
+		ID:        msg.ID,
+		Topic:     key.Topic,
+		Partition: key.Partition,
+		Offset:    key.Offset,
+	})
+
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("Unable to remove message from queue %s: %s", key.String(), err)
+	}
+
+	if err := msg.Delete(ctx); err != nil {
+		logrus.Errorf("Unable to remove message: %s", err)
 	}
 
 	return nil
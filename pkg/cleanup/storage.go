@@ -13,6 +13,34 @@ import (
 	"github.com/legionus/kavka/pkg/storage"
 )
 
+// minCleanupGrace is the shortest time the chunk is kept without references.
+const minCleanupGrace = time.Minute
+
+// unreferenced contains the time when the local chunks without references
+// were found first. The chunk is removed only if it is still not referenced
+// after the grace period, so the chunks of the message being produced or
+// imported and the replicas synchronized for it are not removed before the
+// message references them.
+var unreferenced = make(map[digest.Digest]time.Time)
+
+// cleanupGrace returns how long the chunk is kept without references. The
+// write waits for the replicas of the chunks up to replication-timeout before
+// it references them.
+func cleanupGrace(cfg *config.Config) time.Duration {
+	grace := minCleanupGrace
+	if cfg.Topic.ReplicationTimeout > grace {
+		grace = cfg.Topic.ReplicationTimeout
+	}
+	if cfg.Storage.CleanupPeriod > grace {
+		grace = cfg.Storage.CleanupPeriod
+	}
+	return grace
+}
+
+// RunCleanupStorage starts the service which removes the local copies of
+// chunks which are no longer referenced by messages. Every node removes its
+// own copies, so the chunks released by the cleanup of topics are removed
+// from all nodes.
 func RunCleanupStorage(ctx context.Context) (chan struct{}, error) {
 	stopChan := make(chan struct{})
 
@@ -58,6 +86,10 @@ func CleanupStorage(ctx context.Context) error {
 		return err
 	}
 
+	candidates := make(map[digest.Digest]time.Time)
+	grace := cleanupGrace(cfg)
+	now := time.Now()
+
 	st.Iterate(func(k storage.Key, v storage.Blob) (bool, error) {
 		dgst, err := digest.ParseDigest(string(k))
 		if err != nil {
@@ -67,8 +99,11 @@ func CleanupStorage(ctx context.Context) error {
 
 		value, err := refsColl.Get(
 			&metadata.RefsEtcdKey{
-				Digest: dgst,
+				Digest:    dgst,
+				Partition: metadata.NoPartition,
+				Order:     metadata.NoOrder,
 			},
+			metadata.PrefixKey,
 			metadata.CountKey,
 		)
 		if err != nil {
@@ -80,7 +115,18 @@ func CleanupStorage(ctx context.Context) error {
 			return false, nil
 		}
 
-		logrus.Infof("storage key %s is definitely lost", dgst)
+		found, ok := unreferenced[dgst]
+		if !ok {
+			found = now
+		}
+
+		candidates[dgst] = found
+
+		if now.Sub(found) < grace {
+			return false, nil
+		}
+
+		logrus.Infof("storage key %s is no longer referenced", dgst)
 
 		blobKey := &metadata.BlobEtcdKey{
 			Digest: dgst,
@@ -100,5 +146,7 @@ func CleanupStorage(ctx context.Context) error {
 		return false, nil
 	})
 
+	unreferenced = candidates
+
 	return nil
 }
@@ -0,0 +1,32 @@
+package cleanup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/legionus/kavka/pkg/config"
+)
+
+func TestCleanupGrace(t *testing.T) {
+	tests := []struct {
+		replication time.Duration
+		cleanup     time.Duration
+		expect      time.Duration
+	}{
+		{30 * time.Second, 5 * time.Second, minCleanupGrace},
+		{5 * time.Minute, 5 * time.Second, 5 * time.Minute},
+		{30 * time.Second, 10 * time.Minute, 10 * time.Minute},
+		{0, 5 * time.Second, minCleanupGrace},
+	}
+
+	for _, test := range tests {
+		cfg := &config.Config{}
+		cfg.Topic.ReplicationTimeout = test.replication
+		cfg.Storage.CleanupPeriod = test.cleanup
+
+		if grace := cleanupGrace(cfg); grace != test.expect {
+			t.Errorf("replication-timeout %s, cleanup-period %s: expected %s, got %s",
+				test.replication, test.cleanup, test.expect, grace)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package cleanup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd"
+	"github.com/legionus/kavka/pkg/jobs"
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+// Stages of the topic deletion. The stage is saved in the job before it
+// starts, so the interrupted deletion continues from the same stage. Every
+// stage can be repeated.
+const (
+	topicStageTopics     = "topics"
+	topicStageScheduled  = "scheduled"
+	topicStageQueues     = "queues"
+	topicStageWorkQueues = "workqueues"
+)
+
+// deletionPage is the number of queue records read at once.
+const deletionPage = 100
+
+var topicStages = []string{
+	topicStageTopics,
+	topicStageScheduled,
+	topicStageQueues,
+	topicStageWorkQueues,
+}
+
+// RunTopicDeleter starts the service which processes the topic deletion
+// jobs. Any node can take over the job left by the failed node.
+func RunTopicDeleter(ctx context.Context) (chan struct{}, error) {
+	stopChan := make(chan struct{})
+
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return stopChan, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	c, err := etcd.NewEtcdClient(cfg)
+	if err != nil {
+		return stopChan, err
+	}
+
+	go func() {
+		for {
+			if err := jobs.Process(ctx, c, jobs.TopicDeletion, func(job *jobs.Job) error {
+				if err := deleteTopic(ctx, job); err != nil {
+					return err
+				}
+				logrus.Infof("Topic deleted: %s", job.Name)
+				return nil
+			}); err != nil {
+				logrus.Errorf("topic deletion fails: %s", err)
+			}
+
+			select {
+			case <-time.After(10 * time.Second):
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	return stopChan, nil
+}
+
+func deleteTopic(ctx context.Context, job *jobs.Job) error {
+	start := 0
+
+	for i, stage := range topicStages {
+		if stage == job.Stage {
+			start = i
+			break
+		}
+	}
+
+	job.State = jobs.StateRunning
+
+	for _, stage := range topicStages[start:] {
+		job.Stage = stage
+
+		if err := job.Save(ctx); err != nil {
+			return err
+		}
+
+		var err error
+
+		switch stage {
+		case topicStageTopics:
+			err = deleteTopicRecords(ctx, job)
+		case topicStageScheduled:
+			err = deleteTopicScheduled(ctx, job)
+		case topicStageQueues:
+			err = deleteTopicQueues(ctx, job)
+		case topicStageWorkQueues:
+			err = deleteTopicWorkQueues(ctx, job)
+		}
+
+		if err != nil {
+			return fmt.Errorf("stage %s: %s", stage, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteTopicRecords removes the topic and its partitions first, so the topic
+// disappears from the listing and no longer accepts messages.
+func deleteTopicRecords(ctx context.Context, job *jobs.Job) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	topicsColl, err := metadata.NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	records, err := topicsColl.List(&metadata.TopicEtcdKey{
+		Topic:     job.Name,
+		Partition: metadata.NoPartition,
+	})
+	if err != nil {
+		return err
+	}
+
+	txn := metadata.NewTransaction(ctx, cfg)
+
+	for _, rec := range records {
+		key, err := metadata.ParseTopicEtcdKey(rec.RawKey)
+		if err != nil {
+			return err
+		}
+		txn.Delete(key)
+	}
+
+	txn.Delete(&metadata.TopicEtcdKey{
+		Topic:     job.Name,
+		Partition: metadata.NoPartition,
+	})
+
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+
+	job.Progress["partitions"] += int64(len(records))
+
+	return nil
+}
+
+func deleteTopicScheduled(ctx context.Context, job *jobs.Job) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	scheduledColl, err := metadata.NewScheduledCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Scheduled records are sorted by time, so all of them are checked.
+	records, err := scheduledColl.List(&metadata.ScheduledEtcdKey{
+		Time:      metadata.NoTime,
+		Partition: metadata.NoPartition,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		key, err := metadata.ParseScheduledEtcdKey(rec.RawKey)
+		if err != nil {
+			logrus.Errorf("Invalid key %s: %s", rec.RawKey, err)
+			continue
+		}
+
+		if key.Topic != job.Name {
+			continue
+		}
+
+		msg, err := message.ParseMessageInfo(rec.Value)
+		if err != nil {
+			return err
+		}
+
+		if err := releaseMessage(ctx, key.Topic, key.Partition, msg); err != nil {
+			return err
+		}
+
+		if err := scheduledColl.Delete(key); err != nil {
+			return err
+		}
+
+		job.Progress["scheduled"]++
+	}
+
+	return nil
+}
+
+func deleteTopicQueues(ctx context.Context, job *jobs.Job) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	queueColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	prefix := &metadata.QueueEtcdKey{
+		Topic:     job.Name,
+		Partition: metadata.NoPartition,
+		Offset:    metadata.NoOffset,
+	}
+
+	// The progress is saved after every page, so the interrupted deletion
+	// reports the messages removed so far.
+	return metadata.ListPages(queueColl, prefix, deletionPage, func(records []metadata.EtcdValue) error {
+		for _, rec := range records {
+			key, err := metadata.ParseQueueEtcdKey(rec.RawKey)
+			if err != nil {
+				return err
+			}
+
+			msg, err := message.ParseMessageInfo(rec.Value)
+			if err != nil {
+				return err
+			}
+
+			if err := releaseMessage(ctx, key.Topic, key.Partition, msg); err != nil {
+				return err
+			}
+
+			// The queue record is removed last. Until then the message can be
+			// found again if the deletion is interrupted.
+			txn := metadata.NewTransaction(ctx, cfg)
+			txn.Delete(key)
+			txn.Delete(&metadata.MessageEtcdKey{
+				ID:        msg.ID,
+				Topic:     key.Topic,
+				Partition: key.Partition,
+				Offset:    key.Offset,
+			})
+
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+
+			job.Progress["messages"]++
+		}
+
+		return job.Save(ctx)
+	})
+}
+
+func deleteTopicWorkQueues(ctx context.Context, job *jobs.Job) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	workQueuesColl, err := metadata.NewWorkQueuesCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	records, err := workQueuesColl.List(&metadata.WorkQueueEtcdKey{
+		Topic:     job.Name,
+		Partition: metadata.NoPartition,
+		Offset:    metadata.NoOffset,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		key, err := metadata.ParseWorkQueueEtcdKey(rec.RawKey)
+		if err != nil {
+			return err
+		}
+
+		if err := workQueuesColl.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseMessage removes the message references and the local copies of the
+// chunks which are no longer referenced. The copies on other nodes are removed
+// by their storage cleanup (see CleanupStorage).
+func releaseMessage(ctx context.Context, topic string, partition int64, msg *message.MessageInfo) error {
+	if err := msg.RemoveRefs(ctx, topic, partition); err != nil {
+		return fmt.Errorf("Unable to remove references: %s", err)
+	}
+
+	if err := msg.Delete(ctx); err != nil {
+		return fmt.Errorf("Unable to remove message: %s", err)
+	}
+
+	return nil
+}
@@ -1,16 +1,21 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/legionus/kavka/pkg/api"
+	"github.com/legionus/kavka/pkg/transfer"
 )
 
 const (
@@ -35,6 +40,9 @@ func normalizeHost(name string) (*url.URL, error) {
 type Client struct {
 	url        url.URL
 	httpClient http.Client
+	token      string
+
+	maxChunkSize int64
 }
 
 // New returns a client object which allows public access to server.
@@ -63,6 +71,33 @@ func New(hostname string, dialTimeout time.Duration) (*Client, error) {
 	}, nil
 }
 
+// SetMaxChunkSize limits the size of chunks received by GetBlobs. Zero means
+// transfer.DefaultMaxSize.
+func (c *Client) SetMaxChunkSize(size int64) {
+	c.maxChunkSize = size
+}
+
+// SetToken sets the bearer token sent with requests: the tenant token for
+// requests to topics or the admin token for the admin API.
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.httpClient.Do(req)
+}
+
+func (c *Client) get(u *url.URL) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
 func (c *Client) Ping(dgst string) (bool, error) {
 	u := c.url
 	u.Path = api.PingPath + "/" + dgst
@@ -86,6 +121,10 @@ func (c *Client) GetBlob(dgst string) ([]byte, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting blob from %s: %s", u.String(), resp.Status)
+	}
+
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("can't read blob body from %s: %v", u.String(), err)
@@ -93,3 +132,188 @@ func (c *Client) GetBlob(dgst string) ([]byte, error) {
 
 	return body, nil
 }
+
+// GetBlobs requests the chunks in one stream and calls fn for each received
+// chunk. The data of the chunk is verified, the mismatch is passed to fn as
+// *transfer.DigestError. If fn returns an error, the transfer is stopped.
+func (c *Client) GetBlobs(dgsts []string, fn func(*transfer.Blob, error) error) error {
+	u := c.url
+	u.Path = api.BlobsPath
+
+	body, err := json.Marshal(dgsts)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("error getting blobs from %s: %v", u.String(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error getting blobs from %s: %s", u.String(), resp.Status)
+	}
+
+	r := transfer.NewReader(resp.Body)
+	r.MaxSize = c.maxChunkSize
+
+	for {
+		blob, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if _, ok := err.(*transfer.DigestError); err != nil && !ok {
+			return fmt.Errorf("can't read blobs from %s: %v", u.String(), err)
+		}
+		if err := fn(blob, err); err != nil {
+			return err
+		}
+	}
+}
+
+// Response is the envelope of the JSON API responses.
+type Response struct {
+	Data   json.RawMessage `json:"data"`
+	Status string          `json:"status"`
+}
+
+// ResponseError describes the error returned by JSON API.
+type ResponseError struct {
+	Status int    `json:"status"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+}
+
+func (e *ResponseError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%d %s", e.Status, e.Title)
+	}
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Title, e.Detail)
+}
+
+// Do sends a request to JSON API and returns the data part of the response.
+func (c *Client) Do(method, path string, query url.Values, body io.Reader) (json.RawMessage, error) {
+	u := c.url
+	u.Path = path
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting response from %s: %v", u.String(), err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("can't read response from %s: %v", u.String(), err)
+	}
+
+	var res json.RawMessage
+
+	if err := decodeResponse(data, &res); err != nil {
+		if _, ok := err.(*ResponseError); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("bad response from %s: %v", u.String(), err)
+	}
+
+	return res, nil
+}
+
+// DeleteTopic starts the topic deletion and returns the deletion job.
+func (c *Client) DeleteTopic(topic string) (json.RawMessage, error) {
+	return c.Do("DELETE", api.AdminTopicsPath+"/"+topic, nil, nil)
+}
+
+// DecommissionNode starts the node decommission and returns the job. If
+// etcdMember is not empty, the etcd member is removed as well.
+func (c *Client) DecommissionNode(group, node, etcdMember string) (json.RawMessage, error) {
+	body, err := json.Marshal(map[string]string{
+		"etcd-member": etcdMember,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.Do("POST", api.AdminNodesPath+"/"+group+"/"+node+"/decommission", nil, bytes.NewReader(body))
+}
+
+// ExportTopic writes the tar archive of the topic to w. The query may limit
+// the partition and the range of offsets.
+func (c *Client) ExportTopic(topic string, query url.Values, w io.Writer) error {
+	u := c.url
+	u.Path = api.AdminTopicsPath + "/" + topic + "/export"
+	u.RawQuery = query.Encode()
+
+	resp, err := c.get(&u)
+	if err != nil {
+		return fmt.Errorf("error getting response from %s: %v", u.String(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error exporting topic from %s: %s", u.String(), resp.Status)
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("can't read archive from %s: %v", u.String(), err)
+	}
+
+	return nil
+}
+
+// ImportTopic restores the topic from the tar archive.
+func (c *Client) ImportTopic(topic string, r io.Reader) (json.RawMessage, error) {
+	return c.Do("POST", api.AdminTopicsPath+"/"+topic+"/import", nil, r)
+}
+
+// AppendRecord appends the message record to the partition on the node. It is
+// used to forward the messages to the partition leader. The topic is the
+// internal name of the topic.
+func (c *Client) AppendRecord(topic string, partition int64, id, value string) (json.RawMessage, error) {
+	body, err := json.Marshal(map[string]string{
+		"id":    id,
+		"value": value,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.Do("POST", fmt.Sprintf("%s/%s/partitions/%d/append", api.AdminTopicsPath, topic, partition), nil, bytes.NewReader(body))
+}
+
+// GetMessage returns the body of the message.
+func (c *Client) GetMessage(topic string, partition, offset int64) ([]byte, error) {
+	u := c.url
+	u.Path = fmt.Sprintf("%s/%s/%d", api.TopicsPath, topic, partition)
+	u.RawQuery = url.Values{
+		"offset": []string{strconv.FormatInt(offset, 10)},
+	}.Encode()
+
+	resp, err := c.get(&u)
+	if err != nil {
+		return nil, fmt.Errorf("error getting message from %s: %v", u.String(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting message from %s: %s", u.String(), resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("can't read message body from %s: %v", u.String(), err)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,226 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/legionus/kavka/pkg/api"
+)
+
+// Message is the message of the topic partition.
+type Message struct {
+	Topic     string            `json:"topic"`
+	Partition int64             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       string            `json:"key,omitempty"`
+	Tombstone bool              `json:"tombstone,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      []byte            `json:"body"`
+
+	// Acks overrides the write concern of the topic when the message is
+	// produced.
+	Acks string `json:"-"`
+}
+
+// ProduceResult describes the position of the written message.
+type ProduceResult struct {
+	Topic     string `json:"topic"`
+	Partition int64  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+// OffsetRangeError is returned when the requested offset is outside of the
+// partition. OffsetNewest is the offset of the next message.
+type OffsetRangeError struct {
+	Topic        string `json:"topic"`
+	Partition    int64  `json:"partition"`
+	OffsetOldest int64  `json:"offsetfrom"`
+	OffsetNewest int64  `json:"offsetto"`
+}
+
+func (e *OffsetRangeError) Error() string {
+	return fmt.Sprintf("offset out of range (%d, %d)", e.OffsetOldest, e.OffsetNewest)
+}
+
+// Produce writes the message to the topic. If the partition is negative, the
+// server chooses the partition by the message key.
+func (c *Client) Produce(msg *Message) (*ProduceResult, error) {
+	u := c.url
+	u.Path = api.TopicsPath + "/" + msg.Topic
+
+	if msg.Partition >= 0 {
+		u.Path += "/" + strconv.FormatInt(msg.Partition, 10)
+	}
+
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(msg.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.Key != "" {
+		req.Header.Set(api.MessageKeyHeader, msg.Key)
+	}
+
+	if msg.Tombstone {
+		req.Header.Set(api.MessageTombstoneHeader, "true")
+	}
+
+	if msg.Acks != "" {
+		req.Header.Set(api.MessageAcksHeader, msg.Acks)
+	}
+
+	for name, value := range msg.Headers {
+		req.Header.Set(api.MessageHeaderPrefix+name, value)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting response from %s: %v", u.String(), err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("can't read response from %s: %v", u.String(), err)
+	}
+
+	res := &ProduceResult{}
+
+	if err := decodeResponse(data, res); err != nil {
+		return nil, fmt.Errorf("unable to write message to %s: %v", u.String(), err)
+	}
+
+	return res, nil
+}
+
+// Fetch returns the message with the offset. If the message has been removed,
+// the next one is returned.
+func (c *Client) Fetch(topic string, partition, offset int64) (*Message, error) {
+	return c.fetch(topic, partition, url.Values{
+		"offset": []string{strconv.FormatInt(offset, 10)},
+	})
+}
+
+// FetchRelative returns the message relative to the beginning (positive
+// position) or end (negative position) of the partition.
+func (c *Client) FetchRelative(topic string, partition, position int64) (*Message, error) {
+	return c.fetch(topic, partition, url.Values{
+		"relative": []string{strconv.FormatInt(position, 10)},
+	})
+}
+
+func (c *Client) fetch(topic string, partition int64, query url.Values) (*Message, error) {
+	u := c.url
+	u.Path = fmt.Sprintf("%s/%s/%d", api.TopicsPath, topic, partition)
+	u.RawQuery = query.Encode()
+
+	resp, err := c.get(&u)
+	if err != nil {
+		return nil, fmt.Errorf("error getting message from %s: %v", u.String(), err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("can't read message body from %s: %v", u.String(), err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		// There are no messages after the requested offset.
+		offset, _ := strconv.ParseInt(resp.Header.Get(api.MessageOffsetHeader), 10, 64)
+		return nil, &OffsetRangeError{
+			Topic:        topic,
+			Partition:    partition,
+			OffsetOldest: offset,
+			OffsetNewest: offset,
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		res := &Response{}
+		rangeErr := &OffsetRangeError{}
+
+		if err := json.Unmarshal(body, res); err != nil {
+			return nil, fmt.Errorf("bad response from %s: %v", u.String(), err)
+		}
+		if err := json.Unmarshal(res.Data, rangeErr); err != nil {
+			return nil, fmt.Errorf("bad response from %s: %v", u.String(), err)
+		}
+		return nil, rangeErr
+	default:
+		return nil, fmt.Errorf("error getting message from %s: %s", u.String(), resp.Status)
+	}
+
+	msg := &Message{
+		Topic:     topic,
+		Partition: partition,
+		Key:       resp.Header.Get(api.MessageKeyHeader),
+		Tombstone: resp.Header.Get(api.MessageTombstoneHeader) == "true",
+		Body:      body,
+	}
+
+	msg.Offset, err = strconv.ParseInt(resp.Header.Get(api.MessageOffsetHeader), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad offset from %s: %v", u.String(), err)
+	}
+
+	for name := range resp.Header {
+		if !strings.HasPrefix(name, api.MessageHeaderPrefix) {
+			continue
+		}
+		if msg.Headers == nil {
+			msg.Headers = make(map[string]string)
+		}
+		msg.Headers[strings.ToLower(strings.TrimPrefix(name, api.MessageHeaderPrefix))] = resp.Header.Get(name)
+	}
+
+	return msg, nil
+}
+
+// decodeResponse decodes the data part of the JSON API response.
+func decodeResponse(data []byte, v interface{}) error {
+	res := &Response{}
+
+	if err := json.Unmarshal(data, res); err != nil {
+		return err
+	}
+
+	if res.Status != "success" {
+		respErr := &ResponseError{}
+
+		if err := json.Unmarshal(res.Data, respErr); err != nil {
+			return err
+		}
+		return respErr
+	}
+
+	return json.Unmarshal(res.Data, v)
+}
+
+// TopicInfo describes the topic.
+type TopicInfo struct {
+	Topic      string `json:"topic"`
+	Partitions int64  `json:"partitions"`
+}
+
+// Topics returns the list of topics.
+func (c *Client) Topics() ([]*TopicInfo, error) {
+	data, err := c.Do("GET", api.InfoTopicsPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*TopicInfo
+
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,389 @@
+// Package cluster registers the node in the cluster and tracks the liveness
+// of the nodes.
+//
+// Every node has two records. The permanent record in MembersEtcd is created
+// on the first start. The record in ClusterEtcd is attached to the etcd lease
+// which is kept alive while the node is running, so the record disappears
+// when the node is gone. The locations of chunks on the nodes which are not
+// live are stale and are not used to synchronize chunks. The draining node
+// does not receive new replicas, but its chunks can still be synchronized.
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	v3 "github.com/coreos/etcd/clientv3"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+const (
+	retryPeriod = time.Second
+)
+
+// Version is the version of the node. It is set at build time.
+var Version = "unknown"
+
+// NodeInfo is the record of the live node in ClusterEtcd.
+type NodeInfo struct {
+	Group    string    `json:"group"`
+	Node     string    `json:"node"`
+	Address  string    `json:"address"`
+	Version  string    `json:"version"`
+	Started  time.Time `json:"started"`
+	Capacity int64     `json:"capacity,omitempty"`
+}
+
+// Member describes the node which has ever registered in the cluster.
+type Member struct {
+	Group      string     `json:"group"`
+	Node       string     `json:"node"`
+	Address    string     `json:"address,omitempty"`
+	Version    string     `json:"version,omitempty"`
+	Capacity   int64      `json:"capacity,omitempty"`
+	Registered *time.Time `json:"registered,omitempty"`
+	Started    *time.Time `json:"started,omitempty"`
+	Live       bool       `json:"live"`
+	Draining   bool       `json:"draining"`
+}
+
+type memberInfo struct {
+	Registered time.Time `json:"registered"`
+	Draining   bool      `json:"draining,omitempty"`
+}
+
+// Register registers the node in the cluster. If the lease is lost (e.g. etcd
+// was unavailable longer than the TTL), the node is registered again.
+func Register(ctx context.Context) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	membersColl, err := metadata.NewMembersCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	nodesColl, err := metadata.NewNodesCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	memberKey := &metadata.MemberEtcdKey{
+		Group: cfg.Global.Group,
+		Node:  cfg.Global.Hostname,
+	}
+
+	if _, err := membersColl.Get(memberKey); err != nil {
+		if err != metadata.ErrKeyNotFound {
+			return err
+		}
+
+		data, err := json.Marshal(&memberInfo{
+			Registered: time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := membersColl.Put(memberKey, string(data)); err != nil {
+			return err
+		}
+	}
+
+	info := &NodeInfo{
+		Group:    cfg.Global.Group,
+		Node:     cfg.Global.Hostname,
+		Address:  cfg.Global.AdvertisedAddress(),
+		Version:  Version,
+		Started:  time.Now().UTC(),
+		Capacity: cfg.Storage.Capacity,
+	}
+
+	keepAlive, err := register(nodesColl, cfg, info)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			for range keepAlive {
+			}
+
+			// The keepalive channel is also closed when the node stops.
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+
+			logrus.Warnf("Lease of node %s/%s is lost, registering again", cfg.Global.Group, cfg.Global.Hostname)
+
+			for {
+				if keepAlive, err = register(nodesColl, cfg, info); err == nil {
+					break
+				}
+				logrus.Errorf("Unable to register node: %s", err)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(retryPeriod):
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func register(coll metadata.EtcdCollection, cfg *config.Config, info *NodeInfo) (<-chan *v3.LeaseKeepAliveResponse, error) {
+	client := coll.Client()
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := int64(cfg.Global.NodeTTL / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	resp, err := client.Grant(coll.Context(), ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	lease := v3.LeaseID(resp.ID)
+
+	key := &metadata.ClusterEtcdKey{
+		Group: cfg.Global.Group,
+		Node:  cfg.Global.Hostname,
+	}
+
+	if err := metadata.PutWithLease(coll, key, string(data), lease); err != nil {
+		return nil, err
+	}
+
+	return client.KeepAlive(coll.Context(), lease)
+}
+
+// ListMembers returns all nodes which have ever registered in the cluster.
+func ListMembers(ctx context.Context) ([]*Member, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	membersColl, err := metadata.NewMembersCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	nodesColl, err := metadata.NewNodesCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]*Member)
+
+	get := func(group, node string) *Member {
+		id := group + "/" + node
+		if _, ok := members[id]; !ok {
+			members[id] = &Member{
+				Group: group,
+				Node:  node,
+			}
+		}
+		return members[id]
+	}
+
+	records, err := membersColl.List(&metadata.MemberEtcdKey{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rec := range records {
+		key, err := metadata.ParseMemberEtcdKey(rec.RawKey)
+		if err != nil {
+			return nil, err
+		}
+
+		info := &memberInfo{}
+		if err := json.Unmarshal([]byte(rec.Value), info); err != nil {
+			return nil, fmt.Errorf("bad member %s: %s", rec.RawKey, err)
+		}
+
+		m := get(key.Group, key.Node)
+		m.Registered = &info.Registered
+		m.Draining = info.Draining
+	}
+
+	records, err = nodesColl.List(&metadata.ClusterEtcdKey{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rec := range records {
+		key, err := metadata.ParseClusterEtcdKey(rec.RawKey)
+		if err != nil {
+			return nil, err
+		}
+
+		m := get(key.Group, key.Node)
+		m.Live = true
+
+		// The nodes of the previous versions keep the start time only.
+		info := &NodeInfo{}
+		if err := json.Unmarshal([]byte(rec.Value), info); err != nil {
+			logrus.Debugf("Unable to parse node record %s: %s", rec.RawKey, err)
+			continue
+		}
+
+		m.Address = info.Address
+		m.Version = info.Version
+		m.Capacity = info.Capacity
+		m.Started = &info.Started
+	}
+
+	res := make([]*Member, 0, len(members))
+	for _, m := range members {
+		res = append(res, m)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Group != res[j].Group {
+			return res[i].Group < res[j].Group
+		}
+		return res[i].Node < res[j].Node
+	})
+
+	return res, nil
+}
+
+// Group describes the group of nodes.
+type Group struct {
+	Group string   `json:"group"`
+	Nodes []string `json:"nodes"`
+	Live  int      `json:"live"`
+	// Capacity is the total capacity of the live nodes.
+	Capacity int64 `json:"capacity"`
+}
+
+// Topology describes the nodes and the groups of the cluster.
+type Topology struct {
+	Nodes  []*Member `json:"nodes"`
+	Groups []*Group  `json:"groups"`
+}
+
+// GetTopology returns the nodes and the groups of the cluster.
+func GetTopology(ctx context.Context) (*Topology, error) {
+	members, err := ListMembers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Topology{
+		Nodes:  members,
+		Groups: []*Group{},
+	}
+
+	var last *Group
+
+	// The members are sorted by group.
+	for _, m := range members {
+		if last == nil || last.Group != m.Group {
+			last = &Group{
+				Group: m.Group,
+			}
+			res.Groups = append(res.Groups, last)
+		}
+
+		last.Nodes = append(last.Nodes, m.Node)
+
+		if m.Live {
+			last.Live++
+			last.Capacity += m.Capacity
+		}
+	}
+
+	return res, nil
+}
+
+// SetDraining marks the node as draining. The draining node is excluded from
+// the placement of chunks, but it remains a source to synchronize chunks
+// while it is live.
+func SetDraining(ctx context.Context, group, node string, draining bool) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	membersColl, err := metadata.NewMembersCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	key := &metadata.MemberEtcdKey{
+		Group: group,
+		Node:  node,
+	}
+
+	// The record is replaced only if it has not been changed since it was
+	// read, so the concurrent update is not lost and the removed member is
+	// not restored.
+	for {
+		rec, err := membersColl.Get(key)
+		if err != nil {
+			return err
+		}
+
+		info := &memberInfo{}
+		if err := json.Unmarshal([]byte(rec.Value), info); err != nil {
+			return fmt.Errorf("bad member %s: %s", rec.RawKey, err)
+		}
+
+		info.Draining = draining
+
+		data, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+
+		txn := metadata.NewTransaction(ctx, cfg)
+		txn.Unmodified(key, rec.ModRevision)
+		txn.Put(key, string(data))
+
+		if err := txn.Commit(); err != metadata.ErrKeyModified {
+			return err
+		}
+	}
+}
+
+// Forget removes the node from the cluster.
+func Forget(ctx context.Context, group, node string) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	txn := metadata.NewTransaction(ctx, cfg)
+	txn.Delete(&metadata.ClusterEtcdKey{
+		Group: group,
+		Node:  node,
+	})
+	txn.Delete(&metadata.MemberEtcdKey{
+		Group: group,
+		Node:  node,
+	})
+
+	return txn.Commit()
+}
@@ -3,7 +3,10 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,9 +17,31 @@ import (
 )
 
 const (
-	AppConfigContextVar = "app.config"
+	AppConfigContextVar   = "app.config"
+	TopicConfigContextVar = "app.topic.config"
+
+	CleanupPolicyDelete  = "delete"
+	CleanupPolicyCompact = "compact"
+)
+
+const (
+	// TopicNamePattern matches the topic name as clients see it. The dot
+	// is reserved for the tenant separator.
+	TopicNamePattern = "[A-Za-z0-9_-]+"
+
+	// QualifiedTopicPattern matches the topic name optionally qualified by
+	// the tenant name (tenant.topic).
+	QualifiedTopicPattern = TopicNamePattern + "(?:\\." + TopicNamePattern + ")?"
 )
 
+var topicNameRegexp = regexp.MustCompile("^" + QualifiedTopicPattern + "$")
+
+// ValidTopicName checks that the name is a topic name optionally qualified
+// by the tenant name.
+func ValidTopicName(name string) bool {
+	return topicNameRegexp.MatchString(name)
+}
+
 type cfgKeyConfig int
 
 type CfgLogLevel struct {
@@ -29,34 +54,129 @@ func (d *CfgLogLevel) UnmarshalText(data []byte) (err error) {
 }
 
 type Global struct {
-	// Address is the advertised host:port for client connections
+	// Address is the host:port to listen for client connections
 	Address string
+	// AdvertiseAddress is the host:port by which other nodes reach this node.
+	// By default the hostname and the port of Address are used.
+	AdvertiseAddress string `yaml:"advertise-address"`
 	// Logfile specifies logfile location
 	Logfile string
 	// Hostname specifies the node name
 	Hostname string
 	// Group specifies the name of the group
 	Group string
-	// FIXME
+	// NodeTTL defines how long the node is considered live after it stops
+	// renewing its lease.
+	NodeTTL time.Duration `yaml:"node-ttl"`
+	// Port is used when Address has no port.
 	Port int
 }
 
+// AdvertisedAddress returns the host:port by which other nodes reach this
+// node.
+func (g *Global) AdvertisedAddress() string {
+	if g.AdvertiseAddress != "" {
+		return g.AdvertiseAddress
+	}
+
+	port := strconv.Itoa(g.Port)
+
+	if _, p, err := net.SplitHostPort(g.Address); err == nil && p != "" {
+		port = p
+	}
+
+	return net.JoinHostPort(g.Hostname, port)
+}
+
 type Topic struct {
 	// AllowTopicsCreation enables auto creation of topic on the server
 	AllowTopicsCreation bool `yaml:"allow-topics-creation"`
 	// WriteConcern describes the number of groups, which should confirm write of each block.
 	// This value should not be more than the number of nodes in the cluster.
 	WriteConcern int64 `yaml:"write-concern"`
+	// ReplicationTimeout defines how long the write waits for the write concern. Set 0 to wait forever.
+	ReplicationTimeout time.Duration `yaml:"replication-timeout"`
 	// MessageRetentionPeriod defines the maximum time we will retain a message.
 	MessageRetentionPeriod time.Duration `yaml:"message-retention-period"`
 	// PartitionSize defines maximum partition size.
 	MaxPartitionSize int64 `yaml:"max-partition-size"`
+	// MaxPartitionMessages defines the number of the newest messages kept in partition. Set 0 to disable.
+	MaxPartitionMessages int64 `yaml:"max-partition-messages"`
 	// MaxMessageSize defines maximum size of incoming message. Set 0 to disable.
 	MaxMessageSize int64 `yaml:"max-message-size"`
 	// ChunkSize defines maximum size of a block on which is divided the incoming message.
 	MaxChunkSize int64 `yaml:"max-chunk-size"`
 	// CleanupPeriod sets time period between cleanup iterations.
 	CleanupPeriod time.Duration `yaml:"cleanup-period"`
+	// SchedulerPeriod sets time period between checks for due scheduled messages.
+	SchedulerPeriod time.Duration `yaml:"scheduler-period"`
+	// CleanupPolicy maps topic name to comma-separated list of cleanup policies
+	// ("delete", "compact"). Topics not listed use "delete".
+	CleanupPolicy map[string]string `yaml:"cleanup-policy"`
+	// DeleteRetentionPeriod defines how long the compaction keeps tombstones,
+	// so consumers have time to see the deletes.
+	DeleteRetentionPeriod time.Duration `yaml:"delete-retention-period"`
+	// PartitionLeaders enables the election of a leader for every partition.
+	// The leader allocates offsets in memory and appends messages in batches,
+	// other nodes forward the messages to the leader.
+	PartitionLeaders bool `yaml:"partition-leaders"`
+	// Schema maps topic name to the schema subject. Messages written to JSON
+	// topics are validated against the latest version of the subject.
+	Schema map[string]string `yaml:"schema"`
+}
+
+// CleanupPolicies returns the list of cleanup policies for the topic.
+func (t *Topic) CleanupPolicies(topic string) []string {
+	return parseCleanupPolicy(t.CleanupPolicy[topic])
+}
+
+// validateCleanupPolicies checks that cleanup-policy maps valid topic names to
+// the known policies.
+func (t *Topic) validateCleanupPolicies() error {
+	for topic, policy := range t.CleanupPolicy {
+		if !ValidTopicName(topic) {
+			return fmt.Errorf("cleanup-policy: bad topic name: %q", topic)
+		}
+		if err := validateCleanupPolicy(policy); err != nil {
+			return fmt.Errorf("cleanup-policy: topic %s: %s", topic, err)
+		}
+	}
+	return nil
+}
+
+// HasCleanupPolicy checks whether the policy is enabled for the topic.
+func (t *Topic) HasCleanupPolicy(topic string, policy string) bool {
+	for _, v := range t.CleanupPolicies(topic) {
+		if v == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// SchemaSubject returns the schema subject bound to the topic.
+func (t *Topic) SchemaSubject(topic string) string {
+	return t.Schema[topic]
+}
+
+type WorkQueue struct {
+	// VisibilityTimeout defines how long a received message stays invisible to other consumers.
+	VisibilityTimeout time.Duration `yaml:"visibility-timeout"`
+	// MaxDeliveries defines the number of deliveries after which the message is moved
+	// to the dead-letter topic. Set 0 to disable.
+	MaxDeliveries int64 `yaml:"max-deliveries"`
+	// DeadLetterSuffix is appended to the topic name to obtain the dead-letter topic.
+	DeadLetterSuffix string `yaml:"dead-letter-suffix"`
+}
+
+type Tenants struct {
+	// Required rejects requests which do not identify the tenant.
+	Required bool `yaml:"required"`
+	// AdminToken is the bearer token required by the admin API. If it is not
+	// set and Required is set, the admin API is not available.
+	AdminToken string `yaml:"admin-token"`
+	// AccountingPeriod sets time period between calculations of the storage used by tenants.
+	AccountingPeriod time.Duration `yaml:"accounting-period"`
 }
 
 type Logging struct {
@@ -83,10 +203,37 @@ func (s StorageDriver) Parameters() storage.StorageDriverParameters {
 type Storage struct {
 	// SyncPool specifies the number of concurrent processes synchronization chunks from other servers.
 	SyncPool int
+	// ReplicationFactor defines the number of groups which keep each chunk.
+	// Set 0 to replicate chunks to all nodes.
+	ReplicationFactor int `yaml:"replication-factor"`
+	// RepairPeriod sets time period between scans for under-replicated chunks. Set 0 to disable.
+	RepairPeriod time.Duration `yaml:"repair-period"`
+	// RepairRate limits the number of chunks repaired per second. Set 0 to disable.
+	RepairRate int `yaml:"repair-rate"`
 	// Driver
 	Driver StorageDriver
 	// CleanupPeriod sets time period between cleanup iterations.
 	CleanupPeriod time.Duration `yaml:"cleanup-period"`
+	// Capacity is the storage size in bytes advertised to the cluster. Set 0 if it is unknown.
+	Capacity int64 `yaml:"capacity"`
+}
+
+// CheckWriteConcern checks that the write concern can be satisfied by the
+// replication factor.
+func (s *Storage) CheckWriteConcern(n int64) error {
+	if s.ReplicationFactor > 0 && n > int64(s.ReplicationFactor) {
+		return fmt.Errorf("write-concern must not be more than replication-factor (%d)", s.ReplicationFactor)
+	}
+	return nil
+}
+
+// CheckMaxChunkSize checks that the chunk size of the topic does not exceed
+// the configured one. The nodes do not accept larger chunks from each other.
+func (t *Topic) CheckMaxChunkSize(n int64) error {
+	if n > t.MaxChunkSize {
+		return fmt.Errorf("max-chunk-size must not be more than %d", t.MaxChunkSize)
+	}
+	return nil
 }
 
 type EtcdURLs struct {
@@ -138,11 +285,13 @@ type Etcd struct {
 }
 
 type Config struct {
-	Global  Global
-	Logging Logging
-	Topic   Topic
-	Storage Storage
-	Etcd    Etcd
+	Global    Global
+	Logging   Logging
+	Topic     Topic
+	WorkQueue WorkQueue `yaml:"workqueue"`
+	Tenants   Tenants   `yaml:"tenants"`
+	Storage   Storage
+	Etcd      Etcd
 }
 
 // SetDefaults applies default values to config structure.
@@ -155,13 +304,25 @@ func (c *Config) SetDefaults() *Config {
 	c.Global.Hostname = hostname
 	c.Global.Group = hostname
 	c.Global.Logfile = "/var/log/kavka.log"
+	c.Global.NodeTTL = 10 * time.Second
 
 	c.Topic.MaxChunkSize = int64(1024)
 	c.Topic.WriteConcern = 1
+	c.Topic.ReplicationTimeout = 30 * time.Second
 	c.Topic.CleanupPeriod = 1 * time.Minute
+	c.Topic.SchedulerPeriod = 1 * time.Second
+	c.Topic.DeleteRetentionPeriod = 24 * time.Hour
+
+	c.WorkQueue.VisibilityTimeout = 30 * time.Second
+	c.WorkQueue.MaxDeliveries = 5
+	c.WorkQueue.DeadLetterSuffix = "-dlq"
+
+	c.Tenants.AccountingPeriod = 30 * time.Second
 
 	c.Storage.SyncPool = 10
 	c.Storage.CleanupPeriod = 1 * time.Minute
+	c.Storage.RepairPeriod = 5 * time.Minute
+	c.Storage.RepairRate = 10
 
 	c.Etcd.MinConnections = 3
 	c.Etcd.MaxConnections = 100
@@ -185,6 +346,9 @@ func (c *Config) LoadEnv() *Config {
 	if v := os.Getenv("KAVKA_ADDRESS"); v != "" {
 		c.Global.Address = v
 	}
+	if v := os.Getenv("KAVKA_ADVERTISE_ADDRESS"); v != "" {
+		c.Global.AdvertiseAddress = v
+	}
 	if v := os.Getenv("KAVKA_LOGFILE"); v != "" {
 		c.Global.Logfile = v
 	}
@@ -220,5 +384,17 @@ func NewConfig(filename string) (*Config, error) {
 		return nil, fmt.Errorf("multiple storage drivers specified in configuration")
 	}
 
+	if cfg.Storage.ReplicationFactor < 0 {
+		return nil, fmt.Errorf("replication-factor must not be negative")
+	}
+
+	if err := cfg.Storage.CheckWriteConcern(cfg.Topic.WriteConcern); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Topic.validateCleanupPolicies(); err != nil {
+		return nil, err
+	}
+
 	return cfg, err
 }
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestValidateCleanupPolicies(t *testing.T) {
+	valid := []map[string]string{
+		nil,
+		{"changelog": "compact"},
+		{"events.v1": "delete"},
+		{"tenant.changelog": "delete, compact"},
+		{"foo": ""},
+	}
+
+	for _, policy := range valid {
+		topic := &Topic{CleanupPolicy: policy}
+		if err := topic.validateCleanupPolicies(); err != nil {
+			t.Errorf("%v: unexpected error: %s", policy, err)
+		}
+	}
+
+	invalid := []map[string]string{
+		{"changelog": "compacted"},
+		{"changelog": "delete,"},
+		{"changelog": "retain"},
+		{"bad/topic": "compact"},
+		{"tenant.events.v1": "compact"},
+		{".changelog": "compact"},
+		{"": "compact"},
+	}
+
+	for _, policy := range invalid {
+		topic := &Topic{CleanupPolicy: policy}
+		if err := topic.validateCleanupPolicies(); err == nil {
+			t.Errorf("%v: expected error", policy)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration represented as a string in JSON.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(data, &s); err != nil {
+		return
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return
+}
+
+// TopicConfig contains per-topic overrides of the Topic settings. Unset
+// fields are inherited from the configuration file.
+type TopicConfig struct {
+	MessageRetentionPeriod *Duration `json:"message-retention-period,omitempty"`
+	MaxPartitionSize       *int64    `json:"max-partition-size,omitempty"`
+	MaxPartitionMessages   *int64    `json:"max-partition-messages,omitempty"`
+	MaxMessageSize         *int64    `json:"max-message-size,omitempty"`
+	MaxChunkSize           *int64    `json:"max-chunk-size,omitempty"`
+	WriteConcern           *int64    `json:"write-concern,omitempty"`
+	ReplicationTimeout     *Duration `json:"replication-timeout,omitempty"`
+	CleanupPolicy          *string   `json:"cleanup-policy,omitempty"`
+	DeleteRetentionPeriod  *Duration `json:"delete-retention-period,omitempty"`
+	Schema                 *string   `json:"schema,omitempty"`
+}
+
+// NewTopicConfig returns topic settings with all fields set.
+func NewTopicConfig(t *Topic, topic string) *TopicConfig {
+	policy := strings.Join(t.CleanupPolicies(topic), ",")
+	subject := t.SchemaSubject(topic)
+
+	return &TopicConfig{
+		MessageRetentionPeriod: &Duration{t.MessageRetentionPeriod},
+		MaxPartitionSize:       &t.MaxPartitionSize,
+		MaxPartitionMessages:   &t.MaxPartitionMessages,
+		MaxMessageSize:         &t.MaxMessageSize,
+		MaxChunkSize:           &t.MaxChunkSize,
+		WriteConcern:           &t.WriteConcern,
+		ReplicationTimeout:     &Duration{t.ReplicationTimeout},
+		CleanupPolicy:          &policy,
+		DeleteRetentionPeriod:  &Duration{t.DeleteRetentionPeriod},
+		Schema:                 &subject,
+	}
+}
+
+// Validate checks the overrides.
+func (c *TopicConfig) Validate() error {
+	if c.MessageRetentionPeriod != nil && c.MessageRetentionPeriod.Duration < 0 {
+		return fmt.Errorf("message-retention-period must not be negative")
+	}
+	if c.MaxPartitionSize != nil && *c.MaxPartitionSize < 0 {
+		return fmt.Errorf("max-partition-size must not be negative")
+	}
+	if c.MaxPartitionMessages != nil && *c.MaxPartitionMessages < 0 {
+		return fmt.Errorf("max-partition-messages must not be negative")
+	}
+	if c.MaxMessageSize != nil && *c.MaxMessageSize < 0 {
+		return fmt.Errorf("max-message-size must not be negative")
+	}
+	if c.MaxChunkSize != nil && *c.MaxChunkSize <= 0 {
+		return fmt.Errorf("max-chunk-size must be positive")
+	}
+	if c.WriteConcern != nil && *c.WriteConcern <= 0 {
+		return fmt.Errorf("write-concern must be positive")
+	}
+	if c.ReplicationTimeout != nil && c.ReplicationTimeout.Duration < 0 {
+		return fmt.Errorf("replication-timeout must not be negative")
+	}
+	if c.CleanupPolicy != nil {
+		if err := validateCleanupPolicy(*c.CleanupPolicy); err != nil {
+			return err
+		}
+	}
+	if c.DeleteRetentionPeriod != nil && c.DeleteRetentionPeriod.Duration < 0 {
+		return fmt.Errorf("delete-retention-period must not be negative")
+	}
+	return nil
+}
+
+// Apply returns a copy of the topic settings with overrides applied.
+func (c *TopicConfig) Apply(t *Topic, topic string) *Topic {
+	res := *t
+
+	if c.MessageRetentionPeriod != nil {
+		res.MessageRetentionPeriod = c.MessageRetentionPeriod.Duration
+	}
+	if c.MaxPartitionSize != nil {
+		res.MaxPartitionSize = *c.MaxPartitionSize
+	}
+	if c.MaxPartitionMessages != nil {
+		res.MaxPartitionMessages = *c.MaxPartitionMessages
+	}
+	if c.MaxMessageSize != nil {
+		res.MaxMessageSize = *c.MaxMessageSize
+	}
+	if c.MaxChunkSize != nil {
+		res.MaxChunkSize = *c.MaxChunkSize
+	}
+	if c.WriteConcern != nil {
+		res.WriteConcern = *c.WriteConcern
+	}
+	if c.ReplicationTimeout != nil {
+		res.ReplicationTimeout = c.ReplicationTimeout.Duration
+	}
+	if c.CleanupPolicy != nil {
+		res.CleanupPolicy = map[string]string{
+			topic: *c.CleanupPolicy,
+		}
+	}
+	if c.DeleteRetentionPeriod != nil {
+		res.DeleteRetentionPeriod = c.DeleteRetentionPeriod.Duration
+	}
+	if c.Schema != nil {
+		res.Schema = map[string]string{
+			topic: *c.Schema,
+		}
+	}
+
+	return &res
+}
+
+func parseCleanupPolicy(v string) []string {
+	if v == "" {
+		return []string{CleanupPolicyDelete}
+	}
+
+	var res []string
+	for _, s := range strings.Split(v, ",") {
+		res = append(res, strings.TrimSpace(s))
+	}
+	return res
+}
+
+// validateCleanupPolicy checks that the comma-separated list contains only
+// "delete" and "compact".
+func validateCleanupPolicy(v string) error {
+	for _, policy := range parseCleanupPolicy(v) {
+		switch policy {
+		case CleanupPolicyDelete, CleanupPolicyCompact:
+		case "":
+			return fmt.Errorf("empty cleanup policy in %q", v)
+		default:
+			return fmt.Errorf("unknown cleanup policy: %q (allowed: %s, %s)", policy, CleanupPolicyDelete, CleanupPolicyCompact)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func parseTopicConfig(t *testing.T, data string) *TopicConfig {
+	c := &TopicConfig{}
+	if err := json.Unmarshal([]byte(data), c); err != nil {
+		t.Fatalf("%s: %s", data, err)
+	}
+	return c
+}
+
+func TestTopicConfigValidate(t *testing.T) {
+	valid := []string{
+		`{}`,
+		`{"message-retention-period":"24h"}`,
+		`{"message-retention-period":"0s","max-partition-size":0,"max-partition-messages":0}`,
+		`{"max-message-size":1048576,"max-chunk-size":1024}`,
+		`{"write-concern":2,"replication-timeout":"0s"}`,
+		`{"cleanup-policy":"compact,delete","delete-retention-period":"1h"}`,
+		`{"schema":"events"}`,
+	}
+
+	for _, data := range valid {
+		if err := parseTopicConfig(t, data).Validate(); err != nil {
+			t.Errorf("%s: unexpected error: %s", data, err)
+		}
+	}
+
+	invalid := []string{
+		`{"message-retention-period":"-1h"}`,
+		`{"max-partition-size":-1}`,
+		`{"max-partition-messages":-1}`,
+		`{"max-message-size":-1}`,
+		`{"max-chunk-size":0}`,
+		`{"write-concern":0}`,
+		`{"replication-timeout":"-1s"}`,
+		`{"cleanup-policy":"retain"}`,
+		`{"cleanup-policy":"compact,"}`,
+		`{"delete-retention-period":"-1h"}`,
+	}
+
+	for _, data := range invalid {
+		if err := parseTopicConfig(t, data).Validate(); err == nil {
+			t.Errorf("%s: expected error", data)
+		}
+	}
+}
+
+func TestTopicConfigApply(t *testing.T) {
+	base := &Topic{
+		WriteConcern:           1,
+		ReplicationTimeout:     30 * time.Second,
+		MessageRetentionPeriod: time.Hour,
+		MaxChunkSize:           1024,
+		DeleteRetentionPeriod:  24 * time.Hour,
+		CleanupPolicy:          map[string]string{"changelog": "compact"},
+		Schema:                 map[string]string{"events": "events-value"},
+	}
+
+	testCases := []struct {
+		topic  string
+		config string
+		modify func(*Topic)
+	}{
+		{
+			topic:  "foo",
+			config: `{}`,
+			modify: func(c *Topic) {},
+		},
+		{
+			topic:  "foo",
+			config: `{"message-retention-period":"15m","max-partition-messages":100}`,
+			modify: func(c *Topic) {
+				c.MessageRetentionPeriod = 15 * time.Minute
+				c.MaxPartitionMessages = 100
+			},
+		},
+		{
+			topic:  "foo",
+			config: `{"write-concern":3,"replication-timeout":"0s","max-chunk-size":512}`,
+			modify: func(c *Topic) {
+				c.WriteConcern = 3
+				c.ReplicationTimeout = 0
+				c.MaxChunkSize = 512
+			},
+		},
+		{
+			topic:  "foo",
+			config: `{"cleanup-policy":"compact","delete-retention-period":"1h"}`,
+			modify: func(c *Topic) {
+				c.CleanupPolicy = map[string]string{"foo": "compact"}
+				c.DeleteRetentionPeriod = time.Hour
+			},
+		},
+		{
+			topic:  "events",
+			config: `{"schema":"other"}`,
+			modify: func(c *Topic) {
+				c.Schema = map[string]string{"events": "other"}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		expect := *base
+		tc.modify(&expect)
+
+		res := parseTopicConfig(t, tc.config).Apply(base, tc.topic)
+
+		if !reflect.DeepEqual(res, &expect) {
+			t.Errorf("%s %s: got %+v, expected %+v", tc.topic, tc.config, res, &expect)
+		}
+	}
+
+	// The topic settings from the configuration are not changed.
+	if base.MessageRetentionPeriod != time.Hour || base.CleanupPolicy["changelog"] != "compact" {
+		t.Errorf("base settings are modified: %+v", base)
+	}
+}
+
+func TestNewTopicConfig(t *testing.T) {
+	base := &Topic{
+		WriteConcern:          2,
+		MaxChunkSize:          1024,
+		DeleteRetentionPeriod: time.Hour,
+		CleanupPolicy:         map[string]string{"changelog": "compact, delete"},
+	}
+
+	res := NewTopicConfig(base, "changelog").Apply(&Topic{}, "changelog")
+
+	if res.WriteConcern != 2 || res.MaxChunkSize != 1024 || res.DeleteRetentionPeriod != time.Hour {
+		t.Errorf("unexpected settings: %+v", res)
+	}
+	if !res.HasCleanupPolicy("changelog", CleanupPolicyCompact) || !res.HasCleanupPolicy("changelog", CleanupPolicyDelete) {
+		t.Errorf("unexpected cleanup policies: %v", res.CleanupPolicies("changelog"))
+	}
+}
@@ -0,0 +1,63 @@
+package etcd
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/coreos/etcd/clientv3/concurrency"
+
+	"github.com/legionus/kavka/pkg/context"
+)
+
+// Lead takes part in the election with the prefix until ctx is cancelled and
+// calls fn while the node is the leader. The context of fn is cancelled when
+// the session lease of the leader expires. The next candidate is elected
+// after fn returns or the lease expires.
+func Lead(ctx context.Context, c *EtcdClient, prefix, value string, fn func(ctx context.Context)) {
+	election := concurrency.NewElection(c.Client, prefix)
+
+	for {
+		if err := election.Campaign(ctx, value); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			logrus.Errorf("Election %s fails: %s", prefix, err)
+
+			select {
+			case <-time.After(time.Second):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// Campaign uses the session of the client, so the same session
+		// is returned.
+		if session, err := concurrency.NewSession(c.Client); err != nil {
+			logrus.Errorf("Unable to obtain etcd session: %s", err)
+		} else {
+			leaderCtx, cancel := context.WithCancel(ctx)
+
+			go func() {
+				select {
+				case <-session.Done():
+				case <-leaderCtx.Done():
+				}
+				cancel()
+			}()
+
+			fn(leaderCtx)
+			cancel()
+		}
+
+		if err := election.Resign(context.Background()); err != nil {
+			logrus.Errorf("Unable to resign the leadership of %s: %s", prefix, err)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
@@ -2,6 +2,7 @@ package etcd
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 
 	"github.com/legionus/kavka/pkg/config"
 	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/util"
 )
 
 type EtcdClient struct {
@@ -76,3 +78,43 @@ func (c *EtcdClient) TestEtcdClient() error {
 	}
 	return nil
 }
+
+// ErrMemberNotFound is returned if there is no etcd member with the ID.
+var ErrMemberNotFound = errors.New("member not found")
+
+// RemoveMember removes the member from the etcd cluster and forgets its
+// client URLs.
+func RemoveMember(ctx context.Context, cfg *config.Config, id uint64) error {
+	client, err := NewEtcdClient(cfg)
+	if err != nil {
+		return fmt.Errorf("Unable to create etcd client: %s", err)
+	}
+
+	resp, err := client.MemberList(ctx)
+	if err != nil {
+		return fmt.Errorf("Unable to get member list: %s", err)
+	}
+
+	for _, member := range resp.Members {
+		if id != member.ID {
+			continue
+		}
+
+		if _, err = client.MemberRemove(ctx, id); err != nil {
+			return fmt.Errorf("Unable to remove member: %s", err)
+		}
+
+		var endpoints []string
+		for _, clientURL := range cfg.Etcd.Client.URLs {
+			if util.InSliceString(clientURL, member.ClientURLs) {
+				continue
+			}
+			endpoints = append(endpoints, clientURL)
+		}
+		cfg.Etcd.Client.URLs = endpoints
+
+		return nil
+	}
+
+	return ErrMemberNotFound
+}
@@ -0,0 +1,87 @@
+// Package etcdtest runs the embedded etcd server for the tests which need
+// the real metadata storage.
+package etcdtest
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/embed"
+
+	"github.com/legionus/kavka/pkg/config"
+)
+
+func freeURL(t testing.TB) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to allocate port: %s", err)
+	}
+	defer l.Close()
+
+	u, err := url.Parse("http://" + l.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to parse address: %s", err)
+	}
+	return *u
+}
+
+// Start starts the single-node etcd server and returns the configuration
+// which points to it. The returned function stops the server and removes its
+// data.
+func Start(t testing.TB) (*config.Config, func()) {
+	dir, err := ioutil.TempDir("", "kavka-etcd")
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+
+	clientURL := freeURL(t)
+	peerURL := freeURL(t)
+
+	ecfg := embed.NewConfig()
+	ecfg.Dir = dir
+	ecfg.Name = "test"
+	ecfg.LCUrls = []url.URL{clientURL}
+	ecfg.ACUrls = []url.URL{clientURL}
+	ecfg.LPUrls = []url.URL{peerURL}
+	ecfg.APUrls = []url.URL{peerURL}
+	ecfg.InitialCluster = ecfg.InitialClusterFromName(ecfg.Name)
+
+	e, err := embed.StartEtcd(ecfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unable to start etcd: %s", err)
+	}
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		e.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("etcd is not ready")
+	}
+
+	// The server rejects requests with "not capable" until the cluster
+	// version is decided.
+	for deadline := time.Now().Add(30 * time.Second); e.Server.ClusterVersion() == nil; {
+		if time.Now().After(deadline) {
+			e.Close()
+			os.RemoveAll(dir)
+			t.Fatalf("etcd cluster version is not decided")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cfg := (&config.Config{}).SetDefaults()
+	cfg.Global.Hostname = "test"
+	cfg.Global.Group = "test"
+	cfg.Etcd.Client.URLs = []string{clientURL.String()}
+
+	return cfg, func() {
+		e.Close()
+		os.RemoveAll(dir)
+	}
+}
@@ -119,12 +119,19 @@ func putNewKV(kv v3.KV, key, val string, leaseID v3.LeaseID) (int64, error) {
 
 // NewSequentialKV allocates a new sequential key-value pair at <prefix>/nnnnn
 func NewSequentialKV(kv v3.KV, prefix, val string) (*RemoteKV, error) {
-	return newSequentialKV(kv, prefix, val, v3.NoLease)
+	return newSequentialKV(kv, prefix, val, v3.NoLease, nil)
+}
+
+// NewSequentialKVWithOps allocates a new sequential key-value pair at
+// <prefix>/nnnnn. The operations returned by extra for the allocated key are
+// applied in the same transaction.
+func NewSequentialKVWithOps(kv v3.KV, prefix, val string, extra func(key string) []v3.Op) (*RemoteKV, error) {
+	return newSequentialKV(kv, prefix, val, v3.NoLease, extra)
 }
 
 // newSequentialKV allocates a new sequential key <prefix>/nnnnn with a given
 // value and lease.  Note: a bookkeeping node __<prefix> is also allocated.
-func newSequentialKV(kv v3.KV, prefix, val string, leaseID v3.LeaseID) (*RemoteKV, error) {
+func newSequentialKV(kv v3.KV, prefix, val string, leaseID v3.LeaseID, extra func(key string) []v3.Op) (*RemoteKV, error) {
 	resp, err := kv.Get(context.Background(), prefix, v3.WithLastKey()...)
 	if err != nil {
 		return nil, err
@@ -154,13 +161,18 @@ func newSequentialKV(kv v3.KV, prefix, val string, leaseID v3.LeaseID) (*RemoteK
 	reqPrefix := v3.OpPut(baseKey, "", v3.WithLease(leaseID))
 	reqNewKey := v3.OpPut(newKey, val, v3.WithLease(leaseID))
 
+	ops := []v3.Op{reqPrefix, reqNewKey}
+	if extra != nil {
+		ops = append(ops, extra(newKey)...)
+	}
+
 	txn := kv.Txn(context.Background())
-	txnresp, err := txn.If(cmp).Then(reqPrefix, reqNewKey).Commit()
+	txnresp, err := txn.If(cmp).Then(ops...).Commit()
 	if err != nil {
 		return nil, err
 	}
 	if !txnresp.Succeeded {
-		return newSequentialKV(kv, prefix, val, leaseID)
+		return newSequentialKV(kv, prefix, val, leaseID, extra)
 	}
 	return &RemoteKV{kv, newKey, txnresp.Header.Revision, val}, nil
 }
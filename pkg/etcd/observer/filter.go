@@ -9,7 +9,9 @@ type EtcdFilter struct {
 
 	observer  *EtcdObserver
 	filter    EtcdObserveHandler
+	resync    func()
 	handlerID EtcdObserveKey
+	resyncID  EtcdObserveKey
 }
 
 func NewEtcdFilter(observer *EtcdObserver, handler EtcdObserveHandler) (*EtcdFilter, error) {
@@ -19,11 +21,24 @@ func NewEtcdFilter(observer *EtcdObserver, handler EtcdObserveHandler) (*EtcdFil
 	}, nil
 }
 
+// OnResync sets the handler which is called when the events could be lost.
+// It must be called before Start.
+func (f *EtcdFilter) OnResync(handler func()) *EtcdFilter {
+	f.Lock()
+	defer f.Unlock()
+
+	f.resync = handler
+	return f
+}
+
 func (f *EtcdFilter) Start() *EtcdFilter {
 	f.Lock()
 	defer f.Unlock()
 
 	f.handlerID = f.observer.RegisterHandler(f.filter)
+	if f.resync != nil {
+		f.resyncID = f.observer.RegisterResyncHandler(f.resync)
+	}
 	return f
 }
 
@@ -31,6 +46,11 @@ func (f *EtcdFilter) Stop() {
 	f.Lock()
 	defer f.Unlock()
 
+	if f.resyncID != "" {
+		f.observer.UnregisterHandler(f.resyncID)
+		f.resyncID = ""
+	}
+
 	if f.handlerID == "" {
 		return
 	}
@@ -2,10 +2,12 @@ package observer
 
 import (
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 
 	v3 "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
 	"github.com/pborman/uuid"
 
 	"github.com/legionus/kavka/pkg/config"
@@ -20,11 +22,32 @@ type EtcdEvent struct {
 type EtcdObserveKey string
 type EtcdObserveHandler func(ev *EtcdEvent)
 
+// EtcdObserverStatus describes the state of the observer.
+type EtcdObserverStatus struct {
+	Path string `json:"path"`
+	// Revision is the last revision delivered to the handlers.
+	Revision int64 `json:"revision"`
+	// LastModified is the last revision of the keys under the path.
+	LastModified int64 `json:"last-modified"`
+	// Lag is the number of revisions the observer is behind.
+	Lag       int64      `json:"lag"`
+	LastEvent *time.Time `json:"last-event,omitempty"`
+	Restarts  int64      `json:"restarts"`
+	Resyncs   int64      `json:"resyncs"`
+}
+
 type EtcdObserver struct {
 	sync.RWMutex
 
-	etcdclient *etcd.EtcdClient
-	handlers   map[EtcdObserveKey]EtcdObserveHandler
+	etcdclient     *etcd.EtcdClient
+	handlers       map[EtcdObserveKey]EtcdObserveHandler
+	resyncHandlers map[EtcdObserveKey]func()
+
+	path      string
+	revision  int64
+	lastEvent *time.Time
+	restarts  int64
+	resyncs   int64
 }
 
 func NewEtcdObserver(cfg *config.Config) (*EtcdObserver, error) {
@@ -34,8 +57,9 @@ func NewEtcdObserver(cfg *config.Config) (*EtcdObserver, error) {
 	}
 
 	return &EtcdObserver{
-		etcdclient: c,
-		handlers:   make(map[EtcdObserveKey]EtcdObserveHandler),
+		etcdclient:     c,
+		handlers:       make(map[EtcdObserveKey]EtcdObserveHandler),
+		resyncHandlers: make(map[EtcdObserveKey]func()),
 	}, nil
 }
 
@@ -49,22 +73,138 @@ func (bs *EtcdObserver) RegisterHandler(handler EtcdObserveHandler) EtcdObserveK
 	return id
 }
 
+// RegisterResyncHandler registers the handler which is called when events
+// could be lost (e.g. the revision was compacted while the watch was
+// interrupted). The handler should rebuild its state from etcd.
+func (bs *EtcdObserver) RegisterResyncHandler(handler func()) EtcdObserveKey {
+	bs.Lock()
+	defer bs.Unlock()
+
+	id := EtcdObserveKey(uuid.New())
+
+	bs.resyncHandlers[id] = handler
+	return id
+}
+
 func (bs *EtcdObserver) UnregisterHandler(id EtcdObserveKey) {
 	bs.Lock()
 	defer bs.Unlock()
 
 	delete(bs.handlers, id)
+	delete(bs.resyncHandlers, id)
+}
+
+func (bs *EtcdObserver) notifyResync() {
+	bs.Lock()
+	defer bs.Unlock()
+
+	bs.resyncs++
+
+	for _, handler := range bs.resyncHandlers {
+		go handler()
+	}
+}
+
+// Revision returns the last revision delivered to the handlers.
+func (bs *EtcdObserver) Revision() int64 {
+	bs.RLock()
+	defer bs.RUnlock()
+
+	return bs.revision
+}
+
+func (bs *EtcdObserver) setRevision(rev int64, event bool) {
+	bs.Lock()
+	defer bs.Unlock()
+
+	if rev > bs.revision {
+		bs.revision = rev
+	}
+
+	if event {
+		now := time.Now().UTC()
+		bs.lastEvent = &now
+	}
+}
+
+// Status returns the state of the observer. The lag is the difference between
+// the last revision of the keys under the path and the delivered revision.
+func (bs *EtcdObserver) Status(ctx context.Context) (*EtcdObserverStatus, error) {
+	bs.RLock()
+	res := &EtcdObserverStatus{
+		Path:      bs.path,
+		Revision:  bs.revision,
+		LastEvent: bs.lastEvent,
+		Restarts:  bs.restarts,
+		Resyncs:   bs.resyncs,
+	}
+	bs.RUnlock()
+
+	resp, err := bs.etcdclient.Get(ctx, res.Path, v3.WithLastRev()...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Kvs) > 0 {
+		res.LastModified = resp.Kvs[0].ModRevision
+	}
+
+	if res.LastModified > res.Revision {
+		res.Lag = res.LastModified - res.Revision
+	}
+
+	return res, nil
 }
 
+// Observe watches the keys under the path. When the watch is interrupted, it
+// is resumed from the next revision after the delivered one, so no events are
+// lost. If the revision is already compacted, the watch starts from the
+// current revision and the resync handlers are called.
 func (bs *EtcdObserver) Observe(ctx context.Context, path string) error {
+	bs.Lock()
+	bs.path = path
+	bs.Unlock()
+
+	resync := false
+
 	for {
-		watcher := bs.etcdclient.Watch(ctx, path, v3.WithPrefix())
+		opts := []v3.OpOption{
+			v3.WithPrefix(),
+			v3.WithProgressNotify(),
+		}
+
+		if rev := bs.Revision(); rev > 0 {
+			opts = append(opts, v3.WithRev(rev+1))
+		}
+
+		watchCtx, cancel := context.WithCancel(ctx)
+
+		watcher := bs.etcdclient.Watch(watchCtx, path, opts...)
 		if watcher == nil {
+			cancel()
 			logrus.Fatalf("Unable to make Watch channel")
 			return etcd.ErrNoWatcher
 		}
 
+		// The handlers rebuild their state after the new watch is
+		// created, so the changes made in between are not missed.
+		if resync {
+			bs.notifyResync()
+			resync = false
+		}
+
 		for wresp := range watcher {
+			if wresp.Err() == rpctypes.ErrCompacted {
+				logrus.Warnf("Events of %s are compacted at revision %d, resync", path, wresp.CompactRevision)
+
+				bs.Lock()
+				bs.revision = 0
+				bs.Unlock()
+
+				resync = true
+				break
+			}
+
 			for _, ev := range wresp.Events {
 				// Waiting all handlers necessary to comply with sequence of event processing.
 				// A side effect of this is that one slow handler can hold them all.
@@ -81,14 +221,34 @@ func (bs *EtcdObserver) Observe(ctx context.Context, path string) error {
 				bs.Unlock()
 
 				wg.Wait()
+
+				bs.setRevision(ev.Kv.ModRevision, true)
 			}
 
+			// All events up to the revision of the response are delivered.
+			bs.setRevision(wresp.Header.Revision, false)
+
 			if wresp.Canceled {
 				break
 			}
 		}
+
+		cancel()
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		bs.Lock()
+		bs.restarts++
+		// Without the delivered revision the watch can not be resumed.
+		if bs.revision == 0 {
+			resync = true
+		}
+		bs.Unlock()
+
+		logrus.Warnf("Watch of %s is interrupted, restarting", path)
 	}
-	return nil
 }
 
 func (bs *EtcdObserver) RunEtcdObserver(path string) {
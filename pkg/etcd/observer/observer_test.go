@@ -0,0 +1,123 @@
+package observer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+)
+
+func startObserver(t *testing.T, obsrv *EtcdObserver, path string) (chan string, func()) {
+	events := make(chan string, 16)
+
+	obsrv.RegisterHandler(func(ev *EtcdEvent) {
+		events <- string(ev.Kv.Key)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go obsrv.Observe(ctx, path)
+
+	return events, cancel
+}
+
+func expectEvent(t *testing.T, events chan string, key string) {
+	select {
+	case k := <-events:
+		if k != key {
+			t.Fatalf("unexpected event for %s, expected %s", k, key)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("no event for %s", key)
+	}
+}
+
+func TestObserveResume(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	obsrv, err := NewEtcdObserver(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	resp, err := obsrv.etcdclient.Put(ctx, "/test/a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The observer has already delivered /test/a, so the watch starts
+	// after its revision.
+	obsrv.setRevision(resp.Header.Revision, false)
+
+	for _, key := range []string{"/test/b", "/test/c"} {
+		if _, err := obsrv.etcdclient.Put(ctx, key, "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, cancel := startObserver(t, obsrv, "/test")
+	defer cancel()
+
+	expectEvent(t, events, "/test/b")
+	expectEvent(t, events, "/test/c")
+}
+
+func TestObserveCompacted(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	obsrv, err := NewEtcdObserver(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	var revs []int64
+	for _, key := range []string{"/test/a", "/test/b", "/test/c"} {
+		resp, err := obsrv.etcdclient.Put(ctx, key, "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs = append(revs, resp.Header.Revision)
+	}
+
+	// The event of /test/b is lost by the compaction.
+	obsrv.setRevision(revs[0], false)
+
+	if _, err := obsrv.etcdclient.Compact(ctx, revs[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	resync := make(chan struct{}, 1)
+	obsrv.RegisterResyncHandler(func() {
+		resync <- struct{}{}
+	})
+
+	events, cancel := startObserver(t, obsrv, "/test")
+	defer cancel()
+
+	select {
+	case <-resync:
+	case <-time.After(10 * time.Second):
+		t.Fatal("resync handler is not called")
+	}
+
+	// The watch continues from the current revision.
+	if _, err := obsrv.etcdclient.Put(ctx, "/test/d", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectEvent(t, events, "/test/d")
+
+	status, err := obsrv.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Resyncs != 1 {
+		t.Errorf("expected 1 resync, got %d", status.Resyncs)
+	}
+}
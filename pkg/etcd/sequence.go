@@ -0,0 +1,113 @@
+package etcd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	v3 "github.com/coreos/etcd/clientv3"
+
+	"github.com/legionus/kavka/pkg/context"
+)
+
+// ErrSequenceConflict is returned when the keys under the prefix are created
+// by someone else or the conditions of the transaction are not met.
+var ErrSequenceConflict = errors.New("sequence is modified concurrently")
+
+// SequentialBatch allocates the sequential keys <prefix>/nnnnn in memory and
+// creates them in batches. It is intended for the single writer of the prefix,
+// but the keys created by NewSequentialKV are detected through the bookkeeping
+// node __<prefix>, so both can be used at the same time.
+type SequentialBatch struct {
+	kv     v3.KV
+	prefix string
+
+	loaded bool
+	next   int64
+	rev    int64
+}
+
+// NewSequentialBatch returns the allocator of keys under the prefix. The state
+// is loaded from etcd on the first use.
+func NewSequentialBatch(kv v3.KV, prefix string) *SequentialBatch {
+	return &SequentialBatch{
+		kv:     kv,
+		prefix: prefix,
+	}
+}
+
+func (s *SequentialBatch) load(ctx context.Context) error {
+	baseKey := "__" + s.prefix
+
+	resp, err := s.kv.Txn(ctx).Then(
+		v3.OpGet(s.prefix+"/", v3.WithLastKey()...),
+		v3.OpGet(baseKey),
+	).Commit()
+	if err != nil {
+		return err
+	}
+
+	s.next = 0
+	s.rev = 0
+
+	if kvs := resp.Responses[0].GetResponseRange().Kvs; len(kvs) > 0 {
+		fields := strings.Split(string(kvs[0].Key), "/")
+		if _, err := fmt.Sscanf(fields[len(fields)-1], "%d", &s.next); err != nil {
+			return err
+		}
+		s.next++
+	}
+
+	if kvs := resp.Responses[1].GetResponseRange().Kvs; len(kvs) > 0 {
+		s.rev = kvs[0].ModRevision
+	}
+
+	s.loaded = true
+	return nil
+}
+
+// Create creates the keys for the values in one transaction and returns them.
+// The operations returned by extra for the i-th key are applied in the same
+// transaction. If the prefix was modified by someone else or cmps are not
+// met, ErrSequenceConflict is returned and the state is reloaded on the next
+// call.
+func (s *SequentialBatch) Create(ctx context.Context, vals []string, extra func(key string, i int) []v3.Op, cmps ...v3.Cmp) ([]string, error) {
+	if !s.loaded {
+		if err := s.load(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	baseKey := "__" + s.prefix
+
+	keys := make([]string, len(vals))
+	ops := []v3.Op{v3.OpPut(baseKey, "")}
+
+	for i, val := range vals {
+		keys[i] = fmt.Sprintf("%s/%020d", s.prefix, s.next+int64(i))
+		ops = append(ops, v3.OpPut(keys[i], val))
+
+		if extra != nil {
+			ops = append(ops, extra(keys[i], i)...)
+		}
+	}
+
+	cmps = append([]v3.Cmp{v3.Compare(v3.ModRevision(baseKey), "=", s.rev)}, cmps...)
+
+	resp, err := s.kv.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		// The result of the transaction is unknown.
+		s.loaded = false
+		return nil, err
+	}
+
+	if !resp.Succeeded {
+		s.loaded = false
+		return nil, ErrSequenceConflict
+	}
+
+	s.next += int64(len(vals))
+	s.rev = resp.Header.Revision
+
+	return keys, nil
+}
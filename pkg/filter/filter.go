@@ -0,0 +1,428 @@
+// Package filter implements predicates evaluated against messages on the
+// server side.
+//
+// The expression grammar:
+//
+//	expr    := and ( "||" and )*
+//	and     := unary ( "&&" unary )*
+//	unary   := "!" unary | primary
+//	primary := "(" expr ")" | "exists" "(" field ")" | field op value
+//	op      := "==" | "!=" | "^="
+//	field   := "key" | "header." name | "json." name
+//	value   := quoted string | number | true | false | null
+//
+// The "^=" operator checks that the value starts with the given prefix.
+// Header fields are always strings. JSON fields refer to the top-level fields
+// of the message body.
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Record is a message being checked against the filter.
+type Record struct {
+	Key     string
+	Headers map[string]string
+	Body    []byte
+
+	fields map[string]json.RawMessage
+	parsed bool
+}
+
+func (r *Record) field(name string) (json.RawMessage, bool) {
+	if !r.parsed {
+		r.parsed = true
+		// Non-object bodies have no fields.
+		json.Unmarshal(r.Body, &r.fields)
+	}
+	v, ok := r.fields[name]
+	return v, ok
+}
+
+type expr interface {
+	match(*Record) bool
+}
+
+// Filter is a compiled filter expression.
+type Filter struct {
+	root      expr
+	needsBody bool
+}
+
+// Match checks the record against the filter.
+func (f *Filter) Match(r *Record) bool {
+	return f.root.match(r)
+}
+
+// NeedsBody reports whether the filter refers to the message body.
+func (f *Filter) NeedsBody() bool {
+	return f.needsBody
+}
+
+type notExpr struct {
+	e expr
+}
+
+func (e *notExpr) match(r *Record) bool {
+	return !e.e.match(r)
+}
+
+type andExpr struct {
+	left, right expr
+}
+
+func (e *andExpr) match(r *Record) bool {
+	return e.left.match(r) && e.right.match(r)
+}
+
+type orExpr struct {
+	left, right expr
+}
+
+func (e *orExpr) match(r *Record) bool {
+	return e.left.match(r) || e.right.match(r)
+}
+
+const (
+	fieldKey = iota
+	fieldHeader
+	fieldJSON
+)
+
+type field struct {
+	kind int
+	name string
+}
+
+func (f *field) lookup(r *Record) (interface{}, bool) {
+	switch f.kind {
+	case fieldKey:
+		return r.Key, r.Key != ""
+	case fieldHeader:
+		v, ok := r.Headers[f.name]
+		return v, ok
+	case fieldJSON:
+		raw, ok := r.field(f.name)
+		if !ok {
+			return nil, false
+		}
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, false
+		}
+		return v, true
+	}
+	return nil, false
+}
+
+type existsExpr struct {
+	f *field
+}
+
+func (e *existsExpr) match(r *Record) bool {
+	_, ok := e.f.lookup(r)
+	return ok
+}
+
+type value struct {
+	text   string
+	parsed interface{}
+}
+
+type compareExpr struct {
+	f  *field
+	op string
+	v  *value
+}
+
+func (e *compareExpr) match(r *Record) bool {
+	v, ok := e.f.lookup(r)
+	if !ok {
+		return e.op == "!="
+	}
+
+	switch e.op {
+	case "==":
+		return equal(v, e.v)
+	case "!=":
+		return !equal(v, e.v)
+	case "^=":
+		s, ok := v.(string)
+		return ok && strings.HasPrefix(s, e.v.text)
+	}
+	return false
+}
+
+func equal(v interface{}, val *value) bool {
+	if s, ok := v.(string); ok {
+		return s == val.text
+	}
+	return reflect.DeepEqual(v, val.parsed)
+}
+
+// Parse compiles the filter expression.
+func Parse(s string) (*Filter, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.tokens[p.pos].text, p.tokens[p.pos].pos)
+	}
+
+	return &Filter{
+		root:      root,
+		needsBody: p.needsBody,
+	}, nil
+}
+
+const (
+	tokenOp = iota
+	tokenWord
+	tokenString
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == '.' || c == '+'
+}
+
+func tokenize(s string) ([]token, error) {
+	var res []token
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			res = append(res, token{tokenOp, s[i : i+1], i})
+			i++
+		case c == '!' && (i+1 >= len(s) || s[i+1] != '='):
+			res = append(res, token{tokenOp, "!", i})
+			i++
+		case i+1 < len(s) && (s[i:i+2] == "&&" || s[i:i+2] == "||" || s[i:i+2] == "==" || s[i:i+2] == "!=" || s[i:i+2] == "^="):
+			res = append(res, token{tokenOp, s[i : i+2], i})
+			i += 2
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			str, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad string at position %d: %s", i, err)
+			}
+			res = append(res, token{tokenString, str, i})
+			i = j + 1
+		case isWordChar(c):
+			j := i
+			for ; j < len(s) && isWordChar(s[j]); j++ {
+			}
+			res = append(res, token{tokenWord, s[i:j], i})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+
+	return res, nil
+}
+
+type parser struct {
+	tokens    []token
+	pos       int
+	needsBody bool
+}
+
+func (p *parser) peek(kind int, text string) bool {
+	if p.pos >= len(p.tokens) {
+		return false
+	}
+	t := p.tokens[p.pos]
+	return t.kind == kind && t.text == text
+}
+
+func (p *parser) expect(text string) error {
+	if !p.peek(tokenOp, text) {
+		return p.errorf("expected %q", text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("%s at end of expression", msg)
+	}
+	return fmt.Errorf("%s at position %d", msg, p.tokens[p.pos].pos)
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(tokenOp, "||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(tokenOp, "&&") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.peek(tokenOp, "!") {
+		p.pos++
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	if p.peek(tokenOp, "(") {
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+
+	if p.peek(tokenWord, "exists") {
+		p.pos++
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		f, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &existsExpr{f}, nil
+	}
+
+	f, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenOp {
+		return nil, p.errorf("expected operator")
+	}
+
+	op := p.tokens[p.pos].text
+	if op != "==" && op != "!=" && op != "^=" {
+		return nil, p.errorf("unknown operator %q", op)
+	}
+	p.pos++
+
+	v, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return &compareExpr{f, op, v}, nil
+}
+
+func (p *parser) parseField() (*field, error) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenWord {
+		return nil, p.errorf("expected field")
+	}
+
+	name := p.tokens[p.pos].text
+
+	var f *field
+
+	switch {
+	case name == "key":
+		f = &field{kind: fieldKey}
+	case strings.HasPrefix(name, "header.") && len(name) > len("header."):
+		f = &field{kind: fieldHeader, name: strings.ToLower(name[len("header."):])}
+	case strings.HasPrefix(name, "json.") && len(name) > len("json."):
+		f = &field{kind: fieldJSON, name: name[len("json."):]}
+		p.needsBody = true
+	default:
+		return nil, p.errorf("unknown field %q", name)
+	}
+
+	p.pos++
+	return f, nil
+}
+
+func (p *parser) parseValue() (*value, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, p.errorf("expected value")
+	}
+
+	t := p.tokens[p.pos]
+
+	switch t.kind {
+	case tokenString:
+		p.pos++
+		return &value{text: t.text, parsed: t.text}, nil
+	case tokenWord:
+		var v interface{}
+		if err := json.Unmarshal([]byte(t.text), &v); err != nil {
+			return nil, p.errorf("bad value %q", t.text)
+		}
+		p.pos++
+		return &value{text: t.text, parsed: v}, nil
+	}
+
+	return nil, p.errorf("expected value")
+}
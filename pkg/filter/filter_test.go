@@ -0,0 +1,88 @@
+package filter
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	rec := &Record{
+		Key: "user-1",
+		Headers: map[string]string{
+			"type": "order",
+		},
+		Body: []byte(`{"status":"pending","amount":42,"paid":false,"tags":["a"]}`),
+	}
+
+	chks := map[string]bool{
+		`key == "user-1"`:                           true,
+		`key ^= "user-"`:                            true,
+		`header.type == "order"`:                    true,
+		`header.Type == "order"`:                    true,
+		`header.type != "order"`:                    false,
+		`header.missing != "order"`:                 true,
+		`exists(header.type)`:                       true,
+		`exists(header.missing)`:                    false,
+		`json.status == "pending"`:                  true,
+		`json.status ^= "pend"`:                     true,
+		`json.amount == 42`:                         true,
+		`json.amount == 43`:                         false,
+		`json.paid == false`:                        true,
+		`exists(json.tags)`:                         true,
+		`!exists(json.deleted)`:                     true,
+		`json.amount ^= "4"`:                        false,
+		`header.type == "order" && json.paid`:       false,
+		`header.type == "x" || json.amount == 42`:   true,
+		`!(header.type == "x" || json.amount == 1)`: true,
+		`json.status == "done" || json.paid == false && key == "user-1"`: true,
+	}
+
+	for k, v := range chks {
+		f, err := Parse(k)
+		if err != nil {
+			if v {
+				t.Fatalf("unable to parse %s: %s", k, err)
+			}
+			continue
+		}
+		if f.Match(rec) != v {
+			t.Fatalf("wrong answer for %s, expected %v", k, v)
+		}
+	}
+}
+
+func TestNeedsBody(t *testing.T) {
+	f, err := Parse(`header.type == "order"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.NeedsBody() {
+		t.Fatalf("header filter should not need body")
+	}
+
+	f, err = Parse(`exists(json.type)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.NeedsBody() {
+		t.Fatalf("json filter should need body")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	chks := []string{
+		``,
+		`key`,
+		`key ==`,
+		`foo == 1`,
+		`key == "a`,
+		`(key == "a"`,
+		`key == "a" &&`,
+		`exists(key`,
+		`json.a == bar`,
+		`key == "a" key`,
+	}
+
+	for _, s := range chks {
+		if _, err := Parse(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+}
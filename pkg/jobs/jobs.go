@@ -0,0 +1,392 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	v3 "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+const (
+	TopicDeletion    = "delete-topic"
+	NodeDecommission = "decommission-node"
+
+	StatePending = "pending"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateFailed  = "failed"
+
+	// The failed job is retried with the growing delay until it fails
+	// maxAttempts times.
+	maxAttempts   = 10
+	retryDelay    = 10 * time.Second
+	maxRetryDelay = 10 * time.Minute
+)
+
+var (
+	ErrJobExists   = errors.New("job already exists")
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// Job is the persistent state of a background operation. The job is
+// processed by one node at a time (see Claim). The stage is saved, so the
+// job can be resumed by any node from the last saved stage.
+type Job struct {
+	Type     string            `json:"type"`
+	Name     string            `json:"name"`
+	Owner    string            `json:"owner"`
+	State    string            `json:"state"`
+	Stage    string            `json:"stage,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Attempts int               `json:"attempts,omitempty"`
+	RetryAt  time.Time         `json:"retry-at"`
+	Params   map[string]string `json:"params,omitempty"`
+	Progress map[string]int64  `json:"progress,omitempty"`
+	Created  time.Time         `json:"created"`
+	Updated  time.Time         `json:"updated"`
+}
+
+// Active returns true if the job is not finished yet.
+func (j *Job) Active() bool {
+	return j.State == StatePending || j.State == StateRunning
+}
+
+// Ready returns true if the job is active and its next attempt is due.
+func (j *Job) Ready() bool {
+	return j.Active() && !time.Now().Before(j.RetryAt)
+}
+
+// Done marks the job as completed.
+func (j *Job) Done() {
+	j.State = StateDone
+	j.Stage = ""
+	j.Error = ""
+}
+
+// Fail records the error of the attempt. The job is retried later from the
+// same stage, after maxAttempts it fails permanently.
+func (j *Job) Fail(err error) {
+	j.Error = err.Error()
+	j.Attempts++
+
+	if j.Attempts >= maxAttempts {
+		j.State = StateFailed
+		return
+	}
+
+	delay := retryDelay << uint(j.Attempts-1)
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+
+	j.State = StatePending
+	j.RetryAt = time.Now().Add(delay)
+}
+
+func (j *Job) key() *metadata.JobEtcdKey {
+	return &metadata.JobEtcdKey{
+		Type: j.Type,
+		Name: j.Name,
+	}
+}
+
+// Save stores the job state.
+func (j *Job) Save(ctx context.Context) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	coll, err := metadata.NewJobsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	j.Updated = time.Now()
+
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	return coll.Put(j.key(), string(data))
+}
+
+// claim makes the node the owner of the job for the lifetime of the etcd
+// session of the client. If the owner is gone, its session lease expires and
+// the job can be claimed by another node. It returns false if the job is
+// owned by another node.
+func claim(ctx context.Context, c *etcd.EtcdClient, job *Job) (bool, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return false, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	session, err := concurrency.NewSession(c.Client)
+	if err != nil {
+		return false, err
+	}
+
+	key := &metadata.JobOwnerEtcdKey{
+		Type: job.Type,
+		Name: job.Name,
+	}
+
+	resp, err := c.Txn(ctx).
+		If(v3.Compare(v3.CreateRevision(key.String()), "=", 0)).
+		Then(v3.OpPut(key.String(), cfg.Global.Hostname, v3.WithLease(session.Lease()))).
+		Else(v3.OpGet(key.String())).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+
+	if !resp.Succeeded {
+		kvs := resp.Responses[0].GetResponseRange().Kvs
+		if len(kvs) == 0 || v3.LeaseID(kvs[0].Lease) != session.Lease() {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// release gives up the ownership of the job.
+func release(ctx context.Context, c *etcd.EtcdClient, job *Job) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	key := &metadata.JobOwnerEtcdKey{
+		Type: job.Type,
+		Name: job.Name,
+	}
+
+	_, err := c.Txn(ctx).
+		If(v3.Compare(v3.Value(key.String()), "=", cfg.Global.Hostname)).
+		Then(v3.OpDelete(key.String())).
+		Commit()
+	return err
+}
+
+// Process runs fn for every job of the type which is ready and is not owned
+// by another node. The job is saved after fn, so the job interrupted by the
+// failure of the node is resumed by another node from the saved stage. If fn
+// fails, the job is retried later.
+func Process(ctx context.Context, c *etcd.EtcdClient, jobType string, fn func(*Job) error) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	list, err := List(ctx, jobType)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range list {
+		if !job.Ready() {
+			continue
+		}
+
+		claimed, err := claim(ctx, c, job)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		// The job may have been finished by the previous owner.
+		fresh, err := Get(ctx, job.Type, job.Name)
+		if err != nil || !fresh.Ready() {
+			release(ctx, c, job)
+			continue
+		}
+		job = fresh
+
+		job.Owner = cfg.Global.Hostname
+
+		if err := fn(job); err != nil {
+			logrus.Errorf("Job %s/%s fails: %s", job.Type, job.Name, err)
+			job.Fail(err)
+		} else {
+			job.Done()
+		}
+
+		if err := job.Save(ctx); err != nil {
+			logrus.Errorf("Unable to save job %s/%s: %s", job.Type, job.Name, err)
+		}
+
+		if err := release(ctx, c, job); err != nil {
+			logrus.Errorf("Unable to release job %s/%s: %s", job.Type, job.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Create registers a new job. ErrJobExists is returned if the job with the
+// same name is still active.
+func Create(ctx context.Context, jobType, name string) (*Job, error) {
+	return CreateWithParams(ctx, jobType, name, nil)
+}
+
+// CreateWithParams registers a new job with the parameters of the operation.
+func CreateWithParams(ctx context.Context, jobType, name string, params map[string]string) (*Job, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	coll, err := metadata.NewJobsCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &metadata.JobEtcdKey{
+		Type: jobType,
+		Name: name,
+	}
+
+	revision := int64(0)
+
+	rec, err := coll.Get(key)
+	switch err {
+	case nil:
+		job, err := parseJob(rec.Value)
+		if err != nil {
+			return nil, err
+		}
+		if job.Active() {
+			return job, ErrJobExists
+		}
+		revision = rec.ModRevision
+	case metadata.ErrKeyNotFound:
+	default:
+		return nil, err
+	}
+
+	now := time.Now()
+
+	job := &Job{
+		Type:     jobType,
+		Name:     name,
+		Owner:    cfg.Global.Hostname,
+		State:    StatePending,
+		Params:   params,
+		Progress: make(map[string]int64),
+		Created:  now,
+		Updated:  now,
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	txn := metadata.NewTransaction(ctx, cfg)
+	txn.Unmodified(key, revision)
+	txn.Put(key, string(data))
+
+	if err := txn.Commit(); err != nil {
+		if err == metadata.ErrKeyModified {
+			return nil, ErrJobExists
+		}
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Get returns the job state.
+func Get(ctx context.Context, jobType, name string) (*Job, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	coll, err := metadata.NewJobsCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := coll.Get(&metadata.JobEtcdKey{
+		Type: jobType,
+		Name: name,
+	})
+	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+
+	return parseJob(rec.Value)
+}
+
+// List returns all jobs of the type. If type is empty all jobs are returned.
+func List(ctx context.Context, jobType string) ([]*Job, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	coll, err := metadata.NewJobsCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := coll.List(&metadata.JobEtcdKey{
+		Type: jobType,
+	}, metadata.SortAscend)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Job
+
+	for _, rec := range records {
+		job, err := parseJob(rec.Value)
+		if err != nil {
+			return nil, fmt.Errorf("bad job %s: %s", rec.RawKey, err)
+		}
+		res = append(res, job)
+	}
+
+	return res, nil
+}
+
+// IsActive returns true if there is an unfinished job with the name.
+func IsActive(ctx context.Context, jobType, name string) (bool, error) {
+	job, err := Get(ctx, jobType, name)
+	if err != nil {
+		if err == ErrJobNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return job.Active(), nil
+}
+
+func parseJob(data string) (*Job, error) {
+	job := &Job{}
+	if err := json.Unmarshal([]byte(data), job); err != nil {
+		return nil, err
+	}
+	if job.Progress == nil {
+		job.Progress = make(map[string]int64)
+	}
+	return job, nil
+}
@@ -0,0 +1,110 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3/concurrency"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+)
+
+func TestFail(t *testing.T) {
+	job := &Job{State: StateRunning}
+
+	job.Fail(errors.New("boom"))
+
+	if job.State != StatePending || job.Attempts != 1 || job.Error != "boom" {
+		t.Fatalf("unexpected job state: %#v", job)
+	}
+	if job.Ready() {
+		t.Fatalf("job is retried without delay")
+	}
+
+	for i := 1; i < maxAttempts; i++ {
+		job.Fail(errors.New("boom"))
+	}
+
+	if job.State != StateFailed || job.Active() {
+		t.Fatalf("expected job to fail after %d attempts: %#v", maxAttempts, job)
+	}
+	if job.RetryAt.Sub(time.Now()) > maxRetryDelay {
+		t.Fatalf("delay exceeds the limit: %s", job.RetryAt.Sub(time.Now()))
+	}
+}
+
+func TestClaimTakeover(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	nodeCfg := func(name string) (context.Context, *etcd.EtcdClient) {
+		c := *cfg
+		c.Global.Hostname = name
+
+		client, err := etcd.NewEtcdClient(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return context.WithValue(context.Background(), config.AppConfigContextVar, &c), client
+	}
+
+	ctx1, c1 := nodeCfg("node1")
+	defer c1.Close()
+
+	ctx2, c2 := nodeCfg("node2")
+	defer c2.Close()
+
+	job, err := Create(ctx1, TopicDeletion, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := claim(ctx1, c1, job); err != nil || !ok {
+		t.Fatalf("unable to claim job: %v %v", ok, err)
+	}
+	if ok, err := claim(ctx1, c1, job); err != nil || !ok {
+		t.Fatalf("owner is unable to claim job again: %v %v", ok, err)
+	}
+	if ok, err := claim(ctx2, c2, job); err != nil || ok {
+		t.Fatalf("job is claimed by two nodes: %v %v", ok, err)
+	}
+
+	// The owner is gone along with its lease.
+	session, err := concurrency.NewSession(c1.Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	processed := 0
+
+	err = Process(ctx2, c2, TopicDeletion, func(job *Job) error {
+		processed++
+		if job.Owner != "node2" {
+			t.Fatalf("unexpected owner: %s", job.Owner)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if processed != 1 {
+		t.Fatalf("expected job to be taken over, processed %d", processed)
+	}
+
+	job, err = Get(ctx2, TopicDeletion, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != StateDone {
+		t.Fatalf("unexpected job state: %s", job.State)
+	}
+}
@@ -0,0 +1,676 @@
+// Package leader elects the leaders of partitions which append the messages.
+//
+// Without the leader every node allocates the offset of the message by the
+// optimistic transaction (see etcd.NewSequentialKV), which is retried when
+// several nodes append to the same partition at once. The leader allocates
+// offsets in memory and appends the messages accumulated while the previous
+// transaction was in progress in one transaction. Other nodes forward the
+// messages to the leader.
+//
+// The node becomes a candidate when it appends to the partition and withdraws
+// when the partition is not used for a while. The candidates are elected
+// through etcd, the election key is attached to the lease of the etcd session,
+// so when the leader is gone, the next candidate is elected after the lease
+// expires. Until then the nodes append the messages themselves as without the
+// leader. The leader checks its election key in every transaction, so the
+// leader which lost the lease can not append anything.
+package leader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	v3 "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+
+	"github.com/legionus/kavka/pkg/client"
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/placement"
+)
+
+const (
+	AppLeadersContextVar = "app.leaders"
+
+	// The transaction of the batch should fit into the etcd request.
+	maxBatch      = 50
+	maxBatchBytes = 512 * 1024
+
+	// maxConflicts limits the retries of the batch when the partition is
+	// modified by other nodes.
+	maxConflicts = 10
+
+	leaderCacheTTL = time.Second
+	idlePeriod     = time.Minute
+	retryPeriod    = time.Second
+
+	// cancelTTL is the lifetime of the cancel key of the forwarded record in
+	// seconds. It should outlive the request queued by the leader.
+	cancelTTL = 10 * 60
+)
+
+var (
+	// ErrNotLeader is returned when the message can not be appended by the
+	// leader. The caller should append the message itself.
+	ErrNotLeader = errors.New("not the leader of the partition")
+
+	// ErrAppendCancelled is returned when the forwarded record is not
+	// appended because the node which forwarded it appends it itself.
+	ErrAppendCancelled = errors.New("append of the forwarded record is cancelled")
+)
+
+type request struct {
+	id    string
+	value string
+	// cancel is the key which cancels the forwarded record.
+	cancel string
+	key    *metadata.QueueEtcdKey
+	err    error
+	done   chan struct{}
+}
+
+// candidacy is the participation of the node in the election of the partition.
+type candidacy struct {
+	sync.Mutex
+
+	topic     string
+	partition int64
+	election  *concurrency.Election
+	cancel    func()
+
+	leading  bool
+	queue    []*request
+	wakeup   chan struct{}
+	lastUsed time.Time
+
+	leader     *placement.Node
+	leaderTime time.Time
+}
+
+// Leaders manages the elections of partitions in which the node takes part.
+type Leaders struct {
+	sync.Mutex
+
+	client *etcd.EtcdClient
+	self   placement.Node
+	value  string
+	// token is sent to the leader, the records are appended by the admin
+	// API.
+	token string
+
+	partitions map[string]*candidacy
+	clients    map[string]*client.Client
+}
+
+// RunLeaders returns the manager of partition elections and starts the
+// service which withdraws the node from the elections of unused partitions.
+func RunLeaders(ctx context.Context) (*Leaders, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	c, err := etcd.NewEtcdClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	self := placement.Node{
+		Group:   cfg.Global.Group,
+		Node:    cfg.Global.Hostname,
+		Address: cfg.Global.AdvertisedAddress(),
+	}
+
+	value, err := json.Marshal(self)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Leaders{
+		client:     c,
+		self:       self,
+		value:      string(value),
+		token:      cfg.Tenants.AdminToken,
+		partitions: make(map[string]*candidacy),
+		clients:    make(map[string]*client.Client),
+	}
+
+	go func() {
+		for {
+			select {
+			case <-time.After(idlePeriod):
+				l.expire()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return l, nil
+}
+
+// Append appends the message record to the partition. If the node is not the
+// leader and forward is true, the record is forwarded to the leader. If there
+// is no leader or it is unavailable, ErrNotLeader is returned.
+//
+// The forwarded record could be appended even if the response of the leader
+// is lost. So after the failed forward the record is cancelled (see
+// CancelEtcdKey) and looked up by the message ID. The record without ID can
+// not be cancelled, so the error is returned instead of ErrNotLeader to avoid
+// the duplicate.
+func (l *Leaders) Append(ctx context.Context, topic string, partition int64, id, value string, forward bool) (*metadata.QueueEtcdKey, error) {
+	p := l.get(topic, partition)
+
+	cancel := metadata.NoString
+	if !forward && id != metadata.NoString {
+		cancel = CancelKey(topic, partition, id).String()
+	}
+
+	res, err := p.append(id, value, cancel)
+	if err != ErrNotLeader || !forward {
+		return res, err
+	}
+
+	node, err := l.leaderOf(ctx, p)
+	if err != nil {
+		if err != concurrency.ErrElectionNoLeader {
+			logrus.Errorf("Unable to get leader of %s/%d: %s", topic, partition, err)
+		}
+		return nil, ErrNotLeader
+	}
+
+	// The node is elected, but does not lead yet.
+	if node.Group == l.self.Group && node.Node == l.self.Node {
+		return nil, ErrNotLeader
+	}
+
+	res, err = l.forward(node, topic, partition, id, value)
+	if err != nil {
+		logrus.Warnf("Unable to forward message to leader %s/%s of %s/%d: %s", node.Group, node.Node, topic, partition, err)
+
+		p.Lock()
+		p.leader = nil
+		p.Unlock()
+
+		if id == metadata.NoString {
+			return nil, err
+		}
+
+		if err := l.cancel(ctx, topic, partition, id); err != nil {
+			return nil, err
+		}
+
+		res, err = l.lookup(ctx, topic, partition, id)
+		if err != nil {
+			return nil, err
+		}
+		if res != nil {
+			return res, nil
+		}
+
+		return nil, ErrNotLeader
+	}
+
+	return res, nil
+}
+
+// CancelKey returns the key which cancels the forwarded record.
+func CancelKey(topic string, partition int64, id string) *metadata.CancelEtcdKey {
+	return &metadata.CancelEtcdKey{
+		Topic:     topic,
+		Partition: partition,
+		ID:        id,
+	}
+}
+
+// cancel prevents the forwarded record from being appended by the leader
+// later.
+func (l *Leaders) cancel(ctx context.Context, topic string, partition int64, id string) error {
+	lease, err := l.client.Grant(ctx, cancelTTL)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.client.Put(ctx, CancelKey(topic, partition, id).String(), "", v3.WithLease(lease.ID))
+	return err
+}
+
+// lookup returns the record of the message in the partition or nil if the
+// message is not appended.
+func (l *Leaders) lookup(ctx context.Context, topic string, partition int64, id string) (*metadata.QueueEtcdKey, error) {
+	prefix := &metadata.MessageEtcdKey{
+		ID:        id,
+		Topic:     topic,
+		Partition: partition,
+		Offset:    metadata.NoOffset,
+	}
+
+	resp, err := l.client.Get(ctx, prefix.String()+"/", v3.WithPrefix(), v3.WithLimit(1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+
+	return metadata.ParseQueueEtcdKey(string(resp.Kvs[0].Value))
+}
+
+func (l *Leaders) get(topic string, partition int64) *candidacy {
+	l.Lock()
+	defer l.Unlock()
+
+	key := &metadata.LeaderEtcdKey{
+		Topic:     topic,
+		Partition: partition,
+	}
+
+	p, ok := l.partitions[key.String()]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		p = &candidacy{
+			topic:     topic,
+			partition: partition,
+			election:  concurrency.NewElection(l.client.Client, key.String()),
+			cancel:    cancel,
+			wakeup:    make(chan struct{}, 1),
+		}
+		l.partitions[key.String()] = p
+
+		go l.campaign(ctx, p)
+	}
+
+	p.Lock()
+	p.lastUsed = time.Now()
+	p.Unlock()
+
+	return p
+}
+
+// expire withdraws the node from the elections of partitions which are not
+// used.
+func (l *Leaders) expire() {
+	l.Lock()
+	defer l.Unlock()
+
+	for name, p := range l.partitions {
+		p.Lock()
+		idle := time.Since(p.lastUsed) > idlePeriod
+		p.Unlock()
+
+		if idle {
+			p.cancel()
+			delete(l.partitions, name)
+		}
+	}
+}
+
+func (l *Leaders) leaderOf(ctx context.Context, p *candidacy) (*placement.Node, error) {
+	p.Lock()
+	if p.leader != nil && time.Since(p.leaderTime) < leaderCacheTTL {
+		node := *p.leader
+		p.Unlock()
+		return &node, nil
+	}
+	p.Unlock()
+
+	value, err := p.election.Leader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &placement.Node{}
+	if err := json.Unmarshal([]byte(value), node); err != nil {
+		return nil, err
+	}
+
+	p.Lock()
+	p.leader = node
+	p.leaderTime = time.Now()
+	p.Unlock()
+
+	return node, nil
+}
+
+func (l *Leaders) forward(node *placement.Node, topic string, partition int64, id, value string) (*metadata.QueueEtcdKey, error) {
+	if node.Address == "" {
+		return nil, fmt.Errorf("address is unknown")
+	}
+
+	l.Lock()
+	c, ok := l.clients[node.Address]
+	if !ok {
+		var err error
+		c, err = client.New(node.Address, 3*time.Second)
+		if err != nil {
+			l.Unlock()
+			return nil, err
+		}
+		c.SetToken(l.token)
+		l.clients[node.Address] = c
+	}
+	l.Unlock()
+
+	data, err := c.AppendRecord(topic, partition, id, value)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &metadata.QueueEtcdKey{}
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// campaign takes part in the election of the partition until ctx is cancelled.
+func (l *Leaders) campaign(ctx context.Context, p *candidacy) {
+	for {
+		err := p.election.Campaign(ctx, l.value)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			logrus.Errorf("Election of %s/%d fails: %s", p.topic, p.partition, err)
+
+			select {
+			case <-time.After(retryPeriod):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		logrus.Infof("Node is elected the leader of %s/%d", p.topic, p.partition)
+
+		// Campaign uses the session of the client, so the same session
+		// is returned.
+		if session, err := concurrency.NewSession(l.client.Client); err != nil {
+			logrus.Errorf("Unable to obtain etcd session: %s", err)
+		} else {
+			l.lead(ctx, p, session.Done())
+		}
+
+		if err := p.election.Resign(context.Background()); err != nil {
+			logrus.Errorf("Unable to resign the leadership of %s/%d: %s", p.topic, p.partition, err)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		logrus.Warnf("Node is no longer the leader of %s/%d", p.topic, p.partition)
+	}
+}
+
+// lead appends the queued records until the leadership is lost or ctx is
+// cancelled.
+func (l *Leaders) lead(ctx context.Context, p *candidacy, done <-chan struct{}) {
+	prefix := &metadata.QueueEtcdKey{
+		Topic:     p.topic,
+		Partition: p.partition,
+		Offset:    metadata.NoOffset,
+	}
+
+	seq := etcd.NewSequentialBatch(l.client, prefix.String())
+	fence := v3.Compare(v3.CreateRevision(p.election.Key()), ">", 0)
+
+	p.Lock()
+	p.leading = true
+	p.Unlock()
+
+	defer p.stop()
+
+	for {
+		select {
+		case <-p.wakeup:
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		}
+
+		for {
+			batch := p.take()
+			if len(batch) == 0 {
+				break
+			}
+
+			if err := l.commit(seq, p, fence, batch); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// commit appends the batch in one transaction and replies to the requests.
+// The forwarded records are appended only if they are not cancelled. It
+// returns ErrNotLeader if the node is no longer the leader.
+func (l *Leaders) commit(seq *etcd.SequentialBatch, p *candidacy, fence v3.Cmp, batch []*request) error {
+	for i := 0; ; {
+		vals := make([]string, len(batch))
+		cmps := []v3.Cmp{fence}
+
+		for j, req := range batch {
+			vals[j] = req.value
+
+			if req.cancel != metadata.NoString {
+				cmps = append(cmps, v3.Compare(v3.CreateRevision(req.cancel), "=", 0))
+			}
+		}
+
+		extra := func(key string, i int) []v3.Op {
+			if batch[i].id == metadata.NoString {
+				return nil
+			}
+			return metadata.MessageIndexOps(key, batch[i].id)
+		}
+
+		keys, err := seq.Create(context.Background(), vals, extra, cmps...)
+		if err == nil {
+			reply(batch, keys, nil)
+			return nil
+		}
+
+		if err != etcd.ErrSequenceConflict {
+			reply(batch, nil, err)
+			return nil
+		}
+
+		if rest := l.dropCancelled(batch); len(rest) < len(batch) {
+			if len(rest) == 0 {
+				return nil
+			}
+			batch = rest
+			continue
+		}
+
+		// The partition is modified by other nodes (e.g. the messages
+		// are appended without the leader) or the election key is gone.
+		if i >= maxConflicts || !l.elected(p) {
+			reply(batch, nil, ErrNotLeader)
+			return ErrNotLeader
+		}
+		i++
+	}
+}
+
+// dropCancelled replies to the cancelled requests and returns the rest.
+func (l *Leaders) dropCancelled(batch []*request) []*request {
+	var rest []*request
+
+	for _, req := range batch {
+		if req.cancel != metadata.NoString {
+			resp, err := l.client.Get(context.Background(), req.cancel, v3.WithCountOnly())
+			if err == nil && resp.Count > 0 {
+				req.err = ErrAppendCancelled
+				close(req.done)
+				continue
+			}
+		}
+		rest = append(rest, req)
+	}
+
+	return rest
+}
+
+func (l *Leaders) elected(p *candidacy) bool {
+	resp, err := l.client.Get(context.Background(), p.election.Key())
+	if err != nil {
+		return false
+	}
+	return len(resp.Kvs) > 0
+}
+
+func (p *candidacy) append(id, value, cancel string) (*metadata.QueueEtcdKey, error) {
+	p.Lock()
+	if !p.leading {
+		p.Unlock()
+		return nil, ErrNotLeader
+	}
+
+	req := &request{
+		id:     id,
+		value:  value,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	p.queue = append(p.queue, req)
+	p.Unlock()
+
+	select {
+	case p.wakeup <- struct{}{}:
+	default:
+	}
+
+	<-req.done
+
+	return req.key, req.err
+}
+
+// take returns the next batch of the queued records.
+func (p *candidacy) take() []*request {
+	p.Lock()
+	defer p.Unlock()
+
+	size := 0
+
+	for i, req := range p.queue {
+		size += len(req.value)
+
+		if i > 0 && (i >= maxBatch || size > maxBatchBytes) {
+			batch := p.queue[:i]
+			p.queue = p.queue[i:]
+			return batch
+		}
+	}
+
+	batch := p.queue
+	p.queue = nil
+
+	return batch
+}
+
+// stop stops accepting the records and rejects the queued ones.
+func (p *candidacy) stop() {
+	p.Lock()
+	p.leading = false
+	batch := p.queue
+	p.queue = nil
+	p.Unlock()
+
+	reply(batch, nil, ErrNotLeader)
+}
+
+func reply(batch []*request, keys []string, err error) {
+	for i, req := range batch {
+		if err != nil {
+			req.err = err
+		} else {
+			req.key, req.err = metadata.ParseQueueEtcdKey(keys[i])
+		}
+		close(req.done)
+	}
+}
+
+// Election describes the election of the partition leader.
+type Election struct {
+	Topic      string         `json:"topic"`
+	Partition  int64          `json:"partition"`
+	Leader     placement.Node `json:"leader"`
+	Candidates int64          `json:"candidates"`
+}
+
+// List returns the elections of partitions which have candidates.
+func List(ctx context.Context) ([]*Election, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	c, err := etcd.NewEtcdClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	// The first candidate of the partition is the leader.
+	resp, err := c.Get(ctx, metadata.LeadersEtcd+"/",
+		v3.WithPrefix(),
+		v3.WithSort(v3.SortByCreateRevision, v3.SortAscend),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*Election{}
+	elections := make(map[string]*Election)
+
+	for _, kv := range resp.Kvs {
+		key, err := metadata.ParseLeaderEtcdKey(string(kv.Key))
+		if err != nil || key.Candidate == metadata.NoString {
+			logrus.Errorf("Unable to parse key: %s", string(kv.Key))
+			continue
+		}
+
+		key.Candidate = metadata.NoString
+
+		e, ok := elections[key.String()]
+		if !ok {
+			e = &Election{
+				Topic:     key.Topic,
+				Partition: key.Partition,
+			}
+			if err := json.Unmarshal(kv.Value, &e.Leader); err != nil {
+				logrus.Errorf("Unable to parse leader of %s/%d: %s", key.Topic, key.Partition, err)
+			}
+
+			elections[key.String()] = e
+			res = append(res, e)
+		}
+
+		e.Candidates++
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Topic != res[j].Topic {
+			return res[i].Topic < res[j].Topic
+		}
+		return res[i].Partition < res[j].Partition
+	})
+
+	return res, nil
+}
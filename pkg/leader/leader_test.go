@@ -0,0 +1,356 @@
+package leader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	v3 "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/placement"
+)
+
+func TestTake(t *testing.T) {
+	p := &candidacy{}
+
+	for i := 0; i < maxBatch+10; i++ {
+		p.queue = append(p.queue, &request{value: "x"})
+	}
+
+	if n := len(p.take()); n != maxBatch {
+		t.Fatalf("expected %d records, got %d", maxBatch, n)
+	}
+	if n := len(p.take()); n != 10 {
+		t.Fatalf("expected 10 records, got %d", n)
+	}
+	if n := len(p.take()); n != 0 {
+		t.Fatalf("expected empty batch, got %d", n)
+	}
+
+	big := strings.Repeat("x", maxBatchBytes/2+1)
+
+	p.queue = []*request{{value: big}, {value: big}, {value: "x"}}
+
+	if n := len(p.take()); n != 1 {
+		t.Fatalf("expected 1 record, got %d", n)
+	}
+
+	// The record bigger than the limit is taken alone.
+	p.queue = []*request{{value: big + big}, {value: "x"}}
+
+	if n := len(p.take()); n != 1 {
+		t.Fatalf("expected 1 record, got %d", n)
+	}
+	if n := len(p.take()); n != 1 {
+		t.Fatalf("expected 1 record, got %d", n)
+	}
+}
+
+func countRecords(t *testing.T, c *etcd.EtcdClient, topic string, partition int64) int64 {
+	prefix := &metadata.QueueEtcdKey{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    metadata.NoOffset,
+	}
+
+	resp, err := c.Get(context.Background(), prefix.String()+"/", v3.WithPrefix(), v3.WithCountOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Count
+}
+
+func TestSequenceConflict(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	ctx := context.WithValue(context.Background(), config.AppConfigContextVar, cfg)
+
+	c, err := etcd.NewEtcdClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	prefix := &metadata.QueueEtcdKey{
+		Topic:     "foo",
+		Partition: 0,
+		Offset:    metadata.NoOffset,
+	}
+
+	seq := etcd.NewSequentialBatch(c, prefix.String())
+
+	if _, err := seq.Create(ctx, []string{"a"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The record appended without the leader modifies the bookkeeping key
+	// of the partition.
+	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := queuesColl.(*metadata.QueuesCollection).CreateMessage(&metadata.QueueEtcdKey{Topic: "foo", Partition: 0}, "", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := seq.Create(ctx, []string{"c"}, nil); err != etcd.ErrSequenceConflict {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+
+	// The state is reloaded, so the offset follows the foreign record.
+	keys, err := seq.Create(ctx, []string{"c"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := metadata.ParseQueueEtcdKey(keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Offset != 2 {
+		t.Fatalf("expected offset 2, got %d", key.Offset)
+	}
+}
+
+func TestFence(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	c, err := etcd.NewEtcdClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	electionKey := &metadata.LeaderEtcdKey{
+		Topic:     "foo",
+		Partition: 0,
+	}
+
+	l := &Leaders{client: c}
+	p := &candidacy{
+		topic:     "foo",
+		partition: 0,
+		election:  concurrency.NewElection(c.Client, electionKey.String()),
+	}
+
+	if err := p.election.Campaign(context.Background(), "leader"); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := &metadata.QueueEtcdKey{
+		Topic:     "foo",
+		Partition: 0,
+		Offset:    metadata.NoOffset,
+	}
+
+	seq := etcd.NewSequentialBatch(c, prefix.String())
+	fence := v3.Compare(v3.CreateRevision(p.election.Key()), ">", 0)
+
+	req := &request{id: "m1", value: "a", done: make(chan struct{})}
+
+	if err := l.commit(seq, p, fence, []*request{req}); err != nil {
+		t.Fatal(err)
+	}
+	<-req.done
+
+	if req.err != nil || req.key.Offset != 0 {
+		t.Fatalf("unexpected result: %v, %#v", req.err, req.key)
+	}
+
+	// The election key is removed as if the lease expired.
+	if _, err := c.Delete(context.Background(), p.election.Key()); err != nil {
+		t.Fatal(err)
+	}
+
+	req = &request{id: "m2", value: "b", done: make(chan struct{})}
+
+	if err := l.commit(seq, p, fence, []*request{req}); err != ErrNotLeader {
+		t.Fatalf("expected %v, got %v", ErrNotLeader, err)
+	}
+
+	if n := countRecords(t, c, "foo", 0); n != 1 {
+		t.Fatalf("expected 1 record, got %d", n)
+	}
+}
+
+func TestForward(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	ctx := context.WithValue(context.Background(), config.AppConfigContextVar, cfg)
+
+	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The leader appends the record, but the response is lost if the
+	// connection is dropped.
+	var drop int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+
+		req := struct {
+			ID    string `json:"id"`
+			Value string `json:"value"`
+		}{}
+		json.Unmarshal(data, &req)
+
+		key, err := queuesColl.(*metadata.QueuesCollection).CreateMessage(&metadata.QueueEtcdKey{Topic: "foo", Partition: 0}, req.ID, req.Value)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if atomic.LoadInt32(&drop) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+
+		data, _ = json.Marshal(key)
+		w.Write([]byte(`{"status":"success","data":` + string(data) + `}`))
+	}))
+	defer srv.Close()
+
+	c, err := etcd.NewEtcdClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	value, _ := json.Marshal(placement.Node{
+		Group:   "other",
+		Node:    "other",
+		Address: srv.Listener.Addr().String(),
+	})
+
+	electionKey := &metadata.LeaderEtcdKey{
+		Topic:     "foo",
+		Partition: 0,
+	}
+
+	if err := concurrency.NewElection(c.Client, electionKey.String()).Campaign(ctx, string(value)); err != nil {
+		t.Fatal(err)
+	}
+
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	l, err := RunLeaders(lctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, p := range l.partitions {
+			p.cancel()
+		}
+	}()
+
+	key, err := l.Append(ctx, "foo", 0, "m1", "a", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Offset != 0 {
+		t.Fatalf("expected offset 0, got %d", key.Offset)
+	}
+
+	atomic.StoreInt32(&drop, 1)
+
+	// The record appended by the leader is found by the message ID, so it
+	// is not appended once more.
+	key, err = l.Append(ctx, "foo", 0, "m2", "b", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Offset != 1 {
+		t.Fatalf("expected offset 1, got %d", key.Offset)
+	}
+
+	// The record without ID can not be found.
+	if _, err := l.Append(ctx, "foo", 0, "", "c", true); err == nil || err == ErrNotLeader {
+		t.Fatalf("expected forward error, got %v", err)
+	}
+
+	if n := countRecords(t, c, "foo", 0); n != 3 {
+		t.Fatalf("expected 3 records, got %d", n)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	c, err := etcd.NewEtcdClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	electionKey := &metadata.LeaderEtcdKey{
+		Topic:     "foo",
+		Partition: 0,
+	}
+
+	l := &Leaders{client: c}
+	p := &candidacy{
+		topic:     "foo",
+		partition: 0,
+		election:  concurrency.NewElection(c.Client, electionKey.String()),
+	}
+
+	if err := p.election.Campaign(context.Background(), "leader"); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := &metadata.QueueEtcdKey{
+		Topic:     "foo",
+		Partition: 0,
+		Offset:    metadata.NoOffset,
+	}
+
+	seq := etcd.NewSequentialBatch(c, prefix.String())
+	fence := v3.Compare(v3.CreateRevision(p.election.Key()), ">", 0)
+
+	// The node which forwarded the record has given up and cancelled it.
+	if err := l.cancel(context.Background(), "foo", 0, "m1"); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := []*request{
+		{id: "m1", value: "a", cancel: CancelKey("foo", 0, "m1").String(), done: make(chan struct{})},
+		{id: "m2", value: "b", cancel: CancelKey("foo", 0, "m2").String(), done: make(chan struct{})},
+	}
+
+	if err := l.commit(seq, p, fence, batch); err != nil {
+		t.Fatal(err)
+	}
+
+	<-batch[0].done
+	<-batch[1].done
+
+	if batch[0].err != ErrAppendCancelled {
+		t.Fatalf("expected %v, got %v", ErrAppendCancelled, batch[0].err)
+	}
+	if batch[1].err != nil || batch[1].key.Offset != 0 {
+		t.Fatalf("unexpected result: %v, %#v", batch[1].err, batch[1].key)
+	}
+
+	if n := countRecords(t, c, "foo", 0); n != 1 {
+		t.Fatalf("expected 1 record, got %d", n)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -16,6 +15,7 @@ import (
 	"github.com/legionus/kavka/pkg/digest"
 	"github.com/legionus/kavka/pkg/etcd/observer"
 	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/placement"
 	"github.com/legionus/kavka/pkg/storage"
 	"github.com/legionus/kavka/pkg/syncer"
 )
@@ -43,6 +43,9 @@ func ParseMessageInfo(data string) (*MessageInfo, error) {
 type MessageInfo struct {
 	ID           string               `json:"id"`
 	CreationTime string               `json:"creation-time"`
+	Key          string               `json:"key,omitempty"`
+	Tombstone    bool                 `json:"tombstone,omitempty"`
+	Headers      map[string]string    `json:"headers,omitempty"`
 	Blobs        []storage.Descriptor `json:"blobs"`
 }
 
@@ -109,6 +112,29 @@ func (d *MessageInfo) CopyIn(ctx context.Context, r io.Reader) error {
 		return fmt.Errorf("Unable to obtain blob observer from context")
 	}
 
+	topicCfg, ok := ctx.Value(config.TopicConfigContextVar).(*config.Topic)
+	if !ok {
+		topicCfg = &cfg.Topic
+	}
+
+	acks, ok := ctx.Value(AcksContextVar).(int64)
+	if !ok {
+		acks = topicCfg.WriteConcern
+	}
+
+	draining, err := placement.IsDraining(ctx, cfg.Global.Group, cfg.Global.Hostname)
+	if err != nil {
+		return err
+	}
+	if draining {
+		return ErrNodeDraining
+	}
+
+	required, err := requiredGroups(ctx, cfg, acks)
+	if err != nil {
+		return err
+	}
+
 	blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
 	if err != nil {
 		return err
@@ -116,83 +142,87 @@ func (d *MessageInfo) CopyIn(ctx context.Context, r io.Reader) error {
 
 	nowValue := time.Now().String()
 
-	// Count number of groups replicated chunk.
-	replic := make(map[digest.Digest]int64)
+	rep := newReplication(required)
 
-	// Track groups replacated chunk.
-	chunks := make(map[digest.Digest]map[string]struct{})
+	if required > 0 {
+		bf, err := observer.NewEtcdFilter(obsrv, func(ev *observer.EtcdEvent) {
+			if !ev.IsCreate() {
+				return
+			}
 
-	var wg sync.WaitGroup
+			resKey, err := metadata.ParseBlobsEtcdKey(string(ev.Kv.Key))
+			if err != nil {
+				logrus.Error(err)
+				return
+			}
 
-	bf, err := observer.NewEtcdFilter(obsrv, func(ev *observer.EtcdEvent) {
-		if !ev.IsCreate() {
-			return
-		}
+			rep.Confirm(resKey.Digest, resKey.Group)
+		})
 
-		resKey, err := metadata.ParseBlobsEtcdKey(string(ev.Kv.Key))
 		if err != nil {
-			logrus.Error(err)
-			return
+			return err
 		}
 
-		if _, ok := chunks[resKey.Digest]; !ok {
-			return
-		}
+		// The confirmations could be lost, so they are read from etcd.
+		bf.OnResync(func() {
+			rep.Recheck(blobsColl)
+		})
 
-		if _, ok := chunks[resKey.Digest][resKey.Group]; !ok {
-			chunks[resKey.Digest][resKey.Group] = struct{}{}
-			replic[resKey.Digest]++
-		}
+		bf.Start()
+		defer bf.Stop()
+	}
 
-		if replic[resKey.Digest] == cfg.Topic.WriteConcern {
-			wg.Done()
-		}
-	})
+	// Chunks written by this call. They are removed if the write fails.
+	var written []storage.Descriptor
 
-	if err != nil {
+	fail := func(err error) error {
+		if len(written) > 0 {
+			partial := &MessageInfo{
+				Blobs: written,
+			}
+			if e := partial.Delete(ctx); e != nil {
+				logrus.Errorf("Unable to remove chunks of failed message %s: %s", d.ID, e)
+			}
+		}
 		return err
 	}
 
-	bf.Start()
-
 	var errIO error
 
 	for errIO != io.EOF {
 		chunk := bytes.NewBuffer(nil)
 
-		_, errIO = io.CopyN(chunk, r, cfg.Topic.MaxChunkSize)
+		_, errIO = io.CopyN(chunk, r, topicCfg.MaxChunkSize)
 
 		if errIO != nil && errIO != io.EOF {
-			return errIO
+			return fail(errIO)
 		}
 
 		dgst := digest.FromBytes(chunk.Bytes())
 
+		desc := storage.Descriptor{
+			Digest: dgst,
+			Size:   int64(chunk.Len()),
+		}
+
 		if has, err := st.Has(dgst); err != nil {
-			return err
+			return fail(err)
 		} else if has {
-			d.Blobs = append(d.Blobs, storage.Descriptor{
-				Digest: dgst,
-				Size:   int64(chunk.Len()),
-			})
+			d.Blobs = append(d.Blobs, desc)
 			continue
 		}
 
 		_, err = st.Write(chunk.Bytes())
 		if err != nil {
 			if err == storage.ErrBlobExists {
-				d.Blobs = append(d.Blobs, storage.Descriptor{
-					Digest: dgst,
-					Size:   int64(chunk.Len()),
-				})
+				d.Blobs = append(d.Blobs, desc)
 				continue
 			}
-			return err
+			return fail(err)
 		}
 
-		chunks[dgst] = make(map[string]struct{})
-		replic[dgst] = int64(0)
-		wg.Add(1)
+		written = append(written, desc)
+		rep.Add(dgst)
 
 		_, err = blobsColl.Create(
 			&metadata.BlobEtcdKey{
@@ -203,21 +233,64 @@ func (d *MessageInfo) CopyIn(ctx context.Context, r io.Reader) error {
 			nowValue,
 		)
 		if err != nil {
-			return err
+			return fail(err)
 		}
 
-		d.Blobs = append(d.Blobs, storage.Descriptor{
-			Digest: dgst,
-			Size:   int64(chunk.Len()),
-		})
+		d.Blobs = append(d.Blobs, desc)
+	}
+
+	if required == 0 {
+		return nil
 	}
 
-	wg.Wait()
-	bf.Stop()
+	if err := rep.Wait(ctx, topicCfg.ReplicationTimeout); err != nil {
+		// Events could be lost, so the locations are checked once more.
+		if _, ok := err.(*ReplicationError); ok && rep.Recheck(blobsColl) {
+			return nil
+		}
+		return fail(err)
+	}
 
 	return nil
 }
 
+// requiredGroups returns the number of groups which should confirm the write
+// of each chunk.
+func requiredGroups(ctx context.Context, cfg *config.Config, acks int64) (int64, error) {
+	// The local group confirms the write itself.
+	if acks == 0 || acks == 1 {
+		return acks, nil
+	}
+
+	nodes, err := placement.ListNodes(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	groups := make(map[string]struct{})
+	for _, n := range nodes {
+		groups[n.Group] = struct{}{}
+	}
+
+	available := int64(len(groups))
+
+	if acks == AcksAll {
+		if cfg.Storage.ReplicationFactor > 0 && int64(cfg.Storage.ReplicationFactor) < available {
+			return int64(cfg.Storage.ReplicationFactor), nil
+		}
+		return available, nil
+	}
+
+	if acks > available {
+		return 0, &UnavailableError{
+			Required:  acks,
+			Available: available,
+		}
+	}
+
+	return acks, nil
+}
+
 func (d *MessageInfo) MakeRefs(ctx context.Context, topic string, partition int64) error {
 	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
 	if !ok {
@@ -225,7 +298,13 @@ func (d *MessageInfo) MakeRefs(ctx context.Context, topic string, partition int6
 	}
 
 	txn := metadata.NewTransaction(ctx, cfg)
+	d.PutRefs(txn, topic, partition)
 
+	return txn.Commit()
+}
+
+// PutRefs adds the references to the chunks of the message to txn.
+func (d *MessageInfo) PutRefs(txn *metadata.Transaction, topic string, partition int64) {
 	ref := &metadata.RefsEtcdKey{
 		Topic:     topic,
 		Partition: partition,
@@ -238,8 +317,6 @@ func (d *MessageInfo) MakeRefs(ctx context.Context, topic string, partition int6
 
 		txn.Put(ref, time.Now().String())
 	}
-
-	return txn.Commit()
 }
 
 func (d *MessageInfo) RemoveRefs(ctx context.Context, topic string, partition int64) error {
@@ -282,13 +359,36 @@ func (d *MessageInfo) Delete(ctx context.Context) error {
 		return err
 	}
 
+	refsColl, err := metadata.NewRefsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
 	for _, blob := range d.Blobs {
+		// The chunk can be shared with other messages.
+		value, err := refsColl.Get(
+			&metadata.RefsEtcdKey{
+				Digest:    blob.Digest,
+				Partition: metadata.NoPartition,
+				Order:     metadata.NoOrder,
+			},
+			metadata.PrefixKey,
+			metadata.CountKey,
+		)
+		if err != nil {
+			return err
+		}
+
+		if value.Count > 0 {
+			continue
+		}
+
 		if err := st.Delete(blob.Digest); err != nil {
 			if err != storage.ErrBlobUnknown {
 				return err
 			}
 		}
-		err := blobsColl.Delete(
+		err = blobsColl.Delete(
 			&metadata.BlobEtcdKey{
 				Digest: blob.Digest,
 				Group:  cfg.Global.Group,
@@ -0,0 +1,225 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+const (
+	// AcksContextVar overrides the write concern of the topic for the request.
+	AcksContextVar = "app.message.acks"
+
+	// AcksAll waits for all the groups which should keep the chunk.
+	AcksAll = -1
+)
+
+// ParseAcks parses the number of groups which should confirm the write of
+// each chunk: a non-negative number or "all".
+func ParseAcks(value string) (int64, error) {
+	if strings.ToLower(value) == "all" {
+		return AcksAll, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("acks must be a non-negative number or \"all\": %s", value)
+	}
+
+	return n, nil
+}
+
+// ErrNodeDraining is returned when the message is written to the draining
+// node. The chunks written to the draining node could be left without replicas
+// after the node is decommissioned.
+var ErrNodeDraining = errors.New("node is draining, write to another node")
+
+// UnavailableError is returned when the cluster has not enough groups to
+// confirm the write.
+type UnavailableError struct {
+	Required  int64 `json:"required"`
+	Available int64 `json:"available"`
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("not enough groups to confirm write: required %d, available %d", e.Required, e.Available)
+}
+
+// UnderReplicatedChunk describes the chunk which was not confirmed by enough
+// groups.
+type UnderReplicatedChunk struct {
+	Digest digest.Digest `json:"digest"`
+	Groups []string      `json:"groups"`
+}
+
+// ReplicationError is returned when the chunks were not confirmed by enough
+// groups in time.
+type ReplicationError struct {
+	Required int64                   `json:"required"`
+	Chunks   []*UnderReplicatedChunk `json:"chunks"`
+}
+
+func (e *ReplicationError) Error() string {
+	var list []string
+	for _, chunk := range e.Chunks {
+		list = append(list, fmt.Sprintf("%s (%d)", chunk.Digest, len(chunk.Groups)))
+	}
+	return fmt.Sprintf("write is not confirmed by %d groups in time, under-replicated chunks: %s", e.Required, strings.Join(list, ", "))
+}
+
+// replication tracks the groups which confirmed the chunks.
+type replication struct {
+	sync.Mutex
+
+	required int64
+	pending  int
+	order    []digest.Digest
+	groups   map[digest.Digest]map[string]struct{}
+	notify   chan struct{}
+}
+
+func newReplication(required int64) *replication {
+	return &replication{
+		required: required,
+		groups:   make(map[digest.Digest]map[string]struct{}),
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// Add starts tracking of the chunk.
+func (r *replication) Add(dgst digest.Digest) {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.groups[dgst]; ok {
+		return
+	}
+
+	r.groups[dgst] = make(map[string]struct{})
+	r.order = append(r.order, dgst)
+	r.pending++
+}
+
+// Confirm registers the copy of the chunk in the group.
+func (r *replication) Confirm(dgst digest.Digest, group string) {
+	r.Lock()
+	defer r.Unlock()
+
+	groups, ok := r.groups[dgst]
+	if !ok {
+		return
+	}
+
+	if _, ok := groups[group]; ok {
+		return
+	}
+
+	groups[group] = struct{}{}
+
+	if int64(len(groups)) != r.required {
+		return
+	}
+
+	r.pending--
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Done checks whether all the chunks are confirmed.
+func (r *replication) Done() bool {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.pending == 0
+}
+
+// Wait waits until all the chunks are confirmed. If timeout is zero, it waits
+// until the context is done.
+func (r *replication) Wait(ctx context.Context, timeout time.Duration) error {
+	var expired <-chan time.Time
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for !r.Done() {
+		select {
+		case <-r.notify:
+		case <-expired:
+			return r.Error()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Recheck confirms the chunks by their locations in etcd and checks whether
+// all the chunks are confirmed.
+func (r *replication) Recheck(blobsColl metadata.EtcdCollection) bool {
+	for _, chunk := range r.Error().Chunks {
+		records, err := blobsColl.List(&metadata.BlobEtcdKey{
+			Digest: chunk.Digest,
+		})
+		if err != nil {
+			logrus.Errorf("Unable to list locations of %s: %s", chunk.Digest, err)
+			return false
+		}
+
+		for _, rec := range records {
+			key, err := metadata.ParseBlobsEtcdKey(rec.RawKey)
+			if err != nil {
+				logrus.Error(err)
+				continue
+			}
+			r.Confirm(key.Digest, key.Group)
+		}
+	}
+
+	return r.Done()
+}
+
+// Error returns the list of chunks which are not confirmed yet.
+func (r *replication) Error() *ReplicationError {
+	r.Lock()
+	defer r.Unlock()
+
+	res := &ReplicationError{
+		Required: r.required,
+	}
+
+	for _, dgst := range r.order {
+		if int64(len(r.groups[dgst])) >= r.required {
+			continue
+		}
+
+		chunk := &UnderReplicatedChunk{
+			Digest: dgst,
+			Groups: []string{},
+		}
+		for group := range r.groups[dgst] {
+			chunk.Groups = append(chunk.Groups, group)
+		}
+		sort.Strings(chunk.Groups)
+
+		res.Chunks = append(res.Chunks, chunk)
+	}
+
+	return res
+}
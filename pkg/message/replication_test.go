@@ -0,0 +1,68 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/legionus/kavka/pkg/digest"
+)
+
+func TestParseAcks(t *testing.T) {
+	testCases := []struct {
+		value  string
+		result int64
+		fail   bool
+	}{
+		{"0", 0, false},
+		{"3", 3, false},
+		{"all", AcksAll, false},
+		{"ALL", AcksAll, false},
+		{"-1", 0, true},
+		{"many", 0, true},
+	}
+
+	for _, tc := range testCases {
+		res, err := ParseAcks(tc.value)
+		if (err != nil) != tc.fail {
+			t.Errorf("%s: unexpected error: %v", tc.value, err)
+			continue
+		}
+		if res != tc.result {
+			t.Errorf("%s: got %d, expected %d", tc.value, res, tc.result)
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	a := digest.FromBytes([]byte("a"))
+	b := digest.FromBytes([]byte("b"))
+
+	rep := newReplication(2)
+	rep.Add(a)
+	rep.Add(b)
+
+	// The same group confirms only once.
+	rep.Confirm(a, "group-1")
+	rep.Confirm(a, "group-1")
+	rep.Confirm(b, "group-1")
+
+	// Unknown chunks are ignored.
+	rep.Confirm(digest.FromBytes([]byte("c")), "group-2")
+
+	if rep.Done() {
+		t.Fatalf("replication must not be done")
+	}
+
+	rep.Confirm(a, "group-2")
+	rep.Confirm(a, "group-3")
+
+	err := rep.Error()
+	if len(err.Chunks) != 1 || err.Chunks[0].Digest != b {
+		t.Fatalf("wrong under-replicated chunks: %s", err)
+	}
+
+	rep.Confirm(b, "group-2")
+
+	if !rep.Done() {
+		t.Fatalf("replication must be done: %s", rep.Error())
+	}
+}
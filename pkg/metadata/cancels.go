@@ -0,0 +1,37 @@
+package metadata
+
+import (
+	"fmt"
+)
+
+const (
+	CancelsEtcd = "/cancels"
+)
+
+// CancelEtcdKey marks the message record forwarded to the partition leader
+// which must not be appended anymore. The node which forwarded the record puts
+// the key before it appends the record itself, and the forwarded record is
+// appended only if the key does not exist. The key is attached to a lease.
+type CancelEtcdKey struct {
+	Topic     string `json:"topic"`
+	Partition int64  `json:"partition"`
+	ID        string `json:"id"`
+}
+
+func (k *CancelEtcdKey) String() (res string) {
+	res = CancelsEtcd
+
+	if k.Topic != NoString {
+		res += "/" + k.Topic
+	}
+
+	if k.Partition > NoPartition {
+		res += fmt.Sprintf("/%d", k.Partition)
+	}
+
+	if k.ID != NoString {
+		res += "/" + k.ID
+	}
+
+	return
+}
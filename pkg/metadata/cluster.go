@@ -64,3 +64,62 @@ func NewNodesCollection(ctx context.Context, cfg *config.Config) (EtcdCollection
 	}
 	return &NodesCollection{base}, nil
 }
+
+const (
+	MembersObserverContextVar = "app.observer.members"
+	MembersEtcd               = "/members"
+)
+
+var (
+	memberEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + MembersEtcd + "/(?P<group>[^/]+)(/(?P<node>.+))?$")
+)
+
+// MemberEtcdKey points to the permanent record of the node. Unlike the record
+// in ClusterEtcd, it is kept when the node is gone.
+type MemberEtcdKey struct {
+	Group string `json:"group"`
+	Node  string `json:"node"`
+}
+
+func (k *MemberEtcdKey) String() (res string) {
+	res = MembersEtcd
+	if k.Group != NoString {
+		res += "/" + k.Group
+	}
+	if k.Node != NoString {
+		res += "/" + k.Node
+	}
+	return
+}
+
+func ParseMemberEtcdKey(value string) (*MemberEtcdKey, error) {
+	key := &MemberEtcdKey{}
+
+	match := memberEtcdKeyRegexp.FindStringSubmatch(value)
+
+	if len(match) < 1 || len(match) > 4 {
+		return key, fmt.Errorf("bad member key: %s", value)
+	}
+
+	if len(match) > 1 {
+		key.Group = match[1]
+	}
+
+	if len(match) > 3 {
+		key.Node = match[3]
+	}
+
+	return key, nil
+}
+
+type MembersCollection struct {
+	EtcdCollection
+}
+
+func NewMembersCollection(ctx context.Context, cfg *config.Config) (EtcdCollection, error) {
+	base, err := newBaseCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &MembersCollection{base}, nil
+}
@@ -57,6 +57,7 @@ func (b *baseCollection) List(key EtcdKey, opts ...ListOption) ([]EtcdValue, err
 		res[i].RawKey = string(v.Key)
 		res[i].Value = string(v.Value)
 		res[i].Count = resp.Count
+		res[i].ModRevision = v.ModRevision
 	}
 
 	return res, nil
@@ -81,6 +82,7 @@ func (b *baseCollection) ListRange(firstKey EtcdKey, lastKey EtcdKey) ([]EtcdVal
 		res[i].RawKey = string(v.Key)
 		res[i].Value = string(v.Value)
 		res[i].Count = resp.Count
+		res[i].ModRevision = v.ModRevision
 	}
 
 	return res, nil
@@ -94,6 +96,8 @@ func (b *baseCollection) Get(key EtcdKey, opts ...GetOption) (*EtcdValue, error)
 
 	for _, opt := range opts {
 		switch opt {
+		case PrefixKey:
+			ops = append(ops, v3.WithPrefix())
 		case FirstKey:
 			ops = append(ops, v3.WithFirstKey()...)
 		case LastKey:
@@ -120,9 +124,10 @@ func (b *baseCollection) Get(key EtcdKey, opts ...GetOption) (*EtcdValue, error)
 	}
 
 	return &EtcdValue{
-		RawKey: string(resp.Kvs[0].Key),
-		Value:  string(resp.Kvs[0].Value),
-		Count:  resp.Count,
+		RawKey:      string(resp.Kvs[0].Key),
+		Value:       string(resp.Kvs[0].Value),
+		Count:       resp.Count,
+		ModRevision: resp.Kvs[0].ModRevision,
 	}, nil
 }
 
@@ -143,3 +148,68 @@ func (b *baseCollection) Delete(key EtcdKey) (err error) {
 	_, err = b.client.Delete(b.ctx, key.String())
 	return
 }
+
+// PutWithLease puts the key attached to the lease. The key is removed when the
+// lease expires.
+func PutWithLease(coll EtcdCollection, key EtcdKey, value string, lease v3.LeaseID) error {
+	_, err := coll.Client().Put(coll.Context(), key.String(), value, v3.WithLease(lease))
+	return err
+}
+
+// CountRange returns the number of keys in the range [firstKey, lastKey).
+func CountRange(coll EtcdCollection, firstKey EtcdKey, lastKey EtcdKey) (int64, error) {
+	resp, err := coll.Client().Get(coll.Context(), firstKey.String(),
+		v3.WithRange(lastKey.String()),
+		v3.WithCountOnly(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+// ListPages lists the keys under the prefix in ascending order. The keys are
+// read by pages of the limit, so the whole range is never loaded at once.
+func ListPages(coll EtcdCollection, key EtcdKey, limit int64, fn func([]EtcdValue) error) error {
+	prefix := key.String() + "/"
+	end := v3.GetPrefixRangeEnd(prefix)
+
+	for start := prefix; ; {
+		resp, err := coll.Client().Get(coll.Context(), start,
+			v3.WithRange(end),
+			v3.WithLimit(limit),
+			v3.WithSort(v3.SortByKey, v3.SortAscend),
+		)
+		if err != nil {
+			return err
+		}
+
+		if len(resp.Kvs) == 0 {
+			return nil
+		}
+
+		res := make([]EtcdValue, len(resp.Kvs))
+
+		for i, v := range resp.Kvs {
+			res[i].RawKey = string(v.Key)
+			res[i].Value = string(v.Value)
+			res[i].ModRevision = v.ModRevision
+		}
+
+		if err := fn(res); err != nil {
+			return err
+		}
+
+		if !resp.More {
+			return nil
+		}
+
+		start = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// DeletePrefix removes the keys under the prefix.
+func DeletePrefix(coll EtcdCollection, key EtcdKey) error {
+	_, err := coll.Client().Delete(coll.Context(), key.String()+"/", v3.WithPrefix())
+	return err
+}
@@ -0,0 +1,58 @@
+package metadata
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+)
+
+func TestListPages(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	ctx := context.WithValue(context.Background(), config.AppConfigContextVar, cfg)
+
+	coll, err := NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 25; i++ {
+		key := &TopicEtcdKey{
+			Topic:     fmt.Sprintf("topic%02d", i),
+			Partition: NoPartition,
+		}
+		if err := coll.Put(key, "{}"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		pages int
+		keys  []string
+	)
+
+	err = ListPages(coll, &TopicEtcdKey{Topic: NoString, Partition: NoPartition}, 10, func(records []EtcdValue) error {
+		pages++
+		for _, rec := range records {
+			keys = append(keys, rec.RawKey)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pages != 3 || len(keys) != 25 {
+		t.Fatalf("expected 25 keys in 3 pages, got %d in %d", len(keys), pages)
+	}
+
+	for i, key := range keys {
+		if expected := fmt.Sprintf("%s/topic%02d", TopicsEtcd, i); key != expected {
+			t.Fatalf("expected %s, got %s", expected, key)
+		}
+	}
+}
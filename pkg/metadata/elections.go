@@ -0,0 +1,19 @@
+package metadata
+
+const (
+	ElectionsEtcd = "/elections"
+)
+
+// ElectionEtcdKey points to the election of the node which runs the service
+// for the whole cluster.
+type ElectionEtcdKey struct {
+	Name string `json:"name"`
+}
+
+func (k *ElectionEtcdKey) String() (res string) {
+	res = ElectionsEtcd
+	if k.Name != NoString {
+		res += "/" + k.Name
+	}
+	return
+}
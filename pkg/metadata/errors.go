@@ -7,4 +7,8 @@ import (
 var (
 	ErrKeyExists   = errors.New("key already exists")
 	ErrKeyNotFound = errors.New("key not found")
+	ErrKeyModified = errors.New("key has been modified")
+
+	ErrPartitionsCount   = errors.New("number of partitions can only be increased")
+	ErrTooManyPartitions = errors.New("too many partitions to create at once")
 )
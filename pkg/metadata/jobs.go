@@ -0,0 +1,92 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+)
+
+const (
+	JobsEtcd      = "/jobs"
+	JobOwnersEtcd = "/jobowners"
+)
+
+var (
+	jobsEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + JobsEtcd + "/(?P<type>[a-z-]+)(/(?P<name>[A-Za-z0-9_.-]+))?$")
+)
+
+// JobEtcdKey describes the state of the background job. The name is unique
+// within the job type (e.g. topic name for the topic deletion).
+type JobEtcdKey struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+func (k *JobEtcdKey) String() (res string) {
+	res = JobsEtcd
+
+	if k.Type != NoString {
+		res += "/" + k.Type
+	}
+
+	if k.Name != NoString {
+		res += "/" + k.Name
+	}
+
+	return
+}
+
+func ParseJobEtcdKey(value string) (*JobEtcdKey, error) {
+	key := &JobEtcdKey{}
+
+	match := jobsEtcdKeyRegexp.FindStringSubmatch(value)
+
+	if len(match) < 1 || len(match) > 4 {
+		return key, fmt.Errorf("bad job key: %s: %#v", value, match)
+	}
+
+	if len(match) > 1 {
+		key.Type = match[1]
+	}
+
+	if len(match) > 3 {
+		key.Name = match[3]
+	}
+
+	return key, nil
+}
+
+// JobOwnerEtcdKey points to the owner of the job. The key is attached to the
+// lease of the owner, so it disappears along with the owner.
+type JobOwnerEtcdKey struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+func (k *JobOwnerEtcdKey) String() (res string) {
+	res = JobOwnersEtcd
+
+	if k.Type != NoString {
+		res += "/" + k.Type
+	}
+
+	if k.Name != NoString {
+		res += "/" + k.Name
+	}
+
+	return
+}
+
+type JobsCollection struct {
+	EtcdCollection
+}
+
+func NewJobsCollection(ctx context.Context, cfg *config.Config) (EtcdCollection, error) {
+	base, err := newBaseCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &JobsCollection{base}, nil
+}
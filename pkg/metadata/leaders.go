@@ -0,0 +1,75 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/legionus/kavka/pkg/config"
+)
+
+const (
+	LeadersEtcd = "/leaders"
+)
+
+var (
+	leaderEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + LeadersEtcd + "/(?P<topic>" + config.QualifiedTopicPattern + ")(/(?P<partition>[0-9]+)(/(?P<candidate>[0-9a-f]+))?)?$")
+)
+
+// LeaderEtcdKey points to the election of the partition leader. Every
+// candidate has its own key named after its lease. The partition is padded, so
+// the election prefix of one partition is not a prefix of another.
+type LeaderEtcdKey struct {
+	Topic     string `json:"topic"`
+	Partition int64  `json:"partition"`
+	Candidate string `json:"candidate"`
+}
+
+func (k *LeaderEtcdKey) String() (res string) {
+	res = LeadersEtcd
+
+	if k.Topic != NoString {
+		res += "/" + k.Topic
+	}
+
+	if k.Partition > NoPartition {
+		res += fmt.Sprintf("/%020d", k.Partition)
+	}
+
+	if k.Candidate != NoString {
+		res += "/" + k.Candidate
+	}
+
+	return
+}
+
+func ParseLeaderEtcdKey(value string) (*LeaderEtcdKey, error) {
+	key := &LeaderEtcdKey{
+		Partition: NoPartition,
+	}
+
+	match := leaderEtcdKeyRegexp.FindStringSubmatch(value)
+
+	if len(match) < 1 || len(match) > 6 {
+		return key, fmt.Errorf("bad leader key: %s", value)
+	}
+
+	var err error
+
+	if len(match) > 1 {
+		key.Topic = match[1]
+	}
+
+	if len(match) > 3 && match[3] != NoString {
+		key.Partition, err = strconv.ParseInt(match[3], 10, 64)
+		if err != nil {
+			return key, err
+		}
+	}
+
+	if len(match) > 5 {
+		key.Candidate = match[5]
+	}
+
+	return key, nil
+}
@@ -0,0 +1,103 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+)
+
+const (
+	MessagesEtcd = "/messages"
+)
+
+var (
+	messagesEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + MessagesEtcd + "/(?P<id>[^/]+)(/(?P<topic>" + config.QualifiedTopicPattern + ")(/(?P<partition>[0-9]+)(/(?P<offset>[0-9]+))?)?)?$")
+)
+
+// MessageEtcdKey is an index record which points from message ID to the
+// queue record. The same message can be stored in several partitions
+// (e.g. after moving to the dead-letter topic).
+type MessageEtcdKey struct {
+	ID        string `json:"id"`
+	Topic     string `json:"topic"`
+	Partition int64  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+func (k *MessageEtcdKey) String() (res string) {
+	res = MessagesEtcd
+
+	if k.ID != NoString {
+		res += "/" + k.ID
+	}
+
+	if k.Topic != NoString {
+		res += "/" + k.Topic
+	}
+
+	if k.Partition > NoPartition {
+		res += fmt.Sprintf("/%d", k.Partition)
+	}
+
+	if k.Offset > NoOffset {
+		res += fmt.Sprintf("/%020d", k.Offset)
+	}
+
+	return
+}
+
+func ParseMessageEtcdKey(value string) (*MessageEtcdKey, error) {
+	key := &MessageEtcdKey{}
+
+	match := messagesEtcdKeyRegexp.FindStringSubmatch(value)
+
+	if len(match) < 1 || len(match) > 8 {
+		return key, fmt.Errorf("bad message key: %s: %#v", value, match)
+	}
+
+	var err error
+
+	key.Partition = NoPartition
+	key.Offset = NoOffset
+
+	if len(match) > 1 {
+		key.ID = match[1]
+	}
+
+	if len(match) > 3 {
+		key.Topic = match[3]
+	}
+
+	if len(match) > 5 && match[5] != NoString {
+		key.Partition, err = strconv.ParseInt(match[5], 10, 64)
+
+		if err != nil {
+			return key, err
+		}
+	}
+
+	if len(match) > 7 && match[7] != NoString {
+		key.Offset, err = strconv.ParseInt(match[7], 10, 64)
+
+		if err != nil {
+			return key, err
+		}
+	}
+
+	return key, nil
+}
+
+type MessagesCollection struct {
+	EtcdCollection
+}
+
+func NewMessagesCollection(ctx context.Context, cfg *config.Config) (EtcdCollection, error) {
+	base, err := newBaseCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &MessagesCollection{base}, nil
+}
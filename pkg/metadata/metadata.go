@@ -39,7 +39,8 @@ type EtcdCollection interface {
 }
 
 type EtcdValue struct {
-	RawKey string
-	Value  string
-	Count  int64
+	RawKey      string
+	Value       string
+	Count       int64
+	ModRevision int64
 }
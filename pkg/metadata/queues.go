@@ -5,6 +5,8 @@ import (
 	"regexp"
 	"strconv"
 
+	v3 "github.com/coreos/etcd/clientv3"
+
 	"github.com/legionus/kavka/pkg/config"
 	"github.com/legionus/kavka/pkg/context"
 	"github.com/legionus/kavka/pkg/etcd"
@@ -16,7 +18,7 @@ const (
 )
 
 var (
-	queuesEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + QueuesEtcd + "/(?P<topic>[A-Za-z0-9_-]+)(/(?P<partition>[0-9]+)(/(?P<offset>[0-9]+))?)?$")
+	queuesEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + QueuesEtcd + "/(?P<topic>" + config.QualifiedTopicPattern + ")(/(?P<partition>[0-9]+)(/(?P<offset>[0-9]+))?)?$")
 )
 
 type QueueEtcdKey struct {
@@ -94,6 +96,16 @@ func NewQueuesCollection(ctx context.Context, cfg *config.Config) (EtcdCollectio
 }
 
 func (b *QueuesCollection) Create(key EtcdKey, value string) (EtcdKey, error) {
+	res, err := b.CreateMessage(key, NoString, value)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CreateMessage appends the record to the partition. If id is specified, the
+// message index record is created in the same transaction.
+func (b *QueuesCollection) CreateMessage(key EtcdKey, id string, value string) (*QueueEtcdKey, error) {
 	topicKey, ok := key.(*QueueEtcdKey)
 	if !ok {
 		return nil, fmt.Errorf("unexpected key")
@@ -105,10 +117,80 @@ func (b *QueuesCollection) Create(key EtcdKey, value string) (EtcdKey, error) {
 		Offset:    NoOffset,
 	}
 
-	res, err := etcd.NewSequentialKV(b.Client(), keyPrefix.String(), value)
+	var extra func(string) []v3.Op
+
+	if id != NoString {
+		extra = func(newKey string) []v3.Op {
+			return MessageIndexOps(newKey, id)
+		}
+	}
+
+	res, err := etcd.NewSequentialKVWithOps(b.Client(), keyPrefix.String(), value, extra)
 	if err != nil {
 		return nil, err
 	}
 
 	return ParseQueueEtcdKey(res.Key())
 }
+
+// CreateMessageTxn appends the record to the partition together with the
+// operations of txn. The record is appended only if the conditions of txn are
+// met, otherwise ErrKeyModified is returned.
+func (b *QueuesCollection) CreateMessageTxn(key EtcdKey, id string, value string, txn *Transaction) (*QueueEtcdKey, error) {
+	topicKey, ok := key.(*QueueEtcdKey)
+	if !ok {
+		return nil, fmt.Errorf("unexpected key")
+	}
+
+	keyPrefix := &QueueEtcdKey{
+		Topic:     topicKey.Topic,
+		Partition: topicKey.Partition,
+		Offset:    NoOffset,
+	}
+
+	extra := func(newKey string, i int) []v3.Op {
+		ops := txn.ops
+		if id != NoString {
+			ops = append(MessageIndexOps(newKey, id), ops...)
+		}
+		return ops
+	}
+
+	seq := etcd.NewSequentialBatch(b.Client(), keyPrefix.String())
+
+	for {
+		keys, err := seq.Create(b.Context(), []string{value}, extra, txn.cmps...)
+		if err == nil {
+			return ParseQueueEtcdKey(keys[0])
+		}
+		if err != etcd.ErrSequenceConflict {
+			return nil, err
+		}
+
+		// The conflict is either in the partition, which is retried,
+		// or in the conditions of txn.
+		resp, err := b.Client().Txn(b.Context()).If(txn.cmps...).Commit()
+		if err != nil {
+			return nil, err
+		}
+		if !resp.Succeeded {
+			return nil, ErrKeyModified
+		}
+	}
+}
+
+// MessageIndexOps returns the operations which create the message index record
+// for the queue record.
+func MessageIndexOps(queueKey string, id string) []v3.Op {
+	key, err := ParseQueueEtcdKey(queueKey)
+	if err != nil {
+		return nil
+	}
+	indexKey := &MessageEtcdKey{
+		ID:        id,
+		Topic:     key.Topic,
+		Partition: key.Partition,
+		Offset:    key.Offset,
+	}
+	return []v3.Op{v3.OpPut(indexKey.String(), queueKey)}
+}
@@ -0,0 +1,82 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/digest"
+)
+
+const (
+	RepairsEtcd     = "/repairs"
+	RepairStatsEtcd = "/repairstats"
+
+	// Actions of the repair requests.
+	RepairSync = "sync"
+	RepairTrim = "trim"
+)
+
+var (
+	repairsEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + RepairsEtcd + "/(?P<group>[^/]+)/(?P<node>[^/]+)/(?P<digest>[a-zA-Z0-9-_+.]+:[a-fA-F0-9]+)$")
+)
+
+// RepairEtcdKey points to the request of the repair to the node. The value of
+// the request is the action (RepairSync or RepairTrim).
+type RepairEtcdKey struct {
+	Group  string        `json:"group"`
+	Node   string        `json:"node"`
+	Digest digest.Digest `json:"digest"`
+}
+
+func (k *RepairEtcdKey) String() (res string) {
+	res = RepairsEtcd
+	if k.Group != NoString {
+		res += "/" + k.Group
+	}
+	if k.Node != NoString {
+		res += "/" + k.Node
+	}
+	if k.Digest != NoString {
+		res += "/" + k.Digest.String()
+	}
+	return
+}
+
+func ParseRepairEtcdKey(value string) (*RepairEtcdKey, error) {
+	match := repairsEtcdKeyRegexp.FindStringSubmatch(value)
+	if len(match) != 4 {
+		return nil, fmt.Errorf("bad repair key: %s", value)
+	}
+
+	dgst, err := digest.ParseDigest(match[3])
+	if err != nil {
+		return nil, err
+	}
+
+	return &RepairEtcdKey{
+		Group:  match[1],
+		Node:   match[2],
+		Digest: dgst,
+	}, nil
+}
+
+// RepairStatsEtcdKey points to the statistics of the last repair pass.
+type RepairStatsEtcdKey struct{}
+
+func (k *RepairStatsEtcdKey) String() string {
+	return RepairStatsEtcd
+}
+
+type RepairsCollection struct {
+	EtcdCollection
+}
+
+func NewRepairsCollection(ctx context.Context, cfg *config.Config) (EtcdCollection, error) {
+	base, err := newBaseCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &RepairsCollection{base}, nil
+}
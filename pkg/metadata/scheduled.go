@@ -0,0 +1,105 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+)
+
+const (
+	ScheduledEtcd = "/scheduled"
+
+	NoTime = -1
+)
+
+var (
+	scheduledEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + ScheduledEtcd + "/(?P<time>[0-9]+)(/(?P<topic>" + config.QualifiedTopicPattern + ")(/(?P<partition>[0-9]+)(/(?P<id>[^/]+))?)?)?$")
+)
+
+// ScheduledEtcdKey describes the message which should be appended to the
+// partition after the specified time. Time is a unix time in nanoseconds, so
+// the keys are sorted by delivery time.
+type ScheduledEtcdKey struct {
+	Time      int64  `json:"time"`
+	Topic     string `json:"topic"`
+	Partition int64  `json:"partition"`
+	ID        string `json:"id"`
+}
+
+func (k *ScheduledEtcdKey) String() (res string) {
+	res = ScheduledEtcd
+
+	if k.Time > NoTime {
+		res += fmt.Sprintf("/%020d", k.Time)
+	}
+
+	if k.Topic != NoString {
+		res += "/" + k.Topic
+	}
+
+	if k.Partition > NoPartition {
+		res += fmt.Sprintf("/%d", k.Partition)
+	}
+
+	if k.ID != NoString {
+		res += "/" + k.ID
+	}
+
+	return
+}
+
+func ParseScheduledEtcdKey(value string) (*ScheduledEtcdKey, error) {
+	key := &ScheduledEtcdKey{}
+
+	match := scheduledEtcdKeyRegexp.FindStringSubmatch(value)
+
+	if len(match) < 1 || len(match) > 8 {
+		return key, fmt.Errorf("bad scheduled key: %s: %#v", value, match)
+	}
+
+	var err error
+
+	key.Time = NoTime
+	key.Partition = NoPartition
+
+	if len(match) > 1 {
+		key.Time, err = strconv.ParseInt(match[1], 10, 64)
+
+		if err != nil {
+			return key, err
+		}
+	}
+
+	if len(match) > 3 {
+		key.Topic = match[3]
+	}
+
+	if len(match) > 5 && match[5] != NoString {
+		key.Partition, err = strconv.ParseInt(match[5], 10, 64)
+
+		if err != nil {
+			return key, err
+		}
+	}
+
+	if len(match) > 7 {
+		key.ID = match[7]
+	}
+
+	return key, nil
+}
+
+type ScheduledCollection struct {
+	EtcdCollection
+}
+
+func NewScheduledCollection(ctx context.Context, cfg *config.Config) (EtcdCollection, error) {
+	base, err := newBaseCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduledCollection{base}, nil
+}
@@ -0,0 +1,79 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+)
+
+const (
+	SchemasEtcd = "/schemas"
+
+	NoVersion = -1
+)
+
+var (
+	schemasEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + SchemasEtcd + "/(?P<subject>[A-Za-z0-9_.-]+)(/(?P<version>[0-9]+))?$")
+)
+
+// SchemaEtcdKey points to the version of the schema. The record of the
+// subject itself (without version) contains the subject settings.
+type SchemaEtcdKey struct {
+	Subject string `json:"subject"`
+	Version int64  `json:"version"`
+}
+
+func (k *SchemaEtcdKey) String() (res string) {
+	res = SchemasEtcd
+
+	if k.Subject != NoString {
+		res += "/" + k.Subject
+	}
+
+	if k.Version > NoVersion {
+		res += fmt.Sprintf("/%020d", k.Version)
+	}
+
+	return
+}
+
+func ParseSchemaEtcdKey(value string) (*SchemaEtcdKey, error) {
+	key := &SchemaEtcdKey{
+		Version: NoVersion,
+	}
+
+	match := schemasEtcdKeyRegexp.FindStringSubmatch(value)
+
+	if len(match) < 1 || len(match) > 4 {
+		return key, fmt.Errorf("bad schema key: %s: %#v", value, match)
+	}
+
+	if len(match) > 1 {
+		key.Subject = match[1]
+	}
+
+	if len(match) > 3 && match[3] != NoString {
+		v, err := strconv.ParseInt(match[3], 10, 64)
+		if err != nil {
+			return key, err
+		}
+		key.Version = v
+	}
+
+	return key, nil
+}
+
+type SchemasCollection struct {
+	EtcdCollection
+}
+
+func NewSchemasCollection(ctx context.Context, cfg *config.Config) (EtcdCollection, error) {
+	base, err := newBaseCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &SchemasCollection{base}, nil
+}
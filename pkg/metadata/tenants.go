@@ -0,0 +1,100 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+)
+
+const (
+	TenantsEtcd      = "/tenants"
+	TenantTokensEtcd = "/tenanttokens"
+	UsageEtcd        = "/usage"
+)
+
+var (
+	tenantsEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + TenantsEtcd + "/(?P<name>[A-Za-z0-9_-]+)$")
+	usageEtcdKeyRegexp   *regexp.Regexp = regexp.MustCompile("^" + UsageEtcd + "/(?P<name>[A-Za-z0-9_-]+)$")
+)
+
+// TenantEtcdKey describes the tenant with its credentials and quotas.
+type TenantEtcdKey struct {
+	Name string `json:"name"`
+}
+
+func (k *TenantEtcdKey) String() (res string) {
+	res = TenantsEtcd
+	if k.Name != NoString {
+		res += "/" + k.Name
+	}
+	return
+}
+
+func ParseTenantEtcdKey(value string) (*TenantEtcdKey, error) {
+	key := &TenantEtcdKey{}
+
+	match := tenantsEtcdKeyRegexp.FindStringSubmatch(value)
+
+	if len(match) != 2 {
+		return key, fmt.Errorf("bad tenant key: %s: %#v", value, match)
+	}
+
+	key.Name = match[1]
+
+	return key, nil
+}
+
+// TenantTokenEtcdKey points from the hash of the tenant token to the tenant.
+type TenantTokenEtcdKey struct {
+	Hash string `json:"hash"`
+}
+
+func (k *TenantTokenEtcdKey) String() (res string) {
+	res = TenantTokensEtcd
+	if k.Hash != NoString {
+		res += "/" + k.Hash
+	}
+	return
+}
+
+// UsageEtcdKey describes the resources used by the tenant. The record is
+// updated periodically.
+type UsageEtcdKey struct {
+	Name string `json:"name"`
+}
+
+func (k *UsageEtcdKey) String() (res string) {
+	res = UsageEtcd
+	if k.Name != NoString {
+		res += "/" + k.Name
+	}
+	return
+}
+
+func ParseUsageEtcdKey(value string) (*UsageEtcdKey, error) {
+	key := &UsageEtcdKey{}
+
+	match := usageEtcdKeyRegexp.FindStringSubmatch(value)
+
+	if len(match) != 2 {
+		return key, fmt.Errorf("bad usage key: %s: %#v", value, match)
+	}
+
+	key.Name = match[1]
+
+	return key, nil
+}
+
+type TenantsCollection struct {
+	EtcdCollection
+}
+
+func NewTenantsCollection(ctx context.Context, cfg *config.Config) (EtcdCollection, error) {
+	base, err := newBaseCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &TenantsCollection{base}, nil
+}
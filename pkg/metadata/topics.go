@@ -1,6 +1,7 @@
 package metadata
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -15,7 +16,7 @@ const (
 )
 
 var (
-	topicsEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + TopicsEtcd + "/(?P<topic>[A-Za-z0-9_-]+)(/(?P<partition>[0-9]+))?$")
+	topicsEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + TopicsEtcd + "/(?P<topic>" + config.QualifiedTopicPattern + ")(/(?P<partition>[0-9]+))?$")
 )
 
 type TopicEtcdKey struct {
@@ -52,7 +53,7 @@ func ParseTopicEtcdKey(value string) (*TopicEtcdKey, error) {
 		key.Topic = match[1]
 	}
 
-	if len(match) > 3 {
+	if len(match) > 3 && match[3] != NoString {
 		key.Partition, err = strconv.ParseInt(match[3], 10, 64)
 
 		if err != nil {
@@ -76,3 +77,200 @@ func NewTopicsCollection(ctx context.Context, cfg *config.Config) (EtcdCollectio
 	}
 	return &TopicsCollection{base}, nil
 }
+
+// GetTopicOverrides returns per-topic settings stored in the topic record.
+// Records created by older versions contain a timestamp and have no overrides.
+func GetTopicOverrides(coll EtcdCollection, topic string) (*config.TopicConfig, error) {
+	res := &config.TopicConfig{}
+
+	value, err := coll.Get(&TopicEtcdKey{
+		Topic:     topic,
+		Partition: NoPartition,
+	})
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return res, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(value.Value), res); err != nil {
+		return &config.TopicConfig{}, nil
+	}
+
+	return res, nil
+}
+
+// PutTopicOverrides stores per-topic settings in the topic record.
+func PutTopicOverrides(coll EtcdCollection, topic string, overrides *config.TopicConfig) error {
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return err
+	}
+
+	return coll.Put(&TopicEtcdKey{
+		Topic:     topic,
+		Partition: NoPartition,
+	}, string(data))
+}
+
+// PutTopicOverridesTxn adds storing of per-topic settings to the transaction.
+func PutTopicOverridesTxn(txn *Transaction, topic string, overrides *config.TopicConfig) error {
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return err
+	}
+
+	txn.Put(&TopicEtcdKey{
+		Topic:     topic,
+		Partition: NoPartition,
+	}, string(data))
+
+	return nil
+}
+
+// GetTopicConfig returns effective settings of the topic.
+func GetTopicConfig(ctx context.Context, cfg *config.Config, topic string) (*config.Topic, error) {
+	coll, err := NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, err := GetTopicOverrides(coll, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return overrides.Apply(&cfg.Topic, topic), nil
+}
+
+// GetPartitionsCount returns the number of partitions of the topic. The
+// partitions are numbered from zero without gaps (see CreatePartitions), so
+// the number of partition records is the count.
+func GetPartitionsCount(coll EtcdCollection, topic string) (int64, error) {
+	records, err := coll.List(&TopicEtcdKey{
+		Topic:     topic,
+		Partition: NoPartition,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	count := int64(0)
+
+	for _, rec := range records {
+		key, err := ParseTopicEtcdKey(rec.RawKey)
+		if err != nil {
+			return 0, err
+		}
+		if key.Partition > NoPartition {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// MaxCreatedPartitions limits the number of partitions created at once by
+// CreatePartitions, so a mistyped partition number does not create thousands
+// of keys.
+const MaxCreatedPartitions = 64
+
+// CreatePartitions creates the partition and all missing partitions before
+// it, so the partitions of the topic have no gaps. The partitions are created
+// in one transaction. ErrTooManyPartitions is returned if more than
+// MaxCreatedPartitions are missing.
+func CreatePartitions(ctx context.Context, cfg *config.Config, topic string, partition int64, value string) error {
+	coll, err := NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	for {
+		records, err := coll.List(&TopicEtcdKey{
+			Topic:     topic,
+			Partition: NoPartition,
+		})
+		if err != nil {
+			return err
+		}
+
+		exists := make(map[int64]struct{})
+
+		for _, rec := range records {
+			key, err := ParseTopicEtcdKey(rec.RawKey)
+			if err != nil {
+				return err
+			}
+			exists[key.Partition] = struct{}{}
+		}
+
+		txn := NewTransaction(ctx, cfg)
+		missing := 0
+
+		for i := int64(0); i <= partition; i++ {
+			if _, ok := exists[i]; ok {
+				continue
+			}
+
+			key := &TopicEtcdKey{
+				Topic:     topic,
+				Partition: i,
+			}
+			txn.Unmodified(key, 0)
+			txn.Put(key, value)
+
+			missing++
+		}
+
+		if missing == 0 {
+			return nil
+		}
+
+		if missing > MaxCreatedPartitions {
+			return ErrTooManyPartitions
+		}
+
+		err = txn.Commit()
+		if err != ErrKeyModified {
+			return err
+		}
+		// Some partitions have been created concurrently.
+	}
+}
+
+// AddPartitions creates partitions of the existing topic up to the specified
+// count. All partitions are created in one transaction. ErrKeyModified is
+// returned if any of the new partitions has been created concurrently.
+func AddPartitions(ctx context.Context, cfg *config.Config, topic string, count int64, value string) error {
+	coll, err := NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	current, err := GetPartitionsCount(coll, topic)
+	if err != nil {
+		return err
+	}
+
+	if current == 0 {
+		return ErrKeyNotFound
+	}
+
+	if count <= current {
+		return ErrPartitionsCount
+	}
+
+	txn := NewTransaction(ctx, cfg)
+
+	for i := current; i < count; i++ {
+		key := &TopicEtcdKey{
+			Topic:     topic,
+			Partition: i,
+		}
+		txn.Unmodified(key, 0)
+		txn.Put(key, value)
+	}
+
+	return txn.Commit()
+}
@@ -0,0 +1,67 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+)
+
+func TestCreatePartitions(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	ctx := context.WithValue(context.Background(), config.AppConfigContextVar, cfg)
+
+	coll, err := NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The topic config does not make a partition.
+	if err := PutTopicOverrides(coll, "foo", &config.TopicConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := GetPartitionsCount(coll, "foo"); err != nil || n != 0 {
+		t.Fatalf("expected no partitions, got %d (%v)", n, err)
+	}
+
+	if err := CreatePartitions(ctx, cfg, "foo", 3, "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := GetPartitionsCount(coll, "foo"); err != nil || n != 4 {
+		t.Fatalf("expected 4 partitions, got %d (%v)", n, err)
+	}
+
+	if err := CreatePartitions(ctx, cfg, "foo", 1, "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddPartitions(ctx, cfg, "foo", 6, "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := GetPartitionsCount(coll, "foo"); err != nil || n != 6 {
+		t.Fatalf("expected 6 partitions, got %d (%v)", n, err)
+	}
+
+	// Other topics with the same prefix are not counted.
+	if err := CreatePartitions(ctx, cfg, "foobar", 0, "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := GetPartitionsCount(coll, "foo"); err != nil || n != 6 {
+		t.Fatalf("expected 6 partitions, got %d (%v)", n, err)
+	}
+
+	if err := CreatePartitions(ctx, cfg, "foo", 6+MaxCreatedPartitions, "x"); err != ErrTooManyPartitions {
+		t.Fatalf("expected %v, got %v", ErrTooManyPartitions, err)
+	}
+
+	if n, err := GetPartitionsCount(coll, "foo"); err != nil || n != 6 {
+		t.Fatalf("expected 6 partitions, got %d (%v)", n, err)
+	}
+}
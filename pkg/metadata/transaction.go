@@ -16,9 +16,16 @@ func NewTransaction(ctx context.Context, cfg *config.Config) *Transaction {
 }
 
 type Transaction struct {
-	ctx    context.Context
-	cfg    *config.Config
-	ops    []v3.Op
+	ctx  context.Context
+	cfg  *config.Config
+	cmps []v3.Cmp
+	ops  []v3.Op
+}
+
+// Unmodified makes the transaction conditional on the key not being modified
+// since the given revision. Zero revision means that the key must not exist.
+func (t *Transaction) Unmodified(key EtcdKey, modRevision int64) {
+	t.cmps = append(t.cmps, v3.Compare(v3.ModRevision(key.String()), "=", modRevision))
 }
 
 func (t *Transaction) Put(key EtcdKey, value string) {
@@ -29,11 +36,19 @@ func (t *Transaction) Delete(key EtcdKey) {
 	t.ops = append(t.ops, v3.OpDelete(key.String()))
 }
 
+// Commit applies the transaction. ErrKeyModified is returned if any of the
+// conditions is not met.
 func (t *Transaction) Commit() error {
 	c, err := etcd.NewEtcdClient(t.cfg)
 	if err != nil {
 		return err
 	}
-	_, err = c.Txn(t.ctx).Then(t.ops...).Commit()
-	return err
+	resp, err := c.Txn(t.ctx).If(t.cmps...).Then(t.ops...).Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrKeyModified
+	}
+	return nil
 }
@@ -0,0 +1,95 @@
+package metadata
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+)
+
+const (
+	WorkQueuesEtcd = "/workqueues"
+)
+
+var (
+	workQueuesEtcdKeyRegexp *regexp.Regexp = regexp.MustCompile("^" + WorkQueuesEtcd + "/(?P<topic>" + config.QualifiedTopicPattern + ")(/(?P<partition>[0-9]+)(/(?P<offset>[0-9]+))?)?$")
+)
+
+// WorkQueueEtcdKey describes the state of the work queue. The partition key
+// (without offset) keeps the next offset which has never been delivered. The
+// offset keys keep leases of messages that have been delivered but not
+// acknowledged yet.
+type WorkQueueEtcdKey struct {
+	Topic     string `json:"topic"`
+	Partition int64  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+func (k *WorkQueueEtcdKey) String() (res string) {
+	res = WorkQueuesEtcd
+
+	if k.Topic != NoString {
+		res += "/" + k.Topic
+	}
+
+	if k.Partition > NoPartition {
+		res += fmt.Sprintf("/%d", k.Partition)
+	}
+
+	if k.Offset > NoOffset {
+		res += fmt.Sprintf("/%020d", k.Offset)
+	}
+
+	return
+}
+
+func ParseWorkQueueEtcdKey(value string) (*WorkQueueEtcdKey, error) {
+	key := &WorkQueueEtcdKey{}
+
+	match := workQueuesEtcdKeyRegexp.FindStringSubmatch(value)
+
+	if len(match) < 1 || len(match) > 6 {
+		return key, fmt.Errorf("bad work queue key: %s: %#v", value, match)
+	}
+
+	var err error
+
+	if len(match) > 1 {
+		key.Topic = match[1]
+	}
+
+	key.Partition = NoPartition
+	key.Offset = NoOffset
+
+	if len(match) > 3 && match[3] != NoString {
+		key.Partition, err = strconv.ParseInt(match[3], 10, 64)
+
+		if err != nil {
+			return key, err
+		}
+	}
+
+	if len(match) > 5 && match[5] != NoString {
+		key.Offset, err = strconv.ParseInt(match[5], 10, 64)
+
+		if err != nil {
+			return key, err
+		}
+	}
+
+	return key, nil
+}
+
+type WorkQueuesCollection struct {
+	EtcdCollection
+}
+
+func NewWorkQueuesCollection(ctx context.Context, cfg *config.Config) (EtcdCollection, error) {
+	base, err := newBaseCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &WorkQueuesCollection{base}, nil
+}
@@ -0,0 +1,82 @@
+package placement
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/legionus/kavka/pkg/cluster"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd/observer"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+const (
+	AppMembershipContextVar = "app.placement.membership"
+)
+
+// Membership keeps the list of the cluster members in memory. The list is
+// reloaded after the records of the live nodes or of the members are changed,
+// so the placement is calculated without a request to etcd.
+type Membership struct {
+	sync.Mutex
+
+	ctx     context.Context
+	members []*cluster.Member
+	stale   bool
+}
+
+// NewMembership loads the list of the members and follows its changes using
+// the cluster and members observers from the context.
+func NewMembership(ctx context.Context) (*Membership, error) {
+	m := &Membership{
+		ctx:   ctx,
+		stale: true,
+	}
+
+	for _, name := range []string{metadata.ClusterObserverContextVar, metadata.MembersObserverContextVar} {
+		obsrv, ok := ctx.Value(name).(*observer.EtcdObserver)
+		if !ok {
+			return nil, fmt.Errorf("Unable to obtain %s from context", name)
+		}
+
+		f, err := observer.NewEtcdFilter(obsrv, func(ev *observer.EtcdEvent) {
+			m.invalidate()
+		})
+		if err != nil {
+			return nil, err
+		}
+		f.OnResync(m.invalidate).Start()
+	}
+
+	if _, err := m.Members(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Membership) invalidate() {
+	m.Lock()
+	m.stale = true
+	m.Unlock()
+}
+
+// Members returns the members of the cluster. The list must not be modified.
+func (m *Membership) Members() ([]*cluster.Member, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if !m.stale {
+		return m.members, nil
+	}
+
+	members, err := cluster.ListMembers(m.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m.members = members
+	m.stale = false
+
+	return members, nil
+}
@@ -0,0 +1,63 @@
+package placement
+
+import (
+	"testing"
+	"time"
+
+	"github.com/legionus/kavka/pkg/cluster"
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+	"github.com/legionus/kavka/pkg/etcd/observer"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+func TestMembership(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	ctx := context.WithValue(context.Background(), config.AppConfigContextVar, cfg)
+
+	for name, path := range map[string]string{
+		metadata.ClusterObserverContextVar: metadata.ClusterEtcd,
+		metadata.MembersObserverContextVar: metadata.MembersEtcd,
+	} {
+		obsrv, err := observer.NewEtcdObserver(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obsrv.RunEtcdObserver(path)
+
+		ctx = context.WithValue(ctx, name, obsrv)
+	}
+
+	m, err := NewMembership(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = context.WithValue(ctx, AppMembershipContextVar, m)
+
+	if nodes, err := ListNodes(ctx); err != nil || len(nodes) != 0 {
+		t.Fatalf("expected no nodes, got %v (%v)", nodes, err)
+	}
+
+	if err := cluster.Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The list is reloaded after the event is delivered.
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		nodes, err := ListNodes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Contains(nodes, cfg.Global.Group, cfg.Global.Hostname) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("registered node is not found: %v", nodes)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,190 @@
+// Package placement chooses the nodes which keep the replicas of a chunk.
+//
+// The rendezvous (highest random weight) hashing is used. Every group gets a
+// weight computed from the digest of the chunk and the name of the group, and
+// the chunk is placed on the groups with the highest weights. Inside the group
+// the node is chosen the same way, so the replicas always belong to distinct
+// groups. When a group joins or leaves the cluster, only the chunks for which
+// this group has one of the highest weights change their placement.
+package placement
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/legionus/kavka/pkg/cluster"
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/digest"
+)
+
+// Node identifies the node of the cluster.
+type Node struct {
+	Group string `json:"group"`
+	Node  string `json:"node"`
+	// Address is the advertised address of the node. It is known only for
+	// the live nodes.
+	Address string `json:"address,omitempty"`
+}
+
+type candidate struct {
+	node   Node
+	weight uint64
+}
+
+func weight(parts ...string) uint64 {
+	h := fnv.New64a()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+
+	// FNV has a weak avalanche for the strings with the same prefix, so
+	// the result is mixed by the finalizer of splitmix64.
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
+
+func better(a, b candidate) bool {
+	if a.weight != b.weight {
+		return a.weight > b.weight
+	}
+	if a.node.Group != b.node.Group {
+		return a.node.Group < b.node.Group
+	}
+	return a.node.Node < b.node.Node
+}
+
+// Select returns the nodes which should keep the chunk. Every node belongs to
+// a distinct group. If factor is zero or exceeds the number of groups, a node
+// from every group is returned.
+func Select(dgst digest.Digest, nodes []Node, factor int) []Node {
+	groups := make(map[string]candidate)
+
+	for _, n := range nodes {
+		c := candidate{
+			node:   n,
+			weight: weight(dgst.String(), n.Group, n.Node),
+		}
+		if best, ok := groups[n.Group]; !ok || better(c, best) {
+			groups[n.Group] = c
+		}
+	}
+
+	list := make([]candidate, 0, len(groups))
+
+	for group, c := range groups {
+		c.weight = weight(dgst.String(), group)
+		list = append(list, c)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return better(list[i], list[j])
+	})
+
+	if factor > 0 && factor < len(list) {
+		list = list[:factor]
+	}
+
+	res := make([]Node, len(list))
+	for i, c := range list {
+		res[i] = c.node
+	}
+
+	return res
+}
+
+// Contains checks whether the node is in the list.
+func Contains(nodes []Node, group, node string) bool {
+	_, ok := Find(nodes, group, node)
+	return ok
+}
+
+// Find returns the node from the list.
+func Find(nodes []Node, group, node string) (Node, bool) {
+	for _, n := range nodes {
+		if n.Group == group && n.Node == node {
+			return n, true
+		}
+	}
+	return Node{}, false
+}
+
+func listMembers(ctx context.Context) ([]*cluster.Member, error) {
+	if m, ok := ctx.Value(AppMembershipContextVar).(*Membership); ok {
+		return m.Members()
+	}
+	return cluster.ListMembers(ctx)
+}
+
+func listNodes(ctx context.Context, draining bool) ([]Node, error) {
+	members, err := listMembers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []Node
+
+	for _, m := range members {
+		if !m.Live || (m.Draining && !draining) {
+			continue
+		}
+		res = append(res, Node{
+			Group:   m.Group,
+			Node:    m.Node,
+			Address: m.Address,
+		})
+	}
+
+	return res, nil
+}
+
+// ListNodes returns the live nodes which can receive replicas. The list kept
+// by Membership is used if it is in the context.
+func ListNodes(ctx context.Context) ([]Node, error) {
+	return listNodes(ctx, false)
+}
+
+// ListLiveNodes returns all live nodes including the draining ones.
+func ListLiveNodes(ctx context.Context) ([]Node, error) {
+	return listNodes(ctx, true)
+}
+
+// IsDraining checks whether the node is marked as draining.
+func IsDraining(ctx context.Context, group, node string) (bool, error) {
+	members, err := listMembers(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range members {
+		if m.Group == group && m.Node == node {
+			return m.Draining, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Locate returns the nodes which should keep the chunk according to the
+// replication factor from the configuration.
+func Locate(ctx context.Context, dgst digest.Digest) ([]Node, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	nodes, err := ListNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return Select(dgst, nodes, cfg.Storage.ReplicationFactor), nil
+}
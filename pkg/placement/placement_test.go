@@ -0,0 +1,97 @@
+package placement
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/legionus/kavka/pkg/digest"
+)
+
+func makeNodes(groups, perGroup int) []Node {
+	var res []Node
+	for g := 0; g < groups; g++ {
+		for n := 0; n < perGroup; n++ {
+			res = append(res, Node{
+				Group: fmt.Sprintf("group-%d", g),
+				Node:  fmt.Sprintf("node-%d-%d", g, n),
+			})
+		}
+	}
+	return res
+}
+
+func TestSelectDistinctGroups(t *testing.T) {
+	nodes := makeNodes(5, 3)
+
+	for i := 0; i < 100; i++ {
+		dgst := digest.FromBytes([]byte(fmt.Sprintf("chunk-%d", i)))
+
+		for factor := 0; factor <= 6; factor++ {
+			res := Select(dgst, nodes, factor)
+
+			expected := factor
+			if factor == 0 || factor > 5 {
+				expected = 5
+			}
+			if len(res) != expected {
+				t.Fatalf("wrong number of nodes: factor=%d got %d, expected %d", factor, len(res), expected)
+			}
+
+			groups := make(map[string]struct{})
+			for _, n := range res {
+				if _, ok := groups[n.Group]; ok {
+					t.Fatalf("group %s is chosen twice: %v", n.Group, res)
+				}
+				groups[n.Group] = struct{}{}
+			}
+
+			// The placement with the smaller factor is the prefix of
+			// the placement with the bigger one.
+			prev := Select(dgst, nodes, factor-1)
+			if factor > 1 && prev[len(prev)-1] != res[len(prev)-1] {
+				t.Fatalf("placement is not stable: %v and %v", prev, res)
+			}
+		}
+	}
+}
+
+func TestSelectStable(t *testing.T) {
+	nodes := makeNodes(4, 2)
+
+	// The order of nodes does not matter.
+	reversed := make([]Node, len(nodes))
+	for i, n := range nodes {
+		reversed[len(nodes)-1-i] = n
+	}
+
+	moved := 0
+
+	for i := 0; i < 1000; i++ {
+		dgst := digest.FromBytes([]byte(fmt.Sprintf("chunk-%d", i)))
+
+		a := Select(dgst, nodes, 2)
+		b := Select(dgst, reversed, 2)
+
+		if fmt.Sprint(a) != fmt.Sprint(b) {
+			t.Fatalf("placement depends on the order of nodes: %v and %v", a, b)
+		}
+
+		// A new group takes only the chunks for which it gets one of
+		// the highest weights.
+		c := Select(dgst, append(nodes, makeNodes(5, 1)[4]), 2)
+
+		for _, n := range a {
+			if !Contains(c, n.Group, n.Node) {
+				moved++
+				if !Contains(c, "group-4", "node-4-0") {
+					t.Fatalf("chunk moved not to the new group: %v and %v", a, c)
+				}
+			}
+		}
+	}
+
+	// About 2/5 of the chunks move to the new group.
+	if moved < 300 || moved > 500 {
+		t.Errorf("unexpected number of moved replicas: %d", moved)
+	}
+}
@@ -0,0 +1,53 @@
+package queue
+
+import (
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+func getOffset(coll metadata.EtcdCollection, key metadata.EtcdKey, opts ...metadata.GetOption) (int64, error) {
+	ans, err := coll.Get(key, opts...)
+	if err != nil {
+		return int64(0), err
+	}
+
+	queueKey, err := metadata.ParseQueueEtcdKey(ans.RawKey)
+	if err != nil {
+		return int64(0), err
+	}
+
+	return queueKey.Offset, nil
+}
+
+// GetCornerOffsets returns the offset of the oldest message in the partition
+// and the offset following the newest one.
+func GetCornerOffsets(coll metadata.EtcdCollection, topic string, partition int64) (int64, int64, error) {
+	offsetOldest, err := getOffset(
+		coll,
+		&metadata.QueueEtcdKey{
+			Topic:     topic,
+			Partition: partition,
+			Offset:    metadata.NoOffset,
+		},
+		metadata.PrefixKey,
+		metadata.FirstKey,
+	)
+	if err != nil {
+		return int64(0), int64(0), err
+	}
+
+	offsetNewest, err := getOffset(
+		coll,
+		&metadata.QueueEtcdKey{
+			Topic:     topic,
+			Partition: partition,
+			Offset:    metadata.NoOffset,
+		},
+		metadata.PrefixKey,
+		metadata.LastKey,
+	)
+	if err != nil {
+		return int64(0), int64(0), err
+	}
+
+	return offsetOldest, offsetNewest + 1, nil
+}
@@ -2,34 +2,99 @@ package queue
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/legionus/kavka/pkg/config"
 	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/leader"
 	"github.com/legionus/kavka/pkg/message"
 	"github.com/legionus/kavka/pkg/metadata"
 )
 
 func CreateQueue(ctx context.Context, topic string, partition int64, msg *message.MessageInfo) (*metadata.QueueEtcdKey, error) {
+	return appendRecord(ctx, topic, partition, msg.ID, msg.String(), true)
+}
+
+// AppendRecord appends the message record forwarded by another node. The
+// record is not forwarded further.
+func AppendRecord(ctx context.Context, topic string, partition int64, id, value string) (*metadata.QueueEtcdKey, error) {
+	return appendRecord(ctx, topic, partition, id, value, false)
+}
+
+func appendRecord(ctx context.Context, topic string, partition int64, id, value string, forward bool) (*metadata.QueueEtcdKey, error) {
 	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
 	if !ok {
 		return nil, fmt.Errorf("Unable to obtain config from context")
 	}
 
+	if leaders, ok := ctx.Value(leader.AppLeadersContextVar).(*leader.Leaders); ok {
+		res, err := leaders.Append(ctx, topic, partition, id, value, forward)
+		if err != leader.ErrNotLeader {
+			return res, err
+		}
+		// There is no available leader, so the record is appended as
+		// without the leader.
+	}
+
 	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := queuesColl.Create(
+	// The node which forwarded the record could cancel it and append it
+	// itself.
+	if !forward && id != metadata.NoString {
+		txn := metadata.NewTransaction(ctx, cfg)
+		txn.Unmodified(leader.CancelKey(topic, partition, id), 0)
+
+		res, err := queuesColl.(*metadata.QueuesCollection).CreateMessageTxn(
+			&metadata.QueueEtcdKey{
+				Topic:     topic,
+				Partition: partition,
+			},
+			id,
+			value,
+			txn,
+		)
+		if err == metadata.ErrKeyModified {
+			return nil, leader.ErrAppendCancelled
+		}
+		return res, err
+	}
+
+	return queuesColl.(*metadata.QueuesCollection).CreateMessage(
 		&metadata.QueueEtcdKey{
 			Topic:     topic,
 			Partition: partition,
 		},
-		msg.String(),
+		id,
+		value,
 	)
+}
+
+// ScheduleQueue stores the message record which will be appended to the
+// partition by the scheduler after deliverAt.
+func ScheduleQueue(ctx context.Context, topic string, partition int64, deliverAt time.Time, msg *message.MessageInfo) (*metadata.ScheduledEtcdKey, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	scheduledColl, err := metadata.NewScheduledCollection(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return res.(*metadata.QueueEtcdKey), nil
+	key := &metadata.ScheduledEtcdKey{
+		Time:      deliverAt.UnixNano(),
+		Topic:     topic,
+		Partition: partition,
+		ID:        msg.ID,
+	}
+
+	if err := scheduledColl.Put(key, msg.String()); err != nil {
+		return nil, err
+	}
+
+	return key, nil
 }
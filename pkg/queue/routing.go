@@ -0,0 +1,32 @@
+package queue
+
+import (
+	"hash/fnv"
+)
+
+// KeyPartition returns the partition of the message with the key.
+//
+// The jump consistent hash is used for routing. When the number of partitions
+// grows from n to m, only (m-n)/m of the keys change their partition and all
+// of them move to the new partitions. Messages with the same key written
+// before the change remain in the old partition.
+func KeyPartition(key string, partitions int64) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	return jumpHash(h.Sum64(), partitions)
+}
+
+// jumpHash implements the algorithm from "A Fast, Minimal Memory, Consistent
+// Hash Algorithm" by John Lamping and Eric Veach.
+func jumpHash(key uint64, buckets int64) int64 {
+	b, j := int64(-1), int64(0)
+
+	for j < buckets {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+
+	return b
+}
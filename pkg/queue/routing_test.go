@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKeyPartitionRange(t *testing.T) {
+	for n := int64(1); n < 20; n++ {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key-%d", i)
+
+			p := KeyPartition(key, n)
+			if p < 0 || p >= n {
+				t.Fatalf("partition out of range: key=%s partitions=%d result=%d", key, n, p)
+			}
+
+			if p != KeyPartition(key, n) {
+				t.Fatalf("routing is not stable: key=%s partitions=%d", key, n)
+			}
+		}
+	}
+}
+
+func TestKeyPartitionGrow(t *testing.T) {
+	moved := 0
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+
+		before := KeyPartition(key, 4)
+		after := KeyPartition(key, 5)
+
+		if before == after {
+			continue
+		}
+
+		if after != 4 {
+			t.Fatalf("key %s moved from %d to the old partition %d", key, before, after)
+		}
+		moved++
+	}
+
+	if moved == 0 || moved > 400 {
+		t.Fatalf("unexpected number of moved keys: %d", moved)
+	}
+}
@@ -0,0 +1,394 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pborman/uuid"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/util"
+)
+
+var (
+	ErrQueueEmpty      = errors.New("no messages available")
+	ErrLeaseNotFound   = errors.New("message is not leased")
+	ErrReceiptMismatch = errors.New("receipt does not match the lease")
+)
+
+// Lease describes the delivered but not acknowledged message.
+type Lease struct {
+	Receipt    string    `json:"receipt"`
+	Deadline   time.Time `json:"deadline"`
+	Deliveries int64     `json:"deliveries"`
+}
+
+func (l Lease) String() string {
+	bytes, err := json.Marshal(l)
+	if err != nil {
+		panic(err)
+	}
+	return string(bytes)
+}
+
+func parseLease(data string) (*Lease, error) {
+	res := &Lease{}
+
+	if err := json.Unmarshal([]byte(data), res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Delivery is a message leased to the consumer.
+type Delivery struct {
+	Key     *metadata.QueueEtcdKey
+	Message *message.MessageInfo
+	Lease   *Lease
+}
+
+// Receive leases the next unacknowledged message of the partition for the
+// visibility timeout. Messages whose lease has expired are delivered again
+// before messages that have never been delivered.
+func Receive(ctx context.Context, topic string, partition int64, timeout time.Duration) (*Delivery, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	if timeout <= 0 {
+		timeout = cfg.WorkQueue.VisibilityTimeout
+	}
+
+	workColl, err := metadata.NewWorkQueuesCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := receiveExpired(ctx, cfg, workColl, queuesColl, topic, partition, timeout)
+	if err != nil || res != nil {
+		return res, err
+	}
+
+	return receiveNext(ctx, cfg, workColl, queuesColl, topic, partition, timeout)
+}
+
+func receiveExpired(ctx context.Context, cfg *config.Config, workColl, queuesColl metadata.EtcdCollection, topic string, partition int64, timeout time.Duration) (*Delivery, error) {
+	records, err := workColl.List(&metadata.WorkQueueEtcdKey{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    metadata.NoOffset,
+	}, metadata.SortAscend)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	for _, rec := range records {
+		lease, err := parseLease(rec.Value)
+		if err != nil {
+			logrus.Errorf("Bad lease %s: %s", rec.RawKey, err)
+			continue
+		}
+
+		if lease.Deadline.After(now) {
+			continue
+		}
+
+		workKey, err := metadata.ParseWorkQueueEtcdKey(rec.RawKey)
+		if err != nil {
+			logrus.Errorf("Invalid key %s: %s", rec.RawKey, err)
+			continue
+		}
+
+		key := &metadata.QueueEtcdKey{
+			Topic:     topic,
+			Partition: partition,
+			Offset:    workKey.Offset,
+		}
+
+		value, err := queuesColl.Get(key)
+		if err != nil {
+			if err != metadata.ErrKeyNotFound {
+				return nil, err
+			}
+			// The message has been removed by cleanup.
+			txn := metadata.NewTransaction(ctx, cfg)
+			txn.Unmodified(workKey, rec.ModRevision)
+			txn.Delete(workKey)
+
+			if err := txn.Commit(); err != nil && err != metadata.ErrKeyModified {
+				return nil, err
+			}
+			continue
+		}
+
+		msg, err := message.ParseMessageInfo(value.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.WorkQueue.MaxDeliveries > 0 && lease.Deliveries >= cfg.WorkQueue.MaxDeliveries {
+			if err := moveToDeadLetter(ctx, cfg, workKey, rec, key, msg); err != nil {
+				logrus.Errorf("Unable to move %s to dead-letter topic: %s", key.String(), err)
+			}
+			continue
+		}
+
+		newLease := &Lease{
+			Receipt:    uuid.New(),
+			Deadline:   now.Add(timeout),
+			Deliveries: lease.Deliveries + 1,
+		}
+
+		txn := metadata.NewTransaction(ctx, cfg)
+		txn.Unmodified(workKey, rec.ModRevision)
+		txn.Put(workKey, newLease.String())
+
+		if err := txn.Commit(); err != nil {
+			if err == metadata.ErrKeyModified {
+				// Another consumer got it first.
+				continue
+			}
+			return nil, err
+		}
+
+		return &Delivery{
+			Key:     key,
+			Message: msg,
+			Lease:   newLease,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+func receiveNext(ctx context.Context, cfg *config.Config, workColl, queuesColl metadata.EtcdCollection, topic string, partition int64, timeout time.Duration) (*Delivery, error) {
+	cursorKey := &metadata.WorkQueueEtcdKey{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    metadata.NoOffset,
+	}
+
+	for {
+		var (
+			offset   int64
+			revision int64
+		)
+
+		cursor, err := workColl.Get(cursorKey)
+		if err != nil {
+			if err != metadata.ErrKeyNotFound {
+				return nil, err
+			}
+		} else {
+			offset = util.ToInt64(cursor.Value)
+			revision = cursor.ModRevision
+		}
+
+		offsetOldest, offsetNewest, err := GetCornerOffsets(queuesColl, topic, partition)
+		if err != nil {
+			if err == metadata.ErrKeyNotFound {
+				return nil, ErrQueueEmpty
+			}
+			return nil, err
+		}
+
+		if offset < offsetOldest {
+			offset = offsetOldest
+		}
+
+		if offset >= offsetNewest {
+			return nil, ErrQueueEmpty
+		}
+
+		key := &metadata.QueueEtcdKey{
+			Topic:     topic,
+			Partition: partition,
+			Offset:    offset,
+		}
+
+		var msg *message.MessageInfo
+
+		value, err := queuesColl.Get(key)
+		if err != nil {
+			if err != metadata.ErrKeyNotFound {
+				return nil, err
+			}
+			// Gap in the partition (e.g. after compaction).
+		} else {
+			msg, err = message.ParseMessageInfo(value.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		lease := &Lease{
+			Receipt:    uuid.New(),
+			Deadline:   time.Now().Add(timeout),
+			Deliveries: 1,
+		}
+
+		txn := metadata.NewTransaction(ctx, cfg)
+		txn.Unmodified(cursorKey, revision)
+		txn.Put(cursorKey, fmt.Sprintf("%d", offset+1))
+
+		if msg != nil {
+			txn.Put(&metadata.WorkQueueEtcdKey{
+				Topic:     topic,
+				Partition: partition,
+				Offset:    offset,
+			}, lease.String())
+		}
+
+		if err := txn.Commit(); err != nil {
+			if err == metadata.ErrKeyModified {
+				continue
+			}
+			return nil, err
+		}
+
+		if msg == nil {
+			continue
+		}
+
+		return &Delivery{
+			Key:     key,
+			Message: msg,
+			Lease:   lease,
+		}, nil
+	}
+}
+
+// moveToDeadLetter appends the message to the dead-letter topic and removes
+// the lease in one transaction, so the message is neither lost nor moved twice.
+func moveToDeadLetter(ctx context.Context, cfg *config.Config, workKey *metadata.WorkQueueEtcdKey, rec metadata.EtcdValue, key *metadata.QueueEtcdKey, msg *message.MessageInfo) error {
+	topicsColl, err := metadata.NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	deadKey := &metadata.TopicEtcdKey{
+		Topic:     key.Topic + cfg.WorkQueue.DeadLetterSuffix,
+		Partition: key.Partition,
+	}
+
+	if _, err := topicsColl.Get(deadKey); err != nil {
+		if err != metadata.ErrKeyNotFound {
+			return err
+		}
+		if err := metadata.CreatePartitions(ctx, cfg, deadKey.Topic, deadKey.Partition, time.Now().String()); err != nil {
+			return err
+		}
+	}
+
+	dead := *msg
+	dead.CreationTime = time.Now().String()
+
+	txn := metadata.NewTransaction(ctx, cfg)
+	txn.Unmodified(workKey, rec.ModRevision)
+	txn.Delete(workKey)
+	dead.PutRefs(txn, deadKey.Topic, deadKey.Partition)
+
+	res, err := queuesColl.(*metadata.QueuesCollection).CreateMessageTxn(
+		&metadata.QueueEtcdKey{
+			Topic:     deadKey.Topic,
+			Partition: deadKey.Partition,
+		},
+		dead.ID,
+		dead.String(),
+		txn,
+	)
+	if err != nil {
+		if err == metadata.ErrKeyModified {
+			// Another consumer got it first.
+			return nil
+		}
+		return err
+	}
+
+	logrus.Infof("Message %s moved to %s", key.String(), res.String())
+	return nil
+}
+
+// Ack removes the message from the pending set.
+func Ack(ctx context.Context, topic string, partition int64, offset int64, receipt string) error {
+	return finishLease(ctx, topic, partition, offset, receipt, true)
+}
+
+// Nack makes the message visible to other consumers immediately.
+func Nack(ctx context.Context, topic string, partition int64, offset int64, receipt string) error {
+	return finishLease(ctx, topic, partition, offset, receipt, false)
+}
+
+func finishLease(ctx context.Context, topic string, partition int64, offset int64, receipt string, ack bool) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	workColl, err := metadata.NewWorkQueuesCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	workKey := &metadata.WorkQueueEtcdKey{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    offset,
+	}
+
+	rec, err := workColl.Get(workKey)
+	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			return ErrLeaseNotFound
+		}
+		return err
+	}
+
+	lease, err := parseLease(rec.Value)
+	if err != nil {
+		return err
+	}
+
+	if lease.Receipt != receipt {
+		return ErrReceiptMismatch
+	}
+
+	txn := metadata.NewTransaction(ctx, cfg)
+	txn.Unmodified(workKey, rec.ModRevision)
+
+	if ack {
+		txn.Delete(workKey)
+	} else {
+		lease.Receipt = ""
+		lease.Deadline = time.Now()
+		txn.Put(workKey, lease.String())
+	}
+
+	if err := txn.Commit(); err != nil {
+		if err == metadata.ErrKeyModified {
+			return ErrReceiptMismatch
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/storage"
+)
+
+const testDigest = digest.Digest("sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+
+func startWorkQueue(t *testing.T) (context.Context, *config.Config, func()) {
+	cfg, stop := etcdtest.Start(t)
+	cfg.WorkQueue.MaxDeliveries = 2
+
+	ctx := context.WithValue(context.Background(), config.AppConfigContextVar, cfg)
+
+	return ctx, cfg, stop
+}
+
+func appendTestMessage(t *testing.T, ctx context.Context, topic string) *message.MessageInfo {
+	msg := &message.MessageInfo{
+		ID:           uuid.New(),
+		CreationTime: time.Now().String(),
+		Blobs: []storage.Descriptor{
+			{Digest: testDigest},
+		},
+	}
+
+	if _, err := CreateQueue(ctx, topic, 0, msg); err != nil {
+		t.Fatalf("unable to append message: %s", err)
+	}
+
+	return msg
+}
+
+func TestReceiveLease(t *testing.T) {
+	ctx, _, stop := startWorkQueue(t)
+	defer stop()
+
+	first := appendTestMessage(t, ctx, "jobs")
+	second := appendTestMessage(t, ctx, "jobs")
+
+	d1, err := Receive(ctx, "jobs", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("unable to receive: %s", err)
+	}
+	if d1.Message.ID != first.ID || d1.Lease.Deliveries != 1 {
+		t.Fatalf("unexpected delivery: %#v %#v", d1.Message, d1.Lease)
+	}
+
+	// The leased message is not visible to other consumers.
+	d2, err := Receive(ctx, "jobs", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("unable to receive: %s", err)
+	}
+	if d2.Message.ID != second.ID {
+		t.Fatalf("expected message %s, got %s", second.ID, d2.Message.ID)
+	}
+
+	if _, err := Receive(ctx, "jobs", 0, time.Minute); err != ErrQueueEmpty {
+		t.Fatalf("expected ErrQueueEmpty, got %v", err)
+	}
+
+	if err := Ack(ctx, "jobs", 0, d1.Key.Offset, "wrong"); err != ErrReceiptMismatch {
+		t.Fatalf("expected ErrReceiptMismatch, got %v", err)
+	}
+	if err := Ack(ctx, "jobs", 0, d1.Key.Offset, d1.Lease.Receipt); err != nil {
+		t.Fatalf("unable to ack: %s", err)
+	}
+	if err := Ack(ctx, "jobs", 0, d1.Key.Offset, d1.Lease.Receipt); err != ErrLeaseNotFound {
+		t.Fatalf("expected ErrLeaseNotFound, got %v", err)
+	}
+}
+
+func TestReceiveExpired(t *testing.T) {
+	ctx, _, stop := startWorkQueue(t)
+	defer stop()
+
+	msg := appendTestMessage(t, ctx, "jobs")
+
+	d1, err := Receive(ctx, "jobs", 0, time.Millisecond)
+	if err != nil {
+		t.Fatalf("unable to receive: %s", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	d2, err := Receive(ctx, "jobs", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("unable to receive: %s", err)
+	}
+	if d2.Message.ID != msg.ID || d2.Lease.Deliveries != 2 {
+		t.Fatalf("unexpected delivery: %#v %#v", d2.Message, d2.Lease)
+	}
+
+	// The consumer of the expired lease can not acknowledge the message.
+	if err := Ack(ctx, "jobs", 0, d1.Key.Offset, d1.Lease.Receipt); err != ErrReceiptMismatch {
+		t.Fatalf("expected ErrReceiptMismatch, got %v", err)
+	}
+}
+
+func TestNack(t *testing.T) {
+	ctx, _, stop := startWorkQueue(t)
+	defer stop()
+
+	msg := appendTestMessage(t, ctx, "jobs")
+
+	d1, err := Receive(ctx, "jobs", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("unable to receive: %s", err)
+	}
+
+	if err := Nack(ctx, "jobs", 0, d1.Key.Offset, d1.Lease.Receipt); err != nil {
+		t.Fatalf("unable to nack: %s", err)
+	}
+
+	d2, err := Receive(ctx, "jobs", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("unable to receive: %s", err)
+	}
+	if d2.Message.ID != msg.ID || d2.Lease.Deliveries != 2 {
+		t.Fatalf("unexpected delivery: %#v %#v", d2.Message, d2.Lease)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	ctx, cfg, stop := startWorkQueue(t)
+	defer stop()
+
+	msg := appendTestMessage(t, ctx, "jobs")
+
+	for i := int64(0); i < cfg.WorkQueue.MaxDeliveries; i++ {
+		d, err := Receive(ctx, "jobs", 0, time.Minute)
+		if err != nil {
+			t.Fatalf("unable to receive: %s", err)
+		}
+		if err := Nack(ctx, "jobs", 0, d.Key.Offset, d.Lease.Receipt); err != nil {
+			t.Fatalf("unable to nack: %s", err)
+		}
+	}
+
+	if _, err := Receive(ctx, "jobs", 0, time.Minute); err != ErrQueueEmpty {
+		t.Fatalf("expected ErrQueueEmpty, got %v", err)
+	}
+
+	workColl, err := metadata.NewWorkQueuesCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := workColl.Get(&metadata.WorkQueueEtcdKey{Topic: "jobs", Partition: 0, Offset: 0}); err != metadata.ErrKeyNotFound {
+		t.Fatalf("expected lease to be removed, got %v", err)
+	}
+
+	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := queuesColl.Get(&metadata.QueueEtcdKey{Topic: "jobs-dlq", Partition: 0, Offset: 0})
+	if err != nil {
+		t.Fatalf("unable to get dead-letter message: %s", err)
+	}
+	dead, err := message.ParseMessageInfo(value.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dead.ID != msg.ID {
+		t.Fatalf("expected message %s, got %s", msg.ID, dead.ID)
+	}
+
+	refsColl, err := metadata.NewRefsCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := refsColl.Get(&metadata.RefsEtcdKey{Digest: testDigest, Topic: "jobs-dlq", Partition: 0, ID: msg.ID, Order: 0}); err != nil {
+		t.Fatalf("dead-letter message is not referenced: %s", err)
+	}
+}
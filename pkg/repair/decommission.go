@@ -0,0 +1,394 @@
+package repair
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/legionus/kavka/pkg/cluster"
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/etcd"
+	"github.com/legionus/kavka/pkg/jobs"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/placement"
+	"github.com/legionus/kavka/pkg/util"
+)
+
+// Parameters of the decommission job.
+const (
+	DecommissionGroup      = "group"
+	DecommissionNode       = "node"
+	DecommissionEtcdMember = "etcd-member"
+)
+
+// DecommissionJobName returns the name of the decommission job of the node.
+// The nodes with the same name can be in different groups.
+func DecommissionJobName(group, node string) string {
+	return group + "." + node
+}
+
+// Stages of the node decommission. The stage is saved in the job before it
+// starts, so the interrupted decommission continues from the same stage.
+// Every stage can be repeated.
+const (
+	decommissionStageDrain     = "drain"
+	decommissionStageReplicate = "replicate"
+	decommissionStageRecords   = "records"
+	decommissionStageEtcd      = "etcd"
+	decommissionStageMember    = "member"
+)
+
+var decommissionStages = []string{
+	decommissionStageDrain,
+	decommissionStageReplicate,
+	decommissionStageRecords,
+	decommissionStageEtcd,
+	decommissionStageMember,
+}
+
+// RunDecommissioner starts the service which processes the node decommission
+// jobs. Any node can take over the job left by the failed node.
+func RunDecommissioner(ctx context.Context) (chan struct{}, error) {
+	stopChan := make(chan struct{})
+
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return stopChan, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	c, err := etcd.NewEtcdClient(cfg)
+	if err != nil {
+		return stopChan, err
+	}
+
+	go func() {
+		for {
+			if err := jobs.Process(ctx, c, jobs.NodeDecommission, func(job *jobs.Job) error {
+				if err := decommissionNode(ctx, job); err != nil {
+					return err
+				}
+				logrus.Infof("Node decommissioned: %s/%s", job.Params[DecommissionGroup], job.Params[DecommissionNode])
+				return nil
+			}); err != nil {
+				logrus.Errorf("node decommission fails: %s", err)
+			}
+
+			select {
+			case <-time.After(10 * time.Second):
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	return stopChan, nil
+}
+
+func decommissionNode(ctx context.Context, job *jobs.Job) error {
+	start := 0
+
+	for i, stage := range decommissionStages {
+		if stage == job.Stage {
+			start = i
+			break
+		}
+	}
+
+	group := job.Params[DecommissionGroup]
+	node := job.Params[DecommissionNode]
+
+	job.State = jobs.StateRunning
+
+	for _, stage := range decommissionStages[start:] {
+		job.Stage = stage
+
+		if err := job.Save(ctx); err != nil {
+			return err
+		}
+
+		var err error
+
+		switch stage {
+		case decommissionStageDrain:
+			err = cluster.SetDraining(ctx, group, node, true)
+		case decommissionStageReplicate:
+			err = waitReplicated(ctx, job)
+		case decommissionStageRecords:
+			err = deleteNodeRecords(ctx, job)
+		case decommissionStageEtcd:
+			err = leaveEtcd(ctx, job)
+		case decommissionStageMember:
+			err = cluster.Forget(ctx, group, node)
+		}
+
+		if err != nil {
+			return fmt.Errorf("stage %s: %s", stage, err)
+		}
+	}
+
+	return nil
+}
+
+// waitReplicated waits until every chunk of the node which is used by messages
+// is kept by enough other groups. The other nodes synchronize these chunks
+// by the requests of the repair, which is started when the node begins
+// draining.
+func waitReplicated(ctx context.Context, job *jobs.Job) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	p, err := newPolicy(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	group := job.Params[DecommissionGroup]
+	node := job.Params[DecommissionNode]
+
+	for {
+		live, err := placement.ListLiveNodes(ctx)
+		if err != nil {
+			return err
+		}
+
+		eligible, err := placement.ListNodes(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(eligible) == 0 {
+			return fmt.Errorf("no nodes to keep the chunks")
+		}
+
+		var total, pending, lost int64
+
+		err = scan(blobsColl, live, eligible, func(chunk *chunkState) error {
+			if !hasNode(chunk, group, node) {
+				return nil
+			}
+
+			total++
+
+			n, err := p.factor(chunk.Digest)
+			if err != nil {
+				return err
+			}
+
+			if len(chunk.Groups) >= limitGroups(n, eligible) {
+				return nil
+			}
+
+			referenced, err := p.referenced(chunk.Digest)
+			if err != nil {
+				return err
+			}
+
+			if !referenced {
+				return nil
+			}
+
+			// The node is gone and nobody else keeps the chunk.
+			if len(chunk.Groups) == 0 && len(chunk.Draining) == 0 {
+				lost++
+				return nil
+			}
+
+			pending++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		job.Progress["chunks"] = total
+		job.Progress["pending"] = pending
+		job.Progress["lost"] = lost
+
+		if err := job.Save(ctx); err != nil {
+			return err
+		}
+
+		if pending == 0 {
+			return nil
+		}
+
+		time.Sleep(drainPeriod)
+	}
+}
+
+func hasNode(chunk *chunkState, group, node string) bool {
+	return placement.Contains(chunk.Draining, group, node) || placement.Contains(chunk.Stale, group, node)
+}
+
+// deleteNodeRecords removes the locations of chunks on the node, so the node
+// is no longer used as a source of chunks. The draining node does not accept
+// messages, but a chunk of the write started before the drain could appear
+// after waitReplicated. So the replicas are counted once more and the location
+// is removed only if the counted locations are not modified. Otherwise the
+// chunk is left to the repair and checked again.
+func deleteNodeRecords(ctx context.Context, job *jobs.Job) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	p, err := newPolicy(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	group := job.Params[DecommissionGroup]
+	node := job.Params[DecommissionNode]
+
+	for {
+		live, err := placement.ListLiveNodes(ctx)
+		if err != nil {
+			return err
+		}
+
+		eligible, err := placement.ListNodes(ctx)
+		if err != nil {
+			return err
+		}
+
+		var pending int64
+
+		err = scan(blobsColl, live, eligible, func(chunk *chunkState) error {
+			if !hasNode(chunk, group, node) {
+				return nil
+			}
+
+			deleted, err := deleteNodeRecord(ctx, cfg, blobsColl, p, chunk.Digest, group, node, live, eligible)
+			if err != nil {
+				return err
+			}
+
+			if !deleted {
+				pending++
+				return nil
+			}
+
+			job.Progress["records"]++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		job.Progress["pending"] = pending
+
+		if err := job.Save(ctx); err != nil {
+			return err
+		}
+
+		if pending == 0 {
+			return nil
+		}
+
+		time.Sleep(drainPeriod)
+	}
+}
+
+// deleteNodeRecord removes the location of the chunk on the node if the chunk
+// is kept by enough other groups. The locations are read again and guarded by
+// the transaction, so the replica removed concurrently is noticed.
+func deleteNodeRecord(ctx context.Context, cfg *config.Config, blobsColl metadata.EtcdCollection, p *policy, dgst digest.Digest, group, node string, live, eligible []placement.Node) (bool, error) {
+	records, err := blobsColl.List(&metadata.BlobEtcdKey{Digest: dgst})
+	if err != nil {
+		return false, err
+	}
+
+	txn := metadata.NewTransaction(ctx, cfg)
+
+	var own *metadata.BlobEtcdKey
+	groups := make(map[string]struct{})
+
+	for _, rec := range records {
+		key, err := metadata.ParseBlobsEtcdKey(rec.RawKey)
+		if err != nil {
+			logrus.Errorf("Unable to parse key: %s", err)
+			continue
+		}
+
+		if key.Group == group && key.Host == node {
+			own = key
+			continue
+		}
+
+		if !placement.Contains(eligible, key.Group, key.Host) {
+			continue
+		}
+
+		groups[key.Group] = struct{}{}
+		txn.Unmodified(key, rec.ModRevision)
+	}
+
+	if own == nil {
+		return true, nil
+	}
+
+	n, err := p.factor(dgst)
+	if err != nil {
+		return false, err
+	}
+
+	if len(groups) < limitGroups(n, eligible) {
+		referenced, err := p.referenced(dgst)
+		if err != nil {
+			return false, err
+		}
+
+		// The repair copies the chunk from the node while it is live or
+		// from the other groups. The chunk kept only by the dead node is
+		// lost anyway.
+		if referenced && (len(groups) > 0 || placement.Contains(live, group, node)) {
+			return false, nil
+		}
+	}
+
+	txn.Delete(own)
+
+	if err := txn.Commit(); err != nil {
+		if err == metadata.ErrKeyModified {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func leaveEtcd(ctx context.Context, job *jobs.Job) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	id, ok := job.Params[DecommissionEtcdMember]
+	if !ok || id == "" {
+		return nil
+	}
+
+	err := etcd.RemoveMember(ctx, cfg, util.ToUint64(id))
+	if err == etcd.ErrMemberNotFound {
+		// The member is already removed.
+		return nil
+	}
+
+	return err
+}
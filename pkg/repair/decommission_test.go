@@ -0,0 +1,171 @@
+package repair
+
+import (
+	"testing"
+	"time"
+
+	"github.com/legionus/kavka/pkg/cluster"
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/etcd"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+	"github.com/legionus/kavka/pkg/jobs"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+func TestDecommissionResume(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	nodeCtx := func(group, node string) context.Context {
+		c := *cfg
+		c.Global.Group = group
+		c.Global.Hostname = node
+		return context.WithValue(context.Background(), config.AppConfigContextVar, &c)
+	}
+
+	for _, n := range [][2]string{{"x", "x1"}, {"b", "b1"}} {
+		if err := cluster.Register(nodeCtx(n[0], n[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := nodeCtx("b", "b1")
+
+	blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refsColl, err := metadata.NewRefsCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replicated := digest.FromBytes([]byte("replicated"))
+	single := digest.FromBytes([]byte("single"))
+
+	locations := []*metadata.BlobEtcdKey{
+		{Digest: replicated, Group: "x", Host: "x1"},
+		{Digest: replicated, Group: "b", Host: "b1"},
+		{Digest: single, Group: "x", Host: "x1"},
+	}
+
+	for _, key := range locations {
+		if err := blobsColl.Put(key, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, dgst := range []digest.Digest{replicated, single} {
+		if err := refsColl.Put(&metadata.RefsEtcdKey{Digest: dgst, Topic: "t", Partition: 0, ID: "id", Order: 0}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The node which started the decommission has passed the replicate
+	// stage and failed. The chunk "single" was written after the stage.
+	if err := cluster.SetDraining(ctx, "x", "x1", true); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := jobs.CreateWithParams(nodeCtx("a", "a1"), jobs.NodeDecommission, DecommissionJobName("x", "x1"), map[string]string{
+		DecommissionGroup: "x",
+		DecommissionNode:  "x1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job.Owner = "a1"
+	job.State = jobs.StateRunning
+	job.Stage = decommissionStageRecords
+
+	if err := job.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := etcd.NewEtcdClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- jobs.Process(ctx, c, jobs.NodeDecommission, func(job *jobs.Job) error {
+			return decommissionNode(ctx, job)
+		})
+	}()
+
+	waitJob := func(fn func(*jobs.Job) bool) *jobs.Job {
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+			job, err := jobs.Get(ctx, jobs.NodeDecommission, DecommissionJobName("x", "x1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fn(job) {
+				return job
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("job is not updated")
+		return nil
+	}
+
+	// The job is resumed from the saved stage by another node and the chunk
+	// without other replicas keeps its location.
+	job = waitJob(func(job *jobs.Job) bool {
+		return job.Progress["pending"] == 1
+	})
+
+	if job.Owner != "b1" || job.Stage != decommissionStageRecords {
+		t.Fatalf("unexpected job: %#v", job)
+	}
+
+	if _, err := blobsColl.Get(locations[0]); err != metadata.ErrKeyNotFound {
+		t.Fatalf("location of the replicated chunk is not removed: %v", err)
+	}
+	if _, err := blobsColl.Get(locations[2]); err != nil {
+		t.Fatalf("location of the single chunk is removed: %v", err)
+	}
+
+	// The repair copies the chunk, so the next pass removes the location.
+	if err := blobsColl.Put(&metadata.BlobEtcdKey{Digest: single, Group: "b", Host: "b1"}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(drainPeriod + 10*time.Second):
+		t.Fatal("decommission is not finished")
+	}
+
+	job = waitJob(func(job *jobs.Job) bool {
+		return !job.Active()
+	})
+
+	if job.State != jobs.StateDone {
+		t.Fatalf("unexpected job state: %s (%s)", job.State, job.Error)
+	}
+
+	if _, err := blobsColl.Get(locations[2]); err != metadata.ErrKeyNotFound {
+		t.Fatalf("location of the single chunk is not removed: %v", err)
+	}
+
+	members, err := cluster.ListMembers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range members {
+		if m.Group == "x" && m.Node == "x1" && m.Registered != nil {
+			t.Fatalf("node is not removed from the cluster")
+		}
+	}
+}
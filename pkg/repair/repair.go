@@ -0,0 +1,823 @@
+// Package repair restores the redundancy of chunks.
+//
+// The node elected through etcd periodically scans the locations of chunks.
+// A chunk is under-replicated if fewer live groups than required keep it. The
+// required number is the replication factor or, if it is not set, the highest
+// write concern of the topics which use the chunk, limited by the number of
+// live groups. The elected node requests the chosen nodes which do not keep
+// the chunk to synchronize it. When the replication factor is set, the nodes
+// outside of the placement are requested to remove their copies of chunks
+// which are kept by all the chosen nodes. Every node processes only the
+// requests addressed to it.
+//
+// The draining node does not count as a keeper of chunks, but its copies are
+// used to restore the redundancy. While such chunks remain, the pass is
+// repeated with the short period.
+package repair
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	v3 "github.com/coreos/etcd/clientv3"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/etcd"
+	"github.com/legionus/kavka/pkg/etcd/observer"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/placement"
+	"github.com/legionus/kavka/pkg/storage"
+	"github.com/legionus/kavka/pkg/syncer"
+)
+
+// Stats describes the repair pass.
+type Stats struct {
+	Node            string    `json:"node"`
+	Started         time.Time `json:"started"`
+	Finished        time.Time `json:"finished"`
+	Scanned         int64     `json:"scanned"`
+	UnderReplicated int64     `json:"under-replicated"`
+	Lost            int64     `json:"lost"`
+	Requested       int64     `json:"requested"`
+	Trimmed         int64     `json:"trimmed"`
+	Draining        int64     `json:"draining"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// chunkState describes the locations of the chunk.
+type chunkState struct {
+	Digest digest.Digest
+	// Groups are the live groups which keep the chunk.
+	Groups map[string]struct{}
+	// Nodes are the live nodes which keep the chunk.
+	Nodes []placement.Node
+	// Draining are the draining nodes which keep the chunk.
+	Draining []placement.Node
+	// Stale are the nodes which keep the chunk, but are not live.
+	Stale []placement.Node
+}
+
+func (c *chunkState) GroupNames() []string {
+	res := make([]string, 0, len(c.Groups))
+	for group := range c.Groups {
+		res = append(res, group)
+	}
+	sort.Strings(res)
+	return res
+}
+
+const (
+	// drainPeriod is the period of the repair while chunks of draining
+	// nodes are not replicated elsewhere.
+	drainPeriod = 10 * time.Second
+
+	// repairBatch is the number of chunks synchronized at once.
+	repairBatch = 100
+
+	// scanBatch is the number of locations read from etcd at once.
+	scanBatch = 1000
+
+	// requestTTL is the lifetime of the repair request. The request which
+	// is not processed in time (e.g. the node has left) expires and is
+	// issued again by the next pass if it is still needed.
+	requestTTL = time.Minute
+
+	// requestsPeriod is the period between checks for the repair requests
+	// addressed to the node.
+	requestsPeriod = 5 * time.Second
+)
+
+// LastPass returns the statistics of the last repair pass. The pass runs on
+// the elected node, the statistics are kept in etcd.
+func LastPass(ctx context.Context) (*Stats, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	coll, err := metadata.NewRepairsCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := coll.Get(&metadata.RepairStatsEtcdKey{})
+	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	stats := &Stats{}
+	if err := json.Unmarshal([]byte(rec.Value), stats); err != nil {
+		return nil, fmt.Errorf("bad repair statistics: %s", err)
+	}
+
+	return stats, nil
+}
+
+// factor returns the number of groups which should keep each chunk of the
+// topics without the write concern of their own.
+func factor(cfg *config.Config) int {
+	if cfg.Storage.ReplicationFactor > 0 {
+		return cfg.Storage.ReplicationFactor
+	}
+	return int(cfg.Topic.WriteConcern)
+}
+
+func countGroups(nodes []placement.Node) int {
+	groups := make(map[string]struct{})
+	for _, n := range nodes {
+		groups[n.Group] = struct{}{}
+	}
+	return len(groups)
+}
+
+// limitGroups limits the required number of groups by the number of live
+// groups.
+func limitGroups(n int, live []placement.Node) int {
+	if groups := countGroups(live); n > groups {
+		n = groups
+	}
+	return n
+}
+
+// required returns the number of live groups which should keep each chunk of
+// the topics without the write concern of their own.
+func required(cfg *config.Config, live []placement.Node) int {
+	return limitGroups(factor(cfg), live)
+}
+
+// policy resolves the number of groups which should keep the chunk. The write
+// concern of the topics is cached for the pass.
+type policy struct {
+	ctx      context.Context
+	cfg      *config.Config
+	refsColl metadata.EtcdCollection
+	topics   map[string]int
+}
+
+func newPolicy(ctx context.Context, cfg *config.Config) (*policy, error) {
+	refsColl, err := metadata.NewRefsCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy{
+		ctx:      ctx,
+		cfg:      cfg,
+		refsColl: refsColl,
+		topics:   make(map[string]int),
+	}, nil
+}
+
+// factor returns the number of groups which should keep the chunk. If the
+// replication factor is not set, the highest write concern of the topics
+// which use the chunk is returned.
+func (p *policy) factor(dgst digest.Digest) (int, error) {
+	if p.cfg.Storage.ReplicationFactor > 0 {
+		return p.cfg.Storage.ReplicationFactor, nil
+	}
+
+	records, err := p.refsColl.List(&metadata.RefsEtcdKey{
+		Digest:    dgst,
+		Partition: metadata.NoPartition,
+		Order:     metadata.NoOrder,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+
+	for _, rec := range records {
+		key, err := metadata.ParseRefsEtcdKey(rec.RawKey)
+		if err != nil {
+			return 0, err
+		}
+
+		writeConcern, ok := p.topics[key.Topic]
+		if !ok {
+			topicCfg, err := metadata.GetTopicConfig(p.ctx, p.cfg, key.Topic)
+			if err != nil {
+				return 0, err
+			}
+
+			writeConcern = int(topicCfg.WriteConcern)
+			p.topics[key.Topic] = writeConcern
+		}
+
+		if writeConcern > n {
+			n = writeConcern
+		}
+	}
+
+	if n == 0 {
+		return factor(p.cfg), nil
+	}
+
+	return n, nil
+}
+
+// referenced checks whether the chunk is used by messages.
+func (p *policy) referenced(dgst digest.Digest) (bool, error) {
+	return isReferenced(p.refsColl, dgst)
+}
+
+// scan calls fn with the locations of every chunk. The locations are read by
+// pages. The live nodes include the draining ones, the eligible nodes do not.
+func scan(blobsColl metadata.EtcdCollection, live, eligible []placement.Node, fn func(*chunkState) error) error {
+	var last *chunkState
+
+	err := metadata.ListPages(blobsColl, &metadata.BlobEtcdKey{}, scanBatch, func(records []metadata.EtcdValue) error {
+		for _, rec := range records {
+			key, err := metadata.ParseBlobsEtcdKey(rec.RawKey)
+			if err != nil {
+				logrus.Errorf("Unable to parse key: %s", err)
+				continue
+			}
+
+			if key.Host == metadata.NoString {
+				continue
+			}
+
+			// The locations of the chunk can be split between pages.
+			if last == nil || last.Digest != key.Digest {
+				if last != nil {
+					if err := fn(last); err != nil {
+						return err
+					}
+				}
+				last = &chunkState{
+					Digest: key.Digest,
+					Groups: make(map[string]struct{}),
+				}
+			}
+
+			node := placement.Node{
+				Group: key.Group,
+				Node:  key.Host,
+			}
+
+			if !placement.Contains(live, key.Group, key.Host) {
+				last.Stale = append(last.Stale, node)
+				continue
+			}
+
+			if !placement.Contains(eligible, key.Group, key.Host) {
+				last.Draining = append(last.Draining, node)
+				continue
+			}
+
+			last.Groups[key.Group] = struct{}{}
+			last.Nodes = append(last.Nodes, node)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if last != nil {
+		return fn(last)
+	}
+
+	return nil
+}
+
+func isReferenced(refsColl metadata.EtcdCollection, dgst digest.Digest) (bool, error) {
+	value, err := refsColl.Get(
+		&metadata.RefsEtcdKey{
+			Digest:    dgst,
+			Partition: metadata.NoPartition,
+			Order:     metadata.NoOrder,
+		},
+		metadata.PrefixKey,
+		metadata.CountKey,
+	)
+	if err != nil {
+		return false, err
+	}
+	return value.Count > 0, nil
+}
+
+// RunRepair starts the processing of the repair requests addressed to this
+// node and takes part in the election of the node which scans the chunks. The
+// scan is periodic, it also starts when a node leaves the cluster or starts
+// draining.
+func RunRepair(ctx context.Context) (chan struct{}, error) {
+	stopChan := make(chan struct{})
+
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return stopChan, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	if cfg.Storage.RepairPeriod == 0 {
+		return stopChan, nil
+	}
+
+	c, err := etcd.NewEtcdClient(cfg)
+	if err != nil {
+		return stopChan, err
+	}
+
+	trigger := make(chan struct{}, 1)
+
+	requestRepair := func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	if obsrv, ok := ctx.Value(metadata.ClusterObserverContextVar).(*observer.EtcdObserver); ok {
+		cf, err := observer.NewEtcdFilter(obsrv, func(ev *observer.EtcdEvent) {
+			if ev.Type != v3.EventTypeDelete {
+				return
+			}
+
+			logrus.Infof("Node %s has left the cluster", string(ev.Kv.Key))
+
+			requestRepair()
+		})
+		if err != nil {
+			return stopChan, err
+		}
+		cf.OnResync(requestRepair).Start()
+	}
+
+	if obsrv, ok := ctx.Value(metadata.MembersObserverContextVar).(*observer.EtcdObserver); ok {
+		mf, err := observer.NewEtcdFilter(obsrv, func(ev *observer.EtcdEvent) {
+			if ev.Type != v3.EventTypePut {
+				return
+			}
+
+			requestRepair()
+		})
+		if err != nil {
+			return stopChan, err
+		}
+		mf.OnResync(requestRepair).Start()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		<-stopChan
+		cancel()
+	}()
+
+	go func() {
+		var limiter <-chan time.Time
+		if cfg.Storage.RepairRate > 0 {
+			ticker := time.NewTicker(time.Second / time.Duration(cfg.Storage.RepairRate))
+			defer ticker.Stop()
+			limiter = ticker.C
+		}
+
+		for {
+			if err := processRequests(ctx, limiter); err != nil {
+				logrus.Errorf("Unable to process repair requests: %s", err)
+			}
+
+			select {
+			case <-time.After(requestsPeriod):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	election := &metadata.ElectionEtcdKey{
+		Name: "repair",
+	}
+
+	go etcd.Lead(ctx, c, election.String(), cfg.Global.Hostname, func(ctx context.Context) {
+		logrus.Infof("Node is elected to repair chunks")
+
+		r := newRepairer()
+		period := cfg.Storage.RepairPeriod
+
+		for {
+			select {
+			case <-time.After(period):
+			case <-trigger:
+			case <-ctx.Done():
+				return
+			}
+
+			stats, err := r.Repair(ctx)
+			if err != nil {
+				logrus.Errorf("Repair fails: %s", err)
+			}
+
+			period = cfg.Storage.RepairPeriod
+			if stats.Draining > 0 && period > drainPeriod {
+				period = drainPeriod
+			}
+		}
+	})
+
+	return stopChan, nil
+}
+
+// repairer keeps the state of the elected node between the passes.
+type repairer struct {
+	// misplaced contains the requests to remove the copies of chunks
+	// outside of their placement found during the previous pass. The copy
+	// is removed only if the chunk is still misplaced on the next pass, so
+	// the chunks just synchronized to read a message are not removed before
+	// they are read.
+	misplaced map[string]struct{}
+
+	// issued contains the time of the requests which have not expired yet,
+	// so the requests are not written again on every pass.
+	issued map[string]time.Time
+}
+
+func newRepairer() *repairer {
+	return &repairer{
+		misplaced: make(map[string]struct{}),
+		issued:    make(map[string]time.Time),
+	}
+}
+
+// repairPass is the state of the single pass.
+type repairPass struct {
+	*repairer
+
+	coll       metadata.EtcdCollection
+	lease      v3.LeaseID
+	granted    time.Time
+	candidates map[string]struct{}
+	stats      *Stats
+}
+
+// Repair scans the locations of chunks and requests the nodes to synchronize
+// the under-replicated chunks or to remove the copies kept outside of the
+// placement.
+func (r *repairer) Repair(ctx context.Context) (*Stats, error) {
+	stats := &Stats{
+		Started: time.Now().UTC(),
+	}
+
+	err := r.repair(ctx, stats)
+	if err != nil {
+		stats.Error = err.Error()
+	}
+
+	stats.Finished = time.Now().UTC()
+
+	if err := saveStats(ctx, stats); err != nil {
+		logrus.Errorf("Unable to save repair statistics: %s", err)
+	}
+
+	return stats, err
+}
+
+func saveStats(ctx context.Context, stats *Stats) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	coll, err := metadata.NewRepairsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	return coll.Put(&metadata.RepairStatsEtcdKey{}, string(data))
+}
+
+func (r *repairer) repair(ctx context.Context, stats *Stats) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	stats.Node = cfg.Global.Hostname
+
+	blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	coll, err := metadata.NewRepairsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	p, err := newPolicy(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	live, err := placement.ListLiveNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	eligible, err := placement.ListNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	granted := time.Now()
+
+	for id, issued := range r.issued {
+		if granted.Sub(issued) >= requestTTL {
+			delete(r.issued, id)
+		}
+	}
+
+	lease, err := coll.Client().Grant(ctx, int64(requestTTL/time.Second))
+	if err != nil {
+		return err
+	}
+
+	pass := &repairPass{
+		repairer:   r,
+		coll:       coll,
+		lease:      lease.ID,
+		granted:    granted,
+		candidates: make(map[string]struct{}),
+		stats:      stats,
+	}
+
+	groups := countGroups(eligible)
+
+	err = scan(blobsColl, live, eligible, func(chunk *chunkState) error {
+		stats.Scanned++
+
+		// Without the replication factor the chunk kept by all groups is
+		// never under-replicated.
+		if cfg.Storage.ReplicationFactor == 0 && len(chunk.Groups) >= groups {
+			return nil
+		}
+
+		n, err := p.factor(chunk.Digest)
+		if err != nil {
+			logrus.Errorf("Unable to check references of %s: %s", chunk.Digest, err)
+			return nil
+		}
+
+		placed := placement.Select(chunk.Digest, eligible, n)
+
+		if len(chunk.Groups) >= limitGroups(n, eligible) {
+			if cfg.Storage.ReplicationFactor > 0 {
+				return pass.trim(chunk, placed)
+			}
+			return nil
+		}
+
+		referenced, err := p.referenced(chunk.Digest)
+		if err != nil {
+			logrus.Errorf("Unable to check references of %s: %s", chunk.Digest, err)
+			return nil
+		}
+
+		// The chunk is not used by messages and will be removed.
+		if !referenced {
+			return nil
+		}
+
+		if len(chunk.Groups) == 0 && len(chunk.Draining) == 0 {
+			logrus.Errorf("Chunk %s is lost: no live node keeps it", chunk.Digest)
+			stats.Lost++
+			return nil
+		}
+
+		stats.UnderReplicated++
+
+		if len(chunk.Draining) > 0 {
+			stats.Draining++
+		}
+
+		for _, n := range placed {
+			if placement.Contains(chunk.Nodes, n.Group, n.Node) {
+				continue
+			}
+			if err := pass.request(n, chunk.Digest, metadata.RepairSync); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	r.misplaced = pass.candidates
+
+	return err
+}
+
+// request asks the node to synchronize or to remove the chunk. The request is
+// not written again until it expires.
+func (p *repairPass) request(node placement.Node, dgst digest.Digest, action string) error {
+	key := &metadata.RepairEtcdKey{
+		Group:  node.Group,
+		Node:   node.Node,
+		Digest: dgst,
+	}
+
+	if _, ok := p.issued[key.String()]; ok {
+		return nil
+	}
+
+	if err := metadata.PutWithLease(p.coll, key, action, p.lease); err != nil {
+		return err
+	}
+
+	p.issued[key.String()] = p.granted
+	p.stats.Requested++
+
+	return nil
+}
+
+// trim requests the nodes outside of the placement of the chunk to remove
+// their copies if all the chosen nodes already have it.
+func (p *repairPass) trim(chunk *chunkState, placed []placement.Node) error {
+	for _, n := range placed {
+		if !placement.Contains(chunk.Nodes, n.Group, n.Node) {
+			return nil
+		}
+	}
+
+	for _, n := range chunk.Nodes {
+		if placement.Contains(placed, n.Group, n.Node) {
+			continue
+		}
+
+		id := (&metadata.RepairEtcdKey{
+			Group:  n.Group,
+			Node:   n.Node,
+			Digest: chunk.Digest,
+		}).String()
+
+		p.candidates[id] = struct{}{}
+
+		if _, ok := p.misplaced[id]; !ok {
+			continue
+		}
+
+		if err := p.request(n, chunk.Digest, metadata.RepairTrim); err != nil {
+			return err
+		}
+
+		p.stats.Trimmed++
+	}
+
+	return nil
+}
+
+// processRequests synchronizes or removes the chunks requested by the elected
+// node. The request is removed even if it fails: it is issued again while it
+// is needed.
+func processRequests(ctx context.Context, limiter <-chan time.Time) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	st, ok := ctx.Value(storage.AppStorageDriverContextVar).(storage.StorageDriver)
+	if !ok {
+		return fmt.Errorf("Unable to obtain storage driver from context")
+	}
+
+	coll, err := metadata.NewRepairsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	prefix := &metadata.RepairEtcdKey{
+		Group: cfg.Global.Group,
+		Node:  cfg.Global.Hostname,
+	}
+
+	return metadata.ListPages(coll, prefix, repairBatch, func(records []metadata.EtcdValue) error {
+		var batch []digest.Digest
+
+		txn := metadata.NewTransaction(ctx, cfg)
+
+		for _, rec := range records {
+			key, err := metadata.ParseRepairEtcdKey(rec.RawKey)
+			if err != nil {
+				logrus.Errorf("Unable to parse key: %s", err)
+				continue
+			}
+
+			txn.Delete(key)
+
+			switch rec.Value {
+			case metadata.RepairSync:
+				if has, err := st.Has(key.Digest); err != nil {
+					logrus.Errorf("Unable to check %s in storage: %s", key.Digest, err)
+					continue
+				} else if has {
+					continue
+				}
+
+				if limiter != nil {
+					<-limiter
+				}
+
+				batch = append(batch, key.Digest)
+
+			case metadata.RepairTrim:
+				if err := trim(ctx, cfg, st, blobsColl, key.Digest); err != nil {
+					logrus.Errorf("Unable to remove local copy of %s: %s", key.Digest, err)
+				}
+			}
+		}
+
+		if len(batch) > 0 {
+			failed, err := syncer.SyncBlobs(ctx, batch)
+			if err != nil {
+				logrus.Errorf("Unable to repair chunks: %s", err)
+				failed = batch
+			}
+
+			for _, dgst := range failed {
+				logrus.Errorf("Unable to repair %s", dgst)
+			}
+
+			logrus.Infof("Repaired %d of %d chunks", len(batch)-len(failed), len(batch))
+		}
+
+		return txn.Commit()
+	})
+}
+
+// trim removes the local copy of the chunk if the node is outside of the
+// placement of the chunk and all the chosen nodes keep it. The locations are
+// checked again, since they could change after the request.
+func trim(ctx context.Context, cfg *config.Config, st storage.StorageDriver, blobsColl metadata.EtcdCollection, dgst digest.Digest) error {
+	if cfg.Storage.ReplicationFactor == 0 {
+		return nil
+	}
+
+	eligible, err := placement.ListNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	placed := placement.Select(dgst, eligible, cfg.Storage.ReplicationFactor)
+
+	if placement.Contains(placed, cfg.Global.Group, cfg.Global.Hostname) {
+		return nil
+	}
+
+	records, err := blobsColl.List(&metadata.BlobEtcdKey{Digest: dgst})
+	if err != nil {
+		return err
+	}
+
+	var nodes []placement.Node
+
+	for _, rec := range records {
+		key, err := metadata.ParseBlobsEtcdKey(rec.RawKey)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, placement.Node{
+			Group: key.Group,
+			Node:  key.Host,
+		})
+	}
+
+	for _, n := range placed {
+		if !placement.Contains(nodes, n.Group, n.Node) {
+			return nil
+		}
+	}
+
+	logrus.Infof("Chunk %s is kept by %d chosen groups, removing local copy", dgst, len(placed))
+
+	blobKey := &metadata.BlobEtcdKey{
+		Digest: dgst,
+		Group:  cfg.Global.Group,
+		Host:   cfg.Global.Hostname,
+	}
+
+	if err := blobsColl.Delete(blobKey); err != nil {
+		return err
+	}
+
+	return st.Delete(dgst)
+}
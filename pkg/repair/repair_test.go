@@ -0,0 +1,129 @@
+package repair
+
+import (
+	"testing"
+
+	"github.com/legionus/kavka/pkg/cluster"
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/placement"
+)
+
+func TestRequired(t *testing.T) {
+	live := []placement.Node{
+		{Group: "a", Node: "a1"},
+		{Group: "a", Node: "a2"},
+		{Group: "b", Node: "b1"},
+		{Group: "c", Node: "c1"},
+	}
+
+	testCases := []struct {
+		factor       int
+		writeConcern int64
+		required     int
+	}{
+		{0, 1, 1},
+		{0, 2, 2},
+		{2, 1, 2},
+		{3, 1, 3},
+		// Not more than the number of live groups.
+		{5, 1, 3},
+		{0, 4, 3},
+	}
+
+	for _, tc := range testCases {
+		cfg := &config.Config{}
+		cfg.Storage.ReplicationFactor = tc.factor
+		cfg.Topic.WriteConcern = tc.writeConcern
+
+		if n := required(cfg, live); n != tc.required {
+			t.Errorf("factor=%d write-concern=%d: got %d, expected %d", tc.factor, tc.writeConcern, n, tc.required)
+		}
+	}
+}
+
+func TestRepairRequests(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	ctx := context.WithValue(context.Background(), config.AppConfigContextVar, cfg)
+
+	for _, n := range []placement.Node{{Group: "a", Node: "a1"}, {Group: "b", Node: "b1"}} {
+		nodeCfg := *cfg
+		nodeCfg.Global.Group = n.Group
+		nodeCfg.Global.Hostname = n.Node
+
+		if err := cluster.Register(context.WithValue(ctx, config.AppConfigContextVar, &nodeCfg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	topicsColl, err := metadata.NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeConcern := int64(2)
+
+	if err := metadata.PutTopicOverrides(topicsColl, "important", &config.TopicConfig{WriteConcern: &writeConcern}); err != nil {
+		t.Fatal(err)
+	}
+
+	blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refsColl, err := metadata.NewRefsCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both chunks are kept by the group "a" only.
+	chunks := map[string]digest.Digest{
+		"important": digest.FromBytes([]byte("important")),
+		"regular":   digest.FromBytes([]byte("regular")),
+	}
+
+	for topic, dgst := range chunks {
+		if err := blobsColl.Put(&metadata.BlobEtcdKey{Digest: dgst, Group: "a", Host: "a1"}, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := refsColl.Put(&metadata.RefsEtcdKey{Digest: dgst, Topic: topic, Partition: 0, ID: "id", Order: 0}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := newRepairer().Repair(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Scanned != 2 || stats.UnderReplicated != 1 || stats.Requested != 1 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+
+	repairsColl, err := metadata.NewRepairsCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := repairsColl.Get(&metadata.RepairEtcdKey{Group: "b", Node: "b1", Digest: chunks["important"]})
+	if err != nil {
+		t.Fatalf("request is not found: %s", err)
+	}
+	if rec.Value != metadata.RepairSync {
+		t.Fatalf("unexpected request: %s", rec.Value)
+	}
+
+	last, err := LastPass(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || last.Requested != 1 {
+		t.Fatalf("unexpected last pass: %#v", last)
+	}
+}
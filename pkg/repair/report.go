@@ -0,0 +1,167 @@
+package repair
+
+import (
+	"fmt"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/placement"
+)
+
+// MessageRef points to the message which contains the chunk.
+type MessageRef struct {
+	Topic     string `json:"topic"`
+	Partition int64  `json:"partition"`
+	ID        string `json:"id"`
+}
+
+// BlobReport describes the under-replicated chunk.
+type BlobReport struct {
+	Digest   digest.Digest    `json:"digest"`
+	Required int              `json:"required"`
+	Groups   []string         `json:"groups"`
+	Stale    []placement.Node `json:"stale,omitempty"`
+	Draining []placement.Node `json:"draining,omitempty"`
+	Placed   []placement.Node `json:"placed"`
+	Messages []*MessageRef    `json:"messages"`
+}
+
+// Report lists the under-replicated chunks. Required is the number of groups
+// for the topics without the write concern of their own.
+type Report struct {
+	Required  int           `json:"required"`
+	LiveNodes int           `json:"live-nodes"`
+	LastPass  *Stats        `json:"last-pass,omitempty"`
+	Blobs     []*BlobReport `json:"blobs"`
+}
+
+// messages returns the messages which contain the chunk.
+func messages(refsColl metadata.EtcdCollection, dgst digest.Digest) ([]*MessageRef, error) {
+	records, err := refsColl.List(&metadata.RefsEtcdKey{
+		Digest:    dgst,
+		Partition: metadata.NoPartition,
+		Order:     metadata.NoOrder,
+	}, metadata.SortAscend)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*MessageRef{}
+	seen := make(map[MessageRef]struct{})
+
+	for _, rec := range records {
+		key, err := metadata.ParseRefsEtcdKey(rec.RawKey)
+		if err != nil {
+			return nil, err
+		}
+
+		ref := MessageRef{
+			Topic:     key.Topic,
+			Partition: key.Partition,
+			ID:        key.ID,
+		}
+
+		// The chunk can occur several times in the message.
+		if _, ok := seen[ref]; ok {
+			continue
+		}
+		seen[ref] = struct{}{}
+
+		res = append(res, &ref)
+	}
+
+	return res, nil
+}
+
+// GetReport returns the chunks referenced by messages which are kept by fewer
+// live groups than required. The locations of chunks are read by pages.
+func GetReport(ctx context.Context) (*Report, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	refsColl, err := metadata.NewRefsCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := newPolicy(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	live, err := placement.ListLiveNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	eligible, err := placement.ListNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lastPass, err := LastPass(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Report{
+		Required:  required(cfg, eligible),
+		LiveNodes: len(live),
+		LastPass:  lastPass,
+		Blobs:     []*BlobReport{},
+	}
+
+	groups := countGroups(eligible)
+
+	err = scan(blobsColl, live, eligible, func(chunk *chunkState) error {
+		if len(chunk.Groups) >= groups {
+			return nil
+		}
+
+		n, err := p.factor(chunk.Digest)
+		if err != nil {
+			return err
+		}
+
+		need := limitGroups(n, eligible)
+
+		if len(chunk.Groups) >= need {
+			return nil
+		}
+
+		refs, err := messages(refsColl, chunk.Digest)
+		if err != nil {
+			return err
+		}
+
+		if len(refs) == 0 {
+			return nil
+		}
+
+		res.Blobs = append(res.Blobs, &BlobReport{
+			Digest:   chunk.Digest,
+			Required: need,
+			Groups:   chunk.GroupNames(),
+			Stale:    chunk.Stale,
+			Draining: chunk.Draining,
+			Placed:   placement.Select(chunk.Digest, eligible, n),
+			Messages: refs,
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+// RunScheduler starts the service which appends due scheduled messages to
+// their partitions.
+func RunScheduler(ctx context.Context) (chan struct{}, error) {
+	stopChan := make(chan struct{})
+
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return stopChan, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	go func() {
+		for {
+			select {
+			case <-time.After(cfg.Topic.SchedulerPeriod):
+			case <-stopChan:
+				return
+			}
+
+			if err := moveDueMessages(ctx); err != nil && err != metadata.ErrKeyNotFound {
+				logrus.Errorf("scheduler fails: %s", err)
+			}
+		}
+	}()
+
+	return stopChan, nil
+}
+
+func moveDueMessages(ctx context.Context) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	scheduledColl, err := metadata.NewScheduledCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	records, err := scheduledColl.ListRange(
+		&metadata.ScheduledEtcdKey{
+			Time:      0,
+			Partition: metadata.NoPartition,
+		},
+		&metadata.ScheduledEtcdKey{
+			Time:      time.Now().UnixNano(),
+			Partition: metadata.NoPartition,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		key, err := metadata.ParseScheduledEtcdKey(rec.RawKey)
+		if err != nil {
+			logrus.Errorf("Invalid key %s: %s", rec.RawKey, err)
+			continue
+		}
+
+		msg, err := message.ParseMessageInfo(rec.Value)
+		if err != nil {
+			logrus.Errorf("Bad message: %s", err)
+			continue
+		}
+
+		// Retention starts when the message becomes visible.
+		msg.CreationTime = time.Now().String()
+
+		// The record is removed in the same transaction, so the message
+		// is appended once even if other nodes process the same range.
+		txn := metadata.NewTransaction(ctx, cfg)
+		txn.Unmodified(key, rec.ModRevision)
+		txn.Delete(key)
+
+		res, err := queuesColl.(*metadata.QueuesCollection).CreateMessageTxn(
+			&metadata.QueueEtcdKey{
+				Topic:     key.Topic,
+				Partition: key.Partition,
+			},
+			msg.ID,
+			msg.String(),
+			txn,
+		)
+		if err != nil {
+			if err != metadata.ErrKeyModified {
+				logrus.Errorf("Unable to append scheduled message %s: %s", key.String(), err)
+			}
+			continue
+		}
+
+		logrus.Infof("Scheduled message delivered: %s", res.String())
+	}
+
+	return nil
+}
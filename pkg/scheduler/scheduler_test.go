@@ -0,0 +1,130 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/queue"
+)
+
+func TestMoveDueMessages(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	ctx := context.WithValue(context.Background(), config.AppConfigContextVar, cfg)
+
+	testCases := []struct {
+		id        string
+		partition int64
+		delay     time.Duration
+		delivered bool
+	}{
+		{"past", 0, -time.Hour, true},
+		{"now", 0, 0, true},
+		{"other-partition", 1, -time.Minute, true},
+		{"future", 0, time.Hour, false},
+		{"far-future", 1, 24 * time.Hour, false},
+	}
+
+	created := time.Now().Add(-48 * time.Hour).String()
+
+	for _, tc := range testCases {
+		msg := &message.MessageInfo{
+			ID:           tc.id,
+			CreationTime: created,
+		}
+		if _, err := queue.ScheduleQueue(ctx, "test", tc.partition, time.Now().Add(tc.delay), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := moveDueMessages(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second pass must not append the delivered messages again.
+	if err := moveDueMessages(ctx); err != nil && err != metadata.ErrKeyNotFound {
+		t.Fatal(err)
+	}
+
+	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := queuesColl.List(&metadata.QueueEtcdKey{
+		Topic:     "test",
+		Partition: metadata.NoPartition,
+		Offset:    metadata.NoOffset,
+	})
+	if err != nil && err != metadata.ErrKeyNotFound {
+		t.Fatal(err)
+	}
+
+	delivered := make(map[string]int64)
+
+	for _, rec := range records {
+		key, err := metadata.ParseQueueEtcdKey(rec.RawKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := message.ParseMessageInfo(rec.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := delivered[msg.ID]; ok {
+			t.Errorf("%s: delivered twice", msg.ID)
+		}
+		delivered[msg.ID] = key.Partition
+
+		// Retention starts when the message becomes visible.
+		if msg.CreationTime == created {
+			t.Errorf("%s: creation time is not updated", msg.ID)
+		}
+	}
+
+	scheduledColl, err := metadata.NewScheduledCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err = scheduledColl.List(&metadata.ScheduledEtcdKey{
+		Time:      metadata.NoTime,
+		Partition: metadata.NoPartition,
+	})
+	if err != nil && err != metadata.ErrKeyNotFound {
+		t.Fatal(err)
+	}
+
+	scheduled := make(map[string]struct{})
+
+	for _, rec := range records {
+		key, err := metadata.ParseScheduledEtcdKey(rec.RawKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scheduled[key.ID] = struct{}{}
+	}
+
+	for _, tc := range testCases {
+		partition, ok := delivered[tc.id]
+		if ok != tc.delivered {
+			t.Errorf("%s: delivered %v, expected %v", tc.id, ok, tc.delivered)
+			continue
+		}
+		if ok && partition != tc.partition {
+			t.Errorf("%s: delivered to partition %d, expected %d", tc.id, partition, tc.partition)
+		}
+
+		if _, ok := scheduled[tc.id]; ok == tc.delivered {
+			t.Errorf("%s: scheduled record is kept %v, expected %v", tc.id, ok, !tc.delivered)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// CompatibilityNone disables the compatibility checks.
+	CompatibilityNone = "none"
+	// CompatibilityBackward requires the new schema to accept all documents
+	// valid against the previous version, so consumers may upgrade first.
+	CompatibilityBackward = "backward"
+	// CompatibilityForward requires the previous version to accept all
+	// documents valid against the new schema, so producers may upgrade first.
+	CompatibilityForward = "forward"
+	// CompatibilityFull requires both backward and forward compatibility.
+	CompatibilityFull = "full"
+)
+
+// ValidateCompatibility checks the compatibility level name.
+func ValidateCompatibility(level string) error {
+	switch level {
+	case CompatibilityNone, CompatibilityBackward, CompatibilityForward, CompatibilityFull:
+		return nil
+	}
+	return fmt.Errorf("unknown compatibility: %s", level)
+}
+
+// IncompatibleError describes why the schema is not compatible with the
+// previous version.
+type IncompatibleError struct {
+	Level  string
+	Path   string
+	Reason string
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("schema is not %s compatible at %s: %s", e.Level, pointerOrRoot(e.Path), e.Reason)
+}
+
+// CheckCompatibility checks the new schema against the previous version. The
+// check is conservative: a schema may be reported as incompatible when the
+// sets of valid documents can't be compared by the supported keywords.
+func CheckCompatibility(level string, prev, next *Schema) error {
+	check := func(a, b *Schema) error {
+		path, reason := covers(a, b, "")
+		if reason == "" {
+			return nil
+		}
+		return &IncompatibleError{
+			Level:  level,
+			Path:   path,
+			Reason: reason,
+		}
+	}
+
+	switch level {
+	case CompatibilityNone:
+		return nil
+	case CompatibilityBackward:
+		return check(prev, next)
+	case CompatibilityForward:
+		return check(next, prev)
+	case CompatibilityFull:
+		if err := check(prev, next); err != nil {
+			return err
+		}
+		return check(next, prev)
+	}
+
+	return fmt.Errorf("unknown compatibility: %s", level)
+}
+
+var anySchema = &Schema{}
+
+// mayBe reports whether the schema allows values of the type.
+func (s *Schema) mayBe(t string) bool {
+	if len(s.Type) == 0 || hasType(s.Type, t) {
+		return true
+	}
+	return t == "number" && hasType(s.Type, "integer")
+}
+
+// covers checks that every document valid against a is valid against b. It
+// returns the path and the reason of the first difference found.
+func covers(a, b *Schema, path string) (string, string) {
+	if a == nil {
+		a = anySchema
+	}
+	if b == nil {
+		b = anySchema
+	}
+
+	if a.reject {
+		return "", ""
+	}
+	if b.reject {
+		return path, "value is no longer allowed"
+	}
+
+	// Only the listed values are valid against a.
+	if a.Enum != nil {
+		for _, v := range a.Enum {
+			if err := b.validate(v, path); err != nil {
+				return path, fmt.Sprintf("value %v is no longer allowed", v)
+			}
+		}
+		return "", ""
+	}
+
+	if b.Enum != nil {
+		return path, "allowed values are restricted"
+	}
+
+	if len(b.Type) > 0 {
+		if len(a.Type) == 0 {
+			return path, fmt.Sprintf("type is restricted to %v", b.Type)
+		}
+		for _, t := range a.Type {
+			if !hasType(b.Type, t) {
+				return path, fmt.Sprintf("type %s is no longer allowed", t)
+			}
+		}
+	}
+
+	if a.mayBe("number") {
+		if reason := coversBound(a.Minimum, a.ExclusiveMinimum, b.Minimum, b.ExclusiveMinimum, false); reason != "" {
+			return path, "minimum " + reason
+		}
+		if reason := coversBound(a.Maximum, a.ExclusiveMaximum, b.Maximum, b.ExclusiveMaximum, true); reason != "" {
+			return path, "maximum " + reason
+		}
+	}
+
+	if a.mayBe("string") {
+		if a.MinLength < b.MinLength {
+			return path, "minLength is increased"
+		}
+		if b.MaxLength != nil && (a.MaxLength == nil || *a.MaxLength > *b.MaxLength) {
+			return path, "maxLength is decreased"
+		}
+		if b.Pattern != "" && a.Pattern != b.Pattern {
+			return path, "pattern is changed"
+		}
+	}
+
+	if a.mayBe("array") {
+		if a.MinItems < b.MinItems {
+			return path, "minItems is increased"
+		}
+		if b.MaxItems != nil && (a.MaxItems == nil || *a.MaxItems > *b.MaxItems) {
+			return path, "maxItems is decreased"
+		}
+		if p, reason := covers(a.Items, b.Items, pointer(path, "items")); reason != "" {
+			return p, reason
+		}
+	}
+
+	if a.mayBe("object") {
+		required := make(map[string]struct{})
+		for _, name := range a.Required {
+			required[name] = struct{}{}
+		}
+		for _, name := range b.Required {
+			if _, ok := required[name]; !ok {
+				return pointer(path, name), "property became required"
+			}
+		}
+
+		for _, name := range sortedNames(b.Properties) {
+			if p, reason := covers(a.property(name), b.Properties[name], pointer(path, name)); reason != "" {
+				return p, reason
+			}
+		}
+
+		if b.Additional != nil {
+			for _, name := range sortedNames(a.Properties) {
+				if _, ok := b.Properties[name]; ok {
+					continue
+				}
+				if p, reason := covers(a.Properties[name], b.Additional, pointer(path, name)); reason != "" {
+					return p, reason
+				}
+			}
+			if p, reason := covers(a.Additional, b.Additional, pointer(path, "additionalProperties")); reason != "" {
+				return p, reason
+			}
+		}
+	}
+
+	return "", ""
+}
+
+func sortedNames(m map[string]*Schema) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// coversBound checks that the bound of a is not weaker than the bound of b.
+func coversBound(aIncl, aExcl, bIncl, bExcl *float64, upper bool) string {
+	av, aStrict, aok := bound(aIncl, aExcl, upper)
+	bv, bStrict, bok := bound(bIncl, bExcl, upper)
+
+	if !bok {
+		return ""
+	}
+	if !aok {
+		return "is added"
+	}
+
+	stricter := av > bv
+	if upper {
+		stricter = av < bv
+	}
+
+	if stricter || (av == bv && (aStrict || !bStrict)) {
+		return ""
+	}
+	return "is narrowed"
+}
+
+// bound returns the tightest of the inclusive and exclusive bounds.
+func bound(incl, excl *float64, upper bool) (float64, bool, bool) {
+	switch {
+	case incl == nil && excl == nil:
+		return 0, false, false
+	case incl == nil:
+		return *excl, true, true
+	case excl == nil:
+		return *incl, false, true
+	}
+
+	if (!upper && *excl >= *incl) || (upper && *excl <= *incl) {
+		return *excl, true, true
+	}
+	return *incl, false, true
+}
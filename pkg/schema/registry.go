@@ -0,0 +1,563 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+const (
+	DefaultCompatibility = CompatibilityBackward
+)
+
+var (
+	ErrSubjectNotFound = errors.New("subject not found")
+	ErrVersionNotFound = errors.New("schema version not found")
+	ErrVersionExists   = errors.New("schema version has been registered concurrently")
+	ErrSubjectModified = errors.New("subject has been modified concurrently")
+)
+
+// SubjectInUseError is returned when the subject bound to topics is removed.
+type SubjectInUseError struct {
+	Subject string
+	Topics  []string
+}
+
+func (e *SubjectInUseError) Error() string {
+	return fmt.Sprintf("subject %s is used by topics: %s", e.Subject, strings.Join(e.Topics, ", "))
+}
+
+// Subject is the named sequence of schema versions.
+type Subject struct {
+	Name          string    `json:"name"`
+	Compatibility string    `json:"compatibility"`
+	Created       time.Time `json:"created"`
+	Versions      []int64   `json:"versions,omitempty"`
+}
+
+// Version is the registered schema.
+type Version struct {
+	Subject string          `json:"subject"`
+	Version int64           `json:"version"`
+	Schema  json.RawMessage `json:"schema"`
+	Created time.Time       `json:"created"`
+}
+
+type cachedSchema struct {
+	modRevision int64
+	schema      *Schema
+}
+
+// compiled keeps the compiled versions to avoid parsing the schema for every
+// message.
+var compiled = struct {
+	sync.Mutex
+	m map[string]*cachedSchema
+}{
+	m: make(map[string]*cachedSchema),
+}
+
+func schemasCollection(ctx context.Context) (metadata.EtcdCollection, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+	return metadata.NewSchemasCollection(ctx, cfg)
+}
+
+func subjectKey(name string) *metadata.SchemaEtcdKey {
+	return &metadata.SchemaEtcdKey{
+		Subject: name,
+		Version: metadata.NoVersion,
+	}
+}
+
+// getSubject returns the subject and the revision of its record.
+func getSubject(coll metadata.EtcdCollection, name string) (*Subject, int64, error) {
+	rec, err := coll.Get(subjectKey(name))
+	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			return nil, 0, ErrSubjectNotFound
+		}
+		return nil, 0, err
+	}
+
+	s := &Subject{}
+	if err := json.Unmarshal([]byte(rec.Value), s); err != nil {
+		return nil, 0, fmt.Errorf("bad subject %s: %s", name, err)
+	}
+
+	return s, rec.ModRevision, nil
+}
+
+func listVersions(coll metadata.EtcdCollection, name string) ([]metadata.EtcdValue, error) {
+	return coll.List(subjectKey(name), metadata.SortAscend)
+}
+
+// GetSubject returns the subject with the list of its versions.
+func GetSubject(ctx context.Context, name string) (*Subject, error) {
+	coll, err := schemasCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s, _, err := getSubject(coll, name)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := listVersions(coll, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rec := range records {
+		key, err := metadata.ParseSchemaEtcdKey(rec.RawKey)
+		if err != nil {
+			return nil, err
+		}
+		s.Versions = append(s.Versions, key.Version)
+	}
+
+	return s, nil
+}
+
+// ListSubjects returns all subjects.
+func ListSubjects(ctx context.Context) ([]*Subject, error) {
+	coll, err := schemasCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := coll.List(subjectKey(metadata.NoString), metadata.SortAscend)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*Subject{}
+	subjects := make(map[string]*Subject)
+
+	for _, rec := range records {
+		key, err := metadata.ParseSchemaEtcdKey(rec.RawKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if key.Version == metadata.NoVersion {
+			s := &Subject{}
+			if err := json.Unmarshal([]byte(rec.Value), s); err != nil {
+				return nil, fmt.Errorf("bad subject %s: %s", key.Subject, err)
+			}
+			subjects[key.Subject] = s
+			res = append(res, s)
+			continue
+		}
+
+		// The subject record is sorted before its versions.
+		if s, ok := subjects[key.Subject]; ok {
+			s.Versions = append(s.Versions, key.Version)
+		}
+	}
+
+	return res, nil
+}
+
+// SetCompatibility changes the compatibility level of the subject. The subject
+// is created if it doesn't exist.
+func SetCompatibility(ctx context.Context, name string, level string) (*Subject, error) {
+	if err := ValidateCompatibility(level); err != nil {
+		return nil, err
+	}
+
+	coll, err := schemasCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s, _, err := getSubject(coll, name)
+	if err != nil {
+		if err != ErrSubjectNotFound {
+			return nil, err
+		}
+		s = &Subject{
+			Name:    name,
+			Created: time.Now().UTC(),
+		}
+	}
+
+	s.Compatibility = level
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := coll.Put(subjectKey(name), string(data)); err != nil {
+		return nil, err
+	}
+
+	return GetSubject(ctx, name)
+}
+
+func parseVersion(rec *metadata.EtcdValue) (*Version, error) {
+	v := &Version{}
+	if err := json.Unmarshal([]byte(rec.Value), v); err != nil {
+		return nil, fmt.Errorf("bad schema %s: %s", rec.RawKey, err)
+	}
+	return v, nil
+}
+
+func getVersion(coll metadata.EtcdCollection, name string, version int64) (*metadata.EtcdValue, error) {
+	if version != metadata.NoVersion {
+		rec, err := coll.Get(&metadata.SchemaEtcdKey{
+			Subject: name,
+			Version: version,
+		})
+		if err == metadata.ErrKeyNotFound {
+			return nil, ErrVersionNotFound
+		}
+		return rec, err
+	}
+
+	records, err := listVersions(coll, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, ErrVersionNotFound
+	}
+
+	return &records[len(records)-1], nil
+}
+
+// GetVersion returns the version of the schema. NoVersion means the latest
+// version.
+func GetVersion(ctx context.Context, name string, version int64) (*Version, error) {
+	coll, err := schemasCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, err := getSubject(coll, name); err != nil {
+		return nil, err
+	}
+
+	rec, err := getVersion(coll, name, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseVersion(rec)
+}
+
+// Register adds the new version of the schema after checking its
+// compatibility with the latest version. If the schema is the same as the
+// latest version, the latest version is returned.
+func Register(ctx context.Context, name string, data []byte) (*Version, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	next, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	coll, err := schemasCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s, revision, err := getSubject(coll, name)
+	if err != nil {
+		if err != ErrSubjectNotFound {
+			return nil, err
+		}
+		s = &Subject{
+			Name:          name,
+			Compatibility: DefaultCompatibility,
+			Created:       time.Now().UTC(),
+		}
+	}
+
+	subjectData, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Version{
+		Subject: name,
+		Version: 1,
+		Schema:  json.RawMessage(data),
+		Created: time.Now().UTC(),
+	}
+
+	latest, err := getVersion(coll, name, metadata.NoVersion)
+	switch err {
+	case nil:
+		prev, err := parseVersion(latest)
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.Equal(bytes.TrimSpace(prev.Schema), bytes.TrimSpace(data)) {
+			return prev, nil
+		}
+
+		prevSchema, err := Parse(prev.Schema)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := CheckCompatibility(s.Compatibility, prevSchema, next); err != nil {
+			return nil, err
+		}
+
+		res.Version = prev.Version + 1
+
+	case ErrVersionNotFound:
+	default:
+		return nil, err
+	}
+
+	value, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &metadata.SchemaEtcdKey{
+		Subject: name,
+		Version: res.Version,
+	}
+
+	// The subject record is rewritten with every version, so the version is
+	// registered only if neither the subject (e.g. its compatibility level)
+	// nor its versions have been changed since the check.
+	txn := metadata.NewTransaction(ctx, cfg)
+	txn.Unmodified(subjectKey(name), revision)
+	txn.Unmodified(key, 0)
+	txn.Put(subjectKey(name), string(subjectData))
+	txn.Put(key, string(value))
+
+	if err := txn.Commit(); err != nil {
+		if err == metadata.ErrKeyModified {
+			return nil, ErrVersionExists
+		}
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// boundTopics returns the topics which use the subject.
+func boundTopics(ctx context.Context, cfg *config.Config, name string) ([]string, error) {
+	coll, err := metadata.NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := coll.List(&metadata.TopicEtcdKey{
+		Topic:     metadata.NoString,
+		Partition: metadata.NoPartition,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := make(map[string]string)
+
+	for topic, subject := range cfg.Topic.Schema {
+		bindings[topic] = subject
+	}
+
+	for _, rec := range records {
+		key, err := metadata.ParseTopicEtcdKey(rec.RawKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if key.Partition != metadata.NoPartition {
+			continue
+		}
+
+		overrides := &config.TopicConfig{}
+
+		// Records created by older versions have no overrides.
+		if err := json.Unmarshal([]byte(rec.Value), overrides); err != nil {
+			continue
+		}
+
+		if overrides.Schema != nil {
+			bindings[key.Topic] = *overrides.Schema
+		}
+	}
+
+	var topics []string
+
+	for topic, subject := range bindings {
+		if subject == name {
+			topics = append(topics, topic)
+		}
+	}
+
+	sort.Strings(topics)
+
+	return topics, nil
+}
+
+// DeleteSubject removes the subject and all its versions. The subject used by
+// topics can not be removed.
+func DeleteSubject(ctx context.Context, name string) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	coll, err := schemasCollection(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, revision, err := getSubject(coll, name)
+	if err != nil {
+		return err
+	}
+
+	topics, err := boundTopics(ctx, cfg, name)
+	if err != nil {
+		return err
+	}
+
+	if len(topics) > 0 {
+		return &SubjectInUseError{
+			Subject: name,
+			Topics:  topics,
+		}
+	}
+
+	records, err := listVersions(coll, name)
+	if err != nil {
+		return err
+	}
+
+	// The binding of topics and the registration of versions rewrite the
+	// subject record, so they can not happen concurrently with the removal.
+	txn := metadata.NewTransaction(ctx, cfg)
+	txn.Unmodified(subjectKey(name), revision)
+
+	for _, rec := range records {
+		key, err := metadata.ParseSchemaEtcdKey(rec.RawKey)
+		if err != nil {
+			return err
+		}
+		txn.Unmodified(key, rec.ModRevision)
+		txn.Delete(key)
+	}
+
+	txn.Delete(subjectKey(name))
+
+	if err := txn.Commit(); err != nil {
+		if err == metadata.ErrKeyModified {
+			return ErrSubjectModified
+		}
+		return err
+	}
+
+	return nil
+}
+
+// PutTopicConfig stores per-topic settings. If the topic is bound to the
+// subject, the subject must exist and it can not be removed concurrently.
+func PutTopicConfig(ctx context.Context, topic string, overrides *config.TopicConfig) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	txn := metadata.NewTransaction(ctx, cfg)
+
+	if overrides.Schema != nil && *overrides.Schema != "" {
+		coll, err := schemasCollection(ctx)
+		if err != nil {
+			return err
+		}
+
+		s, revision, err := getSubject(coll, *overrides.Schema)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+
+		txn.Unmodified(subjectKey(s.Name), revision)
+		txn.Put(subjectKey(s.Name), string(data))
+	}
+
+	if err := metadata.PutTopicOverridesTxn(txn, topic, overrides); err != nil {
+		return err
+	}
+
+	if err := txn.Commit(); err != nil {
+		if err == metadata.ErrKeyModified {
+			return ErrSubjectModified
+		}
+		return err
+	}
+
+	return nil
+}
+
+// ValidateDocument checks the JSON document against the latest version of
+// the subject.
+func ValidateDocument(ctx context.Context, name string, data []byte) error {
+	coll, err := schemasCollection(ctx)
+	if err != nil {
+		return err
+	}
+
+	rec, err := getVersion(coll, name, metadata.NoVersion)
+	if err != nil {
+		return err
+	}
+
+	compiled.Lock()
+	cached, ok := compiled.m[rec.RawKey]
+	compiled.Unlock()
+
+	if !ok || cached.modRevision != rec.ModRevision {
+		v, err := parseVersion(rec)
+		if err != nil {
+			return err
+		}
+
+		s, err := Parse(v.Schema)
+		if err != nil {
+			return err
+		}
+
+		cached = &cachedSchema{
+			modRevision: rec.ModRevision,
+			schema:      s,
+		}
+
+		compiled.Lock()
+		compiled.m[rec.RawKey] = cached
+		compiled.Unlock()
+	}
+
+	return cached.schema.Validate(data)
+}
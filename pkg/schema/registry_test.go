@@ -0,0 +1,55 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+)
+
+func TestDeleteBoundSubject(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	cfg.Topic.Schema = map[string]string{"static": "orders"}
+
+	ctx := context.WithValue(context.Background(), config.AppConfigContextVar, cfg)
+
+	if _, err := Register(ctx, "orders", []byte(orderSchema)); err != nil {
+		t.Fatal(err)
+	}
+
+	subject := "orders"
+
+	if err := PutTopicConfig(ctx, "foo", &config.TopicConfig{Schema: &subject}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := DeleteSubject(ctx, "orders")
+	inUse, ok := err.(*SubjectInUseError)
+	if !ok {
+		t.Fatalf("expected SubjectInUseError, got %v", err)
+	}
+	if len(inUse.Topics) != 2 || inUse.Topics[0] != "foo" || inUse.Topics[1] != "static" {
+		t.Fatalf("unexpected topics: %v", inUse.Topics)
+	}
+
+	// The topic setting overrides the configuration.
+	unbound := ""
+
+	if err := PutTopicConfig(ctx, "foo", &config.TopicConfig{Schema: &unbound}); err != nil {
+		t.Fatal(err)
+	}
+	if err := PutTopicConfig(ctx, "static", &config.TopicConfig{Schema: &unbound}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteSubject(ctx, "orders"); err != nil {
+		t.Fatalf("unable to remove subject: %s", err)
+	}
+
+	if err := PutTopicConfig(ctx, "foo", &config.TopicConfig{Schema: &subject}); err != ErrSubjectNotFound {
+		t.Fatalf("expected ErrSubjectNotFound, got %v", err)
+	}
+}
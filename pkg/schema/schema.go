@@ -0,0 +1,483 @@
+// Package schema implements the registry of JSON schemas and validation of
+// messages written to JSON topics.
+//
+// The following subset of JSON Schema is supported:
+//
+//	type, enum, const,
+//	minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+//	minLength, maxLength, pattern,
+//	items, minItems, maxItems,
+//	properties, required, additionalProperties
+//
+// The annotations ($schema, $id, title, description, default, examples,
+// format) are ignored. Schemas with other keywords are rejected, so that a
+// constraint is never silently ignored.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var annotations = map[string]struct{}{
+	"$schema":     {},
+	"$id":         {},
+	"title":       {},
+	"description": {},
+	"default":     {},
+	"examples":    {},
+	"format":      {},
+}
+
+var keywords = map[string]struct{}{
+	"type":                 {},
+	"enum":                 {},
+	"const":                {},
+	"minimum":              {},
+	"maximum":              {},
+	"exclusiveMinimum":     {},
+	"exclusiveMaximum":     {},
+	"minLength":            {},
+	"maxLength":            {},
+	"pattern":              {},
+	"items":                {},
+	"minItems":             {},
+	"maxItems":             {},
+	"properties":           {},
+	"required":             {},
+	"additionalProperties": {},
+}
+
+var types = map[string]struct{}{
+	"null":    {},
+	"boolean": {},
+	"object":  {},
+	"array":   {},
+	"number":  {},
+	"integer": {},
+	"string":  {},
+}
+
+// Schema is a compiled JSON schema.
+type Schema struct {
+	// reject is set for the false schema which matches nothing.
+	reject bool
+
+	Type             []string
+	Enum             []interface{}
+	Minimum          *float64
+	Maximum          *float64
+	ExclusiveMinimum *float64
+	ExclusiveMaximum *float64
+	MinLength        int64
+	MaxLength        *int64
+	Pattern          string
+	Items            *Schema
+	MinItems         int64
+	MaxItems         *int64
+	Properties       map[string]*Schema
+	Required         []string
+	Additional       *Schema
+
+	pattern *regexp.Regexp
+}
+
+// ValidationError describes the first violation found in the document. The
+// Path is a JSON pointer to the invalid value.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", pointerOrRoot(e.Path), e.Message)
+}
+
+func decode(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected data after JSON value")
+	}
+	return nil
+}
+
+func pointer(path, name string) string {
+	name = strings.Replace(name, "~", "~0", -1)
+	name = strings.Replace(name, "/", "~1", -1)
+	return path + "/" + name
+}
+
+// Parse compiles the JSON schema.
+func Parse(data []byte) (*Schema, error) {
+	var v interface{}
+
+	if err := decode(data, &v); err != nil {
+		return nil, fmt.Errorf("bad schema: %s", err)
+	}
+
+	return compile(v, "")
+}
+
+func compile(v interface{}, path string) (*Schema, error) {
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("bad schema at %s: %s", pointerOrRoot(path), fmt.Sprintf(format, args...))
+	}
+
+	if b, ok := v.(bool); ok {
+		return &Schema{reject: !b}, nil
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errorf("schema must be an object or boolean")
+	}
+
+	s := &Schema{}
+
+	for name, value := range obj {
+		if _, ok := annotations[name]; ok {
+			continue
+		}
+		if _, ok := keywords[name]; !ok {
+			return nil, errorf("unsupported keyword %q", name)
+		}
+
+		var err error
+
+		switch name {
+		case "type":
+			switch t := value.(type) {
+			case string:
+				s.Type = []string{t}
+			case []interface{}:
+				for _, item := range t {
+					str, ok := item.(string)
+					if !ok {
+						return nil, errorf("type must be a string or an array of strings")
+					}
+					s.Type = append(s.Type, str)
+				}
+			default:
+				return nil, errorf("type must be a string or an array of strings")
+			}
+			for _, t := range s.Type {
+				if _, ok := types[t]; !ok {
+					return nil, errorf("unknown type %q", t)
+				}
+			}
+		case "enum":
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, errorf("enum must be a non-empty array")
+			}
+			s.Enum = list
+		case "const":
+			s.Enum = []interface{}{value}
+		case "minimum":
+			s.Minimum, err = toNumber(value)
+		case "maximum":
+			s.Maximum, err = toNumber(value)
+		case "exclusiveMinimum":
+			s.ExclusiveMinimum, err = toNumber(value)
+		case "exclusiveMaximum":
+			s.ExclusiveMaximum, err = toNumber(value)
+		case "minLength":
+			s.MinLength, err = toCount(value)
+		case "maxLength":
+			var n int64
+			if n, err = toCount(value); err == nil {
+				s.MaxLength = &n
+			}
+		case "minItems":
+			s.MinItems, err = toCount(value)
+		case "maxItems":
+			var n int64
+			if n, err = toCount(value); err == nil {
+				s.MaxItems = &n
+			}
+		case "pattern":
+			str, ok := value.(string)
+			if !ok {
+				return nil, errorf("pattern must be a string")
+			}
+			if s.pattern, err = regexp.Compile(str); err == nil {
+				s.Pattern = str
+			}
+		case "items":
+			if s.Items, err = compile(value, pointer(path, "items")); err != nil {
+				return nil, err
+			}
+		case "required":
+			list, ok := value.([]interface{})
+			if !ok {
+				return nil, errorf("required must be an array of strings")
+			}
+			for _, item := range list {
+				str, ok := item.(string)
+				if !ok {
+					return nil, errorf("required must be an array of strings")
+				}
+				s.Required = append(s.Required, str)
+			}
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, errorf("properties must be an object")
+			}
+			s.Properties = make(map[string]*Schema)
+			for prop, sub := range props {
+				if s.Properties[prop], err = compile(sub, pointer(pointer(path, "properties"), prop)); err != nil {
+					return nil, err
+				}
+			}
+		case "additionalProperties":
+			if s.Additional, err = compile(value, pointer(path, "additionalProperties")); err != nil {
+				return nil, err
+			}
+		}
+
+		if err != nil {
+			return nil, errorf("%s: %s", name, err)
+		}
+	}
+
+	return s, nil
+}
+
+func pointerOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func toNumber(v interface{}) (*float64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("must be a number")
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func toCount(v interface{}) (int64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("must be a non-negative integer")
+	}
+	i, err := n.Int64()
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("must be a non-negative integer")
+	}
+	return i, nil
+}
+
+// Validate checks the JSON document against the schema.
+func (s *Schema) Validate(data []byte) error {
+	var v interface{}
+
+	if err := decode(data, &v); err != nil {
+		return &ValidationError{Message: fmt.Sprintf("bad JSON: %s", err)}
+	}
+
+	return s.validate(v, "")
+}
+
+func typeOf(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case json.Number:
+		if f, err := t.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	}
+	return "unknown"
+}
+
+func hasType(list []string, t string) bool {
+	for _, v := range list {
+		if v == t || (v == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, errx := x.Float64()
+		fy, erry := y.Float64()
+		return errx == nil && erry == nil && fx == fy
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			if w, ok := y[k]; !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func (s *Schema) validate(v interface{}, path string) error {
+	fail := func(format string, args ...interface{}) error {
+		return &ValidationError{
+			Path:    path,
+			Message: fmt.Sprintf(format, args...),
+		}
+	}
+
+	if s.reject {
+		return fail("value is not allowed")
+	}
+
+	t := typeOf(v)
+
+	if len(s.Type) > 0 && !hasType(s.Type, t) {
+		return fail("expected %s, got %s", strings.Join(s.Type, " or "), t)
+	}
+
+	if s.Enum != nil {
+		found := false
+		for _, item := range s.Enum {
+			if equal(v, item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("value is not one of the allowed values")
+		}
+	}
+
+	switch x := v.(type) {
+	case json.Number:
+		f, err := x.Float64()
+		if err != nil {
+			return fail("bad number: %s", err)
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return fail("must be >= %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fail("must be <= %v", *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+			return fail("must be > %v", *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && f >= *s.ExclusiveMaximum {
+			return fail("must be < %v", *s.ExclusiveMaximum)
+		}
+
+	case string:
+		n := int64(utf8.RuneCountInString(x))
+		if n < s.MinLength {
+			return fail("must be at least %d characters long", s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fail("must be at most %d characters long", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(x) {
+			return fail("does not match pattern %q", s.Pattern)
+		}
+
+	case []interface{}:
+		n := int64(len(x))
+		if n < s.MinItems {
+			return fail("must have at least %d items", s.MinItems)
+		}
+		if s.MaxItems != nil && n > *s.MaxItems {
+			return fail("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range x {
+				if err := s.Items.validate(item, pointer(path, fmt.Sprintf("%d", i))); err != nil {
+					return err
+				}
+			}
+		}
+
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := x[name]; !ok {
+				return &ValidationError{
+					Path:    pointer(path, name),
+					Message: "required property is missing",
+				}
+			}
+		}
+
+		// Check properties in a stable order to report the same error
+		// for the same document.
+		var names []string
+		for name := range x {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			sub := s.property(name)
+			if sub == nil {
+				continue
+			}
+			if err := sub.validate(x[name], pointer(path, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// property returns the schema of the object property or nil if any value is
+// allowed.
+func (s *Schema) property(name string) *Schema {
+	if sub, ok := s.Properties[name]; ok {
+		return sub
+	}
+	return s.Additional
+}
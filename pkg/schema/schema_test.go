@@ -0,0 +1,138 @@
+package schema
+
+import (
+	"testing"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"status": {"enum": ["new", "paid"]},
+		"items": {
+			"type": "array",
+			"minItems": 1,
+			"items": {
+				"type": "object",
+				"properties": {"sku": {"type": "string", "pattern": "^[A-Z]+$"}},
+				"required": ["sku"]
+			}
+		}
+	},
+	"required": ["id", "items"],
+	"additionalProperties": false
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(orderSchema))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		doc  string
+		path string
+	}{
+		{`{"id":1,"items":[{"sku":"A"}]}`, ""},
+		{`{"id":1,"status":"paid","items":[{"sku":"A"},{"sku":"B"}]}`, ""},
+		{`[]`, "/"},
+		{`{"items":[{"sku":"A"}]}`, "/id"},
+		{`{"id":0,"items":[{"sku":"A"}]}`, "/id"},
+		{`{"id":1.5,"items":[{"sku":"A"}]}`, "/id"},
+		{`{"id":1,"status":"lost","items":[{"sku":"A"}]}`, "/status"},
+		{`{"id":1,"items":[]}`, "/items"},
+		{`{"id":1,"items":[{"sku":"A"},{"sku":"b"}]}`, "/items/1/sku"},
+		{`{"id":1,"items":[{"sku":"A"},{}]}`, "/items/1/sku"},
+		{`{"id":1,"items":[{"sku":"A"}],"x/y":1}`, "/x~1y"},
+	}
+
+	for _, tc := range testCases {
+		err := s.Validate([]byte(tc.doc))
+
+		if tc.path == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", tc.doc, err)
+			}
+			continue
+		}
+
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("%s: expected validation error, got %v", tc.doc, err)
+			continue
+		}
+
+		if pointerOrRoot(verr.Path) != tc.path {
+			t.Errorf("%s: wrong path = %q, expected %q (%s)", tc.doc, verr.Path, tc.path, verr)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []string{
+		`[]`,
+		`{"type":"text"}`,
+		`{"oneOf":[{"type":"string"}]}`,
+		`{"minLength":-1}`,
+		`{"pattern":"("}`,
+		`{"properties":{"a":{"$ref":"#/b"}}}`,
+	}
+
+	for _, tc := range testCases {
+		if _, err := Parse([]byte(tc)); err == nil {
+			t.Errorf("%s: expected error", tc)
+		}
+	}
+}
+
+func TestCompatibility(t *testing.T) {
+	prev := `{
+		"type": "object",
+		"properties": {
+			"id": {"type": "integer"},
+			"name": {"type": "string", "maxLength": 10}
+		},
+		"required": ["id"]
+	}`
+
+	testCases := []struct {
+		next     string
+		backward bool
+		forward  bool
+	}{
+		// Optional property is added.
+		{`{"type":"object","properties":{"id":{"type":"integer"},"name":{"type":"string","maxLength":10},"tag":{"type":"string"}},"required":["id"]}`, false, true},
+		// Required property is added.
+		{`{"type":"object","properties":{"id":{"type":"integer"},"name":{"type":"string","maxLength":10}},"required":["id","name"]}`, false, true},
+		// Required property is removed.
+		{`{"type":"object","properties":{"id":{"type":"integer"},"name":{"type":"string","maxLength":10}}}`, true, false},
+		// Type is widened.
+		{`{"type":"object","properties":{"id":{"type":"number"},"name":{"type":"string","maxLength":10}},"required":["id"]}`, true, false},
+		// Limit is relaxed.
+		{`{"type":"object","properties":{"id":{"type":"integer"},"name":{"type":"string","maxLength":20}},"required":["id"]}`, true, false},
+		// Same schema.
+		{prev, true, true},
+	}
+
+	prevSchema, err := Parse([]byte(prev))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for i, tc := range testCases {
+		nextSchema, err := Parse([]byte(tc.next))
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+
+		err = CheckCompatibility(CompatibilityBackward, prevSchema, nextSchema)
+		if (err == nil) != tc.backward {
+			t.Errorf("%d: wrong backward compatibility: %v", i, err)
+		}
+
+		err = CheckCompatibility(CompatibilityForward, prevSchema, nextSchema)
+		if (err == nil) != tc.forward {
+			t.Errorf("%d: wrong forward compatibility: %v", i, err)
+		}
+	}
+}
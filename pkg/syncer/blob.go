@@ -12,121 +12,277 @@ import (
 	"github.com/legionus/kavka/pkg/context"
 	"github.com/legionus/kavka/pkg/digest"
 	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/placement"
 	"github.com/legionus/kavka/pkg/storage"
+	"github.com/legionus/kavka/pkg/transfer"
 )
 
+// SyncBlob synchronizes the chunk from the live nodes which keep it.
 func SyncBlob(ctx context.Context, dgst digest.Digest) error {
-	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
-	if !ok {
-		return fmt.Errorf("Unable to obtain config from context")
+	failed, err := SyncBlobs(ctx, []digest.Digest{dgst})
+	if err != nil {
+		return err
 	}
 
-	st, ok := ctx.Value(storage.AppStorageDriverContextVar).(storage.StorageDriver)
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to sync %s", dgst.String())
+	}
+
+	return nil
+}
+
+// SyncBlobs synchronizes the chunks from the live nodes which keep them. The
+// chunks are requested in batches, one stream per node, and the nodes are
+// queried in parallel. The chunks which a node does not return are requested
+// from the next node which keeps them. SyncBlobs returns the digests which
+// were not synchronized.
+func SyncBlobs(ctx context.Context, dgsts []digest.Digest) ([]digest.Digest, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
 	if !ok {
-		return fmt.Errorf("unable to obtain storage driver from context")
+		return nil, fmt.Errorf("Unable to obtain config from context")
 	}
 
 	blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	prefixKey := &metadata.BlobEtcdKey{
-		Digest: dgst,
-	}
-
-	nodes, err := blobsColl.List(prefixKey)
+	// Locations on the nodes which are not live are stale.
+	live, err := placement.ListLiveNodes(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if len(nodes) == 0 {
-		return fmt.Errorf("digest not found")
-	}
+	var order []digest.Digest
+	sources := make(map[digest.Digest][]placement.Node)
 
-	// FIXME use goroutines
-	for _, node := range nodes {
-		key, err := metadata.ParseBlobsEtcdKey(node.RawKey)
-		if err != nil {
-			logrus.Errorf("unable to parse key %s: %v", node.RawKey, err)
+	for _, dgst := range dgsts {
+		if _, ok := sources[dgst]; ok {
 			continue
 		}
 
-		c, err := client.New(fmt.Sprintf("%s:%d", key.Host, cfg.Global.Port), 3*time.Second)
+		records, err := blobsColl.List(&metadata.BlobEtcdKey{
+			Digest: dgst,
+		})
 		if err != nil {
-			logrus.Errorf("unable to make client for remote server %s: %v", key.Host, err)
-			continue
+			return nil, err
 		}
 
-		data, err := c.GetBlob(dgst.String())
-		if err != nil {
-			logrus.Errorf("unable to get blob %s from remote server %s: %v", dgst.String(), key.Host, err)
-			continue
+		order = append(order, dgst)
+		sources[dgst] = []placement.Node{}
+
+		for _, rec := range records {
+			key, err := metadata.ParseBlobsEtcdKey(rec.RawKey)
+			if err != nil {
+				logrus.Errorf("unable to parse key %s: %v", rec.RawKey, err)
+				continue
+			}
+
+			if key.Group == cfg.Global.Group && key.Host == cfg.Global.Hostname {
+				continue
+			}
+
+			node, ok := placement.Find(live, key.Group, key.Host)
+			if !ok {
+				logrus.Debugf("skip stale location of blob %s on %s", dgst.String(), key.Host)
+				continue
+			}
+
+			sources[dgst] = append(sources[dgst], node)
 		}
+	}
 
-		res, err := st.Write(data)
+	return syncBlobs(ctx, order, sources), nil
+}
 
-		if res != dgst {
-			logrus.Errorf("produce different digests when sync blob %s from remote server %s: got %s", dgst.String(), key.Host, res.String())
-			continue
+// syncBlobs synchronizes the chunks from the given sources. It returns the
+// digests which were not synchronized.
+func syncBlobs(ctx context.Context, order []digest.Digest, sources map[digest.Digest][]placement.Node) []digest.Digest {
+	var mu sync.Mutex
+	synced := make(map[digest.Digest]struct{})
+
+	for {
+		// Every chunk is requested from the next node which keeps it.
+		batches := make(map[placement.Node][]digest.Digest)
+
+		for _, dgst := range order {
+			if _, ok := synced[dgst]; ok || len(sources[dgst]) == 0 {
+				continue
+			}
+
+			node := sources[dgst][0]
+			sources[dgst] = sources[dgst][1:]
+
+			batches[node] = append(batches[node], dgst)
 		}
 
-		if err != nil && err != storage.ErrBlobExists {
-			logrus.Errorf("unable to write blob %s: %v", dgst.String(), err)
-			return err
+		if len(batches) == 0 {
+			break
 		}
 
-		_, err = blobsColl.Create(
-			&metadata.BlobEtcdKey{
-				Digest: dgst,
-				Group:  cfg.Global.Group,
-				Host:   cfg.Global.Hostname,
-			},
-			time.Now().String(),
-		)
-		if err != nil {
-			logrus.Errorf("unable to blob metadata %s: %v", dgst.String(), err)
-			return err
+		var wg sync.WaitGroup
+
+		for node, list := range batches {
+			wg.Add(1)
+
+			go func(node placement.Node, list []digest.Digest) {
+				defer wg.Done()
+
+				received, err := fetchBlobs(ctx, node, list)
+				if err != nil {
+					logrus.Errorf("unable to get blobs from remote server %s: %v", node.Node, err)
+				}
+
+				mu.Lock()
+				for _, dgst := range received {
+					synced[dgst] = struct{}{}
+				}
+				mu.Unlock()
+			}(node, list)
 		}
 
-		logrus.Infof("sync %s from remote server %s", dgst.String(), key.Host)
-		return nil
+		wg.Wait()
 	}
 
-	return fmt.Errorf("unable to sync %s", dgst.String())
+	var failed []digest.Digest
+
+	for _, dgst := range order {
+		if _, ok := synced[dgst]; !ok {
+			failed = append(failed, dgst)
+		}
+	}
+
+	return failed
 }
 
-func SyncBlobSeries(ctx context.Context, blobs []storage.Descriptor) error {
+// fetchBlobs requests the chunks from the node, stores them and registers the
+// new locations. It returns the digests of the stored chunks.
+func fetchBlobs(ctx context.Context, node placement.Node, list []digest.Digest) ([]digest.Digest, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+
 	st, ok := ctx.Value(storage.AppStorageDriverContextVar).(storage.StorageDriver)
 	if !ok {
-		return fmt.Errorf("unable to obtain storage driver from context")
+		return nil, fmt.Errorf("unable to obtain storage driver from context")
 	}
 
-	errors := make([]error, len(blobs))
+	blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	var wg sync.WaitGroup
+	// The nodes of the previous versions do not advertise the address.
+	address := node.Address
+	if address == "" {
+		address = fmt.Sprintf("%s:%d", node.Node, cfg.Global.Port)
+	}
+
+	c, err := client.New(address, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c.SetMaxChunkSize(cfg.Topic.MaxChunkSize)
 
-	for i, chunk := range blobs {
-		wg.Add(1)
+	requested := make(map[digest.Digest]struct{})
+	for _, dgst := range list {
+		requested[dgst] = struct{}{}
+	}
 
-		go func(i int, dgst digest.Digest) {
-			defer wg.Done()
+	var received []digest.Digest
 
-			var has bool
-			if has, errors[i] = st.Has(dgst); errors[i] != nil {
-				return
-			} else if has {
-				return
+	for len(list) > 0 {
+		n := len(list)
+		if n > transfer.MaxBatch {
+			n = transfer.MaxBatch
+		}
+
+		batch := make([]string, n)
+		for i, dgst := range list[:n] {
+			batch[i] = dgst.String()
+		}
+		list = list[n:]
+
+		err := c.GetBlobs(batch, func(blob *transfer.Blob, err error) error {
+			if err != nil {
+				logrus.Errorf("bad blob %s from remote server %s: %v", blob.Digest, node.Node, err)
+				return nil
+			}
+
+			if _, ok := requested[blob.Digest]; !ok {
+				return fmt.Errorf("unexpected blob %s", blob.Digest)
+			}
+
+			if blob.Missing {
+				logrus.Debugf("blob %s is not found on remote server %s", blob.Digest, node.Node)
+				return nil
+			}
+
+			res, err := st.Write(blob.Data)
+			if err != nil && err != storage.ErrBlobExists {
+				return fmt.Errorf("unable to write blob %s: %v", blob.Digest, err)
 			}
-			errors[i] = SyncBlob(ctx, dgst)
-		}(i, chunk.Digest)
+
+			if res != blob.Digest {
+				logrus.Errorf("produce different digests when sync blob %s from remote server %s: got %s", blob.Digest, node.Node, res)
+				return nil
+			}
+
+			_, err = blobsColl.Create(
+				&metadata.BlobEtcdKey{
+					Digest: blob.Digest,
+					Group:  cfg.Global.Group,
+					Host:   cfg.Global.Hostname,
+				},
+				time.Now().String(),
+			)
+			if err != nil {
+				return fmt.Errorf("unable to blob metadata %s: %v", blob.Digest, err)
+			}
+
+			logrus.Infof("sync %s from remote server %s", blob.Digest, node.Node)
+
+			received = append(received, blob.Digest)
+			return nil
+		})
+		if err != nil {
+			return received, err
+		}
+	}
+
+	return received, nil
+}
+
+func SyncBlobSeries(ctx context.Context, blobs []storage.Descriptor) error {
+	st, ok := ctx.Value(storage.AppStorageDriverContextVar).(storage.StorageDriver)
+	if !ok {
+		return fmt.Errorf("unable to obtain storage driver from context")
 	}
-	wg.Wait()
 
-	for _, err := range errors {
+	var missing []digest.Digest
+
+	for _, chunk := range blobs {
+		has, err := st.Has(chunk.Digest)
 		if err != nil {
 			return err
 		}
+		if !has {
+			missing = append(missing, chunk.Digest)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	failed, err := SyncBlobs(ctx, missing)
+	if err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to sync %s", failed[0].String())
 	}
 
 	return nil
@@ -0,0 +1,227 @@
+package syncer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/digest"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/placement"
+	"github.com/legionus/kavka/pkg/storage"
+	"github.com/legionus/kavka/pkg/transfer"
+)
+
+// CatchUpProgress describes the pass which synchronizes the chunks missed by
+// the node.
+type CatchUpProgress struct {
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Scanned  int64      `json:"scanned"`
+	Missing  int64      `json:"missing"`
+	Synced   int64      `json:"synced"`
+	Failed   int64      `json:"failed"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// catchUpPage is the number of location records read from etcd at once.
+const catchUpPage = 1000
+
+var catchUpState struct {
+	sync.Mutex
+	progress *CatchUpProgress
+}
+
+// LastCatchUp returns the progress of the current or the last catch-up pass on
+// this node.
+func LastCatchUp() *CatchUpProgress {
+	catchUpState.Lock()
+	defer catchUpState.Unlock()
+
+	if catchUpState.progress == nil {
+		return nil
+	}
+
+	res := *catchUpState.progress
+	return &res
+}
+
+// catchUp reads the locations of all chunks by pages and synchronizes the chunks which
+// the node should keep, but does not have. The chunks are synchronized in
+// batches, every batch takes a slot of the pool.
+func catchUp(ctx context.Context, pool chan int) error {
+	progress := &CatchUpProgress{
+		Started: time.Now().UTC(),
+	}
+
+	catchUpState.Lock()
+	catchUpState.progress = progress
+	catchUpState.Unlock()
+
+	err := catchUpPass(ctx, pool, progress)
+
+	catchUpState.Lock()
+	defer catchUpState.Unlock()
+
+	now := time.Now().UTC()
+	progress.Finished = &now
+
+	if err != nil {
+		progress.Error = err.Error()
+	}
+
+	logrus.Infof("Catch-up finished: scanned %d chunks, synced %d of %d missing, failed %d",
+		progress.Scanned, progress.Synced, progress.Missing, progress.Failed)
+
+	return err
+}
+
+func catchUpPass(ctx context.Context, pool chan int, progress *CatchUpProgress) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	st, ok := ctx.Value(storage.AppStorageDriverContextVar).(storage.StorageDriver)
+	if !ok {
+		return fmt.Errorf("Unable to obtain storage driver from context")
+	}
+
+	blobsColl, err := metadata.NewBlobsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := placement.ListNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Locations on the nodes which are not live are stale.
+	live, err := placement.ListLiveNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg    sync.WaitGroup
+		batch []digest.Digest
+	)
+
+	// The locations are listed already, so they are passed to the sync
+	// without the lookup of every chunk.
+	sources := make(map[digest.Digest][]placement.Node)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		pool <- 1
+		wg.Add(1)
+
+		list := make(map[digest.Digest][]placement.Node)
+		for _, dgst := range batch {
+			list[dgst] = sources[dgst]
+			delete(sources, dgst)
+		}
+
+		go func(order []digest.Digest, list map[digest.Digest][]placement.Node) {
+			defer func() {
+				<-pool
+				wg.Done()
+			}()
+
+			failed := syncBlobs(ctx, order, list)
+
+			catchUpState.Lock()
+			defer catchUpState.Unlock()
+
+			progress.Synced += int64(len(order) - len(failed))
+			progress.Failed += int64(len(failed))
+
+			logrus.Infof("Catch-up: synced %d of %d missing chunks", progress.Synced, progress.Missing)
+		}(batch, list)
+
+		batch = nil
+	}
+
+	// The records are sorted, so all the locations of the chunk go in a row,
+	// but they can be split between pages.
+	var chunk digest.Digest
+	var locations []placement.Node
+
+	check := func() {
+		if chunk == "" {
+			return
+		}
+
+		catchUpState.Lock()
+		progress.Scanned++
+		catchUpState.Unlock()
+
+		// Only the chunks placed on this node are checked in the storage.
+		if !shouldHold(cfg, nodes, chunk) {
+			return
+		}
+
+		if has, err := st.Has(chunk); err != nil {
+			logrus.Errorf("Unable to check %s in storage: %s", chunk, err)
+			return
+		} else if has {
+			return
+		}
+
+		catchUpState.Lock()
+		progress.Missing++
+		catchUpState.Unlock()
+
+		batch = append(batch, chunk)
+		sources[chunk] = locations
+
+		if len(batch) >= transfer.MaxBatch {
+			flush()
+		}
+	}
+
+	err = metadata.ListPages(blobsColl, &metadata.BlobEtcdKey{}, catchUpPage, func(records []metadata.EtcdValue) error {
+		for _, rec := range records {
+			key, err := metadata.ParseBlobsEtcdKey(rec.RawKey)
+			if err != nil {
+				logrus.Errorf("Unable to parse key: %s", err)
+				continue
+			}
+
+			if key.Host == metadata.NoString {
+				continue
+			}
+
+			if key.Digest != chunk {
+				check()
+				chunk = key.Digest
+				locations = nil
+			}
+
+			if key.Group == cfg.Global.Group && key.Host == cfg.Global.Hostname {
+				continue
+			}
+
+			if node, ok := placement.Find(live, key.Group, key.Host); ok {
+				locations = append(locations, node)
+			}
+		}
+		return nil
+	})
+
+	if err == nil {
+		check()
+	}
+	flush()
+	wg.Wait()
+
+	return err
+}
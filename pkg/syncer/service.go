@@ -10,6 +10,7 @@ import (
 	"github.com/legionus/kavka/pkg/digest"
 	"github.com/legionus/kavka/pkg/etcd/observer"
 	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/placement"
 	"github.com/legionus/kavka/pkg/storage"
 )
 
@@ -58,6 +59,19 @@ func RunSyncer(ctx context.Context) error {
 				return
 			}
 
+			// The draining node does not receive new replicas.
+			nodes, err := placement.ListNodes(ctx)
+			if err != nil {
+				logrus.Error(err)
+				<-pool
+				return
+			}
+
+			if !shouldHold(cfg, nodes, dgst) {
+				<-pool
+				return
+			}
+
 			SyncBlob(ctx, dgst)
 
 			<-pool
@@ -68,7 +82,37 @@ func RunSyncer(ctx context.Context) error {
 		return err
 	}
 
-	bf.Start()
+	// The events are missed while the node is down or after the compaction
+	// of the watched revisions, so the missing chunks are found by the
+	// catch-up.
+	trigger := make(chan struct{}, 1)
+
+	requestCatchUp := func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	bf.OnResync(requestCatchUp).Start()
+
+	go func() {
+		for range trigger {
+			if err := catchUp(ctx, pool); err != nil {
+				logrus.Errorf("Catch-up fails: %s", err)
+			}
+		}
+	}()
+
+	requestCatchUp()
 
 	return nil
 }
+
+// shouldHold checks whether the node should keep the chunk.
+func shouldHold(cfg *config.Config, nodes []placement.Node, dgst digest.Digest) bool {
+	if cfg.Storage.ReplicationFactor > 0 {
+		nodes = placement.Select(dgst, nodes, cfg.Storage.ReplicationFactor)
+	}
+	return placement.Contains(nodes, cfg.Global.Group, cfg.Global.Hostname)
+}
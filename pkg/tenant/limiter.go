@@ -0,0 +1,64 @@
+package tenant
+
+import (
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter limits the rate of produce requests of tenants on this node. The
+// token bucket holds up to one second of the rate.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes one token from the bucket of the tenant. Zero rate means no
+// limit.
+func (l *Limiter) Allow(name string, rate float64, now time.Time) bool {
+	if rate <= 0 {
+		return true
+	}
+
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[name]
+	if !ok {
+		b = &bucket{
+			tokens: burst,
+			last:   now,
+		}
+		l.buckets[name] = b
+	}
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
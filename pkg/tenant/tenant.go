@@ -0,0 +1,347 @@
+package tenant
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/metadata"
+)
+
+const (
+	TenantContextVar = "app.tenant"
+
+	// Separator delimits the tenant name and the topic name in etcd keys.
+	// Topic names in the API can not contain it.
+	Separator = "."
+)
+
+var (
+	ErrTenantExists   = errors.New("tenant already exists")
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrUnauthorized   = errors.New("unknown tenant credentials")
+	ErrBadTopicName   = errors.New("bad topic name")
+)
+
+// Quota limits resources of the tenant. Zero value means no limit.
+type Quota struct {
+	// Topics is the maximum number of topics.
+	Topics int64 `json:"topics,omitempty"`
+	// Partitions is the maximum number of partitions in all topics.
+	Partitions int64 `json:"partitions,omitempty"`
+	// Bytes is the maximum size of stored messages.
+	Bytes int64 `json:"bytes,omitempty"`
+	// ProduceRate is the maximum number of messages per second accepted by
+	// each node. The limit is not shared between nodes, so the tenant can
+	// produce up to ProduceRate times the number of nodes in the cluster.
+	ProduceRate float64 `json:"produce-rate,omitempty"`
+}
+
+// Tenant is the owner of topics.
+type Tenant struct {
+	Name    string    `json:"name"`
+	Token   string    `json:"token,omitempty"`
+	Quota   Quota     `json:"quota"`
+	Created time.Time `json:"created"`
+}
+
+// Usage describes resources used by the tenant.
+type Usage struct {
+	Topics     int64     `json:"topics"`
+	Partitions int64     `json:"partitions"`
+	Messages   int64     `json:"messages"`
+	Bytes      int64     `json:"bytes"`
+	Updated    time.Time `json:"updated"`
+}
+
+// QualifyTopic returns the topic name used in etcd keys. Topics of requests
+// without the tenant are not qualified.
+func QualifyTopic(t *Tenant, topic string) string {
+	if t == nil {
+		return topic
+	}
+	return t.Name + Separator + topic
+}
+
+// SplitTopic returns the tenant name and the topic name of the qualified
+// topic.
+func SplitTopic(name string) (string, string) {
+	i := strings.Index(name, Separator)
+	if i < 0 {
+		return "", name
+	}
+	return name[:i], name[i+len(Separator):]
+}
+
+// Owns checks that the qualified topic belongs to the tenant.
+func Owns(t *Tenant, name string) bool {
+	owner, _ := SplitTopic(name)
+	if t == nil {
+		return owner == ""
+	}
+	return owner == t.Name
+}
+
+// CheckTopic checks the name of the topic to be created. A qualified name
+// is allowed only for an existing tenant.
+func CheckTopic(ctx context.Context, name string) error {
+	if !config.ValidTopicName(name) {
+		return ErrBadTopicName
+	}
+	owner, _ := SplitTopic(name)
+	if owner == "" {
+		return nil
+	}
+	_, err := Get(ctx, owner)
+	return err
+}
+
+// DisplayTopic returns the topic name as it is seen by the tenant.
+func DisplayTopic(t *Tenant, name string) string {
+	if t == nil {
+		return name
+	}
+	_, topic := SplitTopic(name)
+	return topic
+}
+
+// tokenKey returns the key of the token index. Only the hash of the token is
+// used in the key, so the key does not reveal the token.
+func tokenKey(token string) *metadata.TenantTokenEtcdKey {
+	sum := sha256.Sum256([]byte(token))
+	return &metadata.TenantTokenEtcdKey{
+		Hash: hex.EncodeToString(sum[:]),
+	}
+}
+
+func tenantsCollection(ctx context.Context) (metadata.EtcdCollection, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return nil, fmt.Errorf("Unable to obtain config from context")
+	}
+	return metadata.NewTenantsCollection(ctx, cfg)
+}
+
+// Create registers the new tenant.
+func Create(ctx context.Context, t *Tenant) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	key := &metadata.TenantEtcdKey{
+		Name: t.Name,
+	}
+
+	txn := metadata.NewTransaction(ctx, cfg)
+	txn.Unmodified(key, 0)
+	txn.Put(key, string(data))
+	txn.Put(tokenKey(t.Token), t.Name)
+
+	if err := txn.Commit(); err != nil {
+		if err == metadata.ErrKeyModified {
+			return ErrTenantExists
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Put updates the tenant.
+func Put(ctx context.Context, t *Tenant) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	txn := metadata.NewTransaction(ctx, cfg)
+	txn.Put(&metadata.TenantEtcdKey{Name: t.Name}, string(data))
+	txn.Put(tokenKey(t.Token), t.Name)
+
+	return txn.Commit()
+}
+
+// Get returns the tenant by name.
+func Get(ctx context.Context, name string) (*Tenant, error) {
+	coll, err := tenantsCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := coll.Get(&metadata.TenantEtcdKey{
+		Name: name,
+	})
+	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+
+	t := &Tenant{}
+	if err := json.Unmarshal([]byte(rec.Value), t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// List returns all tenants.
+func List(ctx context.Context) ([]*Tenant, error) {
+	coll, err := tenantsCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := coll.List(&metadata.TenantEtcdKey{}, metadata.SortAscend)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Tenant
+
+	for _, rec := range records {
+		t := &Tenant{}
+		if err := json.Unmarshal([]byte(rec.Value), t); err != nil {
+			return nil, fmt.Errorf("bad tenant %s: %s", rec.RawKey, err)
+		}
+		res = append(res, t)
+	}
+
+	return res, nil
+}
+
+// Delete removes the tenant. Topics of the tenant are not removed.
+func Delete(ctx context.Context, name string) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	t, err := Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	txn := metadata.NewTransaction(ctx, cfg)
+	txn.Delete(&metadata.TenantEtcdKey{Name: name})
+	txn.Delete(tokenKey(t.Token))
+	txn.Delete(&metadata.UsageEtcdKey{Name: name})
+
+	return txn.Commit()
+}
+
+// Identify returns the tenant with the token. The tenant is found by the hash
+// of the token.
+func Identify(ctx context.Context, token string) (*Tenant, error) {
+	coll, err := tenantsCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := coll.Get(tokenKey(token))
+	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			return nil, ErrUnauthorized
+		}
+		return nil, err
+	}
+
+	t, err := Get(ctx, rec.Value)
+	if err != nil {
+		if err == ErrTenantNotFound {
+			return nil, ErrUnauthorized
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) != 1 {
+		return nil, ErrUnauthorized
+	}
+
+	return t, nil
+}
+
+// GetUsage returns the last calculated usage of the tenant.
+func GetUsage(ctx context.Context, name string) (*Usage, error) {
+	coll, err := tenantsCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Usage{}
+
+	rec, err := coll.Get(&metadata.UsageEtcdKey{
+		Name: name,
+	})
+	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			return res, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(rec.Value), res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// CountTopics returns the number of topics and partitions of the tenant.
+func CountTopics(ctx context.Context, t *Tenant) (int64, int64, error) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return 0, 0, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	coll, err := metadata.NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	records, err := coll.List(&metadata.TopicEtcdKey{
+		Topic:     metadata.NoString,
+		Partition: metadata.NoPartition,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	topics := make(map[string]struct{})
+	partitions := int64(0)
+
+	for _, rec := range records {
+		key, err := metadata.ParseTopicEtcdKey(rec.RawKey)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if key.Partition == metadata.NoPartition || !Owns(t, key.Topic) {
+			continue
+		}
+
+		topics[key.Topic] = struct{}{}
+		partitions++
+	}
+
+	return int64(len(topics)), partitions, nil
+}
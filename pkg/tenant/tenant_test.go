@@ -0,0 +1,162 @@
+package tenant
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd/etcdtest"
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/queue"
+	"github.com/legionus/kavka/pkg/storage"
+)
+
+func TestQualifyTopic(t *testing.T) {
+	team := &Tenant{Name: "team"}
+
+	name := QualifyTopic(team, "foo")
+	if name != "team.foo" {
+		t.Fatalf("unexpected qualified name: %s", name)
+	}
+
+	if !Owns(team, name) {
+		t.Fatalf("tenant must own %s", name)
+	}
+
+	if Owns(nil, name) || Owns(&Tenant{Name: "other"}, name) {
+		t.Fatalf("topic %s belongs to another tenant", name)
+	}
+
+	if !Owns(nil, "foo") || Owns(team, "foo") {
+		t.Fatalf("topic without tenant belongs to the default namespace")
+	}
+
+	if v := DisplayTopic(team, name); v != "foo" {
+		t.Fatalf("unexpected display name: %s", v)
+	}
+
+	if v := QualifyTopic(nil, "foo"); v != "foo" {
+		t.Fatalf("unexpected name without tenant: %s", v)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter()
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if !l.Allow("team", 2, now) {
+			t.Fatalf("request %d must be allowed", i)
+		}
+	}
+
+	if l.Allow("team", 2, now) {
+		t.Fatalf("request must be limited")
+	}
+
+	if !l.Allow("other", 2, now) {
+		t.Fatalf("tenants must have separate limits")
+	}
+
+	if !l.Allow("team", 2, now.Add(500*time.Millisecond)) {
+		t.Fatalf("bucket must be refilled")
+	}
+
+	if !l.Allow("team", 0, now) {
+		t.Fatalf("zero rate must not limit requests")
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	ctx := context.WithValue(context.Background(), config.AppConfigContextVar, cfg)
+
+	team := &Tenant{Name: "team", Token: uuid.New()}
+
+	if err := Create(ctx, team); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := Identify(ctx, team.Token)
+	if err != nil {
+		t.Fatalf("unable to identify tenant: %s", err)
+	}
+	if found.Name != team.Name {
+		t.Fatalf("expected tenant %s, got %s", team.Name, found.Name)
+	}
+
+	if _, err := Identify(ctx, uuid.New()); err != ErrUnauthorized {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+
+	if err := Delete(ctx, team.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Identify(ctx, team.Token); err != ErrUnauthorized {
+		t.Fatalf("expected ErrUnauthorized after removal, got %v", err)
+	}
+}
+
+func TestAccountantUpdate(t *testing.T) {
+	cfg, stop := etcdtest.Start(t)
+	defer stop()
+
+	ctx := context.WithValue(context.Background(), config.AppConfigContextVar, cfg)
+
+	coll, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appendMessage := func(size int64) {
+		msg := &message.MessageInfo{
+			ID:           uuid.New(),
+			CreationTime: time.Now().String(),
+			Blobs: []storage.Descriptor{
+				{Size: size},
+			},
+		}
+		if _, err := queue.CreateQueue(ctx, "team.foo", 0, msg); err != nil {
+			t.Fatalf("unable to append message: %s", err)
+		}
+	}
+
+	check := func(a *accountant, messages, bytes int64) {
+		p, err := a.update(coll, "team.foo", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.messages != messages || p.bytes != bytes {
+			t.Fatalf("expected %d messages of %d bytes, got %d of %d", messages, bytes, p.messages, p.bytes)
+		}
+		a.partitions[(&metadata.TopicEtcdKey{Topic: "team.foo", Partition: 0}).String()] = p
+	}
+
+	a := newAccountant()
+
+	check(a, 0, 0)
+
+	appendMessage(1)
+	appendMessage(10)
+	appendMessage(100)
+
+	check(a, 3, 111)
+
+	// The head of the partition is removed and new messages are appended.
+	if err := coll.Delete(&metadata.QueueEtcdKey{Topic: "team.foo", Partition: 0, Offset: 0}); err != nil {
+		t.Fatal(err)
+	}
+	appendMessage(1000)
+
+	check(a, 3, 1110)
+
+	// The incremental result matches the full recalculation.
+	check(newAccountant(), 3, 1110)
+}
@@ -0,0 +1,299 @@
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd"
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/queue"
+)
+
+const (
+	// resyncPasses is the number of incremental passes between the full
+	// recalculations. The full recalculation accounts the messages removed
+	// from the middle of partitions (e.g. by compaction).
+	resyncPasses = 10
+
+	// accountingBatch is the number of queue records read at once.
+	accountingBatch = 1000
+)
+
+// partitionUsage is the accounted part of the partition. The sizes of the
+// accounted messages are kept, so the messages removed from the head of the
+// partition are subtracted without reading them.
+type partitionUsage struct {
+	first    int64
+	sizes    []int64 // -1 for gaps
+	messages int64
+	bytes    int64
+}
+
+func (p *partitionUsage) next() int64 {
+	return p.first + int64(len(p.sizes))
+}
+
+// accountant calculates the usage of tenants. Only the messages appended
+// since the previous pass are read.
+type accountant struct {
+	partitions map[string]*partitionUsage
+	passes     int
+}
+
+func newAccountant() *accountant {
+	return &accountant{
+		partitions: make(map[string]*partitionUsage),
+	}
+}
+
+// RunAccounting starts the service which periodically calculates resources
+// used by tenants. The result is used to enforce the storage quota. The usage
+// is calculated by the node elected through etcd.
+func RunAccounting(ctx context.Context) (chan struct{}, error) {
+	stopChan := make(chan struct{})
+
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return stopChan, fmt.Errorf("Unable to obtain config from context")
+	}
+
+	c, err := etcd.NewEtcdClient(cfg)
+	if err != nil {
+		return stopChan, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		<-stopChan
+		cancel()
+	}()
+
+	election := &metadata.ElectionEtcdKey{
+		Name: "accounting",
+	}
+
+	go etcd.Lead(ctx, c, election.String(), cfg.Global.Hostname, func(ctx context.Context) {
+		logrus.Infof("Node is elected to account tenants")
+
+		a := newAccountant()
+
+		for {
+			if err := a.account(ctx); err != nil {
+				logrus.Errorf("tenant accounting fails: %s", err)
+			}
+
+			select {
+			case <-time.After(cfg.Tenants.AccountingPeriod):
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+
+	return stopChan, nil
+}
+
+func (a *accountant) account(ctx context.Context) error {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		return fmt.Errorf("Unable to obtain config from context")
+	}
+
+	tenants, err := List(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(tenants) == 0 {
+		return nil
+	}
+
+	a.passes++
+	if a.passes >= resyncPasses {
+		a.passes = 0
+		a.partitions = make(map[string]*partitionUsage)
+	}
+
+	usage := make(map[string]*Usage)
+
+	for _, t := range tenants {
+		usage[t.Name] = &Usage{}
+	}
+
+	topicsColl, err := metadata.NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	records, err := topicsColl.List(&metadata.TopicEtcdKey{
+		Topic:     metadata.NoString,
+		Partition: metadata.NoPartition,
+	})
+	if err != nil {
+		return err
+	}
+
+	topics := make(map[string]struct{})
+	partitions := make(map[string]*partitionUsage)
+
+	for _, rec := range records {
+		key, err := metadata.ParseTopicEtcdKey(rec.RawKey)
+		if err != nil {
+			logrus.Errorf("Invalid key %s: %s", rec.RawKey, err)
+			continue
+		}
+
+		if key.Partition == metadata.NoPartition {
+			continue
+		}
+
+		name, _ := SplitTopic(key.Topic)
+
+		u, ok := usage[name]
+		if !ok {
+			continue
+		}
+
+		if _, ok := topics[key.Topic]; !ok {
+			topics[key.Topic] = struct{}{}
+			u.Topics++
+		}
+		u.Partitions++
+
+		p, err := a.update(queuesColl, key.Topic, key.Partition)
+		if err != nil {
+			return err
+		}
+
+		partitions[rec.RawKey] = p
+
+		u.Messages += p.messages
+		u.Bytes += p.bytes
+	}
+
+	// The partitions of removed topics are forgotten.
+	a.partitions = partitions
+
+	coll, err := metadata.NewTenantsCollection(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for name, u := range usage {
+		u.Updated = now
+
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+
+		if err := coll.Put(&metadata.UsageEtcdKey{Name: name}, string(data)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// update accounts the messages appended to the partition and removed from its
+// head since the previous pass.
+func (a *accountant) update(coll metadata.EtcdCollection, topic string, partition int64) (*partitionUsage, error) {
+	name := (&metadata.TopicEtcdKey{
+		Topic:     topic,
+		Partition: partition,
+	}).String()
+
+	oldest, newest, err := queue.GetCornerOffsets(coll, topic, partition)
+	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			return &partitionUsage{}, nil
+		}
+		return nil, err
+	}
+
+	p, ok := a.partitions[name]
+	if !ok || oldest < p.first || oldest > p.next() || newest < p.next() {
+		p = &partitionUsage{
+			first: oldest,
+		}
+	}
+
+	for p.first < oldest {
+		if size := p.sizes[0]; size >= 0 {
+			p.messages--
+			p.bytes -= size
+		}
+		p.sizes = p.sizes[1:]
+		p.first++
+	}
+
+	for p.next() < newest {
+		last := p.next() + accountingBatch
+		if last > newest {
+			last = newest
+		}
+
+		records, err := coll.ListRange(
+			&metadata.QueueEtcdKey{
+				Topic:     topic,
+				Partition: partition,
+				Offset:    p.next(),
+			},
+			&metadata.QueueEtcdKey{
+				Topic:     topic,
+				Partition: partition,
+				Offset:    last,
+			},
+		)
+		if err != nil && err != metadata.ErrKeyNotFound {
+			return nil, err
+		}
+
+		for _, rec := range records {
+			key, err := metadata.ParseQueueEtcdKey(rec.RawKey)
+			if err != nil {
+				logrus.Errorf("Invalid key %s: %s", rec.RawKey, err)
+				continue
+			}
+
+			msg, err := message.ParseMessageInfo(rec.Value)
+			if err != nil {
+				logrus.Errorf("Bad message %s: %s", rec.RawKey, err)
+				continue
+			}
+
+			for p.next() < key.Offset {
+				p.sizes = append(p.sizes, -1)
+			}
+
+			size := int64(0)
+			for _, blob := range msg.Blobs {
+				size += blob.Size
+			}
+
+			p.sizes = append(p.sizes, size)
+			p.messages++
+			p.bytes += size
+		}
+
+		for p.next() < last {
+			p.sizes = append(p.sizes, -1)
+		}
+	}
+
+	return p, nil
+}
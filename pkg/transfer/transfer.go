@@ -0,0 +1,184 @@
+// Package transfer implements the framing of the batch transfer of chunks
+// between nodes.
+//
+// The stream consists of frames. Every frame starts with the header line
+// "<digest> <size>\n" followed by size bytes of the chunk. If the node does not
+// have the chunk, the size is -1 and no data follows. The stream ends with the
+// line "end\n", so the truncated stream can be detected.
+package transfer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/legionus/kavka/pkg/digest"
+)
+
+const (
+	// ContentType is the media type of the stream.
+	ContentType = "application/vnd.kavka.blobs"
+
+	// MaxBatch is the maximum number of chunks requested at once.
+	MaxBatch = 1000
+
+	// DefaultMaxSize limits the size of the chunk if the reader has no
+	// limit of its own.
+	DefaultMaxSize = 64 * 1024 * 1024
+
+	trailer = "end"
+)
+
+var (
+	// ErrTruncated is returned when the stream ends before the trailer.
+	ErrTruncated = errors.New("truncated stream")
+)
+
+// DigestError is returned when the data of the chunk does not match its digest.
+// The stream can be read further after this error.
+type DigestError struct {
+	Expected digest.Digest
+	Actual   digest.Digest
+}
+
+func (e *DigestError) Error() string {
+	return fmt.Sprintf("digest mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// Blob is the chunk received from the stream.
+type Blob struct {
+	Digest  digest.Digest
+	Data    []byte
+	Missing bool
+}
+
+// Writer writes the frames to the stream.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a new Writer.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w: bufio.NewWriter(w),
+	}
+}
+
+// WriteBlob writes the chunk of the given size from r.
+func (w *Writer) WriteBlob(dgst digest.Digest, size int64, r io.Reader) error {
+	if _, err := fmt.Fprintf(w.w, "%s %d\n", dgst, size); err != nil {
+		return err
+	}
+
+	n, err := io.CopyN(w.w, r, size)
+	if err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("short read of %s: %d of %d bytes", dgst, n, size)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// WriteMissing reports that the chunk is not available.
+func (w *Writer) WriteMissing(dgst digest.Digest) error {
+	_, err := fmt.Fprintf(w.w, "%s -1\n", dgst)
+	return err
+}
+
+// Close writes the trailer and flushes the stream. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if _, err := fmt.Fprintf(w.w, "%s\n", trailer); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// Reader reads the frames from the stream.
+type Reader struct {
+	r *bufio.Reader
+	// MaxSize limits the size of the chunk. Zero means DefaultMaxSize.
+	MaxSize int64
+}
+
+// NewReader returns a new Reader.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r: bufio.NewReader(r),
+	}
+}
+
+// Next returns the next chunk. The data is verified against the digest, if it
+// does not match, *DigestError is returned. At the end of the stream io.EOF is
+// returned.
+func (r *Reader) Next() (*Blob, error) {
+	line, err := r.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+
+	if line == trailer {
+		return nil, io.EOF
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("bad frame header: %q", line)
+	}
+
+	dgst, err := digest.ParseDigest(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("bad frame header: %s", err)
+	}
+
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < -1 {
+		return nil, fmt.Errorf("bad frame header: %q", line)
+	}
+
+	blob := &Blob{
+		Digest: dgst,
+	}
+
+	if size == -1 {
+		blob.Missing = true
+		return blob, nil
+	}
+
+	maxSize := r.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	if size > maxSize {
+		return nil, fmt.Errorf("chunk %s is too big: %d bytes", dgst, size)
+	}
+
+	blob.Data = make([]byte, size)
+
+	if _, err := io.ReadFull(r.r, blob.Data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+
+	if actual := dgst.Algorithm().FromBytes(blob.Data); actual != dgst {
+		return blob, &DigestError{
+			Expected: dgst,
+			Actual:   actual,
+		}
+	}
+
+	return blob, nil
+}
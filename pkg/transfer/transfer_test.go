@@ -0,0 +1,118 @@
+package transfer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/legionus/kavka/pkg/digest"
+)
+
+func TestStream(t *testing.T) {
+	chunks := [][]byte{
+		[]byte("first chunk"),
+		[]byte(""),
+		[]byte("line\nwith\nnewlines\n"),
+	}
+	missing := digest.FromBytes([]byte("missing"))
+
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf)
+	for _, data := range chunks {
+		if err := w.WriteBlob(digest.FromBytes(data), int64(len(data)), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.WriteMissing(missing); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(&buf)
+
+	for _, data := range chunks {
+		blob, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blob.Digest != digest.FromBytes(data) || !bytes.Equal(blob.Data, data) || blob.Missing {
+			t.Fatalf("unexpected blob: %s %q", blob.Digest, blob.Data)
+		}
+	}
+
+	blob, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Digest != missing || !blob.Missing {
+		t.Fatalf("expected missing blob %s, got %s", missing, blob.Digest)
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	good := []byte("good")
+	dgst := digest.FromBytes([]byte("other"))
+
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf)
+	w.WriteBlob(dgst, int64(len(good)), bytes.NewReader(good))
+	w.WriteBlob(digest.FromBytes(good), int64(len(good)), bytes.NewReader(good))
+	w.Close()
+
+	stream := buf.String()
+
+	r := NewReader(strings.NewReader(stream))
+
+	// The stream continues after the corrupted chunk.
+	if _, err := r.Next(); err == nil {
+		t.Fatal("expected digest mismatch")
+	} else if _, ok := err.(*DigestError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if blob, err := r.Next(); err != nil || !bytes.Equal(blob.Data, good) {
+		t.Fatalf("unexpected result: %v", err)
+	}
+
+	r = NewReader(strings.NewReader(stream[:len(stream)-10]))
+
+	r.Next()
+	if _, err := r.Next(); err != ErrTruncated {
+		t.Fatalf("expected truncated stream, got %v", err)
+	}
+}
+
+func TestStreamMaxSize(t *testing.T) {
+	data := []byte("too big")
+
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf)
+	w.WriteBlob(digest.FromBytes(data), int64(len(data)), bytes.NewReader(data))
+	w.Close()
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	r.MaxSize = 3
+
+	if _, err := r.Next(); err == nil {
+		t.Fatal("expected too big chunk")
+	}
+
+	// The size from the header is not trusted without the limit.
+	header := fmt.Sprintf("%s %d\n", digest.FromBytes(data), DefaultMaxSize+1)
+
+	r = NewReader(strings.NewReader(header))
+
+	if _, err := r.Next(); err == nil || err == ErrTruncated {
+		t.Fatalf("expected too big chunk, got %v", err)
+	}
+}
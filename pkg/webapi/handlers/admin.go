@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/jobs"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/schema"
+	"github.com/legionus/kavka/pkg/webapi"
+)
+
+type responseTopicConfig struct {
+	Topic     string              `json:"topic"`
+	Overrides *config.TopicConfig `json:"overrides"`
+	Effective *config.TopicConfig `json:"effective"`
+}
+
+func writeTopicConfig(w http.ResponseWriter, cfg *config.Config, topic string, overrides *config.TopicConfig) {
+	writeJSON(w, &responseTopicConfig{
+		Topic:     topic,
+		Overrides: overrides,
+		Effective: config.NewTopicConfig(overrides.Apply(&cfg.Topic, topic), topic),
+	})
+}
+
+func adminTopicConfigGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain config from context")
+		return
+	}
+
+	topicsColl, err := metadata.NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	overrides, err := metadata.GetTopicOverrides(topicsColl, p.Get("topic"))
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to get topic config: %s", err)
+		return
+	}
+
+	writeTopicConfig(w, cfg, p.Get("topic"), overrides)
+}
+
+func adminTopicConfigPutHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain config from context")
+		return
+	}
+
+	topicsColl, err := metadata.NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	partitions, err := metadata.GetPartitionsCount(topicsColl, p.Get("topic"))
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	if partitions == 0 {
+		webapi.HTTPResponse(w, http.StatusNotFound, "topic not found")
+		return
+	}
+
+	msg, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to read body: %s", err)
+		return
+	}
+
+	overrides := &config.TopicConfig{}
+
+	if err = json.Unmarshal(msg, overrides); err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Bad topic config: %s", err)
+		return
+	}
+
+	if err = overrides.Validate(); err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Bad topic config: %s", err)
+		return
+	}
+
+	if overrides.MaxChunkSize != nil {
+		if err = cfg.Topic.CheckMaxChunkSize(*overrides.MaxChunkSize); err != nil {
+			webapi.HTTPResponse(w, http.StatusBadRequest, "Bad topic config: %s", err)
+			return
+		}
+	}
+
+	if overrides.WriteConcern != nil {
+		if err = cfg.Storage.CheckWriteConcern(*overrides.WriteConcern); err != nil {
+			webapi.HTTPResponse(w, http.StatusBadRequest, "Bad topic config: %s", err)
+			return
+		}
+	}
+
+	if err = schema.PutTopicConfig(ctx, p.Get("topic"), overrides); err != nil {
+		switch err {
+		case schema.ErrSubjectNotFound:
+			webapi.HTTPResponse(w, http.StatusBadRequest, "Bad topic config: schema subject not found: %s", *overrides.Schema)
+		case schema.ErrSubjectModified:
+			webapi.HTTPResponse(w, http.StatusConflict, "%s", err)
+		default:
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to store topic config: %s", err)
+		}
+		return
+	}
+
+	writeTopicConfig(w, cfg, p.Get("topic"), overrides)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to marshal json: %v", err)
+		return
+	}
+
+	w.Write(b)
+}
+
+func adminTopicDeleteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain config from context")
+		return
+	}
+
+	topicsColl, err := metadata.NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	partitions, err := metadata.GetPartitionsCount(topicsColl, p.Get("topic"))
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	if partitions == 0 {
+		// The topic records are removed first, so the topic being
+		// deleted has no partitions.
+		active, err := jobs.IsActive(ctx, jobs.TopicDeletion, p.Get("topic"))
+		if err != nil {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+		if !active {
+			webapi.HTTPResponse(w, http.StatusNotFound, "topic not found")
+			return
+		}
+	}
+
+	job, err := jobs.Create(ctx, jobs.TopicDeletion, p.Get("topic"))
+	if err != nil {
+		if err != jobs.ErrJobExists {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to create job: %s", err)
+			return
+		}
+		if job == nil {
+			webapi.HTTPResponse(w, http.StatusConflict, "%s", err)
+			return
+		}
+	}
+
+	webapi.HTTPResponse(w, http.StatusAccepted, "")
+	writeJSON(w, job)
+}
+
+func adminJobsListHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	list, err := jobs.List(ctx, p.Get("type"))
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to list jobs: %s", err)
+		return
+	}
+
+	if list == nil {
+		list = []*jobs.Job{}
+	}
+
+	writeJSON(w, list)
+}
+
+func adminJobGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	job, err := jobs.Get(ctx, p.Get("type"), p.Get("name"))
+	if err != nil {
+		if err == jobs.ErrJobNotFound {
+			webapi.HTTPResponse(w, http.StatusNotFound, "%s", err)
+			return
+		}
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to get job: %s", err)
+		return
+	}
+
+	writeJSON(w, job)
+}
+
+type responseTopicPartitions struct {
+	Topic      string `json:"topic"`
+	Partitions int64  `json:"partitions"`
+}
+
+func adminTopicPartitionsGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain config from context")
+		return
+	}
+
+	topicsColl, err := metadata.NewTopicsCollection(ctx, cfg)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	count, err := metadata.GetPartitionsCount(topicsColl, p.Get("topic"))
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to get partitions: %s", err)
+		return
+	}
+
+	if count == 0 {
+		webapi.HTTPResponse(w, http.StatusNotFound, "topic not found")
+		return
+	}
+
+	writeJSON(w, &responseTopicPartitions{
+		Topic:      p.Get("topic"),
+		Partitions: count,
+	})
+}
+
+func adminTopicPartitionsPostHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain config from context")
+		return
+	}
+
+	msg, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to read body: %s", err)
+		return
+	}
+
+	req := &responseTopicPartitions{}
+
+	if err = json.Unmarshal(msg, req); err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Bad request: %s", err)
+		return
+	}
+
+	err = metadata.AddPartitions(ctx, cfg, p.Get("topic"), req.Partitions, time.Now().String())
+	switch err {
+	case nil:
+	case metadata.ErrKeyNotFound:
+		webapi.HTTPResponse(w, http.StatusNotFound, "topic not found")
+		return
+	case metadata.ErrPartitionsCount:
+		webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
+		return
+	case metadata.ErrKeyModified:
+		webapi.HTTPResponse(w, http.StatusConflict, "partitions have been changed concurrently")
+		return
+	default:
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to add partitions: %s", err)
+		return
+	}
+
+	writeJSON(w, &responseTopicPartitions{
+		Topic:      p.Get("topic"),
+		Partitions: req.Partitions,
+	})
+}
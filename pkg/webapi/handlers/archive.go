@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/legionus/kavka/pkg/archive"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/jobs"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/tenant"
+	"github.com/legionus/kavka/pkg/util"
+	"github.com/legionus/kavka/pkg/webapi"
+)
+
+func adminTopicExportHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	rng := &archive.Range{
+		Partition:  metadata.NoPartition,
+		OffsetFrom: util.ToInt64(p.Get("from")),
+		OffsetTo:   util.ToInt64(p.Get("to")),
+	}
+
+	if v := p.Get("partition"); v != "" {
+		rng.Partition = util.ToInt64(v)
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", p.Get("topic")+".tar"))
+
+	if err := archive.Export(ctx, w, p.Get("topic"), rng); err != nil {
+		if err == metadata.ErrKeyNotFound {
+			webapi.HTTPResponse(w, http.StatusNotFound, "topic not found")
+		} else {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to export topic: %s", err)
+		}
+	}
+}
+
+func adminTopicImportHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	switch err := tenant.CheckTopic(ctx, p.Get("topic")); err {
+	case nil:
+	case tenant.ErrBadTopicName, tenant.ErrTenantNotFound:
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Unable to import topic: %s", err)
+		return
+	default:
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	deleting, err := jobs.IsActive(ctx, jobs.TopicDeletion, p.Get("topic"))
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	if deleting {
+		webapi.HTTPResponse(w, http.StatusConflict, "topic is being deleted")
+		return
+	}
+
+	res, err := archive.Import(ctx, r.Body, p.Get("topic"))
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Unable to import topic: %s", err)
+		return
+	}
+
+	writeJSON(w, res)
+}
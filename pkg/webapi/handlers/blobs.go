@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/url"
 
+	"github.com/Sirupsen/logrus"
+
 	"github.com/legionus/kavka/pkg/context"
 	"github.com/legionus/kavka/pkg/digest"
 	"github.com/legionus/kavka/pkg/storage"
+	"github.com/legionus/kavka/pkg/transfer"
 	"github.com/legionus/kavka/pkg/webapi"
 )
 
@@ -47,3 +52,63 @@ func blobGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
 
 	blobReader.Close()
 }
+
+// blobsBatchHandler streams the requested chunks. The body of the request is
+// the JSON list of digests.
+func blobsBatchHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	st, ok := ctx.Value(storage.AppStorageDriverContextVar).(storage.StorageDriver)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain storage driver from context")
+		return
+	}
+
+	var list []digest.Digest
+
+	if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Bad list of digests: %s", err)
+		return
+	}
+
+	if len(list) > transfer.MaxBatch {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Too many digests: %d, maximum %d", len(list), transfer.MaxBatch)
+		return
+	}
+
+	for _, dgst := range list {
+		if err := dgst.Validate(); err != nil {
+			webapi.HTTPResponse(w, http.StatusBadRequest, "Bad digest %s: %s", dgst, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", transfer.ContentType)
+
+	tw := transfer.NewWriter(w)
+
+	// The status is already sent, so the errors are only logged. The client
+	// detects the broken stream by the missing trailer.
+	for _, dgst := range list {
+		blob, err := st.Read(dgst)
+		if err != nil {
+			if err != storage.ErrBlobUnknown {
+				logrus.Errorf("Unable to read %s: %s", dgst, err)
+			}
+			if err := tw.WriteMissing(dgst); err != nil {
+				logrus.Errorf("Unable to send blobs: %s", err)
+				return
+			}
+			continue
+		}
+
+		if err := tw.WriteBlob(dgst, int64(len(blob)), bytes.NewReader(blob)); err != nil {
+			logrus.Errorf("Unable to send %s: %s", dgst, err)
+			return
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		logrus.Errorf("Unable to send blobs: %s", err)
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/legionus/kavka/pkg/cluster"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/etcd/observer"
+	"github.com/legionus/kavka/pkg/jobs"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/repair"
+	"github.com/legionus/kavka/pkg/syncer"
+	"github.com/legionus/kavka/pkg/webapi"
+)
+
+type requestDecommission struct {
+	EtcdMember string `json:"etcd-member,omitempty"`
+}
+
+func clusterHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	topology, err := cluster.GetTopology(ctx)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to list nodes: %s", err)
+		return
+	}
+
+	writeJSON(w, topology)
+}
+
+func adminNodesListHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	members, err := cluster.ListMembers(ctx)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to list nodes: %s", err)
+		return
+	}
+
+	writeJSON(w, members)
+}
+
+func adminRepairReportHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	report, err := repair.GetReport(ctx)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to make repair report: %s", err)
+		return
+	}
+
+	writeJSON(w, report)
+}
+
+func adminSyncProgressHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	progress := syncer.LastCatchUp()
+	if progress == nil {
+		webapi.HTTPResponse(w, http.StatusNotFound, "Catch-up has not started yet")
+		return
+	}
+
+	writeJSON(w, progress)
+}
+
+func adminObserversHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	res := []*observer.EtcdObserverStatus{}
+
+	for _, name := range []string{
+		metadata.BlobsObserverContextVar,
+		metadata.ClusterObserverContextVar,
+		metadata.MembersObserverContextVar,
+		metadata.QueuesObserverContextVar,
+		metadata.RefsObserverContextVar,
+		metadata.TopicsObserverContextVar,
+	} {
+		obsrv, ok := ctx.Value(name).(*observer.EtcdObserver)
+		if !ok {
+			continue
+		}
+
+		status, err := obsrv.Status(ctx)
+		if err != nil {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to get observer status: %s", err)
+			return
+		}
+
+		res = append(res, status)
+	}
+
+	writeJSON(w, res)
+}
+
+func adminNodeDecommissionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	msg, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to read body: %s", err)
+		return
+	}
+
+	req := &requestDecommission{}
+
+	if len(msg) > 0 {
+		if err = json.Unmarshal(msg, req); err != nil {
+			webapi.HTTPResponse(w, http.StatusBadRequest, "Bad request: %s", err)
+			return
+		}
+	}
+
+	if req.EtcdMember != "" {
+		if _, err := strconv.ParseUint(req.EtcdMember, 10, 64); err != nil {
+			webapi.HTTPResponse(w, http.StatusBadRequest, "Bad etcd member: %s", req.EtcdMember)
+			return
+		}
+	}
+
+	group, node := p.Get("group"), p.Get("node")
+
+	members, err := cluster.ListMembers(ctx)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to list nodes: %s", err)
+		return
+	}
+
+	found := false
+	others := 0
+
+	for _, m := range members {
+		if m.Group == group && m.Node == node {
+			found = true
+			continue
+		}
+		if m.Live && !m.Draining {
+			others++
+		}
+	}
+
+	if !found {
+		webapi.HTTPResponse(w, http.StatusNotFound, "Node not found: %s/%s", group, node)
+		return
+	}
+
+	if others == 0 {
+		webapi.HTTPResponse(w, http.StatusConflict, "No other nodes to keep the chunks")
+		return
+	}
+
+	job, err := jobs.CreateWithParams(ctx, jobs.NodeDecommission, repair.DecommissionJobName(group, node), map[string]string{
+		repair.DecommissionGroup:      group,
+		repair.DecommissionNode:       node,
+		repair.DecommissionEtcdMember: req.EtcdMember,
+	})
+	if err != nil {
+		if err != jobs.ErrJobExists {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to create job: %s", err)
+			return
+		}
+		if job == nil {
+			webapi.HTTPResponse(w, http.StatusConflict, "%s", err)
+			return
+		}
+	}
+
+	webapi.HTTPResponse(w, http.StatusAccepted, "")
+	writeJSON(w, job)
+}
@@ -23,7 +23,22 @@ func defultHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
           <tr>
             <th class="text-right">Write raw message to Kavka</th>
             <td>POST</td>
-            <td><code>{schema}://{host}` + api.TopicsPath + `/{topic}/{partition}</code></td>
+            <td>
+               <p><code>{schema}://{host}` + api.TopicsPath + `/{topic}/{partition}</code></p>
+               The <b>` + api.MessageDeliverAtHeader + `</b> header delays the delivery until the specified time (RFC3339).
+               The <b>` + api.MessageHeaderPrefix + `{name}</b> headers are stored with the message.
+               The <b>` + api.MessageAcksHeader + `</b> header (0, 1, ..., all) overrides the write concern of the topic.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Write raw message to the partition chosen by key</th>
+            <td>POST</td>
+            <td>
+               <p><code>{schema}://{host}` + api.TopicsPath + `/{topic}</code></p>
+               The partition is chosen by the <b>` + api.MessageKeyHeader + `</b> header using the jump consistent hash.
+               When partitions are added, only the keys moving to the new partitions change their partition.
+               Messages without a key are written to a random partition.
+            </td>
           </tr>
           <tr>
             <th class="text-right">Read from Kavka by absolute position</th>
@@ -46,6 +61,11 @@ func defultHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
             <td>POST</td>
             <td><code>{schema}://{host}` + api.JSONTopicsPath + `/{topic}/{partition}</code></td>
           </tr>
+          <tr>
+            <th class="text-right">Write to the partition chosen by key</th>
+            <td>POST</td>
+            <td><code>{schema}://{host}` + api.JSONTopicsPath + `/{topic}</code></td>
+          </tr>
           <tr>
             <th class="text-right">Read from Kavka by absolute position</th>
             <td>GET</td>
@@ -61,6 +81,43 @@ func defultHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
                The <b>{position}</b> can be positive or negative.
             </td>
           </tr>
+          <tr>
+            <th class="text-right">Read only messages matching the filter</th>
+            <td>GET</td>
+            <td>
+               <p><code>{schema}://{host}` + api.JSONTopicsPath + `/{topic}/{partition}?offset={offset}&limit={limit}&filter={expression}</code></p>
+               Example: <code>header.type == "order" &amp;&amp; (json.status ^= "pend" || !exists(json.deleted))</code>.
+               The raw API returns the first matching message starting from the offset.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Read message by ID</th>
+            <td>GET</td>
+            <td>
+               <p><code>{schema}://{host}` + api.MessagesPath + `/{id}?topic={topic}</code></p>
+               The location of the message is returned in <b>` + api.MessageTopicHeader + `</b>, <b>` + api.MessagePartitionHeader + `</b>
+               and <b>` + api.MessageOffsetHeader + `</b> headers. The <b>{topic}</b> is optional.
+            </td>
+          </tr>
+          <tr class="info"><td colspan="3"><h4>Work queue API</h4></td></tr>
+          <tr>
+            <th class="text-right">Lease the next unacknowledged message</th>
+            <td>POST</td>
+            <td>
+               <p><code>{schema}://{host}` + api.WorkQueuesPath + `/{topic}/{partition}/receive?timeout={duration}</code></p>
+               The offset and the receipt are returned in <b>` + api.MessageOffsetHeader + `</b> and <b>` + api.MessageReceiptHeader + `</b> headers.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Acknowledge the message</th>
+            <td>POST</td>
+            <td><code>{schema}://{host}` + api.WorkQueuesPath + `/{topic}/{partition}/ack?offset={offset}&receipt={receipt}</code></td>
+          </tr>
+          <tr>
+            <th class="text-right">Return the message to the queue</th>
+            <td>POST</td>
+            <td><code>{schema}://{host}` + api.WorkQueuesPath + `/{topic}/{partition}/nack?offset={offset}&receipt={receipt}</code></td>
+          </tr>
           <tr class="info"><td colspan="3"><h4>Infomation about topics and partitions</h4></td></tr>
           <tr>
             <th class="text-right">Obtain topic list</th>
@@ -83,6 +140,221 @@ func defultHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
             <td>GET</td>
             <td><code>{schema}://{host}` + api.BlobsPath + `/{digest}</code></td>
           </tr>
+          <tr>
+            <th class="text-right">Obtain several blobs at once</th>
+            <td>POST</td>
+            <td>
+               <p><code>{schema}://{host}` + api.BlobsPath + `</code></p>
+               The body is the JSON list of digests (at most 1000). The response is the stream of frames
+               <code>{digest} {size}\n</code> followed by the data of the blob; the size is -1 if the blob is not found.
+               The stream ends with <code>end\n</code>.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Obtain cluster topology</th>
+            <td>GET</td>
+            <td>
+               <p><code>{schema}://{host}` + api.ClusterPath + `</code></p>
+               The nodes with their advertised addresses, versions, start times and capacities, and the groups of nodes.
+            </td>
+          </tr>
+          <tr class="info"><td colspan="3"><h4>Schema registry</h4></td></tr>
+          <tr>
+            <th class="text-right">List subjects</th>
+            <td>GET</td>
+            <td><code>{schema}://{host}` + api.SchemasPath + `</code></td>
+          </tr>
+          <tr>
+            <th class="text-right">Obtain subject and its versions</th>
+            <td>GET</td>
+            <td><code>{schema}://{host}` + api.SchemasPath + `/{subject}</code></td>
+          </tr>
+          <tr>
+            <th class="text-right">Register new version of JSON schema</th>
+            <td>POST</td>
+            <td>
+               <p><code>{schema}://{host}` + api.SchemasPath + `/{subject}</code></p>
+               The schema is checked for compatibility with the latest version of the subject.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Obtain version of schema</th>
+            <td>GET</td>
+            <td><code>{schema}://{host}` + api.SchemasPath + `/{subject}/versions/{version|latest}</code></td>
+          </tr>
+          <tr>
+            <th class="text-right">Change compatibility level</th>
+            <td>PUT</td>
+            <td>
+               <p><code>{schema}://{host}` + api.SchemasPath + `/{subject}/config</code></p>
+               Example: <code>{"compatibility":"full"}</code>. The levels are <b>none</b>, <b>backward</b> (default), <b>forward</b> and <b>full</b>.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Delete subject</th>
+            <td>DELETE</td>
+            <td><code>{schema}://{host}` + api.SchemasPath + `/{subject}</code></td>
+          </tr>
+          <tr class="info"><td colspan="3"><h4>Administration</h4></td></tr>
+          <tr>
+            <th class="text-right">Obtain topic settings</th>
+            <td>GET</td>
+            <td><code>{schema}://{host}` + api.AdminTopicsPath + `/{topic}/config</code></td>
+          </tr>
+          <tr>
+            <th class="text-right">Override topic settings</th>
+            <td>PUT</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminTopicsPath + `/{topic}/config</code></p>
+               Example: <code>{"message-retention-period":"1h","max-partition-size":1048576,"max-partition-messages":10000,"cleanup-policy":"compact"}</code>.
+               The unset fields are inherited from the server configuration.
+               The <b>schema</b> field binds the topic to the schema subject.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Obtain number of partitions</th>
+            <td>GET</td>
+            <td><code>{schema}://{host}` + api.AdminTopicsPath + `/{topic}/partitions</code></td>
+          </tr>
+          <tr>
+            <th class="text-right">Increase number of partitions</th>
+            <td>POST</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminTopicsPath + `/{topic}/partitions</code></p>
+               Example: <code>{"partitions":8}</code>. The number of partitions can only be increased.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Export topic to tar archive</th>
+            <td>GET</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminTopicsPath + `/{topic}/export?partition={partition}&from={offset}&to={offset}</code></p>
+               The archive contains the queue records and the chunks referenced by them. All parameters are optional.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Import topic from tar archive</th>
+            <td>POST</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminTopicsPath + `/{topic}/import</code></p>
+               The messages are appended to the same partitions. The chunks already present in the cluster are skipped.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Delete topic</th>
+            <td>DELETE</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminTopicsPath + `/{topic}</code></p>
+               Removes partitions, messages, references and unused chunks of the topic in the background.
+               Returns the deletion job.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">List background jobs</th>
+            <td>GET</td>
+            <td><code>{schema}://{host}` + api.AdminJobsPath + `[/{type}]</code></td>
+          </tr>
+          <tr>
+            <th class="text-right">Obtain job status</th>
+            <td>GET</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminJobsPath + `/{type}/{name}</code></p>
+               Example: <code>` + api.AdminJobsPath + `/delete-topic/{topic}</code>
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">List tenants</th>
+            <td>GET</td>
+            <td><code>{schema}://{host}` + api.AdminTenantsPath + `</code></td>
+          </tr>
+          <tr>
+            <th class="text-right">Create tenant</th>
+            <td>POST</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminTenantsPath + `</code></p>
+               Example: <code>{"name":"team","quota":{"topics":10,"partitions":40,"bytes":1073741824,"produce-rate":100}}</code>.
+               The response contains the token of the tenant. Clients send it in the <b>Authorization: Bearer {token}</b> header.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Obtain tenant and its usage</th>
+            <td>GET</td>
+            <td><code>{schema}://{host}` + api.AdminTenantsPath + `/{name}</code></td>
+          </tr>
+          <tr>
+            <th class="text-right">Change tenant quota</th>
+            <td>PUT</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminTenantsPath + `/{name}</code></p>
+               Example: <code>{"quota":{"bytes":2147483648}}</code>
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Delete tenant</th>
+            <td>DELETE</td>
+            <td><code>{schema}://{host}` + api.AdminTenantsPath + `/{name}</code></td>
+          </tr>
+          <tr>
+            <th class="text-right">List nodes and their liveness</th>
+            <td>GET</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminNodesPath + `</code></p>
+               The node is live while it keeps its etcd lease. Chunk locations on the nodes which are not live are ignored.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Decommission node</th>
+            <td>POST</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminNodesPath + `/{group}/{node}/decommission</code></p>
+               Starts the job which drains the node, waits until its chunks are kept by other groups and removes the node from the cluster.
+               The optional body <code>{"etcd-member":"{memberid}"}</code> also removes the etcd member.
+               The job is available in <code>` + api.AdminJobsPath + `/decommission-node/{node}</code>.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">List under-replicated chunks</th>
+            <td>GET</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminRepairPath + `</code></p>
+               The chunks kept by fewer live groups than required, the messages which contain them and the last repair pass on the node.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Obtain progress of catch-up</th>
+            <td>GET</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminSyncPath + `</code></p>
+               The current or the last pass which synchronizes the chunks missed by the node while it was down
+               or while the watch of <code>/blobs</code> was interrupted.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Obtain state of etcd observers</th>
+            <td>GET</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminObserversPath + `</code></p>
+               The last delivered revision of each watched path and the lag behind the last change under the path.
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">List partition leaders</th>
+            <td>GET</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminLeadersPath + `</code></p>
+               The leader and the number of candidates of every partition which has an election
+               (<code>partition-leaders</code> is enabled).
+            </td>
+          </tr>
+          <tr>
+            <th class="text-right">Append message record</th>
+            <td>POST</td>
+            <td>
+               <p><code>{schema}://{host}` + api.AdminTopicsPath + `/{topic}/partitions/{partition}/append</code></p>
+               Used by nodes to forward the message record to the partition leader. The body is
+               <code>{"id":"...","value":"..."}</code>, the chunks of the message should already be written.
+            </td>
+          </tr>
           <tr class="info"><td colspan="3"><h4>Infomation about etcd cluster members</h4></td></tr>
           <tr>
             <th class="text-right">Obtain information about members</th>
@@ -61,7 +61,7 @@ func errorOutOfRange(ctx context.Context, w http.ResponseWriter, r *http.Request
 		data := &apiJSONErrorOutOfRange{
 			Code:         status,
 			Message:      fmt.Sprintf("Offset out of range (%v, %v)", offsetFrom, offsetTo),
-			Topic:        topic,
+			Topic:        displayTopic(ctx, topic),
 			Partition:    partition,
 			OffsetOldest: offsetFrom,
 			OffsetNewest: offsetTo,
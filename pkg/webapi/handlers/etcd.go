@@ -168,38 +168,13 @@ func etcdDeleteHandler(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	client, err := etcd.NewEtcdClient(cfg)
-	if err != nil {
-		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to create etcd client: %s", err)
-		return
-	}
-
-	id := util.ToUint64(p.Get("memberid"))
-
-	resp, err := client.MemberList(ctx)
-	if err != nil {
-		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to get member list: %s", err)
-		return
-	}
-
-	_, err = client.MemberRemove(ctx, id)
-	if err != nil {
-		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to remove member: %s", err)
-		return
-	}
-
-	for _, member := range resp.Members {
-		if id != member.ID {
-			continue
+	if err := etcd.RemoveMember(ctx, cfg, util.ToUint64(p.Get("memberid"))); err != nil {
+		if err == etcd.ErrMemberNotFound {
+			webapi.HTTPResponse(w, http.StatusNotFound, "%s", err)
+			return
 		}
-		var endpoints []string
-		for _, clientURL := range cfg.Etcd.Client.URLs {
-			if util.InSliceString(clientURL, member.ClientURLs) {
-				continue
-			}
-			endpoints = append(endpoints, clientURL)
-		}
-		cfg.Etcd.Client.URLs = endpoints
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
 	}
 
 	w.Write([]byte("OK"))
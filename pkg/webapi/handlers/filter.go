@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/legionus/kavka/pkg/api"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/filter"
+	"github.com/legionus/kavka/pkg/message"
+)
+
+func parseFilter(p *url.Values) (*filter.Filter, error) {
+	v := p.Get("filter")
+	if v == "" {
+		return nil, nil
+	}
+
+	f, err := filter.Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("bad filter: %s", err)
+	}
+
+	return f, nil
+}
+
+// matchMessage checks the message against the filter. The message body is
+// read only if the filter needs it and is returned to avoid reading it twice.
+func matchMessage(ctx context.Context, f *filter.Filter, msg *message.MessageInfo) (bool, []byte, error) {
+	if f == nil {
+		return true, nil, nil
+	}
+
+	rec := &filter.Record{
+		Key:     msg.Key,
+		Headers: msg.Headers,
+	}
+
+	if f.NeedsBody() {
+		buf := bytes.NewBuffer(nil)
+
+		if err := msg.CopyOut(ctx, buf); err != nil {
+			return false, nil, err
+		}
+		rec.Body = buf.Bytes()
+	}
+
+	return f.Match(rec), rec.Body, nil
+}
+
+func setMessageHeaders(w http.ResponseWriter, offset int64, msg *message.MessageInfo) {
+	w.Header().Set(api.MessageOffsetHeader, fmt.Sprintf("%d", offset))
+
+	if msg.Key != "" {
+		w.Header().Set(api.MessageKeyHeader, msg.Key)
+	}
+
+	if msg.Tombstone {
+		w.Header().Set(api.MessageTombstoneHeader, "true")
+	}
+
+	for name, value := range msg.Headers {
+		w.Header().Set(api.MessageHeaderPrefix+name, value)
+	}
+}
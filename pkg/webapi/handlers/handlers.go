@@ -5,6 +5,7 @@ import (
 	"regexp"
 
 	"github.com/legionus/kavka/pkg/api"
+	"github.com/legionus/kavka/pkg/config"
 	"github.com/legionus/kavka/pkg/context"
 	"github.com/legionus/kavka/pkg/webapi"
 	"github.com/legionus/kavka/pkg/webapi/middleware/jsonresponse"
@@ -24,20 +25,26 @@ type EndpointsInfo struct {
 var Endpoints *EndpointsInfo = &EndpointsInfo{
 	Endpoints: []HandlerInfo{
 		{
-			Regexp: regexp.MustCompile("^" + api.TopicsPath + "/(?P<topic>[A-Za-z0-9_-]+)/(?P<partition>[0-9]+)/?$"),
+			Regexp: regexp.MustCompile("^" + api.TopicsPath + "/(?P<topic>" + config.TopicNamePattern + ")/(?P<partition>[0-9]+)/?$"),
 			Handlers: MethodHandlers{
 				"GET":  topicGetHandler,
 				"POST": jsonresponse.Handler(topicPostHandler),
 			},
 		},
 		{
-			Regexp: regexp.MustCompile("^" + api.InfoTopicsPath + "/(?P<topic>[A-Za-z0-9_-]+)/(?P<partition>[0-9]+)/?$"),
+			Regexp: regexp.MustCompile("^" + api.TopicsPath + "/(?P<topic>" + config.TopicNamePattern + ")/?$"),
+			Handlers: MethodHandlers{
+				"POST": jsonresponse.Handler(topicPostHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.InfoTopicsPath + "/(?P<topic>" + config.TopicNamePattern + ")/(?P<partition>[0-9]+)/?$"),
 			Handlers: MethodHandlers{
 				"GET": jsonresponse.Handler(infoSinglePartitionHandler),
 			},
 		},
 		{
-			Regexp: regexp.MustCompile("^" + api.InfoTopicsPath + "/(?P<topic>[A-Za-z0-9_-]+)/?$"),
+			Regexp: regexp.MustCompile("^" + api.InfoTopicsPath + "/(?P<topic>" + config.TopicNamePattern + ")/?$"),
 			Handlers: MethodHandlers{
 				"GET": jsonresponse.Handler(infoAllPartitionHandler),
 			},
@@ -55,12 +62,188 @@ var Endpoints *EndpointsInfo = &EndpointsInfo{
 			},
 		},
 		{
-			Regexp: regexp.MustCompile("^" + api.JSONTopicsPath + "/(?P<topic>[A-Za-z0-9_-]+)/(?P<partition>[0-9]+)/?$"),
+			Regexp: regexp.MustCompile("^" + api.BlobsPath + "/?$"),
+			Handlers: MethodHandlers{
+				"POST": blobsBatchHandler,
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.JSONTopicsPath + "/(?P<topic>" + config.TopicNamePattern + ")/(?P<partition>[0-9]+)/?$"),
 			Handlers: MethodHandlers{
 				"GET":  jsonresponse.Handler(jsonGetHandler),
 				"POST": jsonresponse.Handler(jsonPostHandler),
 			},
 		},
+		{
+			Regexp: regexp.MustCompile("^" + api.JSONTopicsPath + "/(?P<topic>" + config.TopicNamePattern + ")/?$"),
+			Handlers: MethodHandlers{
+				"POST": jsonresponse.Handler(jsonPostHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.WorkQueuesPath + "/(?P<topic>" + config.TopicNamePattern + ")/(?P<partition>[0-9]+)/receive/?$"),
+			Handlers: MethodHandlers{
+				"POST": workQueueReceiveHandler,
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.WorkQueuesPath + "/(?P<topic>" + config.TopicNamePattern + ")/(?P<partition>[0-9]+)/ack/?$"),
+			Handlers: MethodHandlers{
+				"POST": jsonresponse.Handler(workQueueAckHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.WorkQueuesPath + "/(?P<topic>" + config.TopicNamePattern + ")/(?P<partition>[0-9]+)/nack/?$"),
+			Handlers: MethodHandlers{
+				"POST": jsonresponse.Handler(workQueueNackHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.MessagesPath + "/(?P<id>[A-Za-z0-9_-]+)/?$"),
+			Handlers: MethodHandlers{
+				"GET": messageGetHandler,
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.SchemasPath + "/(?P<subject>[A-Za-z0-9_.-]+)/versions/(?P<version>[0-9]+|latest)/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(schemaVersionGetHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.SchemasPath + "/(?P<subject>[A-Za-z0-9_.-]+)/config/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(schemaConfigGetHandler),
+				"PUT": jsonresponse.Handler(schemaConfigPutHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.SchemasPath + "/(?P<subject>[A-Za-z0-9_.-]+)/?$"),
+			Handlers: MethodHandlers{
+				"GET":    jsonresponse.Handler(schemaSubjectGetHandler),
+				"POST":   jsonresponse.Handler(schemaRegisterHandler),
+				"DELETE": jsonresponse.Handler(schemaSubjectDeleteHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.SchemasPath + "/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(schemasListHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminTopicsPath + "/(?P<topic>" + config.QualifiedTopicPattern + ")/config/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(adminTopicConfigGetHandler),
+				"PUT": jsonresponse.Handler(adminTopicConfigPutHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminTopicsPath + "/(?P<topic>" + config.QualifiedTopicPattern + ")/partitions/?$"),
+			Handlers: MethodHandlers{
+				"GET":  jsonresponse.Handler(adminTopicPartitionsGetHandler),
+				"POST": jsonresponse.Handler(adminTopicPartitionsPostHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminTopicsPath + "/(?P<topic>" + config.QualifiedTopicPattern + ")/partitions/(?P<partition>[0-9]+)/append/?$"),
+			Handlers: MethodHandlers{
+				"POST": jsonresponse.Handler(adminTopicPartitionAppendHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminTopicsPath + "/(?P<topic>" + config.QualifiedTopicPattern + ")/export/?$"),
+			Handlers: MethodHandlers{
+				"GET": adminTopicExportHandler,
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminTopicsPath + "/(?P<topic>" + config.QualifiedTopicPattern + ")/import/?$"),
+			Handlers: MethodHandlers{
+				"POST": jsonresponse.Handler(adminTopicImportHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminTopicsPath + "/(?P<topic>" + config.QualifiedTopicPattern + ")/?$"),
+			Handlers: MethodHandlers{
+				"DELETE": jsonresponse.Handler(adminTopicDeleteHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminJobsPath + "/(?P<type>[a-z-]+)/(?P<name>[A-Za-z0-9_.-]+)/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(adminJobGetHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminJobsPath + "/(?P<type>[a-z-]+)/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(adminJobsListHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminJobsPath + "/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(adminJobsListHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminTenantsPath + "/(?P<name>[A-Za-z0-9_-]+)/?$"),
+			Handlers: MethodHandlers{
+				"GET":    jsonresponse.Handler(adminTenantGetHandler),
+				"PUT":    jsonresponse.Handler(adminTenantPutHandler),
+				"DELETE": jsonresponse.Handler(adminTenantDeleteHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminTenantsPath + "/?$"),
+			Handlers: MethodHandlers{
+				"GET":  jsonresponse.Handler(adminTenantsListHandler),
+				"POST": jsonresponse.Handler(adminTenantCreateHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.ClusterPath + "/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(clusterHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminNodesPath + "/(?P<group>[^/]+)/(?P<node>[A-Za-z0-9_.-]+)/decommission/?$"),
+			Handlers: MethodHandlers{
+				"POST": jsonresponse.Handler(adminNodeDecommissionHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminNodesPath + "/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(adminNodesListHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminRepairPath + "/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(adminRepairReportHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminSyncPath + "/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(adminSyncProgressHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminObserversPath + "/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(adminObserversHandler),
+			},
+		},
+		{
+			Regexp: regexp.MustCompile("^" + api.AdminLeadersPath + "/?$"),
+			Handlers: MethodHandlers{
+				"GET": jsonresponse.Handler(adminLeadersListHandler),
+			},
+		},
 		{
 			Regexp: regexp.MustCompile("^" + api.PingPath + "$"),
 			Handlers: MethodHandlers{
@@ -115,6 +298,11 @@ func Handler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 
 		ctx = context.WithValue(ctx, webapi.HTTPRequestQueryParamsContextVar, &p)
 
+		if ctx, ok = identifyTenant(ctx, w, r, &p); !ok {
+			apiJSONHandler(ctx, w, r)
+			return
+		}
+
 		var reqHandler webapi.Handler
 
 		if reqHandler, ok = a.Handlers[r.Method]; !ok {
@@ -9,6 +9,8 @@ import (
 	"github.com/legionus/kavka/pkg/config"
 	"github.com/legionus/kavka/pkg/context"
 	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/queue"
+	"github.com/legionus/kavka/pkg/tenant"
 	"github.com/legionus/kavka/pkg/webapi"
 )
 
@@ -20,52 +22,6 @@ type responsePartitionInfo struct {
 	OffsetNewest int64  `json:"offsetto"`
 }
 
-func getOffset(coll metadata.EtcdCollection, key metadata.EtcdKey, opts ...metadata.GetOption) (int64, error) {
-	ans, err := coll.Get(key, opts...)
-	if err != nil {
-		return int64(0), err
-	}
-
-	queueKey, err := metadata.ParseQueueEtcdKey(ans.RawKey)
-	if err != nil {
-		return int64(0), err
-	}
-
-	return queueKey.Offset, nil
-}
-
-func getCornerOffsets(coll metadata.EtcdCollection, topic string, partition int64) (int64, int64, error) {
-	offsetOldest, err := getOffset(
-		coll,
-		&metadata.QueueEtcdKey{
-			Topic:     topic,
-			Partition: partition,
-			Offset:    metadata.NoOffset,
-		},
-		metadata.PrefixKey,
-		metadata.FirstKey,
-	)
-	if err != nil {
-		return int64(0), int64(0), err
-	}
-
-	offsetNewest, err := getOffset(
-		coll,
-		&metadata.QueueEtcdKey{
-			Topic:     topic,
-			Partition: partition,
-			Offset:    metadata.NoOffset,
-		},
-		metadata.PrefixKey,
-		metadata.LastKey,
-	)
-	if err != nil {
-		return int64(0), int64(0), err
-	}
-
-	return offsetOldest, offsetNewest + 1, nil
-}
-
 func infoSinglePartitionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
 	if !ok {
@@ -124,10 +80,14 @@ func getPartitionInfo(ctx context.Context, cfg *config.Config, topic string, w h
 			webapi.HTTPResponse(w, http.StatusInternalServerError, "Invalid key '%s': %s", v.RawKey, err)
 			return
 		}
-		e.Topic = key.Topic
+
+		if key.Partition == metadata.NoPartition || !tenant.Owns(requestTenant(ctx), key.Topic) {
+			continue
+		}
+		e.Topic = displayTopic(ctx, key.Topic)
 		e.Partition = key.Partition
 
-		e.OffsetOldest, e.OffsetNewest, err = getCornerOffsets(queuesColl, key.Topic, key.Partition)
+		e.OffsetOldest, e.OffsetNewest, err = queue.GetCornerOffsets(queuesColl, key.Topic, key.Partition)
 		if err != nil {
 			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
 			return
@@ -175,10 +135,14 @@ func infoTopicsHandler(ctx context.Context, w http.ResponseWriter, r *http.Reque
 			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
 			return
 		}
-		if _, ok := info[key.Topic]; !ok {
-			info[key.Topic] = int64(0)
+		if key.Partition == metadata.NoPartition || !tenant.Owns(requestTenant(ctx), key.Topic) {
+			continue
+		}
+		name := displayTopic(ctx, key.Topic)
+		if _, ok := info[name]; !ok {
+			info[name] = int64(0)
 		}
-		info[key.Topic]++
+		info[name]++
 	}
 
 	delim := ""
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/leader"
+	"github.com/legionus/kavka/pkg/queue"
+	"github.com/legionus/kavka/pkg/util"
+	"github.com/legionus/kavka/pkg/webapi"
+)
+
+type requestAppendRecord struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
+}
+
+func adminLeadersListHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	elections, err := leader.List(ctx)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to list leaders: %s", err)
+		return
+	}
+
+	writeJSON(w, elections)
+}
+
+// adminTopicPartitionAppendHandler appends the message record forwarded by
+// another node to the partition leader.
+func adminTopicPartitionAppendHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	msg, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to read body: %s", err)
+		return
+	}
+
+	req := &requestAppendRecord{}
+
+	if err = json.Unmarshal(msg, req); err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Bad request: %s", err)
+		return
+	}
+
+	if req.Value == "" {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Bad request: value is required")
+		return
+	}
+
+	rec, err := queue.AppendRecord(ctx, p.Get("topic"), util.ToInt64(p.Get("partition")), req.ID, req.Value)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to append record: %s", err)
+		return
+	}
+
+	writeJSON(w, rec)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/legionus/kavka/pkg/api"
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/message"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/tenant"
+	"github.com/legionus/kavka/pkg/webapi"
+)
+
+func messageGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain config from context")
+		return
+	}
+
+	messagesColl, err := metadata.NewMessagesCollection(ctx, cfg)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	queuesColl, err := metadata.NewQueuesCollection(ctx, cfg)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	records, err := messagesColl.List(&metadata.MessageEtcdKey{
+		ID:        p.Get("id"),
+		Topic:     p.Get("topic"),
+		Partition: metadata.NoPartition,
+		Offset:    metadata.NoOffset,
+	}, metadata.SortAscend)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	for _, rec := range records {
+		key, err := metadata.ParseQueueEtcdKey(rec.Value)
+		if err != nil {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+
+		if !tenant.Owns(requestTenant(ctx), key.Topic) {
+			continue
+		}
+
+		res, err := queuesColl.Get(key)
+		if err != nil {
+			if err == metadata.ErrKeyNotFound {
+				// Stale index record.
+				continue
+			}
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+
+		data, err := message.ParseMessageInfo(res.Value)
+		if err != nil {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		w.Header().Set(api.MessageTopicHeader, displayTopic(ctx, key.Topic))
+		w.Header().Set(api.MessagePartitionHeader, fmt.Sprintf("%d", key.Partition))
+		w.Header().Set(api.MessageCreationTimeHeader, data.CreationTime)
+		setMessageHeaders(w, key.Offset, data)
+
+		if err := data.CopyOut(ctx, w); err != nil {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		}
+		return
+	}
+
+	webapi.HTTPResponse(w, http.StatusNotFound, "Message not found: %s", p.Get("id"))
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/schema"
+	"github.com/legionus/kavka/pkg/util"
+	"github.com/legionus/kavka/pkg/webapi"
+)
+
+type requestSchemaConfig struct {
+	Compatibility string `json:"compatibility"`
+}
+
+// schemaError sends the response for errors of the schema registry.
+func schemaError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case *schema.IncompatibleError, *schema.SubjectInUseError:
+		webapi.HTTPResponse(w, http.StatusConflict, "%s", err)
+		return
+	}
+
+	switch err {
+	case schema.ErrSubjectNotFound, schema.ErrVersionNotFound:
+		webapi.HTTPResponse(w, http.StatusNotFound, "%s", err)
+	case schema.ErrVersionExists, schema.ErrSubjectModified:
+		webapi.HTTPResponse(w, http.StatusConflict, "%s", err)
+	default:
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+	}
+}
+
+func schemasListHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	list, err := schema.ListSubjects(ctx)
+	if err != nil {
+		schemaError(w, err)
+		return
+	}
+
+	writeJSON(w, list)
+}
+
+func schemaSubjectGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	s, err := schema.GetSubject(ctx, p.Get("subject"))
+	if err != nil {
+		schemaError(w, err)
+		return
+	}
+
+	writeJSON(w, s)
+}
+
+func schemaRegisterHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	msg, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to read body: %s", err)
+		return
+	}
+
+	if _, err := schema.Parse(msg); err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	v, err := schema.Register(ctx, p.Get("subject"), msg)
+	if err != nil {
+		schemaError(w, err)
+		return
+	}
+
+	writeJSON(w, v)
+}
+
+func schemaSubjectDeleteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	if err := schema.DeleteSubject(ctx, p.Get("subject")); err != nil {
+		schemaError(w, err)
+		return
+	}
+
+	writeJSON(w, map[string]string{
+		"subject": p.Get("subject"),
+	})
+}
+
+func schemaConfigGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	s, err := schema.GetSubject(ctx, p.Get("subject"))
+	if err != nil {
+		schemaError(w, err)
+		return
+	}
+
+	writeJSON(w, &requestSchemaConfig{
+		Compatibility: s.Compatibility,
+	})
+}
+
+func schemaConfigPutHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	msg, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to read body: %s", err)
+		return
+	}
+
+	req := &requestSchemaConfig{}
+
+	if err = json.Unmarshal(msg, req); err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Bad request: %s", err)
+		return
+	}
+
+	if err := schema.ValidateCompatibility(req.Compatibility); err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	if _, err := schema.SetCompatibility(ctx, p.Get("subject"), req.Compatibility); err != nil {
+		schemaError(w, err)
+		return
+	}
+
+	writeJSON(w, req)
+}
+
+func schemaVersionGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	version := int64(metadata.NoVersion)
+
+	if v := p.Get("version"); v != "latest" {
+		version = util.ToInt64(v)
+	}
+
+	res, err := schema.GetVersion(ctx, p.Get("subject"), version)
+	if err != nil {
+		schemaError(w, err)
+		return
+	}
+
+	writeJSON(w, res)
+}
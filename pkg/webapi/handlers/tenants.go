@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/legionus/kavka/pkg/api"
+	"github.com/legionus/kavka/pkg/config"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/metadata"
+	"github.com/legionus/kavka/pkg/tenant"
+	"github.com/legionus/kavka/pkg/webapi"
+)
+
+var (
+	tenantNameRegexp = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+	// tenantPaths are endpoints which work with topics of the tenant.
+	tenantPaths = []string{
+		api.TopicsPath,
+		api.JSONTopicsPath,
+		api.InfoTopicsPath,
+		api.WorkQueuesPath,
+		api.MessagesPath,
+	}
+
+	produceLimiter = tenant.NewLimiter()
+)
+
+func isTenantPath(path string) bool {
+	for _, prefix := range tenantPaths {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// identifyTenant finds the tenant by the bearer token of the request and
+// replaces the topic parameter with the qualified topic name. It returns
+// false if the request has been rejected.
+func identifyTenant(ctx context.Context, w http.ResponseWriter, r *http.Request, p *url.Values) (context.Context, bool) {
+	cfg, ok := ctx.Value(config.AppConfigContextVar).(*config.Config)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain config from context")
+		return ctx, false
+	}
+
+	token := ""
+	if v := r.Header.Get("Authorization"); strings.HasPrefix(v, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(v, "Bearer "))
+	}
+
+	if strings.HasPrefix(r.URL.Path, api.AdminPath+"/") {
+		return ctx, checkAdmin(cfg, w, token)
+	}
+
+	if !isTenantPath(r.URL.Path) {
+		return ctx, true
+	}
+
+	if token == "" {
+		if cfg.Tenants.Required {
+			webapi.HTTPResponse(w, http.StatusUnauthorized, "tenant credentials required")
+			return ctx, false
+		}
+		return ctx, true
+	}
+
+	t, err := tenant.Identify(ctx, token)
+	if err != nil {
+		if err == tenant.ErrUnauthorized {
+			webapi.HTTPResponse(w, http.StatusUnauthorized, "%s", err)
+		} else {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		}
+		return ctx, false
+	}
+
+	if v := p.Get("topic"); v != "" {
+		p.Set("topic", tenant.QualifyTopic(t, v))
+	}
+
+	return context.WithValue(ctx, tenant.TenantContextVar, t), true
+}
+
+// checkAdmin checks the credentials of the admin request. Without
+// tenants.admin-token the admin API is available to the requests without a
+// token unless the tenants are required. It returns false if the request has
+// been rejected.
+func checkAdmin(cfg *config.Config, w http.ResponseWriter, token string) bool {
+	if cfg.Tenants.AdminToken != "" {
+		if subtle.ConstantTimeCompare([]byte(cfg.Tenants.AdminToken), []byte(token)) != 1 {
+			webapi.HTTPResponse(w, http.StatusUnauthorized, "admin credentials required")
+			return false
+		}
+		return true
+	}
+
+	if cfg.Tenants.Required {
+		webapi.HTTPResponse(w, http.StatusForbidden, "admin API is disabled: tenants.admin-token is not set")
+		return false
+	}
+
+	if token != "" {
+		webapi.HTTPResponse(w, http.StatusForbidden, "admin API is not available for tenants")
+		return false
+	}
+
+	return true
+}
+
+func requestTenant(ctx context.Context) *tenant.Tenant {
+	t, _ := ctx.Value(tenant.TenantContextVar).(*tenant.Tenant)
+	return t
+}
+
+// displayTopic returns the topic name as it is seen by the client.
+func displayTopic(ctx context.Context, name string) string {
+	return tenant.DisplayTopic(requestTenant(ctx), name)
+}
+
+// checkProduceQuota checks the quotas of the tenant before writing to the
+// partition. It returns false if the request has been rejected.
+func checkProduceQuota(ctx context.Context, w http.ResponseWriter, coll metadata.EtcdCollection, key *metadata.TopicEtcdKey) bool {
+	t := requestTenant(ctx)
+	if t == nil {
+		return true
+	}
+
+	if !produceLimiter.Allow(t.Name, t.Quota.ProduceRate, time.Now()) {
+		webapi.HTTPResponse(w, http.StatusTooManyRequests, "produce rate quota exceeded")
+		return false
+	}
+
+	if t.Quota.Bytes > 0 {
+		usage, err := tenant.GetUsage(ctx, t.Name)
+		if err != nil {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+			return false
+		}
+		if usage.Bytes >= t.Quota.Bytes {
+			webapi.HTTPResponse(w, http.StatusInsufficientStorage, "storage quota exceeded")
+			return false
+		}
+	}
+
+	if t.Quota.Topics == 0 && t.Quota.Partitions == 0 {
+		return true
+	}
+
+	// The quotas of topics and partitions are checked only if the partition
+	// is going to be created.
+	if _, err := coll.Get(key); err != metadata.ErrKeyNotFound {
+		if err != nil {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+			return false
+		}
+		return true
+	}
+
+	topics, partitions, err := tenant.CountTopics(ctx, t)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return false
+	}
+
+	count, err := metadata.GetPartitionsCount(coll, key.Topic)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return false
+	}
+
+	// All the partitions before the new one are created as well.
+	created := key.Partition + 1 - count
+	if created < 1 {
+		created = 1
+	}
+
+	if t.Quota.Partitions > 0 && partitions+created > t.Quota.Partitions {
+		webapi.HTTPResponse(w, http.StatusForbidden, "partitions quota exceeded")
+		return false
+	}
+
+	if t.Quota.Topics > 0 && topics >= t.Quota.Topics && count == 0 {
+		webapi.HTTPResponse(w, http.StatusForbidden, "topics quota exceeded")
+		return false
+	}
+
+	return true
+}
+
+type responseTenant struct {
+	Name    string        `json:"name"`
+	Token   string        `json:"token,omitempty"`
+	Quota   tenant.Quota  `json:"quota"`
+	Created time.Time     `json:"created"`
+	Usage   *tenant.Usage `json:"usage,omitempty"`
+}
+
+func newResponseTenant(ctx context.Context, t *tenant.Tenant) (*responseTenant, error) {
+	usage, err := tenant.GetUsage(ctx, t.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &responseTenant{
+		Name:    t.Name,
+		Quota:   t.Quota,
+		Created: t.Created,
+		Usage:   usage,
+	}, nil
+}
+
+func adminTenantsListHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	list, err := tenant.List(ctx)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to list tenants: %s", err)
+		return
+	}
+
+	res := []*responseTenant{}
+
+	for _, t := range list {
+		v, err := newResponseTenant(ctx, t)
+		if err != nil {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+		res = append(res, v)
+	}
+
+	writeJSON(w, res)
+}
+
+func adminTenantCreateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	msg, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to read body: %s", err)
+		return
+	}
+
+	t := &tenant.Tenant{}
+
+	if err = json.Unmarshal(msg, t); err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Bad tenant: %s", err)
+		return
+	}
+
+	if !tenantNameRegexp.MatchString(t.Name) {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Bad tenant name: %q", t.Name)
+		return
+	}
+
+	t.Token = uuid.New()
+	t.Created = time.Now()
+
+	if err := tenant.Create(ctx, t); err != nil {
+		if err == tenant.ErrTenantExists {
+			webapi.HTTPResponse(w, http.StatusConflict, "%s", err)
+			return
+		}
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to create tenant: %s", err)
+		return
+	}
+
+	// The token is shown only once.
+	writeJSON(w, &responseTenant{
+		Name:    t.Name,
+		Token:   t.Token,
+		Quota:   t.Quota,
+		Created: t.Created,
+	})
+}
+
+func adminTenantGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	t, err := tenant.Get(ctx, p.Get("name"))
+	if err != nil {
+		if err == tenant.ErrTenantNotFound {
+			webapi.HTTPResponse(w, http.StatusNotFound, "%s", err)
+			return
+		}
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	res, err := newResponseTenant(ctx, t)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	writeJSON(w, res)
+}
+
+func adminTenantPutHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	t, err := tenant.Get(ctx, p.Get("name"))
+	if err != nil {
+		if err == tenant.ErrTenantNotFound {
+			webapi.HTTPResponse(w, http.StatusNotFound, "%s", err)
+			return
+		}
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	msg, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to read body: %s", err)
+		return
+	}
+
+	req := &responseTenant{}
+
+	if err = json.Unmarshal(msg, req); err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "Bad tenant: %s", err)
+		return
+	}
+
+	t.Quota = req.Quota
+
+	if err := tenant.Put(ctx, t); err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to update tenant: %s", err)
+		return
+	}
+
+	res, err := newResponseTenant(ctx, t)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	writeJSON(w, res)
+}
+
+func adminTenantDeleteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	if err := tenant.Delete(ctx, p.Get("name")); err != nil {
+		if err == tenant.ErrTenantNotFound {
+			webapi.HTTPResponse(w, http.StatusNotFound, "%s", err)
+			return
+		}
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to delete tenant: %s", err)
+		return
+	}
+}
@@ -3,12 +3,18 @@ package handlers
 import (
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/legionus/kavka/pkg/api"
 	"github.com/legionus/kavka/pkg/config"
 	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/filter"
+	"github.com/legionus/kavka/pkg/jobs"
 	"github.com/legionus/kavka/pkg/message"
 	"github.com/legionus/kavka/pkg/metadata"
 	"github.com/legionus/kavka/pkg/queue"
@@ -16,6 +22,12 @@ import (
 	"github.com/legionus/kavka/pkg/webapi"
 )
 
+const (
+	// filterBatchSize is the number of queue records fetched at once while
+	// looking for a message matching the filter.
+	filterBatchSize = 100
+)
+
 func topicGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
 	if !ok {
@@ -39,8 +51,18 @@ func topicGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request
 		Partition: util.ToInt64(p.Get("partition")),
 	}
 
-	offsetOldest, offsetNewest, err := getCornerOffsets(queuesColl, key.Topic, key.Partition)
+	msgFilter, err := parseFilter(p)
 	if err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	offsetOldest, offsetNewest, err := queue.GetCornerOffsets(queuesColl, key.Topic, key.Partition)
+	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			errorOutOfRange(ctx, w, r, key.Topic, key.Partition, 0, 0)
+			return
+		}
 		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to get offsets: %v", err)
 		return
 	}
@@ -71,8 +93,18 @@ func topicGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	if msgFilter != nil {
+		filteredGet(ctx, w, queuesColl, key, offsetNewest, msgFilter)
+		return
+	}
+
 	res, err := queuesColl.Get(key)
 	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			// The message has been removed by cleanup. Send the next one.
+			filteredGet(ctx, w, queuesColl, key, offsetNewest, nil)
+			return
+		}
 		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to get message: %v", err)
 		return
 	}
@@ -83,11 +115,89 @@ func topicGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	setMessageHeaders(w, key.Offset, data)
+
 	if err := data.CopyOut(ctx, w); err != nil {
 		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
 	}
 }
 
+// filteredGet sends the first message matching the filter starting from the
+// key offset. If there is no such message, the response is empty and the
+// offset header points to the end of the partition.
+func filteredGet(ctx context.Context, w http.ResponseWriter, queuesColl metadata.EtcdCollection, key *metadata.QueueEtcdKey, offsetNewest int64, msgFilter *filter.Filter) {
+	for offset := key.Offset; offset < offsetNewest; offset += filterBatchSize {
+		lastkey := &metadata.QueueEtcdKey{
+			Topic:     key.Topic,
+			Partition: key.Partition,
+			Offset:    offset + filterBatchSize,
+		}
+
+		if lastkey.Offset > offsetNewest {
+			lastkey.Offset = offsetNewest
+		}
+
+		records, err := queuesColl.ListRange(
+			&metadata.QueueEtcdKey{
+				Topic:     key.Topic,
+				Partition: key.Partition,
+				Offset:    offset,
+			},
+			lastkey,
+		)
+		if err != nil {
+			if err == metadata.ErrKeyNotFound {
+				continue
+			}
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+
+		for _, rec := range records {
+			if !webapi.IsAlive(w) {
+				return
+			}
+
+			recKey, err := metadata.ParseQueueEtcdKey(rec.RawKey)
+			if err != nil {
+				webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+				return
+			}
+
+			data, err := message.ParseMessageInfo(rec.Value)
+			if err != nil {
+				webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+				return
+			}
+
+			matched, body, err := matchMessage(ctx, msgFilter, data)
+			if err != nil {
+				webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+				return
+			}
+
+			if !matched {
+				continue
+			}
+
+			setMessageHeaders(w, recKey.Offset, data)
+
+			if body != nil {
+				w.Write(body)
+				return
+			}
+
+			if err := data.CopyOut(ctx, w); err != nil {
+				webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+			}
+			return
+		}
+	}
+
+	w.Header().Set(api.MessageOffsetHeader, fmt.Sprintf("%d", offsetNewest))
+	webapi.HTTPResponse(w, http.StatusNoContent, "")
+}
+
 func topicPostHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
@@ -109,52 +219,224 @@ func topicPostHandler(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	topicKey, err := messagePartition(topicsColl, p, r)
+	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			webapi.HTTPResponse(w, http.StatusNotFound, "topic not found")
+		} else {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		}
+		return
+	}
+
+	deleting, err := jobs.IsActive(ctx, jobs.TopicDeletion, topicKey.Topic)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	if deleting {
+		webapi.HTTPResponse(w, http.StatusConflict, "topic is being deleted")
+		return
+	}
+
+	if !checkProduceQuota(ctx, w, topicsColl, topicKey) {
+		return
+	}
+
+	topicCfg, err := metadata.GetTopicConfig(ctx, cfg, topicKey.Topic)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to get topic config: %s", err)
+		return
+	}
+	ctx = context.WithValue(ctx, config.TopicConfigContextVar, topicCfg)
+
 	var stream io.Reader = r.Body
 
-	if cfg.Topic.MaxMessageSize > 0 {
+	if topicCfg.MaxMessageSize > 0 {
 		stream = &io.LimitedReader{
 			R: r.Body,
-			N: cfg.Topic.MaxMessageSize,
+			N: topicCfg.MaxMessageSize,
 		}
 	}
 
-	topicKey := &metadata.TopicEtcdKey{
-		Topic:     p.Get("topic"),
-		Partition: util.ToInt64(p.Get("partition")),
-	}
-
-	if err := hasKey(topicsColl, topicKey, time.Now().String(), cfg.Topic.AllowTopicsCreation); err != nil {
-		if err != metadata.ErrKeyNotFound {
-			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
-		} else {
+	if err := hasKey(ctx, cfg, topicsColl, topicKey, time.Now().String(), cfg.Topic.AllowTopicsCreation); err != nil {
+		switch err {
+		case metadata.ErrKeyNotFound:
 			webapi.HTTPResponse(w, http.StatusBadRequest, "creating partitions is prohibited")
+		case metadata.ErrTooManyPartitions:
+			webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
+		default:
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
 		}
 		return
 	}
 
-	topicValue := message.NewMessageInfo()
+	topicValue, err := newMessageInfo(r)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	deliverAt, err := parseDeliverAt(r)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	ctx, err = withAcks(ctx, cfg, r)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
+		return
+	}
 
 	if err := topicValue.CopyIn(ctx, stream); err != nil {
-		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		copyInError(w, err)
 		return
 	}
 
-	if err := topicValue.MakeRefs(ctx, topicKey.Topic, topicKey.Partition); err != nil {
+	publishMessage(ctx, w, topicKey, topicValue, deliverAt)
+}
+
+// withAcks overrides the write concern of the topic by the request header.
+func withAcks(ctx context.Context, cfg *config.Config, r *http.Request) (context.Context, error) {
+	v := r.Header.Get(api.MessageAcksHeader)
+	if v == "" {
+		return ctx, nil
+	}
+
+	acks, err := message.ParseAcks(v)
+	if err != nil {
+		return ctx, fmt.Errorf("bad %s header: %s", api.MessageAcksHeader, err)
+	}
+
+	if err := cfg.Storage.CheckWriteConcern(acks); err != nil {
+		return ctx, fmt.Errorf("bad %s header: %s", api.MessageAcksHeader, err)
+	}
+
+	return context.WithValue(ctx, message.AcksContextVar, acks), nil
+}
+
+// copyInError sends the response for errors of writing the message body.
+func copyInError(w http.ResponseWriter, err error) {
+	if err == message.ErrNodeDraining {
+		webapi.HTTPResponse(w, http.StatusServiceUnavailable, "%s", err)
+		return
+	}
+
+	switch err.(type) {
+	case *message.UnavailableError:
+		webapi.HTTPResponse(w, http.StatusServiceUnavailable, "%s", err)
+	case *message.ReplicationError:
+		webapi.HTTPResponse(w, http.StatusGatewayTimeout, "%s", err)
+	default:
 		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+	}
+}
+
+func newMessageInfo(r *http.Request) (*message.MessageInfo, error) {
+	msg := message.NewMessageInfo()
+	msg.Key = r.Header.Get(api.MessageKeyHeader)
+
+	for name, values := range r.Header {
+		if !strings.HasPrefix(name, api.MessageHeaderPrefix) || len(name) == len(api.MessageHeaderPrefix) {
+			continue
+		}
+		if msg.Headers == nil {
+			msg.Headers = make(map[string]string)
+		}
+		msg.Headers[strings.ToLower(name[len(api.MessageHeaderPrefix):])] = values[0]
+	}
+
+	if v := r.Header.Get(api.MessageTombstoneHeader); v != "" {
+		tombstone, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s header: %s", api.MessageTombstoneHeader, v)
+		}
+		if tombstone && msg.Key == "" {
+			return nil, fmt.Errorf("tombstone requires %s header", api.MessageKeyHeader)
+		}
+		msg.Tombstone = tombstone
+	}
+
+	return msg, nil
+}
+
+func parseDeliverAt(r *http.Request) (time.Time, error) {
+	v := r.Header.Get(api.MessageDeliverAtHeader)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	res, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad %s header: %s", api.MessageDeliverAtHeader, v)
+	}
+
+	return res, nil
+}
+
+// publishMessage makes the stored message available to consumers. If
+// deliverAt is in the future, the message is handed over to the scheduler.
+func publishMessage(ctx context.Context, w http.ResponseWriter, topicKey *metadata.TopicEtcdKey, msg *message.MessageInfo, deliverAt time.Time) {
+	if err := msg.MakeRefs(ctx, topicKey.Topic, topicKey.Partition); err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	if deliverAt.After(time.Now()) {
+		rec, err := queue.ScheduleQueue(ctx, topicKey.Topic, topicKey.Partition, deliverAt, msg)
+		if err != nil {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+
+		out := fmt.Sprintf(`{"topic":%q,"partition":%d,"deliver-at":%q}`, displayTopic(ctx, rec.Topic), rec.Partition, deliverAt.Format(time.RFC3339))
+		w.Write([]byte(out))
 		return
 	}
 
-	rec, err := queue.CreateQueue(ctx, topicKey.Topic, topicKey.Partition, topicValue)
+	rec, err := queue.CreateQueue(ctx, topicKey.Topic, topicKey.Partition, msg)
 	if err != nil {
 		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
 		return
 	}
 
-	out := fmt.Sprintf("{topic: %q, partition: %d, offset: %d}", rec.Topic, rec.Partition, rec.Offset)
+	out := fmt.Sprintf(`{"topic":%q,"partition":%d,"offset":%d}`, displayTopic(ctx, rec.Topic), rec.Partition, rec.Offset)
 	w.Write([]byte(out))
 }
 
-func hasKey(coll metadata.EtcdCollection, key metadata.EtcdKey, value string, allowCreation bool) error {
+// messagePartition returns the partition of the posted message. If the
+// partition is not specified in the request, it is chosen by the message key
+// or randomly if the message has no key.
+func messagePartition(coll metadata.EtcdCollection, p *url.Values, r *http.Request) (*metadata.TopicEtcdKey, error) {
+	key := &metadata.TopicEtcdKey{
+		Topic:     p.Get("topic"),
+		Partition: util.ToInt64(p.Get("partition")),
+	}
+
+	if p.Get("partition") != "" {
+		return key, nil
+	}
+
+	count, err := metadata.GetPartitionsCount(coll, key.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return nil, metadata.ErrKeyNotFound
+	}
+
+	if v := r.Header.Get(api.MessageKeyHeader); v != "" {
+		key.Partition = queue.KeyPartition(v, count)
+	} else {
+		key.Partition = rand.Int63n(count)
+	}
+
+	return key, nil
+}
+
+func hasKey(ctx context.Context, cfg *config.Config, coll metadata.EtcdCollection, key *metadata.TopicEtcdKey, value string, allowCreation bool) error {
 	if _, err := coll.Get(key); err != nil {
 		if err != metadata.ErrKeyNotFound {
 			return err
@@ -162,7 +444,7 @@ func hasKey(coll metadata.EtcdCollection, key metadata.EtcdKey, value string, al
 		if !allowCreation {
 			return err
 		}
-		return coll.Put(key, value)
+		return metadata.CreatePartitions(ctx, cfg, key.Topic, key.Partition, value)
 	}
 	return nil
 }
@@ -12,9 +12,11 @@ import (
 
 	"github.com/legionus/kavka/pkg/config"
 	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/jobs"
 	"github.com/legionus/kavka/pkg/message"
 	"github.com/legionus/kavka/pkg/metadata"
 	"github.com/legionus/kavka/pkg/queue"
+	"github.com/legionus/kavka/pkg/schema"
 	"github.com/legionus/kavka/pkg/util"
 	"github.com/legionus/kavka/pkg/webapi"
 )
@@ -46,7 +48,13 @@ func jsonGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
 		Partition: util.ToInt64(p.Get("partition")),
 	}
 
-	offsetOldest, offsetNewest, err := getCornerOffsets(queuesColl, key.Topic, key.Partition)
+	msgFilter, err := parseFilter(p)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	offsetOldest, offsetNewest, err := queue.GetCornerOffsets(queuesColl, key.Topic, key.Partition)
 	if err != nil {
 		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to get offsets: %v", err)
 		return
@@ -80,54 +88,105 @@ func jsonGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
 		length = 1
 	}
 
-	lastkey := &metadata.QueueEtcdKey{
-		Topic:     key.Topic,
+	queryStr, err := json.Marshal(&metadata.QueueEtcdKey{
+		Topic:     displayTopic(ctx, key.Topic),
 		Partition: key.Partition,
-		Offset:    key.Offset + length,
-	}
-
-	if lastkey.Offset >= offsetNewest {
-		lastkey.Offset = offsetNewest
-	}
-
-	queryStr, err := json.Marshal(key)
+		Offset:    key.Offset,
+	})
 	if err != nil {
 		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to marshal json: %v", err)
 		return
 	}
 
-	mataRange, err := queuesColl.ListRange(key, lastkey)
-	if err != nil {
-		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
-		return
-	}
-
 	successSent := false
 
-	for _, msg := range mataRange {
-		if !webapi.IsAlive(w) {
-			return
+	// The limit is the number of messages matching the filter, so the
+	// partition is scanned until enough messages are found.
+	next := key.Offset
+	found := int64(0)
+
+	for offset := key.Offset; offset < offsetNewest && found < length; offset += filterBatchSize {
+		lastkey := &metadata.QueueEtcdKey{
+			Topic:     key.Topic,
+			Partition: key.Partition,
+			Offset:    offset + filterBatchSize,
 		}
 
-		data, err := message.ParseMessageInfo(msg.Value)
-		if err != nil {
-			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
-			return
+		if lastkey.Offset > offsetNewest {
+			lastkey.Offset = offsetNewest
 		}
 
-		if !successSent {
-			successSent = true
+		next = lastkey.Offset
 
-			w.Write([]byte(`{`))
-			w.Write([]byte(`"query":`))
-			w.Write(queryStr)
-			w.Write([]byte(`,"messages":[`))
-		} else {
-			w.Write([]byte(`,`))
+		records, err := queuesColl.ListRange(
+			&metadata.QueueEtcdKey{
+				Topic:     key.Topic,
+				Partition: key.Partition,
+				Offset:    offset,
+			},
+			lastkey,
+		)
+		if err != nil {
+			if err == metadata.ErrKeyNotFound {
+				continue
+			}
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+			return
 		}
 
-		if err := data.CopyOut(ctx, w); err != nil {
-			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		for _, rec := range records {
+			if !webapi.IsAlive(w) {
+				return
+			}
+
+			recKey, err := metadata.ParseQueueEtcdKey(rec.RawKey)
+			if err != nil {
+				webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+				return
+			}
+
+			data, err := message.ParseMessageInfo(rec.Value)
+			if err != nil {
+				webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+				return
+			}
+
+			matched, body, err := matchMessage(ctx, msgFilter, data)
+			if err != nil {
+				webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+				return
+			}
+
+			if !matched {
+				continue
+			}
+
+			if !successSent {
+				successSent = true
+
+				w.Write([]byte(`{`))
+				w.Write([]byte(`"query":`))
+				w.Write(queryStr)
+				w.Write([]byte(`,"messages":[`))
+			} else {
+				w.Write([]byte(`,`))
+			}
+
+			found++
+
+			// A tombstone may have no body, which is not a JSON value.
+			if len(data.Blobs) == 0 {
+				w.Write([]byte(`null`))
+			} else if body != nil {
+				w.Write(body)
+			} else if err := data.CopyOut(ctx, w); err != nil {
+				webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+			}
+
+			if found >= length {
+				next = recKey.Offset + 1
+				break
+			}
 		}
 	}
 
@@ -138,7 +197,7 @@ func jsonGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request)
 		w.Write([]byte(`,"messages":[`))
 	}
 
-	w.Write([]byte(`]}`))
+	w.Write([]byte(fmt.Sprintf(`],"next":%d}`, next)))
 }
 
 func jsonPostHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -162,25 +221,54 @@ func jsonPostHandler(ctx context.Context, w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	topicKey, err := messagePartition(topicsColl, p, r)
+	if err != nil {
+		if err == metadata.ErrKeyNotFound {
+			webapi.HTTPResponse(w, http.StatusNotFound, "topic not found")
+		} else {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		}
+		return
+	}
+
+	deleting, err := jobs.IsActive(ctx, jobs.TopicDeletion, topicKey.Topic)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	if deleting {
+		webapi.HTTPResponse(w, http.StatusConflict, "topic is being deleted")
+		return
+	}
+
+	if !checkProduceQuota(ctx, w, topicsColl, topicKey) {
+		return
+	}
+
+	topicCfg, err := metadata.GetTopicConfig(ctx, cfg, topicKey.Topic)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to get topic config: %s", err)
+		return
+	}
+	ctx = context.WithValue(ctx, config.TopicConfigContextVar, topicCfg)
+
 	var stream io.Reader = r.Body
 
-	if cfg.Topic.MaxMessageSize > 0 {
+	if topicCfg.MaxMessageSize > 0 {
 		stream = &io.LimitedReader{
 			R: r.Body,
-			N: cfg.Topic.MaxMessageSize,
+			N: topicCfg.MaxMessageSize,
 		}
 	}
 
-	topicKey := &metadata.TopicEtcdKey{
-		Topic:     p.Get("topic"),
-		Partition: util.ToInt64(p.Get("partition")),
-	}
-
-	if err := hasKey(topicsColl, topicKey, time.Now().String(), cfg.Topic.AllowTopicsCreation); err != nil {
-		if err != metadata.ErrKeyNotFound {
-			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
-		} else {
+	if err := hasKey(ctx, cfg, topicsColl, topicKey, time.Now().String(), cfg.Topic.AllowTopicsCreation); err != nil {
+		switch err {
+		case metadata.ErrKeyNotFound:
 			webapi.HTTPResponse(w, http.StatusBadRequest, "creating partitions is prohibited")
+		case metadata.ErrTooManyPartitions:
+			webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
+		default:
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
 		}
 		return
 	}
@@ -191,30 +279,48 @@ func jsonPostHandler(ctx context.Context, w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	var m json.RawMessage
-	if err = json.Unmarshal(msg, &m); err != nil {
-		webapi.HTTPResponse(w, http.StatusBadRequest, "Message must be JSON")
+	topicValue, err := newMessageInfo(r)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
 		return
 	}
 
-	topicValue := message.NewMessageInfo()
-
-	if err := topicValue.CopyIn(ctx, bytes.NewReader(msg)); err != nil {
-		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+	deliverAt, err := parseDeliverAt(r)
+	if err != nil {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
 		return
 	}
 
-	if err := topicValue.MakeRefs(ctx, topicKey.Topic, topicKey.Partition); err != nil {
-		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
-		return
+	// Tombstone may have no body.
+	if !topicValue.Tombstone || len(msg) > 0 {
+		var m json.RawMessage
+		if err = json.Unmarshal(msg, &m); err != nil {
+			webapi.HTTPResponse(w, http.StatusBadRequest, "Message must be JSON")
+			return
+		}
+
+		if subject := topicCfg.SchemaSubject(topicKey.Topic); subject != "" {
+			if err := schema.ValidateDocument(ctx, subject, msg); err != nil {
+				if _, ok := err.(*schema.ValidationError); ok {
+					webapi.HTTPResponse(w, http.StatusUnprocessableEntity, "Message does not match schema %s: %s", subject, err)
+				} else {
+					webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to validate message: %s", err)
+				}
+				return
+			}
+		}
 	}
 
-	rec, err := queue.CreateQueue(ctx, topicKey.Topic, topicKey.Partition, topicValue)
+	ctx, err = withAcks(ctx, cfg, r)
 	if err != nil {
-		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		webapi.HTTPResponse(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	if err := topicValue.CopyIn(ctx, bytes.NewReader(msg)); err != nil {
+		copyInError(w, err)
 		return
 	}
 
-	out := fmt.Sprintf("{topic: %q, partition: %d, offset: %d}", rec.Topic, rec.Partition, rec.Offset)
-	w.Write([]byte(out))
+	publishMessage(ctx, w, topicKey, topicValue, deliverAt)
 }
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/legionus/kavka/pkg/api"
+	"github.com/legionus/kavka/pkg/context"
+	"github.com/legionus/kavka/pkg/queue"
+	"github.com/legionus/kavka/pkg/util"
+	"github.com/legionus/kavka/pkg/webapi"
+)
+
+func workQueueReceiveHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	var timeout time.Duration
+
+	if v := p.Get("timeout"); v != "" {
+		var err error
+
+		timeout, err = time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			webapi.HTTPResponse(w, http.StatusBadRequest, "Bad timeout: %s", v)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	res, err := queue.Receive(ctx, p.Get("topic"), util.ToInt64(p.Get("partition")), timeout)
+	if err != nil {
+		if err == queue.ErrQueueEmpty {
+			webapi.HTTPResponse(w, http.StatusNoContent, "")
+		} else {
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		}
+		return
+	}
+
+	setMessageHeaders(w, res.Key.Offset, res.Message)
+	w.Header().Set(api.MessageReceiptHeader, res.Lease.Receipt)
+	w.Header().Set(api.MessageDeliveriesHeader, fmt.Sprintf("%d", res.Lease.Deliveries))
+
+	if err := res.Message.CopyOut(ctx, w); err != nil {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+	}
+}
+
+func workQueueAckHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	workQueueFinish(ctx, w, r, queue.Ack)
+}
+
+func workQueueNackHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	workQueueFinish(ctx, w, r, queue.Nack)
+}
+
+func workQueueFinish(ctx context.Context, w http.ResponseWriter, r *http.Request, fn func(context.Context, string, int64, int64, string) error) {
+	p, ok := ctx.Value(webapi.HTTPRequestQueryParamsContextVar).(*url.Values)
+	if !ok {
+		webapi.HTTPResponse(w, http.StatusInternalServerError, "Unable to obtain params from context")
+		return
+	}
+
+	if p.Get("offset") == "" || p.Get("receipt") == "" {
+		webapi.HTTPResponse(w, http.StatusBadRequest, "offset and receipt required")
+		return
+	}
+
+	err := fn(ctx, p.Get("topic"), util.ToInt64(p.Get("partition")), util.ToInt64(p.Get("offset")), p.Get("receipt"))
+	if err != nil {
+		switch err {
+		case queue.ErrLeaseNotFound:
+			webapi.HTTPResponse(w, http.StatusNotFound, "%s", err)
+		case queue.ErrReceiptMismatch:
+			webapi.HTTPResponse(w, http.StatusConflict, "%s", err)
+		default:
+			webapi.HTTPResponse(w, http.StatusInternalServerError, "%s", err)
+		}
+	}
+}